@@ -0,0 +1,320 @@
+// Package client sends logs to barito-flow producer.
+// Timbers are buffered in memory and sent with ProduceBatch when the batch is full or the flush interval elapsed,
+// failed batches are retried with jittered exponential backoff.
+package client
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/protobuf/proto"
+	"github.com/mostynb/go-grpc-compression/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	ErrClosed         = errkit.Error("Client is closed")
+	ErrBufferFull     = errkit.Error("Client buffer is full")
+	ErrContextMissing = errkit.Error("Timber context is required")
+
+	DefaultMaxBatchItems    = 500
+	DefaultMaxBatchBytes    = 1 << 20
+	DefaultFlushInterval    = time.Second
+	DefaultMaxBufferedItems = 10000
+	DefaultMaxRetries       = 5
+	DefaultMinBackoff       = 100 * time.Millisecond
+	DefaultMaxBackoff       = 30 * time.Second
+	DefaultRateLimitBackoff = time.Second
+	DefaultRequestTimeout   = 10 * time.Second
+)
+
+// Config of the client, zero values are replaced by the defaults.
+// Context is the app context of every batch, it is required.
+type Config struct {
+	Address     string
+	DialOptions []grpc.DialOption
+	Context     *pb.TimberContext
+
+	MaxBatchItems    int
+	MaxBatchBytes    int
+	FlushInterval    time.Duration
+	MaxBufferedItems int
+
+	MaxRetries       int
+	MinBackoff       time.Duration
+	MaxBackoff       time.Duration
+	RateLimitBackoff time.Duration
+	RequestTimeout   time.Duration
+
+	// OnDelivery is called from the sender goroutine after each batch is sent or given up
+	OnDelivery func(Delivery)
+	// Registerer registers the client metrics, nil means the metrics are not registered
+	Registerer prometheus.Registerer
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxBatchItems <= 0 {
+		c.MaxBatchItems = DefaultMaxBatchItems
+	}
+	if c.MaxBatchBytes <= 0 {
+		c.MaxBatchBytes = DefaultMaxBatchBytes
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = DefaultFlushInterval
+	}
+	if c.MaxBufferedItems <= 0 {
+		c.MaxBufferedItems = DefaultMaxBufferedItems
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = DefaultMinBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.RateLimitBackoff <= 0 {
+		c.RateLimitBackoff = DefaultRateLimitBackoff
+	}
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = DefaultRequestTimeout
+	}
+	return c
+}
+
+// Delivery is the result of a batch, Err is nil when the producer accepted the batch
+type Delivery struct {
+	Items    []*pb.Timber
+	Attempts int
+	Err      error
+}
+
+// Client buffers timbers and sends them to the producer in background, it is safe for concurrent use
+type Client struct {
+	config   Config
+	conn     *grpc.ClientConn
+	producer pb.ProducerClient
+	metrics  *metrics
+
+	mu       sync.Mutex
+	closed   bool
+	batch    []*pb.Timber
+	bytes    int
+	buffered int
+	gen      int
+
+	batches chan []*pb.Timber
+	wg      sync.WaitGroup
+}
+
+// New connects to the producer on config.Address, the connection is made in background
+func New(config Config) (*Client, error) {
+	if config.Context == nil {
+		return nil, ErrContextMissing
+	}
+
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(zstd.Name)),
+	}, config.DialOptions...)
+
+	conn, err := grpc.NewClient(config.Address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(pb.NewProducerClient(conn), conn, config), nil
+}
+
+func newClient(producer pb.ProducerClient, conn *grpc.ClientConn, config Config) *Client {
+	config = config.withDefaults()
+
+	c := &Client{
+		config:   config,
+		conn:     conn,
+		producer: producer,
+		metrics:  newMetrics(config.Registerer, config.Context.GetKafkaTopic()),
+		batches:  make(chan []*pb.Timber, config.MaxBufferedItems),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Send buffers timber, the context of timber is ignored in favor of the client context
+func (c *Client) Send(timber *pb.Timber) error {
+	size := proto.Size(timber)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	if c.buffered >= c.config.MaxBufferedItems {
+		c.metrics.items.WithLabelValues(resultDropped).Inc()
+		return ErrBufferFull
+	}
+
+	if len(c.batch) > 0 && c.bytes+size > c.config.MaxBatchBytes {
+		c.flushLocked()
+	}
+
+	c.batch = append(c.batch, timber)
+	c.bytes += size
+	c.buffered++
+	c.metrics.buffered.Set(float64(c.buffered))
+
+	if len(c.batch) == 1 {
+		gen := c.gen
+		time.AfterFunc(c.config.FlushInterval, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.gen == gen && !c.closed {
+				c.flushLocked()
+			}
+		})
+	}
+	if len(c.batch) >= c.config.MaxBatchItems {
+		c.flushLocked()
+	}
+	return nil
+}
+
+// SendContent buffers a timber of fields, the values must be accepted by structpb.NewValue
+func (c *Client) SendContent(fields map[string]interface{}) error {
+	content, err := structpb.NewStruct(fields)
+	if err != nil {
+		return err
+	}
+	return c.Send(&pb.Timber{Content: content})
+}
+
+// Flush queues the current batch without waiting for the flush interval
+func (c *Client) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.flushLocked()
+	}
+}
+
+// Close sends the buffered timbers, waits until every batch is delivered or given up and closes the connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.flushLocked()
+	c.closed = true
+	close(c.batches)
+	c.mu.Unlock()
+
+	c.wg.Wait()
+
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// flushLocked queues the current batch, caller must hold the lock.
+// The queue never blocks because it holds at least as many batches as buffered items.
+func (c *Client) flushLocked() {
+	if len(c.batch) == 0 {
+		return
+	}
+
+	c.batches <- c.batch
+	c.batch, c.bytes = nil, 0
+	c.gen++
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+
+	for batch := range c.batches {
+		c.deliver(batch)
+	}
+}
+
+func (c *Client) deliver(batch []*pb.Timber) {
+	timberCollection := &pb.TimberCollection{
+		Context: c.config.Context,
+		Items:   batch,
+	}
+
+	var err error
+	attempt := 0
+	for {
+		attempt++
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.RequestTimeout)
+		startTime := time.Now()
+		_, err = c.producer.ProduceBatch(ctx, timberCollection)
+		cancel()
+
+		c.metrics.requestDuration.Observe(time.Since(startTime).Seconds())
+		c.metrics.requests.WithLabelValues(status.Code(err).String()).Inc()
+
+		if err == nil || !retryable(err) || attempt > c.config.MaxRetries {
+			break
+		}
+
+		c.metrics.retries.Inc()
+		time.Sleep(c.backoff(attempt, err))
+	}
+
+	c.mu.Lock()
+	c.buffered -= len(batch)
+	c.metrics.buffered.Set(float64(c.buffered))
+	c.mu.Unlock()
+
+	if err != nil {
+		c.metrics.items.WithLabelValues(resultFailed).Add(float64(len(batch)))
+	} else {
+		c.metrics.items.WithLabelValues(resultSent).Add(float64(len(batch)))
+	}
+
+	if c.config.OnDelivery != nil {
+		c.config.OnDelivery(Delivery{Items: batch, Attempts: attempt, Err: err})
+	}
+}
+
+// backoff returns the wait before the next attempt, it is jittered between the half and the whole of the exponential backoff.
+// Rate limited batches wait at least RateLimitBackoff since the producer bucket is only refilled periodically.
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	d := c.config.MinBackoff << uint(attempt-1)
+	if d <= 0 || d > c.config.MaxBackoff {
+		d = c.config.MaxBackoff
+	}
+	if status.Code(err) == codes.ResourceExhausted && d < c.config.RateLimitBackoff {
+		d = c.config.RateLimitBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable returns true when the producer may accept the batch later, e.g. rate limited or kafka is unavailable
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted, codes.FailedPrecondition:
+		return true
+	}
+	return false
+}
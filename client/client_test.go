@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow"
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/mock"
+	"github.com/BaritoLog/barito-flow/prome"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMain(m *testing.M) {
+	prome.InitProducerInstrumentation()
+	os.Exit(m.Run())
+}
+
+// startProducer runs an in-process producer with mocked kafka, the produced timber collections are sent into the returned channel
+func startProducer(t *testing.T, limiter flow.RateLimiter) (string, chan *pb.TimberCollection) {
+	ctrl := gomock.NewController(t)
+
+	messages := make(chan *pb.TimberCollection, 100)
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).AnyTimes().DoAndReturn(func(message *sarama.ProducerMessage) (int32, int64, error) {
+		b, _ := message.Value.Encode()
+		timberCollection := &pb.TimberCollection{}
+		if err := proto.Unmarshal(b, timberCollection); err != nil {
+			return 0, 0, err
+		}
+		messages <- timberCollection
+		return 0, 0, nil
+	})
+	producer.EXPECT().Close().AnyTimes()
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+	admin.EXPECT().Close().AnyTimes()
+
+	factory := flow.NewDummyKafkaFactory()
+	factory.MakeSyncProducerFunc = func() (sarama.SyncProducer, error) { return producer, nil }
+	factory.MakeKafkaAdminFunc = func() (types.KafkaAdmin, error) { return admin, nil }
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	FatalIfError(t, err)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	service := flow.NewProducerService(map[string]interface{}{
+		"factory":            factory,
+		"grpcAddr":           addr,
+		"topicPrefix":        "",
+		"topicSuffix":        "_logs",
+		"kafkaMaxRetry":      1,
+		"kafkaRetryInterval": 1,
+		"newEventTopic":      "new_topic_events",
+		"grpcMaxRecvMsgSize": 20 * 1000 * 1000,
+		"ignoreKafkaOptions": false,
+		"kafkaMessageFormat": flow.TimberCollectionMessageFormat,
+		"limiter":            limiter,
+	})
+	go service.Start()
+	t.Cleanup(service.Close)
+
+	return addr, messages
+}
+
+func newTestClient(t *testing.T, addr string, config Config) (*Client, chan Delivery) {
+	deliveries := make(chan Delivery, 100)
+
+	config.Address = addr
+	config.Context = pb.SampleTimberContextProto()
+	config.DialOptions = []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.WaitForReady(true))}
+	config.MinBackoff = time.Millisecond
+	config.RateLimitBackoff = time.Millisecond
+	config.OnDelivery = func(delivery Delivery) { deliveries <- delivery }
+
+	c, err := New(config)
+	FatalIfError(t, err)
+	return c, deliveries
+}
+
+func waitDelivery(t *testing.T, deliveries chan Delivery) Delivery {
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no delivery")
+	}
+	return Delivery{}
+}
+
+func TestNew_ContextMissing(t *testing.T) {
+	_, err := New(Config{Address: "localhost:8080"})
+	FatalIfWrongError(t, err, string(ErrContextMissing))
+}
+
+func TestClient_FlushOnBatchSize(t *testing.T) {
+	addr, messages := startProducer(t, flow.NewDummyRateLimiter())
+	c, deliveries := newTestClient(t, addr, Config{MaxBatchItems: 3, FlushInterval: time.Hour})
+	defer c.Close()
+
+	for i := 0; i < 6; i++ {
+		FatalIfError(t, c.SendContent(map[string]interface{}{"message": "some-message", "seq": i}))
+	}
+
+	for i := 0; i < 2; i++ {
+		delivery := waitDelivery(t, deliveries)
+		FatalIfError(t, delivery.Err)
+		FatalIf(t, len(delivery.Items) != 3, "wrong batch size %d", len(delivery.Items))
+
+		timberCollection := <-messages
+		FatalIf(t, timberCollection.GetContext().GetKafkaTopic() != "some_topic", "wrong context %v", timberCollection.GetContext())
+		FatalIf(t, len(timberCollection.GetItems()) != 3, "wrong items %d", len(timberCollection.GetItems()))
+		seq := timberCollection.GetItems()[0].GetContent().GetFields()["seq"].GetNumberValue()
+		FatalIf(t, seq != float64(i*3), "batches should be sent in order, got seq %v", seq)
+	}
+}
+
+func TestClient_FlushOnInterval(t *testing.T) {
+	addr, messages := startProducer(t, flow.NewDummyRateLimiter())
+	c, deliveries := newTestClient(t, addr, Config{FlushInterval: 20 * time.Millisecond})
+	defer c.Close()
+
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+
+	delivery := waitDelivery(t, deliveries)
+	FatalIf(t, delivery.Err != nil || delivery.Attempts != 1, "wrong delivery %+v", delivery)
+	FatalIf(t, len((<-messages).GetItems()) != 1, "timber should be produced")
+}
+
+func TestClient_RetryRateLimited(t *testing.T) {
+	var calls int32
+	limiter := flow.NewDummyRateLimiter()
+	limiter.IsHitLimitFunc = func(topic string, count int, maxTokenIfNotExist int32) bool {
+		return atomic.AddInt32(&calls, 1) <= 2
+	}
+
+	addr, messages := startProducer(t, limiter)
+	registry := prometheus.NewRegistry()
+	c, deliveries := newTestClient(t, addr, Config{FlushInterval: time.Hour, Registerer: registry})
+	defer c.Close()
+
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+	c.Flush()
+
+	delivery := waitDelivery(t, deliveries)
+	FatalIf(t, delivery.Err != nil || delivery.Attempts != 3, "rate limited batch should be retried, got %+v", delivery)
+	FatalIf(t, len((<-messages).GetItems()) != 1, "timber should be produced")
+
+	expected := `
+		# HELP barito_client_retries_total Number of ProduceBatch retries
+		# TYPE barito_client_retries_total counter
+		barito_client_retries_total{app="some_topic"} 2
+		# HELP barito_client_items_total Number of timbers sent, failed after retries or dropped on full buffer
+		# TYPE barito_client_items_total counter
+		barito_client_items_total{app="some_topic",result="sent"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "barito_client_retries_total", "barito_client_items_total"))
+}
+
+func TestClient_GiveUp(t *testing.T) {
+	limiter := flow.NewDummyRateLimiter()
+	limiter.Expect_IsHitLimit_AlwaysTrue()
+
+	addr, _ := startProducer(t, limiter)
+	c, deliveries := newTestClient(t, addr, Config{FlushInterval: time.Hour, MaxRetries: 2, MaxBackoff: time.Millisecond})
+	defer c.Close()
+
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+	c.Flush()
+
+	delivery := waitDelivery(t, deliveries)
+	FatalIf(t, status.Code(delivery.Err) != codes.ResourceExhausted, "expected ResourceExhausted, got %v", delivery.Err)
+	FatalIf(t, delivery.Attempts != 3, "expected 3 attempts, got %d", delivery.Attempts)
+}
+
+func TestClient_NoRetryInvalidArgument(t *testing.T) {
+	addr, _ := startProducer(t, flow.NewDummyRateLimiter())
+	c, deliveries := newTestClient(t, addr, Config{FlushInterval: time.Hour})
+	defer c.Close()
+
+	FatalIfError(t, c.Send(&pb.Timber{}))
+	c.Flush()
+
+	delivery := waitDelivery(t, deliveries)
+	FatalIf(t, status.Code(delivery.Err) != codes.InvalidArgument, "expected InvalidArgument, got %v", delivery.Err)
+	FatalIf(t, delivery.Attempts != 1, "invalid batch should not be retried, got %d attempts", delivery.Attempts)
+}
+
+func TestClient_Close(t *testing.T) {
+	addr, messages := startProducer(t, flow.NewDummyRateLimiter())
+	c, deliveries := newTestClient(t, addr, Config{FlushInterval: time.Hour})
+
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+	FatalIfError(t, c.Close())
+
+	FatalIf(t, len(deliveries) != 1, "close should wait for the delivery")
+	FatalIf(t, len((<-messages).GetItems()) != 2, "close should flush the buffer")
+
+	FatalIfWrongError(t, c.Send(pb.SampleTimberProto()), string(ErrClosed))
+	FatalIfWrongError(t, c.Close(), string(ErrClosed))
+}
+
+type blockingProducerClient struct {
+	pb.ProducerClient
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProducerClient) ProduceBatch(ctx context.Context, in *pb.TimberCollection, opts ...grpc.CallOption) (*pb.ProduceResult, error) {
+	p.once.Do(func() { close(p.started) })
+	<-p.release
+	return &pb.ProduceResult{}, nil
+}
+
+func TestClient_BufferFull(t *testing.T) {
+	producer := &blockingProducerClient{started: make(chan struct{}), release: make(chan struct{})}
+	registry := prometheus.NewRegistry()
+	c := newClient(producer, nil, Config{
+		Context:          pb.SampleTimberContextProto(),
+		MaxBatchItems:    1,
+		MaxBufferedItems: 2,
+		Registerer:       registry,
+	})
+
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+	<-producer.started
+	FatalIfError(t, c.Send(pb.SampleTimberProto()))
+	FatalIfWrongError(t, c.Send(pb.SampleTimberProto()), string(ErrBufferFull))
+
+	close(producer.release)
+	FatalIfError(t, c.Close())
+
+	expected := `
+		# HELP barito_client_items_total Number of timbers sent, failed after retries or dropped on full buffer
+		# TYPE barito_client_items_total counter
+		barito_client_items_total{app="some_topic",result="dropped"} 1
+		barito_client_items_total{app="some_topic",result="sent"} 2
+	`
+	FatalIfError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "barito_client_items_total"))
+}
+
+func TestClient_Backoff(t *testing.T) {
+	c := &Client{config: Config{
+		MinBackoff:       100 * time.Millisecond,
+		MaxBackoff:       time.Second,
+		RateLimitBackoff: 500 * time.Millisecond,
+	}}
+
+	unavailable := status.Error(codes.Unavailable, "some-error")
+	rateLimited := status.Error(codes.ResourceExhausted, "some-error")
+
+	testCases := []struct {
+		attempt  int
+		err      error
+		expected time.Duration
+	}{
+		{1, unavailable, 100 * time.Millisecond},
+		{3, unavailable, 400 * time.Millisecond},
+		{10, unavailable, time.Second},
+		{100, unavailable, time.Second},
+		{1, rateLimited, 500 * time.Millisecond},
+		{4, rateLimited, 800 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		d := c.backoff(tc.attempt, tc.err)
+		FatalIf(t, d < tc.expected/2 || d > tc.expected, "attempt %d of %v: %s is not within jitter of %s", tc.attempt, tc.err, d, tc.expected)
+	}
+}
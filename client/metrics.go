@@ -0,0 +1,54 @@
+package client
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	resultSent    = "sent"
+	resultFailed  = "failed"
+	resultDropped = "dropped"
+)
+
+type metrics struct {
+	items           *prometheus.CounterVec
+	requests        *prometheus.CounterVec
+	retries         prometheus.Counter
+	requestDuration prometheus.Histogram
+	buffered        prometheus.Gauge
+}
+
+// newMetrics creates the metrics of the client of app, they are only registered when registerer is not nil
+func newMetrics(registerer prometheus.Registerer, app string) *metrics {
+	factory := promauto.With(registerer)
+	labels := prometheus.Labels{"app": app}
+
+	return &metrics{
+		items: factory.NewCounterVec(prometheus.CounterOpts{
+			Name:        "barito_client_items_total",
+			Help:        "Number of timbers sent, failed after retries or dropped on full buffer",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		requests: factory.NewCounterVec(prometheus.CounterOpts{
+			Name:        "barito_client_requests_total",
+			Help:        "Number of ProduceBatch requests by gRPC status code",
+			ConstLabels: labels,
+		}, []string{"code"}),
+		retries: factory.NewCounter(prometheus.CounterOpts{
+			Name:        "barito_client_retries_total",
+			Help:        "Number of ProduceBatch retries",
+			ConstLabels: labels,
+		}),
+		requestDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:        "barito_client_request_duration_seconds",
+			Help:        "Duration of ProduceBatch requests",
+			ConstLabels: labels,
+		}),
+		buffered: factory.NewGauge(prometheus.GaugeOpts{
+			Name:        "barito_client_buffered_items",
+			Help:        "Number of timbers waiting to be delivered",
+			ConstLabels: labels,
+		}),
+	}
+}
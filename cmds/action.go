@@ -2,7 +2,11 @@ package cmds
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net/http"
+	"os"
+	"regexp"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -54,21 +58,39 @@ func ActionBaritoConsumerService(c *cli.Context) (err error) {
 	config.Consumer.Group.Heartbeat.Interval = time.Duration(configConsumerGroupHeartbeatInterval()) * time.Second
 	config.Consumer.MaxProcessingTime = time.Duration(configConsumerMaxProcessingTime()) * time.Millisecond
 	config.ChannelBufferSize = configConsumerChannelBufferSize()
-	if configConsumerRebalancingStrategy() == "RoundRobin" {
-		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	} else if configConsumerRebalancingStrategy() == "Range" {
-		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
-	}
+	setupRebalanceStrategy(config)
 
 	factory := flow.NewKafkaFactory(brokers, config)
 
+	indexNamer, err := setupIndexNamer()
+	if err != nil {
+		return
+	}
+
+	var indexTemplates []flow.IndexTemplate
+	if indexTemplateDir := configEsIndexTemplateDir(); indexTemplateDir != "" {
+		if indexTemplates, err = flow.LoadIndexTemplates(indexTemplateDir); err != nil {
+			return
+		}
+	}
+
 	esConfig := flow.NewEsConfig(
 		esIndexMethod,
 		esBulkSize,
 		time.Duration(esFlushIntervalMs),
 		printTPS,
 		configEsDatastreamDefaultComponentTemplateName(),
-	)
+	).WithBulkRetry(
+		configEsBulkMaxRetries(),
+		time.Duration(configEsBulkRetryMinBackoffMs()),
+		time.Duration(configEsBulkRetryMaxBackoffMs()),
+	).WithIndexNamer(indexNamer).WithRollover(flow.RolloverConfig{
+		MaxPrimaryShardSize: configEsRolloverMaxPrimaryShardSize(),
+		MaxAge:              configEsRolloverMaxAge(),
+		DeleteAfter:         configEsRolloverDeleteAfter(),
+		Shards:              configEsRolloverShards(),
+		Replicas:            configEsRolloverReplicas(),
+	}).WithIndexTemplates(indexTemplates).WithClient(configEsClient())
 
 	consumerParams := map[string]interface{}{
 		"factory":                factory,
@@ -86,6 +108,18 @@ func ActionBaritoConsumerService(c *cli.Context) (err error) {
 		"elasticUsername":        elasticUsername,
 		"elasticPassword":        elasticPassword,
 		"redactor":               setupRedactor(),
+		"maxUncommittedOffsets":  configConsumerMaxUncommittedOffsets(),
+		"deadLetterTopic":        configConsumerDeadLetterTopic(),
+	}
+
+	consumerParams["consumerMode"] = configConsumerMode()
+	consumerParams["subscriptionRefreshInterval"] = time.Duration(configConsumerSubscriptionRefreshInterval()) * time.Second
+	consumerParams["subscriptionDelay"] = time.Duration(configConsumerSubscriptionDelay()) * time.Second
+	consumerParams["workerPoolSize"] = configConsumerWorkerPoolSize()
+	if topicPattern := configConsumerTopicPattern(); topicPattern != "" {
+		if consumerParams["topicPattern"], err = regexp.Compile(topicPattern); err != nil {
+			return
+		}
 	}
 
 	// if elasticsearch using mTLS
@@ -95,6 +129,11 @@ func ActionBaritoConsumerService(c *cli.Context) (err error) {
 		consumerParams["elasticClientKey"] = configElasticClientKey()
 	}
 
+	shutdownTracing, err := setupTracing("barito-flow-consumer")
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing. %w", err)
+	}
+
 	service := flow.NewBaritoConsumerService(consumerParams)
 
 	callbackInstrumentation()
@@ -103,8 +142,63 @@ func ActionBaritoConsumerService(c *cli.Context) (err error) {
 		return
 	}
 
-	srvkit.GracefullShutdown(service.Close)
+	srvkit.GracefullShutdown(func() {
+		service.Close()
+		shutdownTracing()
+	})
+
+	return
+}
+
+// ActionRedriveDeadLetter redrives the dead letter topic of the consumer once, e.g. after the index mapping is fixed
+func ActionRedriveDeadLetter(c *cli.Context) (err error) {
+	if c.Bool("verbose") == true {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	prome.InitConsumerInstrumentation()
+
+	topic := configConsumerDeadLetterTopic()
+	if topic == "" {
+		return fmt.Errorf("%s is required", EnvConsumerDeadLetterTopic)
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_6_0_0 // TODO: get version from env
+	config.Consumer.Offsets.CommitInterval = time.Second
+	factory := flow.NewKafkaFactory(configKafkaBrokers(), config)
+
+	httpClient := &http.Client{}
+	if elasticCaCrt := configElasticCaCrt(); elasticCaCrt != "" {
+		if httpClient, err = flow.NewHttpClientWithTLS(elasticCaCrt, configElasticClientCrt(), configElasticClientKey()); err != nil {
+			return
+		}
+	}
+
+	retrier := flow.NewElasticRetrier(
+		timekit.Duration(configElasticsearchRetrierInterval()),
+		configElasticsearchRetrierMaxRetry(),
+		func(err error) { log.Warnf("Elasticsearch retry: %s", err) },
+		func() {},
+	)
+	esConfig := flow.NewEsConfig(configEsIndexMethod(), configEsBulkSize(), time.Duration(configEsFlushIntervalMs()), false,
+		configEsDatastreamDefaultComponentTemplateName()).WithClient(configEsClient())
+	esClient, err := flow.NewElastic(retrier, esConfig, configElasticsearchUrls(), configElasticUsername(), configElasticPassword(), httpClient)
+	if err != nil {
+		return
+	}
+
+	redriver := flow.NewDeadLetterRedriver(flow.DeadLetterRedriveConfig{
+		Topic:       topic,
+		GroupID:     c.String("group"),
+		ErrorType:   c.String("error"),
+		IdleTimeout: c.Duration("idle"),
+	}, factory, &esClient)
 
+	redriven, err := redriver.Run()
+	fmt.Fprintf(os.Stderr, "Redriven %d dead letters of %s\n", redriven, topic)
 	return
 }
 
@@ -188,13 +282,109 @@ func ActionBaritoProducerService(c *cli.Context) (err error) {
 		"ignoreKafkaOptions": ignoreKafkaOptions,
 		"limiter":            rateLimiter,
 		"kafkaMessageFormat": kafkaMessageFormat,
+		"peerGuard":          setupPeerGuard(),
+		"validator": flow.NewTimberValidator(
+			configProducerMaxTimberFields(),
+			configProducerMaxTimberFieldSize(),
+			configProducerMaxTimberSize(),
+		),
+		"microBatch": flow.MicroBatchConfig{
+			Window:   time.Duration(configProducerMicroBatchWindowMs()) * time.Millisecond,
+			MaxItems: configProducerMicroBatchMaxItems(),
+			MaxBytes: configProducerMicroBatchMaxBytes(),
+		},
+		"sharedTopic": flow.SharedTopicConfig{
+			Count:             configProducerSharedTopicCount(),
+			MaxTps:            int32(configProducerSharedTopicMaxTPS()),
+			Name:              configProducerSharedTopicName(),
+			Partitions:        int32(configProducerSharedTopicPartitions()),
+			ReplicationFactor: int32(configProducerSharedTopicReplicationFactor()),
+		},
+	}
+
+	if configProducerOtlp() {
+		producerParams["otlp"] = flow.OtlpConfig{
+			HttpAddr:     configProducerOtlpAddressHttp(),
+			AppAttribute: configProducerOtlpAppAttribute(),
+			AppHeader:    configProducerOtlpAppHeader(),
+			AppMaxTps:    int32(configProducerMaxTPS()),
+		}
+	}
+
+	syslogConfig, err := setupSyslog()
+	if err != nil {
+		return fmt.Errorf("failed to setup syslog listener. %w", err)
+	}
+	if syslogConfig != nil {
+		producerParams["syslog"] = *syslogConfig
+	}
+
+	fluentForwardConfig, err := setupFluentForward()
+	if err != nil {
+		return fmt.Errorf("failed to setup fluent forward listener. %w", err)
+	}
+	if fluentForwardConfig != nil {
+		producerParams["fluentForward"] = *fluentForwardConfig
+	}
+
+	esBulkConfig, err := setupEsBulk()
+	if err != nil {
+		return fmt.Errorf("failed to setup elasticsearch bulk endpoint. %w", err)
+	}
+	if esBulkConfig != nil {
+		producerParams["esBulk"] = *esBulkConfig
+	}
+
+	if tailAddr := configProducerTailAddress(); tailAddr != "" {
+		producerParams["tail"] = flow.TailConfig{
+			Addr:          tailAddr,
+			MaxConcurrent: configProducerTailMaxConcurrent(),
+			GroupPrefix:   configProducerTailGroupPrefix(),
+		}
+	}
+
+	if standbyBrokers := configProducerStandbyKafkaBrokers(); len(standbyBrokers) > 0 {
+		producerParams["kafkaFailover"] = flow.KafkaFailoverConfig{
+			Standby:             flow.NewKafkaFactory(standbyBrokers, config),
+			ErrorThreshold:      configProducerKafkaFailoverErrorThreshold(),
+			FailbackThreshold:   configProducerKafkaFailbackThreshold(),
+			HealthCheckInterval: timekit.Duration(configProducerKafkaHealthCheckInterval()),
+		}
+	}
+
+	if schemaSource := configProducerSchemaSource(); schemaSource != "" {
+		schemaValidator, err := flow.NewSchemaValidator(flow.SchemaConfig{
+			Mode:         configProducerSchemaMode(),
+			Source:       schemaSource,
+			PollInterval: timekit.Duration(configProducerSchemaPollInterval()),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to setup schema validator. %w", err)
+		}
+		producerParams["schemaValidator"] = schemaValidator
+	}
+
+	mirrorConfig, err := setupMirror(config)
+	if err != nil {
+		return fmt.Errorf("failed to setup kafka mirror. %w", err)
+	}
+	if mirrorConfig != nil {
+		producerParams["mirror"] = *mirrorConfig
+	}
+
+	shutdownTracing, err := setupTracing("barito-flow-producer")
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing. %w", err)
 	}
 
 	service := flow.NewProducerService(producerParams)
 
 	go service.Start()
 
-	srvkit.GracefullShutdown(service.Close)
+	srvkit.GracefullShutdown(func() {
+		service.Close()
+		shutdownTracing()
+	})
 	return
 }
 
@@ -215,11 +405,16 @@ func ActionBaritoConsumerGCSService(c *cli.Context) (err error) {
 	config.Consumer.Group.Session.Timeout = time.Duration(configConsumerGroupSessionTimeout()) * time.Second
 	config.Consumer.Group.Heartbeat.Interval = time.Duration(configConsumerGroupHeartbeatInterval()) * time.Second
 	config.Consumer.MaxProcessingTime = time.Duration(configConsumerMaxProcessingTime()) * time.Millisecond
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	setupRebalanceStrategy(config)
 
 	kafkaFactory := flow.NewKafkaFactory(brokers, config)
 	consumerOutputFactory := flow.NewConsumerOutputFactory()
 
+	shutdownTracing, err := setupTracing("barito-flow-consumer-gcs")
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing. %w", err)
+	}
+
 	service := flow.NewBaritoKafkaConsumerGCSFromEnv(kafkaFactory, consumerOutputFactory)
 
 	callbackInstrumentation()
@@ -228,7 +423,10 @@ func ActionBaritoConsumerGCSService(c *cli.Context) (err error) {
 		return
 	}
 
-	srvkit.GracefullShutdown(service.Close)
+	srvkit.GracefullShutdown(func() {
+		service.Close()
+		shutdownTracing()
+	})
 
 	return
 }
@@ -282,6 +480,195 @@ func setupRedisRateLimiter(_ context.Context,
 	), nil
 }
 
+func setupPeerGuard() *flow.PeerGuard {
+	return flow.NewPeerGuard(flow.PeerGuardConfig{
+		MaxConnections:       configProducerMaxConnections(),
+		MaxConcurrentStreams: configProducerPeerMaxConcurrentStreams(),
+		MaxRequestsPerSecond: int32(configProducerPeerMaxRequestsPerSecond()),
+		BanThreshold:         configProducerPeerBanThreshold(),
+		BanDuration:          time.Duration(configProducerPeerBanDuration()) * time.Second,
+	})
+}
+
+func setupSyslog() (*flow.SyslogConfig, error) {
+	config := &flow.SyslogConfig{
+		UDPAddr:    configProducerSyslogAddressUdp(),
+		TCPAddr:    configProducerSyslogAddressTcp(),
+		TLSAddr:    configProducerSyslogAddressTls(),
+		DefaultApp: configProducerSyslogDefaultApp(),
+		AppMaxTps:  int32(configProducerMaxTPS()),
+	}
+	if config.UDPAddr == "" && config.TCPAddr == "" && config.TLSAddr == "" {
+		return nil, nil
+	}
+
+	for _, s := range configProducerSyslogRules() {
+		rule, err := flow.ParseSyslogRule(s)
+		if err != nil {
+			return nil, err
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	if config.TLSAddr != "" {
+		cert, err := tls.LoadX509KeyPair(configProducerSyslogTlsCrt(), configProducerSyslogTlsKey())
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	return config, nil
+}
+
+func setupFluentForward() (*flow.FluentForwardConfig, error) {
+	config := &flow.FluentForwardConfig{
+		Addr:       configProducerFluentForwardAddress(),
+		SharedKey:  configProducerFluentForwardSharedKey(),
+		DefaultApp: configProducerFluentForwardDefaultApp(),
+		AppMaxTps:  int32(configProducerMaxTPS()),
+	}
+	config.MaxMessageSize = configProducerFluentForwardMaxMessageSize()
+	if config.Addr == "" {
+		return nil, nil
+	}
+
+	for _, s := range configProducerFluentForwardRules() {
+		rule, err := flow.ParseFluentForwardRule(s)
+		if err != nil {
+			return nil, err
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	if crt := configProducerFluentForwardTlsCrt(); crt != "" {
+		cert, err := tls.LoadX509KeyPair(crt, configProducerFluentForwardTlsKey())
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	return config, nil
+}
+
+func setupEsBulk() (*flow.EsBulkConfig, error) {
+	config := &flow.EsBulkConfig{
+		Addr:       configProducerEsBulkAddress(),
+		Version:    configProducerEsBulkVersion(),
+		DefaultApp: configProducerEsBulkDefaultApp(),
+		AppMaxTps:  int32(configProducerMaxTPS()),
+	}
+	if config.Addr == "" {
+		return nil, nil
+	}
+
+	for _, s := range configProducerEsBulkRules() {
+		rule, err := flow.ParseEsBulkRule(s)
+		if err != nil {
+			return nil, err
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+
+	return config, nil
+}
+
+// setupIndexNamer returns the naming of the elasticsearch indices, the rules override the default template per index prefix.
+// With event time routing, the catch all index is named in the default timezone.
+func setupIndexNamer() (*flow.IndexNamer, error) {
+	location, err := flow.LoadIndexNameLocation(configEsIndexTimezone())
+	if err != nil {
+		return nil, err
+	}
+
+	namer := &flow.IndexNamer{}
+	if namer.Default, err = flow.NewIndexNameTemplate(configEsIndexNameTemplate(), location); err != nil {
+		return nil, err
+	}
+	for _, s := range configEsIndexNameRules() {
+		rule, err := flow.ParseIndexNameRule(s)
+		if err != nil {
+			return nil, err
+		}
+		namer.Rules = append(namer.Rules, rule)
+	}
+
+	if namer.EventTime = configEsEventTimeRouting(); namer.EventTime {
+		namer.MaxLateness = time.Duration(configEsEventTimeMaxLateness()) * time.Second
+		namer.MaxAhead = time.Duration(configEsEventTimeMaxAhead()) * time.Second
+		if namer.CatchAll, err = flow.NewIndexNameTemplate(configEsCatchAllIndexNameTemplate(), location); err != nil {
+			return nil, err
+		}
+	}
+
+	return namer, nil
+}
+
+// setupRebalanceStrategy sets the partition assignment of the consumer groups, the sticky one keeps the partitions
+// of the remaining members on rebalance so fewer in-flight messages are revoked and consumed again
+func setupRebalanceStrategy(config *sarama.Config) {
+	switch configConsumerRebalancingStrategy() {
+	case "RoundRobin":
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	case "Range":
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	case "Sticky":
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky
+	}
+}
+
+// setupMirror returns the mirror config of the secondary kafka cluster, it shares the producer config of the primary cluster
+func setupMirror(config *sarama.Config) (*flow.MirrorConfig, error) {
+	brokers := configProducerMirrorKafkaBrokers()
+	if len(brokers) == 0 {
+		return nil, nil
+	}
+
+	mirrorConfig := &flow.MirrorConfig{
+		Factory:   flow.NewKafkaFactory(brokers, config),
+		Percent:   configProducerMirrorPercent(),
+		QueueSize: configProducerMirrorQueueSize(),
+	}
+	for _, s := range configProducerMirrorRules() {
+		rule, err := flow.ParseMirrorRule(s)
+		if err != nil {
+			return nil, err
+		}
+		mirrorConfig.Rules = append(mirrorConfig.Rules, rule)
+	}
+
+	return mirrorConfig, nil
+}
+
+// setupTracing installs the tracer provider when the exporter is set, the returned func flushes the pending spans
+func setupTracing(serviceName string) (func(), error) {
+	exporter := configTracingExporter()
+	if exporter == "" {
+		return func() {}, nil
+	}
+
+	shutdown, err := flow.InitTracing(flow.TracingConfig{
+		Exporter:    exporter,
+		Endpoint:    configTracingEndpoint(),
+		Insecure:    configTracingInsecure(),
+		File:        configTracingFile(),
+		ServiceName: serviceName,
+		SampleRatio: configTracingSampleRatio(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Errorf("Failed to flush traces: %s", err)
+		}
+	}, nil
+}
+
 func setupRedactor() *redact.Redactor {
 	var redactor *redact.Redactor
 	var err error
@@ -20,9 +20,26 @@ const (
 
 	EnvElasticsearchUrls                        = "BARITO_ELASTICSEARCH_URLS"
 	EnvEsIndexMethod                            = "BARITO_ELASTICSEARCH_INDEX_METHOD"
+	EnvEsClient                                 = "BARITO_ELASTICSEARCH_CLIENT"
 	EnvEsBulkSize                               = "BARITO_ELASTICSEARCH_BULK_SIZE"
 	EnvEsFlushIntervalMs                        = "BARITO_ELASTICSEARCH_FLUSH_INTERVAL_MS"
 	EnvEsDatastreamDefaultComponentTemplateName = "BARITO_ELASTICSEARCH_DATASTREAM_DEFAULT_COMPONENT_TEMPLATE_NAME"
+	EnvEsBulkMaxRetries                         = "BARITO_ELASTICSEARCH_BULK_MAX_RETRIES"
+	EnvEsBulkRetryMinBackoffMs                  = "BARITO_ELASTICSEARCH_BULK_RETRY_MIN_BACKOFF_MS"
+	EnvEsBulkRetryMaxBackoffMs                  = "BARITO_ELASTICSEARCH_BULK_RETRY_MAX_BACKOFF_MS"
+	EnvEsIndexNameTemplate                      = "BARITO_ELASTICSEARCH_INDEX_NAME_TEMPLATE"
+	EnvEsIndexTimezone                          = "BARITO_ELASTICSEARCH_INDEX_TIMEZONE"
+	EnvEsIndexNameRules                         = "BARITO_ELASTICSEARCH_INDEX_NAME_RULES"
+	EnvEsEventTimeRouting                       = "BARITO_ELASTICSEARCH_EVENT_TIME_ROUTING"
+	EnvEsEventTimeMaxLateness                   = "BARITO_ELASTICSEARCH_EVENT_TIME_MAX_LATENESS"
+	EnvEsEventTimeMaxAhead                      = "BARITO_ELASTICSEARCH_EVENT_TIME_MAX_AHEAD"
+	EnvEsCatchAllIndexNameTemplate              = "BARITO_ELASTICSEARCH_CATCH_ALL_INDEX_NAME_TEMPLATE"
+	EnvEsRolloverMaxPrimaryShardSize            = "BARITO_ELASTICSEARCH_ROLLOVER_MAX_PRIMARY_SHARD_SIZE"
+	EnvEsRolloverMaxAge                         = "BARITO_ELASTICSEARCH_ROLLOVER_MAX_AGE"
+	EnvEsRolloverDeleteAfter                    = "BARITO_ELASTICSEARCH_ROLLOVER_DELETE_AFTER"
+	EnvEsRolloverShards                         = "BARITO_ELASTICSEARCH_ROLLOVER_SHARDS"
+	EnvEsRolloverReplicas                       = "BARITO_ELASTICSEARCH_ROLLOVER_REPLICAS"
+	EnvEsIndexTemplateDir                       = "BARITO_ELASTICSEARCH_INDEX_TEMPLATE_DIR"
 
 	EnvGrpcMaxRecvMsgSize = "BARITO_GRPC_MAX_RECV_MSG_SIZE"
 
@@ -41,6 +58,77 @@ const (
 	EnvProducerIgnoreKafkaOptions     = "BARITO_PRODUCER_IGNORE_KAFKA_OPTIONS"
 	EnvProducerMaxMessageBytes        = "BARITO_PRODUCER_MAX_MESSAGE_BYTES"
 
+	EnvProducerMaxConnections           = "BARITO_PRODUCER_MAX_CONNECTIONS"
+	EnvProducerPeerMaxConcurrentStreams = "BARITO_PRODUCER_PEER_MAX_CONCURRENT_STREAMS"
+	EnvProducerPeerMaxRequestsPerSecond = "BARITO_PRODUCER_PEER_MAX_REQUESTS_PER_SECOND"
+	EnvProducerPeerBanThreshold         = "BARITO_PRODUCER_PEER_BAN_THRESHOLD"
+	EnvProducerPeerBanDuration          = "BARITO_PRODUCER_PEER_BAN_DURATION"
+
+	EnvProducerMaxTimberFields    = "BARITO_PRODUCER_MAX_TIMBER_FIELDS"
+	EnvProducerMaxTimberFieldSize = "BARITO_PRODUCER_MAX_TIMBER_FIELD_BYTES"
+	EnvProducerMaxTimberSize      = "BARITO_PRODUCER_MAX_TIMBER_BYTES"
+
+	EnvProducerMicroBatchWindowMs = "BARITO_PRODUCER_MICRO_BATCH_WINDOW_MS"
+	EnvProducerMicroBatchMaxItems = "BARITO_PRODUCER_MICRO_BATCH_MAX_ITEMS"
+	EnvProducerMicroBatchMaxBytes = "BARITO_PRODUCER_MICRO_BATCH_MAX_BYTES"
+
+	EnvProducerSharedTopicCount             = "BARITO_PRODUCER_SHARED_TOPIC_COUNT"
+	EnvProducerSharedTopicMaxTPS            = "BARITO_PRODUCER_SHARED_TOPIC_MAX_TPS"
+	EnvProducerSharedTopicName              = "BARITO_PRODUCER_SHARED_TOPIC_NAME"
+	EnvProducerSharedTopicPartitions        = "BARITO_PRODUCER_SHARED_TOPIC_PARTITIONS"
+	EnvProducerSharedTopicReplicationFactor = "BARITO_PRODUCER_SHARED_TOPIC_REPLICATION_FACTOR"
+
+	EnvProducerOtlp             = "BARITO_PRODUCER_OTLP"
+	EnvProducerOtlpAddressHttp  = "BARITO_PRODUCER_OTLP_HTTP"
+	EnvProducerOtlpAppAttribute = "BARITO_PRODUCER_OTLP_APP_ATTRIBUTE"
+	EnvProducerOtlpAppHeader    = "BARITO_PRODUCER_OTLP_APP_HEADER"
+
+	EnvProducerSyslogAddressUdp = "BARITO_PRODUCER_SYSLOG_UDP"
+	EnvProducerSyslogAddressTcp = "BARITO_PRODUCER_SYSLOG_TCP"
+	EnvProducerSyslogAddressTls = "BARITO_PRODUCER_SYSLOG_TLS"
+	EnvProducerSyslogTlsCrt     = "BARITO_PRODUCER_SYSLOG_TLS_CRT"
+	EnvProducerSyslogTlsKey     = "BARITO_PRODUCER_SYSLOG_TLS_KEY"
+	EnvProducerSyslogRules      = "BARITO_PRODUCER_SYSLOG_RULES"
+	EnvProducerSyslogDefaultApp = "BARITO_PRODUCER_SYSLOG_DEFAULT_APP"
+
+	EnvProducerFluentForwardAddress    = "BARITO_PRODUCER_FLUENT_FORWARD"
+	EnvProducerFluentForwardTlsCrt     = "BARITO_PRODUCER_FLUENT_FORWARD_TLS_CRT"
+	EnvProducerFluentForwardTlsKey     = "BARITO_PRODUCER_FLUENT_FORWARD_TLS_KEY"
+	EnvProducerFluentForwardSharedKey  = "BARITO_PRODUCER_FLUENT_FORWARD_SHARED_KEY"
+	EnvProducerFluentForwardRules      = "BARITO_PRODUCER_FLUENT_FORWARD_RULES"
+	EnvProducerFluentForwardDefaultApp = "BARITO_PRODUCER_FLUENT_FORWARD_DEFAULT_APP"
+
+	EnvProducerFluentForwardMaxMessageSize = "BARITO_PRODUCER_FLUENT_FORWARD_MAX_MESSAGE_SIZE"
+
+	EnvProducerEsBulkAddress    = "BARITO_PRODUCER_ES_BULK"
+	EnvProducerEsBulkVersion    = "BARITO_PRODUCER_ES_BULK_VERSION"
+	EnvProducerEsBulkRules      = "BARITO_PRODUCER_ES_BULK_RULES"
+	EnvProducerEsBulkDefaultApp = "BARITO_PRODUCER_ES_BULK_DEFAULT_APP"
+
+	EnvProducerTailAddress       = "BARITO_PRODUCER_TAIL"
+	EnvProducerTailMaxConcurrent = "BARITO_PRODUCER_TAIL_MAX_CONCURRENT"
+	EnvProducerTailGroupPrefix   = "BARITO_PRODUCER_TAIL_GROUP_PREFIX"
+
+	EnvProducerMirrorKafkaBrokers = "BARITO_PRODUCER_MIRROR_KAFKA_BROKERS"
+	EnvProducerMirrorPercent      = "BARITO_PRODUCER_MIRROR_PERCENT"
+	EnvProducerMirrorRules        = "BARITO_PRODUCER_MIRROR_RULES"
+	EnvProducerMirrorQueueSize    = "BARITO_PRODUCER_MIRROR_QUEUE_SIZE"
+
+	EnvProducerStandbyKafkaBrokers         = "BARITO_PRODUCER_STANDBY_KAFKA_BROKERS"
+	EnvProducerKafkaFailoverErrorThreshold = "BARITO_PRODUCER_KAFKA_FAILOVER_ERROR_THRESHOLD"
+	EnvProducerKafkaFailbackThreshold      = "BARITO_PRODUCER_KAFKA_FAILBACK_THRESHOLD"
+	EnvProducerKafkaHealthCheckInterval    = "BARITO_PRODUCER_KAFKA_HEALTH_CHECK_INTERVAL"
+
+	EnvProducerSchemaSource       = "BARITO_PRODUCER_SCHEMA_SOURCE"
+	EnvProducerSchemaMode         = "BARITO_PRODUCER_SCHEMA_MODE"
+	EnvProducerSchemaPollInterval = "BARITO_PRODUCER_SCHEMA_POLL_INTERVAL"
+
+	EnvTracingExporter    = "BARITO_TRACING_EXPORTER"
+	EnvTracingEndpoint    = "BARITO_TRACING_ENDPOINT"
+	EnvTracingInsecure    = "BARITO_TRACING_INSECURE"
+	EnvTracingFile        = "BARITO_TRACING_FILE"
+	EnvTracingSampleRatio = "BARITO_TRACING_SAMPLE_RATIO"
+
 	EnvConsulUrl               = "BARITO_CONSUL_URL"
 	EnvConsulKafkaName         = "BARITO_CONSUL_KAFKA_NAME"
 	EnvConsulElasticsearchName = "BARITO_CONSUL_ELASTICSEARCH_NAME"
@@ -54,6 +142,13 @@ const (
 	EnvConsumerGroupHeartbeatInterval       = "BARITO_CONSUMER_GROUP_HEARTBEAT_INTERVAL"
 	EnvConsumerMaxProcessingTime            = "BARITO_CONSUMER_MAX_PROCESSING_TIME"
 	EnvConsumerChannelBufferSize            = "BARITO_CONSUMER_CHANNEL_BUFFER_SIZE"
+	EnvConsumerMaxUncommittedOffsets        = "BARITO_CONSUMER_MAX_UNCOMMITTED_OFFSETS"
+	EnvConsumerDeadLetterTopic              = "BARITO_CONSUMER_DEAD_LETTER_TOPIC"
+	EnvConsumerMode                         = "BARITO_CONSUMER_MODE"
+	EnvConsumerTopicPattern                 = "BARITO_CONSUMER_TOPIC_PATTERN"
+	EnvConsumerSubscriptionRefreshInterval  = "BARITO_CONSUMER_SUBSCRIPTION_REFRESH_INTERVAL"
+	EnvConsumerSubscriptionDelay            = "BARITO_CONSUMER_SUBSCRIPTION_DELAY"
+	EnvConsumerWorkerPoolSize               = "BARITO_CONSUMER_WORKER_POOL_SIZE"
 
 	EnvPrintTPS = "BARITO_PRINT_TPS"
 
@@ -106,18 +201,112 @@ var (
 	DefaultProducerIgnoreKafkaOptions     = "false"
 	DefaultProducerMaxMessageBytes        = 1000000 // Should be set equal to or smaller than the broker's `message.max.bytes`.
 
+	DefaultProducerMaxConnections           = 0 // 0 means unlimited
+	DefaultProducerPeerMaxConcurrentStreams = 0
+	DefaultProducerPeerMaxRequestsPerSecond = 0
+	DefaultProducerPeerBanThreshold         = 0 // 0 means peers are never banned
+	DefaultProducerPeerBanDuration          = 300
+
+	DefaultProducerMaxTimberFields    = 1000 // same as elasticsearch default `index.mapping.total_fields.limit`
+	DefaultProducerMaxTimberFieldSize = 0    // 0 means unlimited
+	DefaultProducerMaxTimberSize      = 0
+
+	DefaultProducerMicroBatchWindowMs = 0 // 0 means micro batching is disabled
+	DefaultProducerMicroBatchMaxItems = 500
+	DefaultProducerMicroBatchMaxBytes = 500000 // keep it below `BARITO_PRODUCER_MAX_MESSAGE_BYTES`
+
+	DefaultProducerSharedTopicCount             = 0 // 0 means every app has its own topic
+	DefaultProducerSharedTopicMaxTPS            = 10
+	DefaultProducerSharedTopicName              = "shared"
+	DefaultProducerSharedTopicPartitions        = -1 // -1 means using broker default
+	DefaultProducerSharedTopicReplicationFactor = -1
+
+	DefaultProducerOtlp             = false
+	DefaultProducerOtlpAddressHttp  = ":4318" // empty means OTLP is only served over gRPC
+	DefaultProducerOtlpAppAttribute = "service.name"
+	DefaultProducerOtlpAppHeader    = "x-barito-app-name"
+
+	DefaultProducerSyslogAddressUdp = "" // empty means the listener is disabled
+	DefaultProducerSyslogAddressTcp = ""
+	DefaultProducerSyslogAddressTls = ""
+	DefaultProducerSyslogTlsCrt     = ""
+	DefaultProducerSyslogTlsKey     = ""
+	DefaultProducerSyslogRules      = []string{}
+	DefaultProducerSyslogDefaultApp = "" // empty means messages without matching rule are dropped
+
+	DefaultProducerFluentForwardAddress    = "" // empty means the listener is disabled
+	DefaultProducerFluentForwardTlsCrt     = ""
+	DefaultProducerFluentForwardTlsKey     = ""
+	DefaultProducerFluentForwardSharedKey  = "" // empty means authentication is disabled
+	DefaultProducerFluentForwardRules      = []string{}
+	DefaultProducerFluentForwardDefaultApp = ""
+
+	DefaultProducerFluentForwardMaxMessageSize = 8 << 20
+
+	DefaultProducerEsBulkAddress    = "" // empty means the endpoint is disabled
+	DefaultProducerEsBulkVersion    = "7.17.0"
+	DefaultProducerEsBulkRules      = []string{}
+	DefaultProducerEsBulkDefaultApp = "" // empty means documents without matching rule are rejected
+
+	DefaultProducerTailAddress       = "" // empty means the endpoint is disabled
+	DefaultProducerTailMaxConcurrent = 10
+	DefaultProducerTailGroupPrefix   = "barito-tail"
+
+	DefaultProducerMirrorKafkaBrokers = []string{} // empty means mirroring is disabled
+	DefaultProducerMirrorPercent      = 100.0
+	DefaultProducerMirrorRules        = []string{}
+	DefaultProducerMirrorQueueSize    = 10000
+
+	DefaultProducerStandbyKafkaBrokers         = []string{} // empty means failover is disabled
+	DefaultProducerKafkaFailoverErrorThreshold = 5
+	DefaultProducerKafkaFailbackThreshold      = 3
+	DefaultProducerKafkaHealthCheckInterval    = "10s"
+
+	DefaultProducerSchemaSource       = "" // empty means schema validation is disabled
+	DefaultProducerSchemaMode         = "metrics"
+	DefaultProducerSchemaPollInterval = "1m"
+
+	DefaultTracingExporter    = "" // empty means tracing is disabled
+	DefaultTracingEndpoint    = "localhost:4317"
+	DefaultTracingInsecure    = false
+	DefaultTracingFile        = "traces.json"
+	DefaultTracingSampleRatio = 1.0
+
 	DefaultNewTopicEventName                        = "new_topic_events"
 	DefaultElasticsearchRetrierInterval             = "30s"
 	DefaultElasticsearchRetrierMaxRetry             = 10
 	DefaultConsumerRebalancingStrategy              = "RoundRobin"
 	DefaultEsIndexMethod                            = "BulkProcessor"
+	DefaultEsClient                                 = "ES7"
 	DefaultEsDatastreamDefaultComponentTemplateName = "barito-default-replica"
 	DefaultEsBulkSize                               = 100
 	DefaultEsFlushIntervalMs                        = 500
+	DefaultEsBulkMaxRetries                         = 5
+	DefaultEsBulkRetryMinBackoffMs                  = 200
+	DefaultEsBulkRetryMaxBackoffMs                  = 30000
+	DefaultEsIndexNameTemplate                      = "{prefix}-{date:2006.01.02}"
+	DefaultEsIndexTimezone                          = "Local"
+	DefaultEsIndexNameRules                         = []string{}
+	DefaultEsEventTimeRouting                       = false
+	DefaultEsEventTimeMaxLateness                   = 604800 // 7 days
+	DefaultEsEventTimeMaxAhead                      = 300
+	DefaultEsCatchAllIndexNameTemplate              = "{prefix}-catch-all-{date:2006.01.02}"
+	DefaultEsRolloverMaxPrimaryShardSize            = "50gb"
+	DefaultEsRolloverMaxAge                         = "1d"
+	DefaultEsRolloverDeleteAfter                    = "" // empty means the rolled over indices are kept
+	DefaultEsRolloverShards                         = 1
+	DefaultEsRolloverReplicas                       = 1
+	DefaultEsIndexTemplateDir                       = "" // empty means indices are created with dynamic mappings and cluster defaults
 	DefaultConsumerGroupSessionTimeout              = 20
 	DefaultConsumerGroupHeartbeatInterval           = 6
 	DefaultConsumerMaxProcessingTime                = 500
 	DefaultConsumerChannelBufferSize                = 256
+	DefaultConsumerMaxUncommittedOffsets            = 100000
+	DefaultConsumerMode                             = "PerTopic"
+	DefaultConsumerTopicPattern                     = "" // empty means the log topics are matched by prefix and suffix
+	DefaultConsumerSubscriptionRefreshInterval      = 30
+	DefaultConsumerSubscriptionDelay                = 300
+	DefaultConsumerWorkerPoolSize                   = 16
 
 	DefaultPrintTPS = "false"
 
@@ -173,6 +362,10 @@ func configEsIndexMethod() (s string) {
 	return stringEnvOrDefault(EnvEsIndexMethod, DefaultEsIndexMethod)
 }
 
+func configEsClient() (s string) {
+	return stringEnvOrDefault(EnvEsClient, DefaultEsClient)
+}
+
 func configEsBulkSize() (i int) {
 	return intEnvOrDefault(EnvEsBulkSize, DefaultEsBulkSize)
 }
@@ -181,6 +374,70 @@ func configEsFlushIntervalMs() (i int) {
 	return intEnvOrDefault(EnvEsFlushIntervalMs, DefaultEsFlushIntervalMs)
 }
 
+func configEsBulkMaxRetries() (i int) {
+	return intEnvOrDefault(EnvEsBulkMaxRetries, DefaultEsBulkMaxRetries)
+}
+
+func configEsBulkRetryMinBackoffMs() (i int) {
+	return intEnvOrDefault(EnvEsBulkRetryMinBackoffMs, DefaultEsBulkRetryMinBackoffMs)
+}
+
+func configEsBulkRetryMaxBackoffMs() (i int) {
+	return intEnvOrDefault(EnvEsBulkRetryMaxBackoffMs, DefaultEsBulkRetryMaxBackoffMs)
+}
+
+func configEsIndexNameTemplate() (s string) {
+	return stringEnvOrDefault(EnvEsIndexNameTemplate, DefaultEsIndexNameTemplate)
+}
+
+func configEsIndexTimezone() (s string) {
+	return stringEnvOrDefault(EnvEsIndexTimezone, DefaultEsIndexTimezone)
+}
+
+func configEsIndexNameRules() (slice []string) {
+	return sliceEnvOrDefault(EnvEsIndexNameRules, ",", DefaultEsIndexNameRules)
+}
+
+func configEsEventTimeRouting() bool {
+	return boolEnvOrDefault(EnvEsEventTimeRouting, DefaultEsEventTimeRouting)
+}
+
+func configEsEventTimeMaxLateness() (i int) {
+	return intEnvOrDefault(EnvEsEventTimeMaxLateness, DefaultEsEventTimeMaxLateness)
+}
+
+func configEsEventTimeMaxAhead() (i int) {
+	return intEnvOrDefault(EnvEsEventTimeMaxAhead, DefaultEsEventTimeMaxAhead)
+}
+
+func configEsCatchAllIndexNameTemplate() (s string) {
+	return stringEnvOrDefault(EnvEsCatchAllIndexNameTemplate, DefaultEsCatchAllIndexNameTemplate)
+}
+
+func configEsRolloverMaxPrimaryShardSize() (s string) {
+	return stringEnvOrDefault(EnvEsRolloverMaxPrimaryShardSize, DefaultEsRolloverMaxPrimaryShardSize)
+}
+
+func configEsRolloverMaxAge() (s string) {
+	return stringEnvOrDefault(EnvEsRolloverMaxAge, DefaultEsRolloverMaxAge)
+}
+
+func configEsRolloverDeleteAfter() (s string) {
+	return stringEnvOrDefault(EnvEsRolloverDeleteAfter, DefaultEsRolloverDeleteAfter)
+}
+
+func configEsRolloverShards() (i int) {
+	return intEnvOrDefault(EnvEsRolloverShards, DefaultEsRolloverShards)
+}
+
+func configEsRolloverReplicas() (i int) {
+	return intEnvOrDefault(EnvEsRolloverReplicas, DefaultEsRolloverReplicas)
+}
+
+func configEsIndexTemplateDir() (s string) {
+	return stringEnvOrDefault(EnvEsIndexTemplateDir, DefaultEsIndexTemplateDir)
+}
+
 func configEsDatastreamDefaultComponentTemplateName() (s string) {
 	return stringEnvOrDefault(EnvEsDatastreamDefaultComponentTemplateName, DefaultEsDatastreamDefaultComponentTemplateName)
 }
@@ -265,6 +522,214 @@ func configProducerMaxMessageBytes() (i int) {
 	return intEnvOrDefault(EnvProducerMaxMessageBytes, DefaultProducerMaxMessageBytes)
 }
 
+func configProducerMaxConnections() (i int) {
+	return intEnvOrDefault(EnvProducerMaxConnections, DefaultProducerMaxConnections)
+}
+
+func configProducerPeerMaxConcurrentStreams() (i int) {
+	return intEnvOrDefault(EnvProducerPeerMaxConcurrentStreams, DefaultProducerPeerMaxConcurrentStreams)
+}
+
+func configProducerPeerMaxRequestsPerSecond() (i int) {
+	return intEnvOrDefault(EnvProducerPeerMaxRequestsPerSecond, DefaultProducerPeerMaxRequestsPerSecond)
+}
+
+func configProducerPeerBanThreshold() (i int) {
+	return intEnvOrDefault(EnvProducerPeerBanThreshold, DefaultProducerPeerBanThreshold)
+}
+
+func configProducerPeerBanDuration() (i int) {
+	return intEnvOrDefault(EnvProducerPeerBanDuration, DefaultProducerPeerBanDuration)
+}
+
+func configProducerMaxTimberFields() (i int) {
+	return intEnvOrDefault(EnvProducerMaxTimberFields, DefaultProducerMaxTimberFields)
+}
+
+func configProducerMaxTimberFieldSize() (i int) {
+	return intEnvOrDefault(EnvProducerMaxTimberFieldSize, DefaultProducerMaxTimberFieldSize)
+}
+
+func configProducerMaxTimberSize() (i int) {
+	return intEnvOrDefault(EnvProducerMaxTimberSize, DefaultProducerMaxTimberSize)
+}
+
+func configProducerMicroBatchWindowMs() (i int) {
+	return intEnvOrDefault(EnvProducerMicroBatchWindowMs, DefaultProducerMicroBatchWindowMs)
+}
+
+func configProducerMicroBatchMaxItems() (i int) {
+	return intEnvOrDefault(EnvProducerMicroBatchMaxItems, DefaultProducerMicroBatchMaxItems)
+}
+
+func configProducerMicroBatchMaxBytes() (i int) {
+	return intEnvOrDefault(EnvProducerMicroBatchMaxBytes, DefaultProducerMicroBatchMaxBytes)
+}
+
+func configProducerSharedTopicCount() (i int) {
+	return intEnvOrDefault(EnvProducerSharedTopicCount, DefaultProducerSharedTopicCount)
+}
+
+func configProducerSharedTopicMaxTPS() (i int) {
+	return intEnvOrDefault(EnvProducerSharedTopicMaxTPS, DefaultProducerSharedTopicMaxTPS)
+}
+
+func configProducerSharedTopicName() (s string) {
+	return stringEnvOrDefault(EnvProducerSharedTopicName, DefaultProducerSharedTopicName)
+}
+
+func configProducerSharedTopicPartitions() (i int) {
+	return intEnvOrDefault(EnvProducerSharedTopicPartitions, DefaultProducerSharedTopicPartitions)
+}
+
+func configProducerSharedTopicReplicationFactor() (i int) {
+	return intEnvOrDefault(EnvProducerSharedTopicReplicationFactor, DefaultProducerSharedTopicReplicationFactor)
+}
+
+func configProducerOtlp() bool {
+	return boolEnvOrDefault(EnvProducerOtlp, DefaultProducerOtlp)
+}
+
+func configProducerOtlpAddressHttp() (s string) {
+	return stringEnvOrDefault(EnvProducerOtlpAddressHttp, DefaultProducerOtlpAddressHttp)
+}
+
+func configProducerOtlpAppAttribute() (s string) {
+	return stringEnvOrDefault(EnvProducerOtlpAppAttribute, DefaultProducerOtlpAppAttribute)
+}
+
+func configProducerOtlpAppHeader() (s string) {
+	return stringEnvOrDefault(EnvProducerOtlpAppHeader, DefaultProducerOtlpAppHeader)
+}
+
+func configProducerSyslogAddressUdp() (s string) {
+	return stringEnvOrDefault(EnvProducerSyslogAddressUdp, DefaultProducerSyslogAddressUdp)
+}
+
+func configProducerSyslogAddressTcp() (s string) {
+	return stringEnvOrDefault(EnvProducerSyslogAddressTcp, DefaultProducerSyslogAddressTcp)
+}
+
+func configProducerSyslogAddressTls() (s string) {
+	return stringEnvOrDefault(EnvProducerSyslogAddressTls, DefaultProducerSyslogAddressTls)
+}
+
+func configProducerSyslogTlsCrt() (s string) {
+	return stringEnvOrDefault(EnvProducerSyslogTlsCrt, DefaultProducerSyslogTlsCrt)
+}
+
+func configProducerSyslogTlsKey() (s string) {
+	return stringEnvOrDefault(EnvProducerSyslogTlsKey, DefaultProducerSyslogTlsKey)
+}
+
+func configProducerSyslogRules() (slice []string) {
+	return sliceEnvOrDefault(EnvProducerSyslogRules, ",", DefaultProducerSyslogRules)
+}
+
+func configProducerSyslogDefaultApp() (s string) {
+	return stringEnvOrDefault(EnvProducerSyslogDefaultApp, DefaultProducerSyslogDefaultApp)
+}
+
+func configProducerFluentForwardAddress() (s string) {
+	return stringEnvOrDefault(EnvProducerFluentForwardAddress, DefaultProducerFluentForwardAddress)
+}
+
+func configProducerFluentForwardTlsCrt() (s string) {
+	return stringEnvOrDefault(EnvProducerFluentForwardTlsCrt, DefaultProducerFluentForwardTlsCrt)
+}
+
+func configProducerFluentForwardTlsKey() (s string) {
+	return stringEnvOrDefault(EnvProducerFluentForwardTlsKey, DefaultProducerFluentForwardTlsKey)
+}
+
+func configProducerFluentForwardSharedKey() (s string) {
+	return stringEnvOrDefault(EnvProducerFluentForwardSharedKey, DefaultProducerFluentForwardSharedKey)
+}
+
+func configProducerFluentForwardRules() (slice []string) {
+	return sliceEnvOrDefault(EnvProducerFluentForwardRules, ",", DefaultProducerFluentForwardRules)
+}
+
+func configProducerFluentForwardDefaultApp() (s string) {
+	return stringEnvOrDefault(EnvProducerFluentForwardDefaultApp, DefaultProducerFluentForwardDefaultApp)
+}
+
+func configProducerFluentForwardMaxMessageSize() (i int) {
+	return intEnvOrDefault(EnvProducerFluentForwardMaxMessageSize, DefaultProducerFluentForwardMaxMessageSize)
+}
+
+func configProducerEsBulkAddress() (s string) {
+	return stringEnvOrDefault(EnvProducerEsBulkAddress, DefaultProducerEsBulkAddress)
+}
+
+func configProducerEsBulkVersion() (s string) {
+	return stringEnvOrDefault(EnvProducerEsBulkVersion, DefaultProducerEsBulkVersion)
+}
+
+func configProducerEsBulkRules() (slice []string) {
+	return sliceEnvOrDefault(EnvProducerEsBulkRules, ",", DefaultProducerEsBulkRules)
+}
+
+func configProducerEsBulkDefaultApp() (s string) {
+	return stringEnvOrDefault(EnvProducerEsBulkDefaultApp, DefaultProducerEsBulkDefaultApp)
+}
+
+func configProducerTailAddress() (s string) {
+	return stringEnvOrDefault(EnvProducerTailAddress, DefaultProducerTailAddress)
+}
+
+func configProducerTailMaxConcurrent() (i int) {
+	return intEnvOrDefault(EnvProducerTailMaxConcurrent, DefaultProducerTailMaxConcurrent)
+}
+
+func configProducerTailGroupPrefix() (s string) {
+	return stringEnvOrDefault(EnvProducerTailGroupPrefix, DefaultProducerTailGroupPrefix)
+}
+
+func configProducerMirrorKafkaBrokers() (slice []string) {
+	return sliceEnvOrDefault(EnvProducerMirrorKafkaBrokers, ",", DefaultProducerMirrorKafkaBrokers)
+}
+
+func configProducerMirrorPercent() (f float64) {
+	return floatEnvOrDefault(EnvProducerMirrorPercent, DefaultProducerMirrorPercent)
+}
+
+func configProducerMirrorRules() (slice []string) {
+	return sliceEnvOrDefault(EnvProducerMirrorRules, ",", DefaultProducerMirrorRules)
+}
+
+func configProducerMirrorQueueSize() (i int) {
+	return intEnvOrDefault(EnvProducerMirrorQueueSize, DefaultProducerMirrorQueueSize)
+}
+
+func configProducerStandbyKafkaBrokers() (slice []string) {
+	return sliceEnvOrDefault(EnvProducerStandbyKafkaBrokers, ",", DefaultProducerStandbyKafkaBrokers)
+}
+
+func configProducerKafkaFailoverErrorThreshold() (i int) {
+	return intEnvOrDefault(EnvProducerKafkaFailoverErrorThreshold, DefaultProducerKafkaFailoverErrorThreshold)
+}
+
+func configProducerKafkaFailbackThreshold() (i int) {
+	return intEnvOrDefault(EnvProducerKafkaFailbackThreshold, DefaultProducerKafkaFailbackThreshold)
+}
+
+func configProducerKafkaHealthCheckInterval() (s string) {
+	return stringEnvOrDefault(EnvProducerKafkaHealthCheckInterval, DefaultProducerKafkaHealthCheckInterval)
+}
+
+func configProducerSchemaSource() (s string) {
+	return stringEnvOrDefault(EnvProducerSchemaSource, DefaultProducerSchemaSource)
+}
+
+func configProducerSchemaMode() (s string) {
+	return stringEnvOrDefault(EnvProducerSchemaMode, DefaultProducerSchemaMode)
+}
+
+func configProducerSchemaPollInterval() (s string) {
+	return stringEnvOrDefault(EnvProducerSchemaPollInterval, DefaultProducerSchemaPollInterval)
+}
+
 func configConsulKafkaName() (s string) {
 	return stringEnvOrDefault(EnvConsulKafkaName, DefaultConsulKafkaName)
 }
@@ -314,6 +779,34 @@ func configConsumerChannelBufferSize() int {
 	return intEnvOrDefault(EnvConsumerChannelBufferSize, DefaultConsumerChannelBufferSize)
 }
 
+func configConsumerMaxUncommittedOffsets() int {
+	return intEnvOrDefault(EnvConsumerMaxUncommittedOffsets, DefaultConsumerMaxUncommittedOffsets)
+}
+
+func configConsumerDeadLetterTopic() string {
+	return stringEnvOrDefault(EnvConsumerDeadLetterTopic, "")
+}
+
+func configConsumerMode() string {
+	return stringEnvOrDefault(EnvConsumerMode, DefaultConsumerMode)
+}
+
+func configConsumerTopicPattern() string {
+	return stringEnvOrDefault(EnvConsumerTopicPattern, DefaultConsumerTopicPattern)
+}
+
+func configConsumerSubscriptionRefreshInterval() int {
+	return intEnvOrDefault(EnvConsumerSubscriptionRefreshInterval, DefaultConsumerSubscriptionRefreshInterval)
+}
+
+func configConsumerSubscriptionDelay() int {
+	return intEnvOrDefault(EnvConsumerSubscriptionDelay, DefaultConsumerSubscriptionDelay)
+}
+
+func configConsumerWorkerPoolSize() int {
+	return intEnvOrDefault(EnvConsumerWorkerPoolSize, DefaultConsumerWorkerPoolSize)
+}
+
 func configPrintTPS() bool {
 	return stringEnvOrDefault(EnvPrintTPS, DefaultPrintTPS) == "true"
 }
@@ -377,6 +870,26 @@ func configConsulRedisName() (s string) {
 	return stringEnvOrDefault(EnvConsulRedisName, DefaultConsulRedisName)
 }
 
+func configTracingExporter() (s string) {
+	return stringEnvOrDefault(EnvTracingExporter, DefaultTracingExporter)
+}
+
+func configTracingEndpoint() (s string) {
+	return stringEnvOrDefault(EnvTracingEndpoint, DefaultTracingEndpoint)
+}
+
+func configTracingInsecure() bool {
+	return boolEnvOrDefault(EnvTracingInsecure, DefaultTracingInsecure)
+}
+
+func configTracingFile() (s string) {
+	return stringEnvOrDefault(EnvTracingFile, DefaultTracingFile)
+}
+
+func configTracingSampleRatio() float64 {
+	return floatEnvOrDefault(EnvTracingSampleRatio, DefaultTracingSampleRatio)
+}
+
 func stringEnvOrDefault(key, defaultValue string) string {
 	s := os.Getenv(key)
 	if len(s) > 0 {
@@ -411,6 +924,18 @@ func intEnvOrDefault(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func floatEnvOrDefault(key string, defaultValue float64) float64 {
+	s := os.Getenv(key)
+	f, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		logConfig("env", key, f)
+		return f
+	}
+
+	logConfig("default", key, defaultValue)
+	return defaultValue
+}
+
 func sliceEnvOrDefault(key, separator string, defaultSlice []string) []string {
 	s := os.Getenv(key)
 
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -32,6 +33,18 @@ const (
 	ErrSpawnWorkerOnNewTopic = errkit.Error("Spawn worker on new topic failed")
 	ErrSpawnWorker           = errkit.Error("Span worker failed")
 	ErrHaltWorker            = errkit.Error("Consumer Worker Halted")
+	ErrMissingIndexPrefix    = errkit.Error("Timber context has no es_index_prefix")
+	ErrEnsureIndexTemplates  = errkit.Error("Ensure index templates failed")
+	ErrConsumerMode          = errkit.Error("Unknown consumer mode")
+
+	// ConsumerModePerTopic consumes every log topic with its own consumer, new topics are found from the new topic events
+	ConsumerModePerTopic = "PerTopic"
+	// ConsumerModeSingleGroup consumes every log topic with a single consumer group refreshing its subscription from metadata
+	ConsumerModeSingleGroup = "SingleGroup"
+
+	DefaultSubscriptionRefreshInterval = 30 * time.Second
+	DefaultSubscriptionDelay           = 5 * time.Minute
+	DefaultWorkerPoolSize              = 16
 
 	PrefixEventGroupID          = "nte"
 	TimberConvertErrorIndexName = "no_index"
@@ -71,6 +84,16 @@ type baritoConsumerService struct {
 
 	elasticUsername string
 	elasticPassword string
+
+	maxUncommittedOffsets int
+	deadLetterTopic       string
+	deadLetter            *deadLetterQueue
+
+	consumerMode                string
+	topicPattern                *regexp.Regexp
+	subscriptionRefreshInterval time.Duration
+	subscriptionDelay           time.Duration
+	workerPoolSize              int
 }
 
 func NewBaritoConsumerService(params map[string]interface{}) BaritoConsumerService {
@@ -92,6 +115,38 @@ func NewBaritoConsumerService(params map[string]interface{}) BaritoConsumerServi
 		redactor:               params["redactor"].(Redactor),
 	}
 
+	if maxUncommittedOffsets, ok := params["maxUncommittedOffsets"]; ok {
+		s.maxUncommittedOffsets = maxUncommittedOffsets.(int)
+	}
+
+	if deadLetterTopic, ok := params["deadLetterTopic"]; ok {
+		s.deadLetterTopic = deadLetterTopic.(string)
+	}
+
+	s.consumerMode = ConsumerModePerTopic
+	if consumerMode, ok := params["consumerMode"]; ok && consumerMode.(string) != "" {
+		s.consumerMode = consumerMode.(string)
+	}
+
+	if topicPattern, ok := params["topicPattern"]; ok {
+		s.topicPattern = topicPattern.(*regexp.Regexp)
+	}
+
+	s.subscriptionRefreshInterval = DefaultSubscriptionRefreshInterval
+	if refreshInterval, ok := params["subscriptionRefreshInterval"]; ok && refreshInterval.(time.Duration) > 0 {
+		s.subscriptionRefreshInterval = refreshInterval.(time.Duration)
+	}
+
+	s.subscriptionDelay = DefaultSubscriptionDelay
+	if subscriptionDelay, ok := params["subscriptionDelay"]; ok && subscriptionDelay.(time.Duration) >= 0 {
+		s.subscriptionDelay = subscriptionDelay.(time.Duration)
+	}
+
+	s.workerPoolSize = DefaultWorkerPoolSize
+	if workerPoolSize, ok := params["workerPoolSize"]; ok && workerPoolSize.(int) > 0 {
+		s.workerPoolSize = workerPoolSize.(int)
+	}
+
 	httpClient := &http.Client{}
 	// if using mTLS, create new http client with tls config
 	if _, ok := params["elasticCaCrt"]; ok {
@@ -114,14 +169,24 @@ func NewBaritoConsumerService(params map[string]interface{}) BaritoConsumerServi
 }
 
 func (s *baritoConsumerService) newHttpClientWithTLS(caCrt, clientCrt, clientKey string) *http.Client {
+	client, err := NewHttpClientWithTLS(caCrt, clientCrt, clientKey)
+	if err != nil {
+		s.logError(errkit.Concat(errors.New("Failed to create http client with tls"), err))
+		panic(err)
+	}
+
+	return client
+}
+
+// NewHttpClientWithTLS returns http client of elasticsearch using mTLS
+func NewHttpClientWithTLS(caCrt, clientCrt, clientKey string) (*http.Client, error) {
 	caCert, _ := os.ReadFile(caCrt)
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM(caCert)
 
 	cert, err := tls.LoadX509KeyPair(clientCrt, clientKey)
 	if err != nil {
-		s.logError(errkit.Concat(errors.New("Failed to create http client with tls"), err))
-		panic(err)
+		return nil, err
 	}
 
 	client := &http.Client{
@@ -133,10 +198,17 @@ func (s *baritoConsumerService) newHttpClientWithTLS(caCrt, clientCrt, clientKey
 		},
 	}
 
-	return client
+	return client, nil
 }
 
 func (s *baritoConsumerService) Start() (err error) {
+	switch s.consumerMode {
+	case "":
+		s.consumerMode = ConsumerModePerTopic
+	case ConsumerModePerTopic, ConsumerModeSingleGroup:
+	default:
+		return errkit.Concat(ErrConsumerMode, errkit.Error(s.consumerMode))
+	}
 
 	admin, err := s.initAdmin()
 	if err != nil {
@@ -144,19 +216,47 @@ func (s *baritoConsumerService) Start() (err error) {
 		return
 	}
 
-	uuid, _ := uuid.NewV4()
-	s.eventWorkerGroupID = fmt.Sprintf("%s-%s", PrefixEventGroupID, uuid)
-	log.Infof("Generate event worker group id: %s", s.eventWorkerGroupID)
+	// the single consumer group finds the new topics itself
+	if s.consumerMode == ConsumerModePerTopic {
+		uuid, _ := uuid.NewV4()
+		s.eventWorkerGroupID = fmt.Sprintf("%s-%s", PrefixEventGroupID, uuid)
+		log.Infof("Generate event worker group id: %s", s.eventWorkerGroupID)
 
-	worker, err := s.initNewTopicWorker(s.eventWorkerGroupID)
-	if err != nil {
-		err = errkit.Concat(ErrMakeNewTopicWorker, err)
-		s.logError(err)
-		prome.IncreaseConsumerTimberConvertError(TimberConvertErrorIndexName)
-		return
+		var worker types.ConsumerWorker
+		worker, err = s.initNewTopicWorker(s.eventWorkerGroupID)
+		if err != nil {
+			err = errkit.Concat(ErrMakeNewTopicWorker, err)
+			s.logError(err)
+			prome.IncreaseConsumerTimberConvertError(TimberConvertErrorIndexName)
+			return
+		}
+
+		worker.Start()
 	}
 
-	worker.Start()
+	if s.esClient != nil {
+		if err = s.esClient.EnsureIndexTemplates(context.Background()); err != nil {
+			err = errkit.Concat(ErrEnsureIndexTemplates, err)
+			s.logError(err)
+			return
+		}
+	}
+
+	if s.deadLetterTopic != "" {
+		if err = s.initDeadLetter(); err != nil {
+			err = errkit.Concat(ErrDeadLetter, err)
+			s.logError(err)
+			return
+		}
+	}
+
+	if s.consumerMode == ConsumerModeSingleGroup {
+		if err = s.spawnSubscriptionWorker(); err != nil {
+			err = errkit.Concat(ErrSpawnWorker, err)
+			s.logError(err)
+		}
+		return
+	}
 
 	for _, topic := range admin.Topics() {
 		if strings.HasPrefix(topic, s.topicPrefix) && strings.HasSuffix(topic, s.topicSuffix) {
@@ -198,10 +298,29 @@ func (s *baritoConsumerService) initAdmin() (admin types.KafkaAdmin, err error)
 	return
 }
 
+// initDeadLetter creates the dead letter topic with broker default partitions if it does not exist
+func (s *baritoConsumerService) initDeadLetter() (err error) {
+	if !s.admin.Exist(s.deadLetterTopic) {
+		if err = s.admin.CreateTopic(s.deadLetterTopic, -1, -1); err != nil {
+			return
+		}
+		s.admin.AddTopic(s.deadLetterTopic)
+	}
+
+	producer, err := s.factory.MakeSyncProducer()
+	if err != nil {
+		return
+	}
+
+	s.deadLetter = newDeadLetterQueue(s.deadLetterTopic, producer)
+	s.esClient.WithDeadLetter(s.deadLetter)
+	return
+}
+
 func (s *baritoConsumerService) initNewTopicWorker(groupID string) (worker types.ConsumerWorker, err error) { // TODO: return worker
 	topic := s.newTopicEventName
 
-	consumer, err := s.factory.MakeClusterConsumer(groupID, topic, sarama.OffsetNewest)
+	consumer, err := s.factory.MakeGroupConsumer(groupID, topic, sarama.OffsetNewest)
 	if err != nil {
 		return
 	}
@@ -227,6 +346,14 @@ func (s baritoConsumerService) Close() {
 	if s.newTopicEventWorker != nil {
 		s.newTopicEventWorker.Stop()
 	}
+
+	if s.esClient != nil {
+		s.esClient.Close()
+	}
+
+	if s.deadLetter != nil {
+		s.deadLetter.Close()
+	}
 }
 
 func (s *baritoConsumerService) spawnLogsWorker(topic string, initialOffset int64) (err error) {
@@ -234,14 +361,15 @@ func (s *baritoConsumerService) spawnLogsWorker(topic string, initialOffset int6
 	if s.uniqueGroupID {
 		groupID = fmt.Sprintf("%s_%s", s.groupID, topic)
 	}
-	consumer, err := s.factory.MakeClusterConsumer(groupID, topic, initialOffset)
+	consumer, err := s.factory.MakeGroupConsumer(groupID, topic, initialOffset)
 	if err != nil {
 		return errkit.Concat(ErrConsumerWorker, err)
 	}
 
-	worker := NewConsumerWorker(topic, consumer)
+	worker := newConsumerWorker(topic, consumer)
+	worker.maxUncommitted = s.maxUncommittedOffsets
 	worker.OnError(s.logError)
-	worker.OnSuccess(s.onStoreTimber)
+	worker.OnMessage(s.storeTimber)
 	worker.Start()
 
 	s.workerMap[topic] = worker
@@ -249,6 +377,37 @@ func (s *baritoConsumerService) spawnLogsWorker(topic string, initialOffset int6
 	return
 }
 
+// spawnSubscriptionWorker consumes every log topic with a single consumer group, the messages are handled by a pool of workerPoolSize goroutines.
+// The worker is kept in the worker map under the group ID. Partitions without committed offset, e.g. of a new topic, are consumed from the oldest offset.
+func (s *baritoConsumerService) spawnSubscriptionWorker() (err error) {
+	consumer, err := s.factory.MakeSubscriptionConsumer(s.groupID, s.matchTopic, s.subscriptionRefreshInterval, s.subscriptionDelay, sarama.OffsetOldest)
+	if err != nil {
+		return errkit.Concat(ErrConsumerWorker, err)
+	}
+
+	worker := newConsumerWorker(s.groupID, consumer)
+	worker.maxUncommitted = s.maxUncommittedOffsets
+	worker.poolSize = s.workerPoolSize
+	worker.OnError(s.logError)
+	worker.OnMessage(s.storeTimber)
+	worker.Start()
+
+	s.workerMap[s.groupID] = worker
+
+	return
+}
+
+// matchTopic returns true for the log topics, matched by the topic pattern when there is one, otherwise by the topic prefix and suffix
+func (s *baritoConsumerService) matchTopic(topic string) bool {
+	if strings.HasPrefix(topic, "__") || topic == s.newTopicEventName || topic == s.deadLetterTopic {
+		return false
+	}
+	if s.topicPattern != nil {
+		return s.topicPattern.MatchString(topic)
+	}
+	return strings.HasPrefix(topic, s.topicPrefix) && strings.HasSuffix(topic, s.topicSuffix)
+}
+
 func (s *baritoConsumerService) logError(err error) {
 	s.lastError = err
 	log.Warn(err.Error())
@@ -273,32 +432,31 @@ func (s *baritoConsumerService) onElasticMaxRetryReached() {
 	s.HaltAllWorker()
 }
 
-func getKafkaMessageFormat(headers []*sarama.RecordHeader) string {
-	for _, header := range headers {
-		if string(header.Key) == MessageFormatHeaderKey {
-			return string(header.Value)
-		}
-	}
-	return TimberMessageFormat
+func (s *baritoConsumerService) onStoreTimber(message *sarama.ConsumerMessage) {
+	s.storeTimber(context.Background(), message)
 }
 
-func (s *baritoConsumerService) onStoreTimber(message *sarama.ConsumerMessage) {
+// storeTimber stores the timbers of message to elasticsearch, the documents are acknowledged to the tracked message in ctx.
+// Messages which are not a timber are published into the dead letter topic, or only logged when there is none,
+// so they do not hold the offset commit.
+func (s *baritoConsumerService) storeTimber(ctx context.Context, message *sarama.ConsumerMessage) error {
 	timberCollection := pb.TimberCollection{}
 	err := error(nil)
 
-	kafkaMessageFormat := getKafkaMessageFormat(message.Headers)
+	metadata := ConvertKafkaHeadersToRecordMetadata(message.Headers)
+	if !metadata.ProduceTimestamp.IsZero() {
+		prome.ObserveConsumerRecordLag(message.Topic, time.Since(metadata.ProduceTimestamp).Seconds())
+	}
 
-	if kafkaMessageFormat == TimberCollectionMessageFormat {
+	if metadata.MessageFormat == TimberCollectionMessageFormat {
 		timberCollection, err = ConvertKafkaMessageToTimberCollection(message)
 		if err != nil {
-			s.logError(errkit.Concat(ErrConvertKafkaMessage, err))
-			return
+			return s.onConvertError(message, err)
 		}
 	} else {
 		timber, err := ConvertKafkaMessageToTimber(message)
 		if err != nil {
-			s.logError(errkit.Concat(ErrConvertKafkaMessage, err))
-			return
+			return s.onConvertError(message, err)
 		}
 		timberCollection = pb.TimberCollection{
 			Items:   []*pb.Timber{&timber},
@@ -306,18 +464,34 @@ func (s *baritoConsumerService) onStoreTimber(message *sarama.ConsumerMessage) {
 		}
 	}
 
-	// store to elasticsearch
+	// store to elasticsearch, the index is taken from each record context
+	// since a shared topic may carry logs of many apps
 	for _, timber := range timberCollection.GetItems() {
-		ctx := context.Background()
 		timber.Context = timberCollection.GetContext()
+		if timber.GetContext().GetEsIndexPrefix() == "" {
+			s.logError(errkit.Concat(ErrStore, ErrMissingIndexPrefix))
+			prome.IncreaseConsumerTimberConvertError(TimberConvertErrorIndexName)
+			continue
+		}
+
 		err = s.esClient.Store(ctx, *timber)
 		if err != nil {
-			s.logError(errkit.Concat(ErrStore, err))
-			return
+			err = errkit.Concat(ErrStore, err)
+			s.logError(err)
+			return err
 		}
 
 		s.logTimber(*timber)
 	}
+	return nil
+}
+
+func (s *baritoConsumerService) onConvertError(message *sarama.ConsumerMessage, err error) error {
+	s.logError(errkit.Concat(ErrConvertKafkaMessage, err))
+	if s.deadLetter == nil {
+		return nil
+	}
+	return s.deadLetter.PublishRecord(message, DeadLetterErrorDecode, err)
 }
 
 func (s *baritoConsumerService) onNewTopicEvent(message *sarama.ConsumerMessage) {
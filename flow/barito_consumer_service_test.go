@@ -3,6 +3,7 @@ package flow
 import (
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -38,7 +39,7 @@ func TestBaritConsumerService_MakeKafkaAdminError(t *testing.T) {
 
 func TestBaritoConsumerService_MakeNewTopicWorkerError(t *testing.T) {
 	factory := NewDummyKafkaFactory()
-	factory.Expect_MakeClusterConsumer_AlwaysError("some-error")
+	factory.Expect_MakeGroupConsumer_AlwaysError("some-error")
 
 	consumerParams := SampleConsumerParams(factory)
 	service := NewBaritoConsumerService(consumerParams)
@@ -54,7 +55,7 @@ func TestBaritoConsumerService(t *testing.T) {
 
 	factory := NewDummyKafkaFactory()
 	factory.Expect_MakeKafkaAdmin_ConsumerServiceSuccess(ctrl, []string{"abc_logs"})
-	factory.Expect_MakeClusterConsumer_AlwaysSuccess(ctrl)
+	factory.Expect_MakeGroupConsumer_AlwaysSuccess(ctrl)
 
 	consumerParams := SampleConsumerParams(factory)
 	service := NewBaritoConsumerService(consumerParams).(*baritoConsumerService)
@@ -80,6 +81,70 @@ func TestBaritoConsumerService(t *testing.T) {
 	FatalIf(t, !worker.IsStart(), "worker of topic abc_logs is not starting")
 }
 
+func TestBaritoConsumerService_SingleGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	factory := NewDummyKafkaFactory()
+	factory.Expect_MakeKafkaAdmin_ConsumerServiceSuccess(ctrl, []string{"abc_logs"})
+	factory.Expect_MakeGroupConsumer_AlwaysError("per topic consumer should not be made")
+
+	var match func(string) bool
+	factory.MakeSubscriptionConsumerFunc = func(groupID string, m func(string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (types.GroupConsumer, error) {
+		FatalIf(t, groupID != "barito-group" || refreshInterval != time.Minute || subscriptionDelay != time.Hour || initialOffset != sarama.OffsetOldest,
+			"wrong subscription consumer %s %s %s %d", groupID, refreshInterval, subscriptionDelay, initialOffset)
+		match = m
+
+		consumer := mock.NewMockGroupConsumer(ctrl)
+		consumer.EXPECT().Messages().AnyTimes()
+		consumer.EXPECT().Notifications().AnyTimes()
+		consumer.EXPECT().Errors().AnyTimes()
+		consumer.EXPECT().Close().AnyTimes()
+		return consumer, nil
+	}
+
+	consumerParams := SampleConsumerParams(factory)
+	consumerParams["groupID"] = "barito-group"
+	consumerParams["newTopicEventName"] = "new_topic_events"
+	consumerParams["deadLetterTopic"] = ""
+	consumerParams["consumerMode"] = ConsumerModeSingleGroup
+	consumerParams["subscriptionRefreshInterval"] = time.Minute
+	consumerParams["subscriptionDelay"] = time.Hour
+	service := NewBaritoConsumerService(consumerParams).(*baritoConsumerService)
+
+	err := service.Start()
+	FatalIfError(t, err)
+	defer service.Close()
+
+	FatalIf(t, service.NewTopicEventWorker() != nil, "new topic event worker should not be made")
+
+	worker, ok := service.WorkerMap()["barito-group"].(*consumerWorker)
+	FatalIf(t, !ok || len(service.WorkerMap()) != 1, "worker of the consumer group is missing")
+	FatalIf(t, worker.poolSize != DefaultWorkerPoolSize, "wrong worker pool size %d", worker.poolSize)
+
+	for topic, want := range map[string]bool{
+		"abc_logs":           true,
+		"abc":                false,
+		"new_topic_events":   false,
+		"__consumer_offsets": false,
+	} {
+		FatalIf(t, match(topic) != want, "wrong match of %s", topic)
+	}
+
+	service.topicPattern = regexp.MustCompile(`^(abc|def)_logs$`)
+	FatalIf(t, !match("def_logs") || match("xyz_logs"), "topics should be matched by the pattern")
+}
+
+func TestBaritoConsumerService_UnknownMode(t *testing.T) {
+	factory := NewDummyKafkaFactory()
+
+	consumerParams := SampleConsumerParams(factory)
+	consumerParams["consumerMode"] = "some-mode"
+	service := NewBaritoConsumerService(consumerParams)
+
+	FatalIfWrongError(t, service.Start(), "Unknown consumer mode: some-mode")
+}
+
 func TestBaritoConsumerService_OnElasticRetry(t *testing.T) {
 	resetPrometheusMetrics()
 	ctrl := gomock.NewController(t)
@@ -100,7 +165,7 @@ func TestBaritoConsumerService_OnElasticRetry(t *testing.T) {
 
 	factory := NewDummyKafkaFactory()
 	factory.Expect_MakeKafkaAdmin_ConsumerServiceSuccess(ctrl, []string{"abc_logs"})
-	factory.Expect_MakeClusterConsumer_AlwaysSuccess(ctrl)
+	factory.Expect_MakeGroupConsumer_AlwaysSuccess(ctrl)
 
 	consumerParams := SampleConsumerParams(factory)
 	consumerParams["elasticRetrierInterval"] = "1ms"
@@ -133,7 +198,7 @@ func TestBaritoConsumerService_SpawnWorkerError(t *testing.T) {
 
 	factory := NewDummyKafkaFactory()
 	factory.Expect_MakeKafkaAdmin_ConsumerServiceSuccess(ctrl, []string{"abc_logs"})
-	factory.Expect_MakeClusterConsumer_ConsumerSpawnWorkerErrorCase(ctrl, "new_topic_events", "some-error")
+	factory.Expect_MakeGroupConsumer_ConsumerSpawnWorkerErrorCase(ctrl, "new_topic_events", "some-error")
 
 	service := &baritoConsumerService{
 		factory:           factory,
@@ -222,12 +287,25 @@ func TestBaritoConsumerService_onStoreTimber(t *testing.T) {
 	FatalIf(t, lastTimberContextIsNil && lastTimberContentIsNil, "lastTimber can't be nil")
 }
 
+func TestBaritoConsumerService_onStoreTimber_MissingIndexPrefix(t *testing.T) {
+	service := &baritoConsumerService{}
+
+	timber := pb.SampleTimberProto()
+	timber.Context.EsIndexPrefix = ""
+	timberBytes, _ := proto.Marshal(timber)
+
+	service.onStoreTimber(&sarama.ConsumerMessage{
+		Value: timberBytes,
+	})
+	FatalIfWrongError(t, service.lastError, string(ErrStore)+": "+string(ErrMissingIndexPrefix))
+}
+
 func TestBaritoConsumerService_onNewTopicEvent(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	factory := NewDummyKafkaFactory()
-	factory.Expect_MakeClusterConsumer_AlwaysSuccess(ctrl)
+	factory.Expect_MakeGroupConsumer_AlwaysSuccess(ctrl)
 
 	service := &baritoConsumerService{
 		factory:   factory,
@@ -247,7 +325,7 @@ func TestBaritoConsumerService_onNewTopicEvent_ErrorSpawnWorker(t *testing.T) {
 	defer ctrl.Finish()
 
 	factory := NewDummyKafkaFactory()
-	factory.Expect_MakeClusterConsumer_AlwaysError("some-error")
+	factory.Expect_MakeGroupConsumer_AlwaysError("some-error")
 
 	service := &baritoConsumerService{
 		factory:   factory,
@@ -268,7 +346,7 @@ func TestBaritoConsumerService_onNewTopicEvent_IgnoreIfTopicExist(t *testing.T)
 	defer ctrl.Finish()
 
 	factory := NewDummyKafkaFactory()
-	factory.Expect_MakeClusterConsumer_AlwaysSuccess(ctrl)
+	factory.Expect_MakeGroupConsumer_AlwaysSuccess(ctrl)
 
 	worker := mock.NewMockConsumerWorker(ctrl)
 	worker.EXPECT().Stop().AnyTimes()
@@ -295,7 +373,7 @@ func TestHaltAllWorker(t *testing.T) {
 
 	factory := NewDummyKafkaFactory()
 	factory.Expect_MakeKafkaAdmin_ConsumerServiceSuccess(ctrl, []string{"abc_logs"})
-	factory.Expect_MakeClusterConsumer_AlwaysSuccess(ctrl)
+	factory.Expect_MakeGroupConsumer_AlwaysSuccess(ctrl)
 
 	consumerParams := SampleConsumerParams(factory)
 	service := NewBaritoConsumerService(consumerParams).(*baritoConsumerService)
@@ -327,7 +405,7 @@ func TestResumeWorker(t *testing.T) {
 
 	factory := NewDummyKafkaFactory()
 	factory.Expect_MakeKafkaAdmin_ConsumerServiceSuccess(ctrl, []string{"abc_logs"})
-	factory.Expect_MakeClusterConsumer_AlwaysSuccess(ctrl)
+	factory.Expect_MakeGroupConsumer_AlwaysSuccess(ctrl)
 
 	consumerParams := SampleConsumerParams(factory)
 	service := NewBaritoConsumerService(consumerParams).(*baritoConsumerService)
@@ -1,6 +1,7 @@
 package flow
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type KafkaConsumerGCSSettings struct {
@@ -116,7 +118,7 @@ func (s *baritoKafkaConsumerGCSService) spawnLogsWorker(topic string, initialOff
 	groupID := s.groupIDPrefix + topic
 
 	// create or get kafka consumer
-	consumer, err := s.kafkaFactory.MakeClusterConsumer(groupID, topic, initialOffset)
+	consumer, err := s.kafkaFactory.MakeGroupConsumer(groupID, topic, initialOffset)
 	if err != nil {
 		err = errkit.Concat(ErrConsumerWorker, err)
 		s.logger.WithField("topic", topic).Error(err)
@@ -132,8 +134,12 @@ func (s *baritoKafkaConsumerGCSService) spawnLogsWorker(topic string, initialOff
 
 	// when there are new message, call the gcs OnMessage
 	worker.OnSuccess(func(msg *sarama.ConsumerMessage) {
+		ctx, span := tracer.Start(MessageTraceContext(context.Background(), msg), "gcs.buffer", trace.WithAttributes(AttributeApp.String(topic)))
+		defer span.End()
+
 		timber, err := ConvertKafkaMessageToTimber(msg)
 		if err != nil {
+			span.RecordError(err)
 			err = errkit.Concat(ErrConvertKafkaMessage, err)
 			s.logger.WithField("topic", topic).Error(err)
 			return
@@ -152,7 +158,7 @@ func (s *baritoKafkaConsumerGCSService) spawnLogsWorker(topic string, initialOff
 
 		// retry indefinitely until success
 		for {
-			err := g.OnMessage([]byte(content))
+			err := g.OnMessage(ctx, []byte(content))
 			if err == nil {
 				break
 			}
@@ -48,11 +48,11 @@ func TestKafkaGCS_Start(t *testing.T) {
 		obj.consumerOuputFactory.EXPECT().MakeConsumerOutputGCS("topic1").Return(gcs, nil)
 		obj.consumerOuputFactory.EXPECT().MakeConsumerOutputGCS("topic2").Return(gcs, nil)
 
-		// should call the clusterConsumer that returned from kafka factory
-		clusterConsumer := mock.NewMockClusterConsumer(ctrl)
-		clusterConsumer.EXPECT().Messages().AnyTimes()
-		clusterConsumer.EXPECT().Errors().AnyTimes()
-		clusterConsumer.EXPECT().Notifications().Return(nil).AnyTimes()
+		// should call the groupConsumer that returned from kafka factory
+		groupConsumer := mock.NewMockGroupConsumer(ctrl)
+		groupConsumer.EXPECT().Messages().AnyTimes()
+		groupConsumer.EXPECT().Errors().AnyTimes()
+		groupConsumer.EXPECT().Notifications().Return(nil).AnyTimes()
 
 		// should call consumerWorker, when registering the message hook
 		consumerWorker := mock.NewMockConsumerWorker(ctrl)
@@ -61,8 +61,8 @@ func TestKafkaGCS_Start(t *testing.T) {
 		consumerWorker.EXPECT().Start().Times(2)
 
 		// should call the kafka factory
-		obj.kafkaFactory.EXPECT().MakeClusterConsumer("test_gcs_topic1", "topic1", sarama.OffsetOldest).Return(clusterConsumer, nil)
-		obj.kafkaFactory.EXPECT().MakeClusterConsumer("test_gcs_topic2", "topic2", sarama.OffsetOldest).Return(clusterConsumer, nil)
+		obj.kafkaFactory.EXPECT().MakeGroupConsumer("test_gcs_topic1", "topic1", sarama.OffsetOldest).Return(groupConsumer, nil)
+		obj.kafkaFactory.EXPECT().MakeGroupConsumer("test_gcs_topic2", "topic2", sarama.OffsetOldest).Return(groupConsumer, nil)
 
 		// should call the consumerWorker factory
 		obj.kafkaFactory.EXPECT().MakeConsumerWorker("topic1", gomock.Any()).Return(consumerWorker)
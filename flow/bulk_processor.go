@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// esBulkProcessor is the bulk processor of the ES 8 and OpenSearch backends, it behaves like the one of the ES 7 client with a single worker:
+// the requests are committed every bulkActions requests or every flushInterval, one bulk at a time, and before and after are called around every bulk
+type esBulkProcessor struct {
+	bulk          func(ctx context.Context, body []byte) (*elastic.BulkResponse, error)
+	bulkActions   int
+	flushInterval time.Duration
+	before        elastic.BulkBeforeFunc
+	after         elastic.BulkAfterFunc
+
+	mu          sync.Mutex
+	requests    []elastic.BulkableRequest
+	commitMu    sync.Mutex
+	executionId int64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newEsBulkProcessor(bulk func(ctx context.Context, body []byte) (*elastic.BulkResponse, error), bulkActions int, flushInterval time.Duration,
+	before elastic.BulkBeforeFunc, after elastic.BulkAfterFunc) *esBulkProcessor {
+
+	p := &esBulkProcessor{
+		bulk:          bulk,
+		bulkActions:   bulkActions,
+		flushInterval: flushInterval,
+		before:        before,
+		after:         after,
+		stop:          make(chan struct{}),
+	}
+
+	if flushInterval > 0 {
+		p.wg.Add(1)
+		go p.flusher()
+	}
+	return p
+}
+
+func (p *esBulkProcessor) flusher() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Flush()
+		}
+	}
+}
+
+// Add queues request, the bulk is committed by the caller once it is full
+func (p *esBulkProcessor) Add(request elastic.BulkableRequest) {
+	p.mu.Lock()
+	p.requests = append(p.requests, request)
+	full := p.bulkActions > 0 && len(p.requests) >= p.bulkActions
+	p.mu.Unlock()
+
+	if full {
+		p.Flush()
+	}
+}
+
+// Flush commits the queued requests
+func (p *esBulkProcessor) Flush() error {
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+
+	p.mu.Lock()
+	requests := p.requests
+	p.requests = nil
+	p.mu.Unlock()
+
+	if len(requests) == 0 {
+		return nil
+	}
+	return p.commit(requests)
+}
+
+func (p *esBulkProcessor) commit(requests []elastic.BulkableRequest) (err error) {
+	p.executionId++
+	id := p.executionId
+
+	if p.before != nil {
+		p.before(id, requests)
+	}
+
+	var response *elastic.BulkResponse
+	var body bytes.Buffer
+	for _, r := range requests {
+		var lines []string
+		if lines, err = r.Source(); err != nil {
+			break
+		}
+		for _, line := range lines {
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+	}
+	if err == nil {
+		response, err = p.bulk(context.Background(), body.Bytes())
+	}
+
+	if p.after != nil {
+		p.after(id, requests, response, err)
+	}
+	return
+}
+
+// Close stops the flusher and commits the queued requests
+func (p *esBulkProcessor) Close() error {
+	close(p.stop)
+	p.wg.Wait()
+	return p.Flush()
+}
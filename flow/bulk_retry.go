@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/olivere/elastic/v7"
+)
+
+const (
+	ErrBulkRetryStopped = errkit.Error("Bulk processor is closed before the item is retried")
+
+	DefaultEsBulkMaxRetries        = 5
+	DefaultEsBulkRetryMinBackoffMs = 200
+	DefaultEsBulkRetryMaxBackoffMs = 30000
+
+	BulkRetryResultRetried   = "retried"
+	BulkRetryResultExhausted = "exhausted"
+)
+
+// retryableBulkItemStatus are the statuses of a bulk item which may succeed later,
+// e.g. 429 es_rejected_execution_exception when the write thread pool queue of the node is full
+var retryableBulkItemStatus = map[int]bool{
+	408: true,
+	429: true,
+	503: true,
+	507: true,
+}
+
+// retryableBulkItem returns the response item of the i-th request of the bulk when elasticsearch may accept it later
+func retryableBulkItem(response *elastic.BulkResponse, i int) (*elastic.BulkResponseItem, bool) {
+	if response == nil || i >= len(response.Items) {
+		return nil, false
+	}
+
+	for _, item := range response.Items[i] {
+		if retryableBulkItemStatus[item.Status] {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// bulkRetrier re-queues the retryable items of a bulk into the bulk processor after an exponential backoff,
+// the item holds the delivery of its message until it is stored or the retry budget is exhausted
+type bulkRetrier struct {
+	mu         sync.Mutex
+	processor  bulkProcessor
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	closed     bool
+}
+
+func newBulkRetrier(maxRetries int, minBackoff, maxBackoff time.Duration) *bulkRetrier {
+	return &bulkRetrier{
+		maxRetries: maxRetries,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// Retry schedules r to be added into the bulk processor again, it returns false when the retry budget of r is exhausted.
+// app and status of the rejected item are the labels of the metric.
+func (b *bulkRetrier) Retry(r bulkRequest, app string, status int) bool {
+	if r.attempt >= b.maxRetries {
+		prome.IncreaseConsumerBulkRetry(app, status, BulkRetryResultExhausted)
+		return false
+	}
+
+	r.attempt++
+	prome.IncreaseConsumerBulkRetry(app, status, BulkRetryResultRetried)
+	time.AfterFunc(b.backoff(r.attempt), func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.closed || b.processor == nil {
+			r.ack(ErrBulkRetryStopped)
+			return
+		}
+		b.processor.Add(r)
+	})
+	return true
+}
+
+// Close drops the pending retries, their messages are not delivered so they are consumed again after restart
+func (b *bulkRetrier) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+}
+
+func (b *bulkRetrier) backoff(attempt int) time.Duration {
+	d := b.minBackoff << uint(attempt-1)
+	if d <= 0 || d > b.maxBackoff {
+		d = b.maxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,152 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	bulkItemRejected = `{"errors":true,"items":[{"index":{"_index":"some-type","status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"}}}]}`
+	bulkItemCreated  = `{"errors":false,"items":[{"index":{"_index":"some-type","status":201,"result":"created"}}]}`
+)
+
+// newBulkRetryTestClient returns elastic client with bulk size 1 whose n-th bulk is answered by responses(n)
+func newBulkRetryTestClient(t *testing.T, maxRetries int, responses func(n int32) string) (*elasticClient, *int32) {
+	var bulks int32
+	ts := httptest.NewServer(&ELasticTestHandler{
+		CustomHandler: func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				w.Write([]byte(responses(atomic.AddInt32(&bulks, 1))))
+			}
+		},
+	})
+	t.Cleanup(ts.Close)
+
+	esConfig := NewEsConfig(IndexMethodBulkProcessor, 1, time.Duration(1000), false, "").WithBulkRetry(maxRetries, 1, 1)
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+	t.Cleanup(client.Close)
+
+	return &client, &bulks
+}
+
+func waitMetrics(t *testing.T, expected string, metricNames ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), metricNames...)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElastic_BulkRetry(t *testing.T) {
+	resetPrometheusMetrics()
+
+	client, bulks := newBulkRetryTestClient(t, 3, func(n int32) string {
+		if n <= 2 {
+			return bulkItemRejected
+		}
+		return bulkItemCreated
+	})
+
+	marked := make(chan int64, 1)
+	tracker := newOffsetTracker(0, func(message *sarama.ConsumerMessage) { marked <- message.Offset }, func(*trackedMessage) {})
+
+	m := trackedTestMessage(tracker, 1)
+	FatalIfError(t, client.Store(withTrackedMessage(context.Background(), m), *pb.SampleTimberProto()))
+	m.release(nil)
+
+	select {
+	case offset := <-marked:
+		FatalIf(t, offset != 1, "expected offset 1 to be marked, got %d", offset)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("offset of the retried document is not marked")
+	}
+	FatalIf(t, atomic.LoadInt32(bulks) != 3, "expected 3 bulks, got %d", atomic.LoadInt32(bulks))
+
+	waitMetrics(t, `
+		# HELP barito_consumer_bulk_retry_total Number of bulk items rejected with a retryable status, re-queued or given up after the retry budget
+		# TYPE barito_consumer_bulk_retry_total counter
+		barito_consumer_bulk_retry_total{index="some-type",result="retried",status="429"} 2
+	`, "barito_consumer_bulk_retry_total")
+}
+
+func TestElastic_BulkRetryExhausted(t *testing.T) {
+	resetPrometheusMetrics()
+
+	client, bulks := newBulkRetryTestClient(t, 2, func(n int32) string { return bulkItemRejected })
+
+	marked := make(chan int64, 1)
+	tracker := newOffsetTracker(0, func(message *sarama.ConsumerMessage) { marked <- message.Offset }, func(*trackedMessage) {})
+
+	m := trackedTestMessage(tracker, 1)
+	FatalIfError(t, client.Store(withTrackedMessage(context.Background(), m), *pb.SampleTimberProto()))
+	m.release(nil)
+
+	waitMetrics(t, `
+		# HELP barito_consumer_bulk_retry_total Number of bulk items rejected with a retryable status, re-queued or given up after the retry budget
+		# TYPE barito_consumer_bulk_retry_total counter
+		barito_consumer_bulk_retry_total{index="some-type",result="exhausted",status="429"} 1
+		barito_consumer_bulk_retry_total{index="some-type",result="retried",status="429"} 2
+		# HELP barito_consumer_undelivered_message_total Number of messages whose documents failed to be stored, redelivered or dropped when they would fail again
+		# TYPE barito_consumer_undelivered_message_total counter
+		barito_consumer_undelivered_message_total{result="dropped",topic="some_topic"} 1
+	`, "barito_consumer_bulk_retry_total", "barito_consumer_undelivered_message_total")
+	FatalIf(t, atomic.LoadInt32(bulks) != 3, "expected 3 bulks, got %d", atomic.LoadInt32(bulks))
+
+	// the document is dropped once the retry budget is exhausted, so the partition is committed again
+	select {
+	case offset := <-marked:
+		FatalIf(t, offset != 1, "expected offset 1 to be marked, got %d", offset)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("offset of the dropped document is not marked")
+	}
+}
+
+func TestBulkRetrier_Close(t *testing.T) {
+	retrier := newBulkRetrier(1, time.Millisecond, time.Millisecond)
+	retrier.Close()
+
+	acked := make(chan error, 1)
+	ok := retrier.Retry(bulkRequest{ack: func(err error) { acked <- err }}, "some-type", 429)
+	FatalIf(t, !ok, "retry should be scheduled within the budget")
+	FatalIfWrongError(t, <-acked, string(ErrBulkRetryStopped))
+
+	ok = retrier.Retry(bulkRequest{attempt: 1}, "some-type", 429)
+	FatalIf(t, ok, "retry should not be scheduled when the budget is exhausted")
+}
+
+func TestBulkRetrier_Backoff(t *testing.T) {
+	retrier := newBulkRetrier(5, 100*time.Millisecond, time.Second)
+
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+
+	for _, tc := range testCases {
+		d := retrier.backoff(tc.attempt)
+		FatalIf(t, d < tc.expected/2 || d > tc.expected, "attempt %d: %s is not within jitter of %s", tc.attempt, d, tc.expected)
+	}
+}
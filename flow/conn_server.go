@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// connServer keeps track of the listeners and connections of plain socket receivers (syslog, fluent forward),
+// so they can be closed together and Close waits until every connection handler returns
+type connServer struct {
+	name      string
+	peerGuard *PeerGuard
+
+	mu        sync.Mutex
+	listeners []io.Closer
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	closed    bool
+}
+
+func newConnServer(name string, peerGuard *PeerGuard) connServer {
+	return connServer{
+		name:      name,
+		peerGuard: peerGuard,
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Close stops every listener and connection, and waits until every handler returns
+func (c *connServer) Close() {
+	c.mu.Lock()
+	c.closed = true
+	for _, l := range c.listeners {
+		l.Close()
+	}
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+}
+
+func (c *connServer) track(l io.Closer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+// serveListener accepts connections of lis in background, and calls handle for each of them in its own goroutine
+func (c *connServer) serveListener(lis net.Listener, transport string, handle func(conn net.Conn, transport string)) {
+	if c.peerGuard != nil {
+		lis = c.peerGuard.Listener(lis)
+	}
+	c.track(lis)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				c.logListenerError(transport, err)
+				return
+			}
+
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return
+			}
+			c.conns[conn] = struct{}{}
+			c.wg.Add(1)
+			c.mu.Unlock()
+
+			go func() {
+				defer c.wg.Done()
+				defer c.release(conn)
+				handle(conn, transport)
+			}()
+		}
+	}()
+}
+
+func (c *connServer) release(conn net.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+	conn.Close()
+}
+
+func (c *connServer) logListenerError(transport string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		log.Errorf("%s %s listener stopped: %s", c.name, transport, err)
+	}
+}
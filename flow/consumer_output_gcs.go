@@ -16,11 +16,16 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"github.com/klauspost/compress/zstd"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrorGCSBufferFull = fmt.Errorf("GCS buffer is full")
 var ErrorGCSStop = fmt.Errorf("GCS is stopped")
 
+// gcsMaxSpanLinks bounds the links of an upload span, a buffer may carry far more records
+const gcsMaxSpanLinks = 128
+
 var _ types.ConsumerOutput = &GCS{}
 
 type Clock interface {
@@ -63,6 +68,7 @@ type GCS struct {
 	isStop       bool
 	clock        Clock
 	bytesCounter int
+	links        []trace.Link
 }
 
 func NewGCSFromEnv(name string) *GCS {
@@ -123,8 +129,9 @@ func (g *GCS) AddOnFlushFunc(f func() error) {
 	g.onFlushFunc = append(g.onFlushFunc, f)
 }
 
-// it will reject when buffer is full, the client should retry indefinitely
-func (g *GCS) OnMessage(msg []byte) error {
+// it will reject when buffer is full, the client should retry indefinitely.
+// The span of ctx is linked to the upload carrying msg, up to gcsMaxSpanLinks per upload.
+func (g *GCS) OnMessage(ctx context.Context, msg []byte) error {
 	if g.isStop {
 		return ErrorGCSStop
 	}
@@ -140,6 +147,9 @@ func (g *GCS) OnMessage(msg []byte) error {
 	n, err := g.buffer.Write(msg)
 	g.bytesCounter += n
 	g.buffer.Write([]byte("\n"))
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && len(g.links) < gcsMaxSpanLinks {
+		g.links = append(g.links, trace.Link{SpanContext: sc})
+	}
 	return err
 }
 
@@ -186,8 +196,12 @@ func (g *GCS) Start() error {
 	}
 }
 
-func (g *GCS) uploadToGCS() error {
-	ctx := context.TODO()
+func (g *GCS) uploadToGCS() (err error) {
+	ctx, span := tracer.Start(context.Background(), "gcs.upload", trace.WithLinks(g.links...), trace.WithAttributes(
+		attribute.String("gcs.bucket", g.bucketName),
+		attribute.String("gcs.name", g.name),
+	))
+	defer func() { endSpan(span, err) }()
 
 	filename := fmt.Sprintf("%s/%s/%s-%s.log", g.bucketPath, g.name, g.name, g.clock.Now().Format(time.RFC3339))
 	// TODO: use dependency injection
@@ -273,6 +287,7 @@ func (g *GCS) Flush() {
 	g.logger.Debug("recreating the buffer")
 	g.buffer.Close()
 	g.bytesCounter = 0
+	g.links = nil
 	for {
 		g.buffer, err = NewFileBuffer(g.name)
 		if err == nil {
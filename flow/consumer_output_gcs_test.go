@@ -42,11 +42,11 @@ func TestGCS_flushMaxBytes(t *testing.T) {
 	go g.Start()
 
 	payload := []byte("12345678901")
-	g.OnMessage(payload)
+	g.OnMessage(context.Background(), payload)
 	require.Empty(t, uploadFunc.called, "should not upload yet")
-	g.OnMessage(payload)
+	g.OnMessage(context.Background(), payload)
 	require.Empty(t, uploadFunc.called, "should not upload yet")
-	g.OnMessage(payload)
+	g.OnMessage(context.Background(), payload)
 	time.Sleep(1 * time.Second)
 	require.Equal(t, 1, uploadFunc.called, "should upload already")
 
@@ -59,7 +59,7 @@ func TestGCS_flushMaxTime(t *testing.T) {
 	g.flushMaxTime = 1 * time.Second
 	g.uploadFunc = uploadFunc.Upload
 	require.Equal(t, 0, uploadFunc.called, "should not upload yet")
-	g.OnMessage([]byte("12"))
+	g.OnMessage(context.Background(), []byte("12"))
 	go g.Start()
 	time.Sleep(2 * time.Second)
 	require.Equal(t, 1, uploadFunc.called, "should upload already")
@@ -69,8 +69,8 @@ func TestGCS_onMessage(t *testing.T) {
 	// should return error when buffer is full
 	g := newTestGCS()
 	g.flushMaxBytes = 10
-	g.OnMessage([]byte("1234567890"))
-	err := g.OnMessage([]byte("1234567890"))
+	g.OnMessage(context.Background(), []byte("1234567890"))
+	err := g.OnMessage(context.Background(), []byte("1234567890"))
 	require.Error(t, err)
 }
 
@@ -78,7 +78,7 @@ func TestGGS_Flush(t *testing.T) {
 	t.Run("should call onFlushFunc when uploadFunc success ", func(t *testing.T) {
 		g := newTestGCS()
 		g.uploadFunc = func() error { return nil }
-		g.OnMessage([]byte("foo"))
+		g.OnMessage(context.Background(), []byte("foo"))
 
 		called := 0
 		for i := 0; i < 10; i++ {
@@ -94,7 +94,7 @@ func TestGGS_Flush(t *testing.T) {
 	t.Run("should NOT call onFlushFunc when uploadFunc failed ", func(t *testing.T) {
 		g := newTestGCS()
 		g.uploadFunc = func() error { return errors.New("foo") }
-		g.OnMessage([]byte("foo"))
+		g.OnMessage(context.Background(), []byte("foo"))
 
 		called := 0
 		for i := 0; i < 10; i++ {
@@ -130,9 +130,9 @@ func TestGCS_uploadToGCS(t *testing.T) {
 	defer ts.Close()
 
 	g := newTestGCS()
-	g.OnMessage([]byte("test1"))
-	g.OnMessage([]byte("test2"))
-	g.OnMessage([]byte("test3"))
+	g.OnMessage(context.Background(), []byte("test1"))
+	g.OnMessage(context.Background(), []byte("test2"))
+	g.OnMessage(context.Background(), []byte("test3"))
 
 	g.storageClient, _ = storage.NewClient(ctx, option.WithoutAuthentication(), option.WithEndpoint(ts.URL))
 	g.name = "authorization-service"
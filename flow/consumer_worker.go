@@ -1,14 +1,16 @@
 package flow
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/BaritoLog/barito-flow/flow/types"
 	"github.com/BaritoLog/barito-flow/prome"
 	"github.com/BaritoLog/go-boilerplate/errkit"
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 	log "github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -18,15 +20,24 @@ const (
 type consumerWorker struct {
 	name               string
 	isStart            bool
-	consumer           types.ClusterConsumer
+	consumer           types.GroupConsumer
 	onErrorFunc        func(error)
 	onSuccessFunc      func(*sarama.ConsumerMessage)
-	onNotificationFunc func(*cluster.Notification)
+	onNotificationFunc func(*types.Notification)
+	onMessageFunc      func(context.Context, *sarama.ConsumerMessage) error
+	tracker            *offsetTracker
+	maxUncommitted     int
+	poolSize           int
+	pool               *workerPool
 	stop               chan int
 	lastMessage        *sarama.ConsumerMessage
 }
 
-func NewConsumerWorker(name string, consumer types.ClusterConsumer) types.ConsumerWorker {
+func NewConsumerWorker(name string, consumer types.GroupConsumer) types.ConsumerWorker {
+	return newConsumerWorker(name, consumer)
+}
+
+func newConsumerWorker(name string, consumer types.GroupConsumer) *consumerWorker {
 	return &consumerWorker{
 		name:     name,
 		consumer: consumer,
@@ -37,12 +48,19 @@ func NewConsumerWorker(name string, consumer types.ClusterConsumer) types.Consum
 func (w *consumerWorker) Start() {
 	log.Warnf("Start worker '%s'", w.name)
 
+	if w.onMessageFunc != nil && w.poolSize > 1 {
+		w.pool = newWorkerPool(w.poolSize)
+	}
+
 	go w.loopErrors()
-	go w.loopNotification()
 	go w.loopMain()
 }
 
 func (w *consumerWorker) Stop() {
+	if w.tracker != nil {
+		w.tracker.Close()
+	}
+
 	if w.consumer != nil {
 		w.consumer.Close()
 	}
@@ -53,6 +71,10 @@ func (w *consumerWorker) Stop() {
 }
 
 func (w *consumerWorker) Halt() {
+	if w.tracker != nil {
+		w.tracker.Close()
+	}
+
 	go func() {
 		w.stop <- 1
 	}()
@@ -71,10 +93,20 @@ func (w *consumerWorker) OnSuccess(f func(*sarama.ConsumerMessage)) {
 	w.onSuccessFunc = f
 }
 
-func (w *consumerWorker) OnNotification(f func(*cluster.Notification)) {
+func (w *consumerWorker) OnNotification(f func(*types.Notification)) {
 	w.onNotificationFunc = f
 }
 
+// OnMessage handles the messages with at-least-once delivery instead of OnSuccess: the offset of a message is marked
+// only when f succeeded and every document acknowledged through the context, see deliveryAck, is delivered.
+// A message which is not delivered is passed to f again, see offsetTracker.
+func (w *consumerWorker) OnMessage(f func(context.Context, *sarama.ConsumerMessage) error) {
+	w.onMessageFunc = f
+	w.tracker = newOffsetTracker(w.maxUncommitted, func(message *sarama.ConsumerMessage) {
+		w.consumer.MarkOffset(message, "")
+	}, w.redeliver)
+}
+
 func (w *consumerWorker) OnConsumerFlush() error {
 	log.Warn("OnConsumerFlush")
 	err := w.consumer.CommitOffsets()
@@ -84,27 +116,101 @@ func (w *consumerWorker) OnConsumerFlush() error {
 	return err
 }
 
+// loopMain handles the messages and the notifications in order, so the partitions released by a rebalance are revoked
+// after their last message is tracked and before any message of the next session
 func (w *consumerWorker) loopMain() {
 	w.isStart = true
+	messages := w.consumer.Messages()
+	notifications := w.consumer.Notifications()
 	for {
+		// the messages are not read while the tracker is full, Track would block the notifications of a rebalance
+		in := messages
+		var available <-chan struct{}
+		if w.tracker != nil && w.tracker.Full() {
+			in, available = nil, w.tracker.Available()
+		}
+
 		select {
-		case message, ok := <-w.consumer.Messages():
-			if ok {
-				prome.IncreaseKafkaMessagesIncoming(message.Topic)
-				w.fireSuccess(message)
-				w.consumer.MarkOffset(message, "")
+		case message, ok := <-in:
+			if !ok {
+				messages = nil
+				continue
 			}
+			prome.IncreaseKafkaMessagesIncoming(message.Topic)
+			ctx, span := w.startSpan(message)
+			if w.onMessageFunc != nil {
+				w.handleMessage(ctx, message, span)
+				continue
+			}
+			w.fireSuccess(message)
+			span.End()
+			w.consumer.MarkOffset(message, "")
+		case notification, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+			w.handleNotification(notification)
+		case <-available:
 		case <-w.stop:
 			w.isStart = false
+			if w.pool != nil {
+				w.pool.Close()
+			}
 			return
 		}
 	}
 }
 
-func (w *consumerWorker) loopNotification() {
-	for notification := range w.consumer.Notifications() {
-		w.fireNotification(notification)
+// startSpan continues the trace of message with a consumer span, the handler continues the trace from the returned context.
+// The message is not changed, so its headers still carry the trace of the producer, e.g. into the dead letter topic.
+func (w *consumerWorker) startSpan(message *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	return tracer.Start(MessageTraceContext(context.Background(), message), "consume "+message.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(message.Topic),
+			AttributeKafkaPartition.Int64(int64(message.Partition)),
+			AttributeKafkaOffset.Int64(message.Offset),
+		),
+	)
+}
+
+// handleMessage passes the tracked message to the handler with ctx of its consumer span, on the worker pool when there is one
+// so the next message is consumed meanwhile, the handler reference is released with its result
+func (w *consumerWorker) handleMessage(ctx context.Context, message *sarama.ConsumerMessage, span trace.Span) {
+	tracked := w.tracker.Track(message)
+	if tracked == nil {
+		span.End()
+		return
+	}
+	tracked.spanContext = span.SpanContext()
+
+	handle := func() {
+		err := w.onMessageFunc(withTrackedMessage(ctx, tracked), message)
+		tracked.release(err)
+		span.End()
+	}
+
+	if w.pool != nil {
+		w.pool.Dispatch(topicPartition{message.Topic, message.Partition}, handle)
+		return
+	}
+	handle()
+}
+
+// redeliver passes the tracked message to the handler again, continuing the trace of its consumer span
+func (w *consumerWorker) redeliver(tracked *trackedMessage) {
+	ctx := trace.ContextWithSpanContext(context.Background(), tracked.spanContext)
+	err := w.onMessageFunc(withTrackedMessage(ctx, tracked), tracked.message)
+	tracked.release(err)
+}
+
+func (w *consumerWorker) handleNotification(notification *types.Notification) {
+	if w.tracker != nil && len(notification.Released) > 0 {
+		w.tracker.Revoke(notification.Released)
 	}
+	w.fireNotification(notification)
 }
 
 func (w *consumerWorker) loopErrors() {
@@ -125,7 +231,7 @@ func (w *consumerWorker) fireError(err error) {
 	}
 }
 
-func (w *consumerWorker) fireNotification(notification *cluster.Notification) {
+func (w *consumerWorker) fireNotification(notification *types.Notification) {
 	if w.onNotificationFunc != nil {
 		w.onNotificationFunc(notification)
 	}
@@ -1,17 +1,20 @@
 package flow
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/BaritoLog/barito-flow/flow/types"
 	"github.com/BaritoLog/barito-flow/mock"
 	. "github.com/BaritoLog/go-boilerplate/testkit"
 	"github.com/BaritoLog/go-boilerplate/timekit"
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 	"github.com/golang/mock/gomock"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -22,9 +25,9 @@ func TestConsumerWorker(t *testing.T) {
 	defer ctrl.Finish()
 
 	want := &sarama.ConsumerMessage{Topic: "test"}
-	wantNotification := &cluster.Notification{}
+	wantNotification := &types.Notification{}
 
-	consumer := mock.NewMockClusterConsumer(ctrl)
+	consumer := mock.NewMockGroupConsumer(ctrl)
 	consumer.EXPECT().Messages().AnyTimes().Return(sampleMessageChannel(want))
 	consumer.EXPECT().Notifications().Return(sampleNotificationChannel(wantNotification))
 	consumer.EXPECT().Errors().Return(sampleErrorChannel())
@@ -39,11 +42,11 @@ func TestConsumerWorker(t *testing.T) {
 	defer ts.Close()
 
 	var got *sarama.ConsumerMessage
-	var gotNotification *cluster.Notification
+	var gotNotification *types.Notification
 
 	worker := NewConsumerWorker("worker", consumer)
 	worker.OnSuccess(func(message *sarama.ConsumerMessage) { got = message })
-	worker.OnNotification(func(notification *cluster.Notification) { gotNotification = notification })
+	worker.OnNotification(func(notification *types.Notification) { gotNotification = notification })
 
 	worker.Start()
 	defer worker.Stop()
@@ -61,12 +64,195 @@ func TestConsumerWorker(t *testing.T) {
 	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_kafka_message_incoming_total"))
 }
 
+func TestConsumerWorker_OnMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	want := &sarama.ConsumerMessage{Topic: "test", Offset: 42}
+
+	consumer := mock.NewMockGroupConsumer(ctrl)
+	consumer.EXPECT().Messages().AnyTimes().Return(sampleMessageChannel(want))
+	consumer.EXPECT().Notifications().Return(sampleNotificationChannel())
+	consumer.EXPECT().Errors().Return(sampleErrorChannel())
+	consumer.EXPECT().Close()
+
+	acks := make(chan func(error), 1)
+
+	worker := newConsumerWorker("worker", consumer)
+	worker.OnMessage(func(ctx context.Context, message *sarama.ConsumerMessage) error {
+		acks <- deliveryAck(ctx)
+		return nil
+	})
+
+	worker.Start()
+	defer worker.Stop()
+
+	ack := <-acks
+	timekit.Sleep("2ms")
+
+	// offset is marked only after the document is acknowledged
+	consumer.EXPECT().MarkOffset(want, "")
+	ack(nil)
+}
+
+func TestConsumerWorker_CommitAfterFailedMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m1 := &sarama.ConsumerMessage{Topic: "test", Partition: 0, Offset: 1}
+	m2 := &sarama.ConsumerMessage{Topic: "test", Partition: 0, Offset: 2}
+	m3 := &sarama.ConsumerMessage{Topic: "test", Partition: 0, Offset: 3}
+
+	consumer := mock.NewMockGroupConsumer(ctrl)
+	consumer.EXPECT().Messages().Return(sampleMessageChannel(m1, m2, m3))
+	consumer.EXPECT().Notifications().Return(sampleNotificationChannel())
+	consumer.EXPECT().Errors().AnyTimes().Return(sampleErrorChannel())
+	consumer.EXPECT().Close()
+
+	marked := make(chan int64, 3)
+	consumer.EXPECT().MarkOffset(gomock.Any(), "").AnyTimes().Do(func(message *sarama.ConsumerMessage, _ string) {
+		marked <- message.Offset
+	})
+
+	var mu sync.Mutex
+	attempts := make(map[int64]int)
+
+	worker := newConsumerWorker("worker", consumer)
+	worker.OnMessage(func(ctx context.Context, message *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts[message.Offset]++
+		switch {
+		case message.Offset == 1 && attempts[1] == 1:
+			return fmt.Errorf("some-error")
+		case message.Offset == 2:
+			return undeliverable(fmt.Errorf("some-error"))
+		}
+		return nil
+	})
+	worker.tracker.minBackoff, worker.tracker.maxBackoff = time.Millisecond, time.Millisecond
+
+	worker.Start()
+	defer worker.Stop()
+
+	// the failed message is redelivered and the undeliverable one is dropped, so the partition is committed up to the last message
+	for {
+		select {
+		case offset := <-marked:
+			if offset == 3 {
+				mu.Lock()
+				defer mu.Unlock()
+				FatalIf(t, attempts[1] != 2 || attempts[2] != 1, "wrong attempts %v", attempts)
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("partition is not committed after the failed message")
+		}
+	}
+}
+
+func TestConsumerWorker_RevokeWhileFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m1 := &sarama.ConsumerMessage{Topic: "test", Partition: 0, Offset: 1}
+	m2 := &sarama.ConsumerMessage{Topic: "test", Partition: 0, Offset: 5}
+	messages := make(chan *sarama.ConsumerMessage)
+	notifications := make(chan *types.Notification)
+
+	consumer := mock.NewMockGroupConsumer(ctrl)
+	consumer.EXPECT().Messages().Return(messages)
+	consumer.EXPECT().Notifications().Return(notifications)
+	consumer.EXPECT().Errors().AnyTimes().Return(sampleErrorChannel())
+	consumer.EXPECT().Close()
+
+	acks := make(chan func(error), 2)
+	revoked := make(chan struct{})
+
+	worker := newConsumerWorker("worker", consumer)
+	worker.maxUncommitted = 1
+	worker.OnMessage(func(ctx context.Context, message *sarama.ConsumerMessage) error {
+		acks <- deliveryAck(ctx)
+		return nil
+	})
+	worker.OnNotification(func(*types.Notification) { close(revoked) })
+
+	worker.Start()
+	defer worker.Stop()
+
+	messages <- m1
+	ack1 := <-acks
+
+	// the notification is handled although the tracker is full
+	select {
+	case notifications <- &types.Notification{Type: types.RebalanceStart, Released: map[string][]int32{"test": {0}}}:
+	case <-time.After(time.Second):
+		t.Fatalf("notification should be handled while the tracker is full")
+	}
+	<-revoked
+
+	// the revoked message is not marked
+	ack1(nil)
+
+	messages <- m2
+	ack2 := <-acks
+	marked := make(chan struct{})
+	consumer.EXPECT().MarkOffset(m2, "").Do(func(*sarama.ConsumerMessage, string) { close(marked) })
+	ack2(nil)
+	<-marked
+}
+
+func TestConsumerWorker_Pool(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	blocked := &sarama.ConsumerMessage{Topic: "a_logs", Partition: 0, Offset: 1}
+	want := &sarama.ConsumerMessage{Topic: "b_logs", Partition: 0, Offset: 7}
+	messages := make(chan *sarama.ConsumerMessage)
+
+	consumer := mock.NewMockGroupConsumer(ctrl)
+	consumer.EXPECT().Messages().Return(messages)
+	consumer.EXPECT().Notifications().Return(sampleNotificationChannel())
+	consumer.EXPECT().Errors().AnyTimes().Return(sampleErrorChannel())
+	consumer.EXPECT().Close()
+
+	unblock := make(chan struct{})
+	handled := make(chan *sarama.ConsumerMessage, 2)
+
+	worker := newConsumerWorker("worker", consumer)
+	worker.poolSize = 2
+	worker.OnMessage(func(ctx context.Context, message *sarama.ConsumerMessage) error {
+		if message == blocked {
+			<-unblock
+		}
+		handled <- message
+		return nil
+	})
+
+	worker.Start()
+	defer worker.Stop()
+
+	// the partitions are handled by different goroutines of the pool
+	marked := make(chan *sarama.ConsumerMessage, 2)
+	consumer.EXPECT().MarkOffset(gomock.Any(), "").Times(2).Do(func(message *sarama.ConsumerMessage, _ string) { marked <- message })
+
+	messages <- blocked
+	messages <- want
+	FatalIf(t, <-handled != want, "message of another partition should not wait for the blocked handler")
+	FatalIf(t, <-marked != want, "wrong marked message")
+
+	close(unblock)
+	FatalIf(t, <-handled != blocked, "blocked message should be handled")
+	FatalIf(t, <-marked != blocked, "wrong marked message")
+}
+
 func TestConsumerWorker_KafkaError(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	consumer := mock.NewMockClusterConsumer(ctrl)
+	consumer := mock.NewMockGroupConsumer(ctrl)
 	consumer.EXPECT().Messages().AnyTimes().Return(sampleMessageChannel())
 	consumer.EXPECT().Notifications().Return(sampleNotificationChannel())
 	consumer.EXPECT().Errors().Return(sampleErrorChannel(fmt.Errorf("expected kafka error")))
@@ -104,8 +290,8 @@ func sampleMessageChannel(messages ...*sarama.ConsumerMessage) <-chan *sarama.Co
 	return messageCh
 }
 
-func sampleNotificationChannel(notifications ...*cluster.Notification) chan *cluster.Notification {
-	notificationCh := make(chan *cluster.Notification)
+func sampleNotificationChannel(notifications ...*types.Notification) chan *types.Notification {
+	notificationCh := make(chan *types.Notification)
 	go func() {
 		for _, notification := range notifications {
 			notificationCh <- notification
@@ -1,7 +1,11 @@
 package flow
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"strconv"
+	"time"
 
 	"github.com/BaritoLog/go-boilerplate/errkit"
 	"github.com/Shopify/sarama"
@@ -16,8 +20,30 @@ const (
 	ProtoParseError      = errkit.Error("Protobuf Parse Error")
 	TimberContentMissing = errkit.Error("Timber Content Missing Error")
 	TimberFieldsMissing  = errkit.Error("Timber Field Missing Error")
+
+	AppSecretHashHeaderKey    = "barito_app_secret_hash"
+	IndexPrefixHeaderKey      = "barito_es_index_prefix"
+	AppNameHeaderKey          = "barito_app_name"
+	AppGroupHeaderKey         = "barito_app_group"
+	ProduceTimestampHeaderKey = "barito_produce_timestamp"
+	SchemaVersionHeaderKey    = "barito_schema_version"
+
+	// SchemaVersion is bumped whenever the record payload or headers change incompatibly
+	SchemaVersion = "1"
 )
 
+// RecordMetadata is the routing metadata carried in kafka record headers,
+// so consumers can filter and route records without decoding the payload
+type RecordMetadata struct {
+	MessageFormat    string
+	AppSecretHash    string
+	IndexPrefix      string
+	AppName          string
+	AppGroup         string
+	ProduceTimestamp time.Time
+	SchemaVersion    string
+}
+
 type LogFormatGcsSimpler struct {
 	Message interface{} `json:"message"`
 	LogTag  string      `json:"log_tag"`
@@ -28,8 +54,9 @@ func ConvertTimberToKafkaMessage(timber *pb.Timber, topic string) *sarama.Produc
 	b, _ := proto.Marshal(timber)
 
 	return &sarama.ProducerMessage{
-		Topic: topic,
-		Value: sarama.ByteEncoder(b),
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(b),
+		Headers: routingHeaders(timber.GetContext()),
 	}
 }
 
@@ -39,13 +66,88 @@ func ConvertTimberCollectionToKafkaMessage(timberCollection *pb.TimberCollection
 	return &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(b),
-		Headers: []sarama.RecordHeader{
+		Headers: append([]sarama.RecordHeader{
 			{
 				Key:   []byte(MessageFormatHeaderKey),
 				Value: []byte(TimberCollectionMessageFormat),
 			},
+		}, routingHeaders(timberCollection.GetContext())...),
+	}
+}
+
+// newAppTimberContext returns the context of logs received by protocols other than barito,
+// app name is used both as kafka topic and index prefix
+func newAppTimberContext(app string, appMaxTps int32) *pb.TimberContext {
+	return &pb.TimberContext{
+		KafkaTopic:    app,
+		EsIndexPrefix: app,
+		AppMaxTps:     appMaxTps,
+	}
+}
+
+func ConvertKafkaHeadersToRecordMetadata(headers []*sarama.RecordHeader) (metadata RecordMetadata) {
+	metadata.MessageFormat = TimberMessageFormat
+	for _, header := range headers {
+		value := string(header.Value)
+		switch string(header.Key) {
+		case MessageFormatHeaderKey:
+			metadata.MessageFormat = value
+		case AppSecretHashHeaderKey:
+			metadata.AppSecretHash = value
+		case IndexPrefixHeaderKey:
+			metadata.IndexPrefix = value
+		case AppNameHeaderKey:
+			metadata.AppName = value
+		case AppGroupHeaderKey:
+			metadata.AppGroup = value
+		case ProduceTimestampHeaderKey:
+			if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+				metadata.ProduceTimestamp = time.Unix(0, ms*int64(time.Millisecond)).UTC()
+			}
+		case SchemaVersionHeaderKey:
+			metadata.SchemaVersion = value
+		}
+	}
+	return
+}
+
+// HashAppSecret returns the value of app secret hash header, the secret itself never leaves the payload
+func HashAppSecret(appSecret string) string {
+	if appSecret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(appSecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// routingHeaders returns the routing metadata headers of timberContext.
+// The app group header is set for the apps sharing the TPS of their app group only, whose app secret is the secret of the app group,
+// so its hash identifies the group.
+func routingHeaders(timberContext *pb.TimberContext) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{
+		{
+			Key:   []byte(ProduceTimestampHeaderKey),
+			Value: []byte(strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)),
+		},
+		{
+			Key:   []byte(SchemaVersionHeaderKey),
+			Value: []byte(SchemaVersion),
 		},
 	}
+	if timberContext == nil {
+		return headers
+	}
+
+	appSecretHash := HashAppSecret(timberContext.GetAppSecret())
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(AppSecretHashHeaderKey), Value: []byte(appSecretHash)},
+		sarama.RecordHeader{Key: []byte(IndexPrefixHeaderKey), Value: []byte(timberContext.GetEsIndexPrefix())},
+		sarama.RecordHeader{Key: []byte(AppNameHeaderKey), Value: []byte(timberContext.GetKafkaTopic())},
+	)
+	if timberContext.GetDisableAppTps() && appSecretHash != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(AppGroupHeaderKey), Value: []byte(appSecretHash)})
+	}
+	return headers
 }
 
 func ConvertKafkaMessageToTimber(message *sarama.ConsumerMessage) (timber pb.Timber, err error) {
@@ -121,6 +223,32 @@ func ConvertTimberToLogFormatGCSSimpleString(timber pb.Timber) (string, error) {
 
 }
 
+// TimberEventTime returns the time the log happened, the @timestamp of the content or Timber.Timestamp when it is missing.
+// @timestamp is either RFC 3339 or epoch milliseconds.
+func TimberEventTime(timber pb.Timber) (time.Time, bool) {
+	value, ok := timber.GetContent().GetFields()["@timestamp"]
+	if !ok {
+		return parseEventTime(timber.GetTimestamp())
+	}
+
+	switch value.GetKind().(type) {
+	case *stpb.Value_StringValue:
+		return parseEventTime(value.GetStringValue())
+	case *stpb.Value_NumberValue:
+		return time.UnixMilli(int64(value.GetNumberValue())), true
+	}
+	return time.Time{}, false
+}
+
+func parseEventTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func ConvertTimberToEsDocumentString(timber pb.Timber, m *jsonpb.Marshaler) (string, error) {
 	doc := timber.GetContent()
 
@@ -2,6 +2,7 @@ package flow
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/BaritoLog/go-boilerplate/testkit"
 	"github.com/Shopify/sarama"
@@ -96,6 +97,35 @@ func TestConvertTimberToEsDocumentString_FieldsIsMissing(t *testing.T) {
 	expected := ""
 	FatalIf(t, expected != document, "expected %s, received %s", expected, document)
 }
+func TestTimberEventTime(t *testing.T) {
+	expected := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		timestamp *structpb.Value
+		fallback  string
+		ok        bool
+	}{
+		{&structpb.Value{Kind: &structpb.Value_StringValue{StringValue: "2024-01-02T03:04:05Z"}}, "", true},
+		{&structpb.Value{Kind: &structpb.Value_StringValue{StringValue: "2024-01-02T10:04:05.000+07:00"}}, "", true},
+		{&structpb.Value{Kind: &structpb.Value_StringValue{StringValue: "2024-01-02T10:04:05+0700"}}, "", true},
+		{&structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: float64(expected.UnixMilli())}}, "", true},
+		{nil, "2024-01-02T03:04:05Z", true},
+		{&structpb.Value{Kind: &structpb.Value_StringValue{StringValue: "yesterday"}}, "2024-01-02T03:04:05Z", false},
+		{&structpb.Value{Kind: &structpb.Value_BoolValue{BoolValue: true}}, "", false},
+	}
+
+	for _, tc := range testCases {
+		timber := pb.Timber{Timestamp: tc.fallback, Content: &structpb.Struct{Fields: map[string]*structpb.Value{}}}
+		if tc.timestamp != nil {
+			timber.Content.Fields["@timestamp"] = tc.timestamp
+		}
+
+		eventTime, ok := TimberEventTime(timber)
+		FatalIf(t, ok != tc.ok, "%v: expected ok %v, got %v", tc.timestamp, tc.ok, ok)
+		FatalIf(t, ok && !eventTime.Equal(expected), "%v: expected %s, got %s", tc.timestamp, expected, eventTime)
+	}
+}
+
 func TestConvertTimberCollectionToKafkaMessage(t *testing.T) {
 	topic := "test-topic"
 	timberCollection := &pb.TimberCollection{
@@ -124,3 +154,49 @@ func TestConvertTimberCollectionToKafkaMessage_EmptyCollection(t *testing.T) {
 	expected, _ := proto.Marshal(timberCollection)
 	FatalIf(t, string(get) != string(expected), "Wrong message value for empty collection")
 }
+
+func TestConvertTimberToKafkaMessage_RoutingHeaders(t *testing.T) {
+	timber := pb.SampleTimberProto()
+	timber.Context.AppSecret = "some-secret"
+
+	message := ConvertTimberToKafkaMessage(timber, "some-topic")
+
+	headers := make([]*sarama.RecordHeader, len(message.Headers))
+	for i := range message.Headers {
+		headers[i] = &message.Headers[i]
+	}
+	metadata := ConvertKafkaHeadersToRecordMetadata(headers)
+
+	FatalIf(t, metadata.MessageFormat != TimberMessageFormat, "wrong message format %s", metadata.MessageFormat)
+	FatalIf(t, metadata.AppSecretHash != HashAppSecret("some-secret"), "wrong app secret hash %s", metadata.AppSecretHash)
+	FatalIf(t, metadata.AppSecretHash == "some-secret", "app secret must not be written as is")
+	FatalIf(t, metadata.IndexPrefix != timber.Context.EsIndexPrefix, "wrong index prefix %s", metadata.IndexPrefix)
+	FatalIf(t, metadata.AppName != timber.Context.KafkaTopic, "wrong app name %s", metadata.AppName)
+	FatalIf(t, metadata.AppGroup != "", "app with its own TPS should have no app group, got %s", metadata.AppGroup)
+	FatalIf(t, metadata.SchemaVersion != SchemaVersion, "wrong schema version %s", metadata.SchemaVersion)
+	FatalIf(t, time.Since(metadata.ProduceTimestamp) > time.Minute, "wrong produce timestamp %s", metadata.ProduceTimestamp)
+}
+
+func TestConvertTimberCollectionToKafkaMessage_RoutingHeaders(t *testing.T) {
+	timberCollection := pb.SampleTimberCollectionProto()
+	timberCollection.Context.DisableAppTps = true
+
+	message := ConvertTimberCollectionToKafkaMessage(timberCollection, "some-topic")
+
+	headers := make([]*sarama.RecordHeader, len(message.Headers))
+	for i := range message.Headers {
+		headers[i] = &message.Headers[i]
+	}
+	metadata := ConvertKafkaHeadersToRecordMetadata(headers)
+
+	FatalIf(t, metadata.MessageFormat != TimberCollectionMessageFormat, "wrong message format %s", metadata.MessageFormat)
+	FatalIf(t, metadata.AppName != timberCollection.Context.KafkaTopic, "wrong app name %s", metadata.AppName)
+	FatalIf(t, metadata.AppGroup != HashAppSecret(timberCollection.Context.AppSecret), "app group should be the hash of the app group secret, got %s", metadata.AppGroup)
+	FatalIf(t, metadata.IndexPrefix != timberCollection.Context.EsIndexPrefix, "wrong index prefix %s", metadata.IndexPrefix)
+}
+
+func TestConvertKafkaHeadersToRecordMetadata_NoHeaders(t *testing.T) {
+	metadata := ConvertKafkaHeadersToRecordMetadata(nil)
+	FatalIf(t, metadata.MessageFormat != TimberMessageFormat, "record without header should be Timber format")
+	FatalIf(t, !metadata.ProduceTimestamp.IsZero(), "produce timestamp should be empty")
+}
@@ -0,0 +1,253 @@
+package flow
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/Shopify/sarama"
+	"github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrDeadLetter        = errkit.Error("Publish to dead letter topic failed")
+	ErrDeadLetterRedrive = errkit.Error("Redrive dead letter failed")
+
+	DeadLetterKindHeaderKey            = "barito_dlq_kind"
+	DeadLetterErrorHeaderKey           = "barito_dlq_error"
+	DeadLetterReasonHeaderKey          = "barito_dlq_reason"
+	DeadLetterSourceTopicHeaderKey     = "barito_dlq_source_topic"
+	DeadLetterSourcePartitionHeaderKey = "barito_dlq_source_partition"
+	DeadLetterSourceOffsetHeaderKey    = "barito_dlq_source_offset"
+	DeadLetterSourceTimestampHeaderKey = "barito_dlq_source_timestamp"
+	DeadLetterIndexHeaderKey           = "barito_dlq_index"
+
+	// DeadLetterKindRecord is the original kafka record which can not be decoded
+	DeadLetterKindRecord = "record"
+	// DeadLetterKindDocument is the rendered elasticsearch document rejected by bulk request
+	DeadLetterKindDocument = "document"
+
+	DeadLetterErrorDecode = "decode_failed"
+
+	DefaultDeadLetterRedriveGroupID     = "barito-dlq-redrive"
+	DefaultDeadLetterRedriveIdleTimeout = 10 * time.Second
+)
+
+// deadLetterQueue publishes what the consumer can not store into the dead letter topic, with the error and source record in headers
+type deadLetterQueue struct {
+	topic    string
+	producer sarama.SyncProducer
+}
+
+func newDeadLetterQueue(topic string, producer sarama.SyncProducer) *deadLetterQueue {
+	return &deadLetterQueue{
+		topic:    topic,
+		producer: producer,
+	}
+}
+
+// PublishRecord publishes the original record of message, e.g. it is not a timber
+func (q *deadLetterQueue) PublishRecord(message *sarama.ConsumerMessage, errorType string, reason error) error {
+	return q.publish(DeadLetterKindRecord, message, message.Key, message.Value, errorType, reason)
+}
+
+// PublishDocument publishes the document of index rejected by elasticsearch, source is the record it is taken from if known
+func (q *deadLetterQueue) PublishDocument(source *sarama.ConsumerMessage, index, document, errorType string, reason error) error {
+	var key []byte
+	if source != nil {
+		key = source.Key
+	}
+	return q.publish(DeadLetterKindDocument, source, key, []byte(document), errorType, reason,
+		sarama.RecordHeader{Key: []byte(DeadLetterIndexHeaderKey), Value: []byte(index)})
+}
+
+func (q *deadLetterQueue) publish(kind string, source *sarama.ConsumerMessage, key, value []byte, errorType string, reason error, headers ...sarama.RecordHeader) error {
+	message := &sarama.ProducerMessage{
+		Topic: q.topic,
+		Value: sarama.ByteEncoder(value),
+		Headers: append([]sarama.RecordHeader{
+			{Key: []byte(DeadLetterKindHeaderKey), Value: []byte(kind)},
+			{Key: []byte(DeadLetterErrorHeaderKey), Value: []byte(errorType)},
+			{Key: []byte(DeadLetterReasonHeaderKey), Value: []byte(reason.Error())},
+		}, headers...),
+	}
+	if key != nil {
+		message.Key = sarama.ByteEncoder(key)
+	}
+
+	sourceTopic := ""
+	if source != nil {
+		sourceTopic = source.Topic
+		message.Headers = append(message.Headers,
+			sarama.RecordHeader{Key: []byte(DeadLetterSourceTopicHeaderKey), Value: []byte(source.Topic)},
+			sarama.RecordHeader{Key: []byte(DeadLetterSourcePartitionHeaderKey), Value: []byte(strconv.Itoa(int(source.Partition)))},
+			sarama.RecordHeader{Key: []byte(DeadLetterSourceOffsetHeaderKey), Value: []byte(strconv.FormatInt(source.Offset, 10))},
+			sarama.RecordHeader{Key: []byte(DeadLetterSourceTimestampHeaderKey), Value: []byte(strconv.FormatInt(source.Timestamp.UnixMilli(), 10))},
+		)
+		// keep the routing headers of the source record for redrive
+		for _, header := range source.Headers {
+			message.Headers = append(message.Headers, *header)
+		}
+	}
+
+	if _, _, err := q.producer.SendMessage(message); err != nil {
+		return errkit.Concat(ErrDeadLetter, err)
+	}
+
+	prome.IncreaseConsumerDeadLetter(sourceTopic, kind, errorType)
+	return nil
+}
+
+func (q *deadLetterQueue) Close() {
+	q.producer.Close()
+}
+
+// failedBulkItem returns the response item of the i-th request of the bulk when elasticsearch failed to store it
+func failedBulkItem(response *elastic.BulkResponse, i int) (*elastic.BulkResponseItem, bool) {
+	if response == nil || i >= len(response.Items) {
+		return nil, false
+	}
+
+	for _, item := range response.Items[i] {
+		if item.Status < 200 || item.Status > 299 {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// DeadLetterRedriveConfig redrives the records of the dead letter topic whose error is ErrorType, or every record when it is empty.
+// With an ErrorType, the group ID is suffixed by it so the records skipped by the filter stay uncommitted for the other error types.
+// Redrive stops when no record is received for IdleTimeout.
+type DeadLetterRedriveConfig struct {
+	Topic       string
+	GroupID     string
+	ErrorType   string
+	IdleTimeout time.Duration
+}
+
+// DeadLetterRedriver sends the undecodable records back to their source topic and indexes the rejected documents again,
+// typically after the decoder or the index mapping is fixed
+type DeadLetterRedriver struct {
+	config   DeadLetterRedriveConfig
+	factory  types.KafkaFactory
+	esClient *elasticClient
+	producer sarama.SyncProducer
+}
+
+func NewDeadLetterRedriver(config DeadLetterRedriveConfig, factory types.KafkaFactory, esClient *elasticClient) *DeadLetterRedriver {
+	if config.GroupID == "" {
+		config.GroupID = DefaultDeadLetterRedriveGroupID
+	}
+	if config.ErrorType != "" {
+		config.GroupID += "-" + config.ErrorType
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultDeadLetterRedriveIdleTimeout
+	}
+
+	return &DeadLetterRedriver{
+		config:   config,
+		factory:  factory,
+		esClient: esClient,
+	}
+}
+
+// Run redrives the dead letters until the topic is idle, the offset of a record is committed only when it is redriven or skipped,
+// for the group of the error type filter.
+// It stops at the first record which failed to be redriven, so it is redriven again on the next run.
+func (r *DeadLetterRedriver) Run() (redriven int, err error) {
+	consumer, err := r.factory.MakeGroupConsumer(r.config.GroupID, r.config.Topic, sarama.OffsetOldest)
+	if err != nil {
+		return 0, errkit.Concat(ErrDeadLetterRedrive, err)
+	}
+	defer consumer.Close()
+
+	r.producer, err = r.factory.MakeSyncProducer()
+	if err != nil {
+		return 0, errkit.Concat(ErrDeadLetterRedrive, err)
+	}
+	defer r.producer.Close()
+
+	idle := time.NewTimer(r.config.IdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case message, ok := <-consumer.Messages():
+			if !ok {
+				return
+			}
+
+			ok, err = r.redrive(message)
+			if err != nil {
+				return redriven, errkit.Concat(ErrDeadLetterRedrive, err)
+			}
+			if ok {
+				redriven++
+			}
+			consumer.MarkOffset(message, "")
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(r.config.IdleTimeout)
+		case err := <-consumer.Errors():
+			log.Warnf("%s", errkit.Concat(ErrDeadLetterRedrive, err))
+		case <-consumer.Notifications():
+		case <-idle.C:
+			return
+		}
+	}
+}
+
+// redrive returns false when the record is skipped by the error type filter
+func (r *DeadLetterRedriver) redrive(message *sarama.ConsumerMessage) (bool, error) {
+	var kind, errorType, sourceTopic, index string
+	var headers []sarama.RecordHeader
+	for _, header := range message.Headers {
+		key := string(header.Key)
+		switch key {
+		case DeadLetterKindHeaderKey:
+			kind = string(header.Value)
+		case DeadLetterErrorHeaderKey:
+			errorType = string(header.Value)
+		case DeadLetterSourceTopicHeaderKey:
+			sourceTopic = string(header.Value)
+		case DeadLetterIndexHeaderKey:
+			index = string(header.Value)
+		default:
+			if !strings.HasPrefix(key, "barito_dlq_") {
+				headers = append(headers, *header)
+			}
+		}
+	}
+
+	if r.config.ErrorType != "" && r.config.ErrorType != errorType {
+		return false, nil
+	}
+
+	switch kind {
+	case DeadLetterKindRecord:
+		record := &sarama.ProducerMessage{
+			Topic:   sourceTopic,
+			Value:   sarama.ByteEncoder(message.Value),
+			Headers: headers,
+		}
+		if message.Key != nil {
+			record.Key = sarama.ByteEncoder(message.Key)
+		}
+		_, _, err := r.producer.SendMessage(record)
+		return true, err
+	case DeadLetterKindDocument:
+		return true, r.esClient.IndexDocument(context.Background(), index, string(message.Value))
+	}
+
+	log.Warnf("Skip dead letter %d of unknown kind '%s'", message.Offset, kind)
+	return false, nil
+}
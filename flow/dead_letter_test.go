@@ -0,0 +1,303 @@
+package flow
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func recordHeaders(message *sarama.ProducerMessage) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range message.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return headers
+}
+
+func expectDeadLetter(producer *mock.MockSyncProducer) chan *sarama.ProducerMessage {
+	published := make(chan *sarama.ProducerMessage, 10)
+	producer.EXPECT().SendMessage(gomock.Any()).AnyTimes().DoAndReturn(func(message *sarama.ProducerMessage) (int32, int64, error) {
+		published <- message
+		return 0, 0, nil
+	})
+	return published
+}
+
+func TestBaritoConsumerService_storeTimber_DeadLetter(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	published := expectDeadLetter(producer)
+
+	service := &baritoConsumerService{deadLetter: newDeadLetterQueue("dead_letter", producer)}
+
+	message := &sarama.ConsumerMessage{
+		Topic:     "abc_logs",
+		Partition: 2,
+		Offset:    42,
+		Timestamp: time.UnixMilli(1700000000000),
+		Value:     []byte(`invalid_proto`),
+		Headers:   []*sarama.RecordHeader{{Key: []byte(AppNameHeaderKey), Value: []byte("abc")}},
+	}
+	FatalIfError(t, service.storeTimber(context.Background(), message))
+	FatalIfWrongError(t, service.lastError, string(ErrConvertKafkaMessage))
+
+	record := <-published
+	b, _ := record.Value.Encode()
+	FatalIf(t, record.Topic != "dead_letter" || string(b) != "invalid_proto", "original record should be published, got %s %s", record.Topic, b)
+
+	headers := recordHeaders(record)
+	FatalIf(t, headers[DeadLetterKindHeaderKey] != DeadLetterKindRecord, "wrong kind %v", headers)
+	FatalIf(t, headers[DeadLetterErrorHeaderKey] != DeadLetterErrorDecode, "wrong error %v", headers)
+	FatalIf(t, headers[DeadLetterSourceTopicHeaderKey] != "abc_logs", "wrong source topic %v", headers)
+	FatalIf(t, headers[DeadLetterSourcePartitionHeaderKey] != "2", "wrong source partition %v", headers)
+	FatalIf(t, headers[DeadLetterSourceOffsetHeaderKey] != "42", "wrong source offset %v", headers)
+	FatalIf(t, headers[DeadLetterSourceTimestampHeaderKey] != "1700000000000", "wrong source timestamp %v", headers)
+	FatalIf(t, headers[AppNameHeaderKey] != "abc", "source headers should be kept %v", headers)
+
+	expected := `
+		# HELP barito_consumer_dead_letter_total Number of undecodable records and rejected documents published into the dead letter topic
+		# TYPE barito_consumer_dead_letter_total counter
+		barito_consumer_dead_letter_total{error="decode_failed",kind="record",topic="abc_logs"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_dead_letter_total"))
+}
+
+func TestBaritoConsumerService_storeTimber_DeadLetterError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), sarama.ErrOutOfBrokers)
+
+	service := &baritoConsumerService{deadLetter: newDeadLetterQueue("dead_letter", producer)}
+
+	err := service.storeTimber(context.Background(), &sarama.ConsumerMessage{Value: []byte(`invalid_proto`)})
+	FatalIfWrongError(t, err, string(ErrDeadLetter)+": "+sarama.ErrOutOfBrokers.Error())
+}
+
+func TestElastic_DeadLetterRejectedDocument(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ts := httptest.NewServer(&ELasticTestHandler{
+		ExistAPIStatus: 200,
+		PostAPIStatus:  200,
+		ResponseBody: []byte(`{"errors":true,"items":[
+			{"index":{"_index":"some-type","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [status]"}}},
+			{"index":{"_index":"some-type","status":201,"result":"created"}}
+		]}`),
+	})
+	defer ts.Close()
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	published := expectDeadLetter(producer)
+
+	esConfig := NewEsConfig(IndexMethodBulkProcessor, 2, time.Duration(1000), false, "")
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+	defer client.bulkProcessor.Close()
+	client.WithDeadLetter(newDeadLetterQueue("dead_letter", producer))
+
+	marked := make(chan int64, 2)
+	tracker := newOffsetTracker(0, func(message *sarama.ConsumerMessage) { marked <- message.Offset }, func(*trackedMessage) {})
+
+	for offset := int64(1); offset <= 2; offset++ {
+		m := trackedTestMessage(tracker, offset)
+		FatalIfError(t, client.Store(withTrackedMessage(context.Background(), m), *pb.SampleTimberProto()))
+		m.release(nil)
+	}
+
+	for _, expected := range []int64{1, 2} {
+		select {
+		case offset := <-marked:
+			FatalIf(t, offset != expected, "dead lettered document should be delivered, expected offset %d, got %d", expected, offset)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("offset %d is not marked", expected)
+		}
+	}
+
+	document := <-published
+	headers := recordHeaders(document)
+	FatalIf(t, headers[DeadLetterKindHeaderKey] != DeadLetterKindDocument, "wrong kind %v", headers)
+	FatalIf(t, headers[DeadLetterErrorHeaderKey] != "mapping_failed", "wrong error %v", headers)
+	FatalIf(t, !strings.HasPrefix(headers[DeadLetterIndexHeaderKey], "some-type-"), "wrong index %v", headers)
+	FatalIf(t, headers[DeadLetterSourceOffsetHeaderKey] != "1", "wrong source offset %v", headers)
+	b, _ := document.Value.Encode()
+	FatalIf(t, !strings.Contains(string(b), `"some-message"`), "rendered document should be published, got %s", b)
+
+	select {
+	case <-published:
+		t.Fatalf("stored document should not be dead lettered")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDeadLetterRedriver_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var indexed []string
+	ts := httptest.NewServer(&ELasticTestHandler{
+		CustomHandler: func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead {
+				body := io.Reader(r.Body)
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					body, _ = gzip.NewReader(r.Body)
+				}
+				b, _ := io.ReadAll(body)
+				indexed = append(indexed, r.URL.Path+" "+string(b))
+			}
+			w.Write([]byte(`{}`))
+		},
+	})
+	defer ts.Close()
+
+	messages := make(chan *sarama.ConsumerMessage, 3)
+	messages <- &sarama.ConsumerMessage{Offset: 0, Value: []byte(`invalid_proto`), Headers: []*sarama.RecordHeader{
+		{Key: []byte(DeadLetterKindHeaderKey), Value: []byte(DeadLetterKindRecord)},
+		{Key: []byte(DeadLetterErrorHeaderKey), Value: []byte(DeadLetterErrorDecode)},
+		{Key: []byte(DeadLetterSourceTopicHeaderKey), Value: []byte("abc_logs")},
+		{Key: []byte(AppNameHeaderKey), Value: []byte("abc")},
+	}}
+	messages <- &sarama.ConsumerMessage{Offset: 1, Value: []byte(`{"message":"fixed"}`), Headers: []*sarama.RecordHeader{
+		{Key: []byte(DeadLetterKindHeaderKey), Value: []byte(DeadLetterKindDocument)},
+		{Key: []byte(DeadLetterErrorHeaderKey), Value: []byte("mapping_failed")},
+		{Key: []byte(DeadLetterIndexHeaderKey), Value: []byte("abc-2024.01.02")},
+	}}
+	messages <- &sarama.ConsumerMessage{Offset: 2, Value: []byte(`{}`), Headers: []*sarama.RecordHeader{
+		{Key: []byte(DeadLetterKindHeaderKey), Value: []byte("unknown")},
+	}}
+
+	consumer := mock.NewMockGroupConsumer(ctrl)
+	consumer.EXPECT().Messages().AnyTimes().Return(messages)
+	consumer.EXPECT().Errors().AnyTimes().Return(nil)
+	consumer.EXPECT().Notifications().AnyTimes().Return(nil)
+	consumer.EXPECT().MarkOffset(gomock.Any(), "").Times(3)
+	consumer.EXPECT().Close()
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	published := expectDeadLetter(producer)
+	producer.EXPECT().Close()
+
+	factory := NewDummyKafkaFactory()
+	factory.MakeGroupConsumerFunc = func(groupID, topic string, initialOffset int64) (types.GroupConsumer, error) {
+		FatalIf(t, groupID != DefaultDeadLetterRedriveGroupID || topic != "dead_letter" || initialOffset != sarama.OffsetOldest,
+			"wrong consumer %s %s %d", groupID, topic, initialOffset)
+		return consumer, nil
+	}
+	factory.MakeSyncProducerFunc = func() (sarama.SyncProducer, error) { return producer, nil }
+
+	esConfig := NewEsConfig(IndexMethodSingleInsert, 1, time.Duration(1000), false, "")
+	esClient, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+
+	redriver := NewDeadLetterRedriver(DeadLetterRedriveConfig{
+		Topic:       "dead_letter",
+		IdleTimeout: 50 * time.Millisecond,
+	}, factory, &esClient)
+
+	redriven, err := redriver.Run()
+	FatalIfError(t, err)
+	FatalIf(t, redriven != 2, "expected 2 redriven, got %d", redriven)
+
+	record := <-published
+	headers := recordHeaders(record)
+	FatalIf(t, record.Topic != "abc_logs", "record should be sent to its source topic, got %s", record.Topic)
+	FatalIf(t, len(headers) != 1 || headers[AppNameHeaderKey] != "abc", "dead letter headers should be removed, got %v", headers)
+
+	FatalIf(t, fmt.Sprint(indexed) != `[/abc-2024.01.02/_doc/ {"message":"fixed"}]`, "document should be indexed, got %v", indexed)
+}
+
+func TestDeadLetterRedriver_Run_ErrorTypes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var indexed []string
+	ts := httptest.NewServer(&ELasticTestHandler{
+		CustomHandler: func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead {
+				indexed = append(indexed, r.URL.Path)
+			}
+			w.Write([]byte(`{}`))
+		},
+	})
+	defer ts.Close()
+
+	deadLetter := func(offset int64, errorType, index string) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{Offset: offset, Value: []byte(`{}`), Headers: []*sarama.RecordHeader{
+			{Key: []byte(DeadLetterKindHeaderKey), Value: []byte(DeadLetterKindDocument)},
+			{Key: []byte(DeadLetterErrorHeaderKey), Value: []byte(errorType)},
+			{Key: []byte(DeadLetterIndexHeaderKey), Value: []byte(index)},
+		}}
+	}
+	topic := []*sarama.ConsumerMessage{
+		deadLetter(0, "mapping_failed", "abc-1"),
+		deadLetter(1, "illegal_argument", "abc-2"),
+		deadLetter(2, "mapping_failed", "abc-3"),
+	}
+
+	// every group consumes the records after its committed offset
+	committed := make(map[string]int64)
+	factory := NewDummyKafkaFactory()
+	factory.MakeGroupConsumerFunc = func(groupID, _ string, _ int64) (types.GroupConsumer, error) {
+		messages := make(chan *sarama.ConsumerMessage, len(topic))
+		for _, message := range topic {
+			if offset, ok := committed[groupID]; !ok || message.Offset > offset {
+				messages <- message
+			}
+		}
+
+		consumer := mock.NewMockGroupConsumer(ctrl)
+		consumer.EXPECT().Messages().AnyTimes().Return(messages)
+		consumer.EXPECT().Errors().AnyTimes().Return(nil)
+		consumer.EXPECT().Notifications().AnyTimes().Return(nil)
+		consumer.EXPECT().MarkOffset(gomock.Any(), "").AnyTimes().Do(func(message *sarama.ConsumerMessage, _ string) {
+			committed[groupID] = message.Offset
+		})
+		consumer.EXPECT().Close()
+		return consumer, nil
+	}
+	factory.MakeSyncProducerFunc = func() (sarama.SyncProducer, error) {
+		producer := mock.NewMockSyncProducer(ctrl)
+		producer.EXPECT().Close()
+		return producer, nil
+	}
+
+	esConfig := NewEsConfig(IndexMethodSingleInsert, 1, time.Duration(1000), false, "")
+	esClient, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+
+	for _, errorType := range []string{"mapping_failed", "illegal_argument"} {
+		redriven, err := NewDeadLetterRedriver(DeadLetterRedriveConfig{
+			Topic:       "dead_letter",
+			ErrorType:   errorType,
+			IdleTimeout: 50 * time.Millisecond,
+		}, factory, &esClient).Run()
+		FatalIfError(t, err)
+		FatalIf(t, redriven == 0, "dead letters of %s should be redriven", errorType)
+	}
+
+	FatalIf(t, fmt.Sprint(indexed) != "[/abc-1/_doc/ /abc-3/_doc/ /abc-2/_doc/]",
+		"dead letters skipped by the first filter should be redriven by the second one, got %v", indexed)
+	FatalIf(t, committed[DefaultDeadLetterRedriveGroupID+"-mapping_failed"] != 2 || committed[DefaultDeadLetterRedriveGroupID+"-illegal_argument"] != 2,
+		"every error type should commit its own offset, got %v", committed)
+}
@@ -2,6 +2,7 @@ package flow
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/BaritoLog/barito-flow/flow/types"
 	"github.com/BaritoLog/barito-flow/mock"
@@ -10,10 +11,11 @@ import (
 )
 
 type dummyKafkaFactory struct {
-	MakeKafkaAdminFunc      func() (admin types.KafkaAdmin, err error)
-	MakeClusterConsumerFunc func(groupID, topic string, initialOffset int64) (consumer types.ClusterConsumer, err error)
-	MakeSyncProducerFunc    func() (producer sarama.SyncProducer, err error)
-	MakeConsumerWorkerFunc  func(name string, consumer types.ClusterConsumer) types.ConsumerWorker
+	MakeKafkaAdminFunc           func() (admin types.KafkaAdmin, err error)
+	MakeSubscriptionConsumerFunc func(groupID string, match func(topic string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (consumer types.GroupConsumer, err error)
+	MakeGroupConsumerFunc        func(groupID, topic string, initialOffset int64) (consumer types.GroupConsumer, err error)
+	MakeSyncProducerFunc         func() (producer sarama.SyncProducer, err error)
+	MakeConsumerWorkerFunc       func(name string, consumer types.GroupConsumer) types.ConsumerWorker
 }
 
 func NewDummyKafkaFactory() *dummyKafkaFactory {
@@ -21,13 +23,16 @@ func NewDummyKafkaFactory() *dummyKafkaFactory {
 		MakeKafkaAdminFunc: func() (admin types.KafkaAdmin, err error) {
 			return nil, nil
 		},
-		MakeClusterConsumerFunc: func(groupID, topic string, initialOffset int64) (worker types.ClusterConsumer, err error) {
+		MakeGroupConsumerFunc: func(groupID, topic string, initialOffset int64) (worker types.GroupConsumer, err error) {
+			return nil, nil
+		},
+		MakeSubscriptionConsumerFunc: func(groupID string, match func(topic string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (consumer types.GroupConsumer, err error) {
 			return nil, nil
 		},
 		MakeSyncProducerFunc: func() (producer sarama.SyncProducer, err error) {
 			return nil, nil
 		},
-		MakeConsumerWorkerFunc: func(name string, consumer types.ClusterConsumer) types.ConsumerWorker {
+		MakeConsumerWorkerFunc: func(name string, consumer types.GroupConsumer) types.ConsumerWorker {
 			return nil
 		},
 	}
@@ -36,27 +41,31 @@ func NewDummyKafkaFactory() *dummyKafkaFactory {
 func (f *dummyKafkaFactory) MakeKafkaAdmin() (admin types.KafkaAdmin, err error) {
 	return f.MakeKafkaAdminFunc()
 }
-func (f *dummyKafkaFactory) MakeClusterConsumer(groupID, topic string, initialOffset int64) (worker types.ClusterConsumer, err error) {
-	return f.MakeClusterConsumerFunc(groupID, topic, initialOffset)
+func (f *dummyKafkaFactory) MakeGroupConsumer(groupID, topic string, initialOffset int64) (worker types.GroupConsumer, err error) {
+	return f.MakeGroupConsumerFunc(groupID, topic, initialOffset)
+}
+
+func (f *dummyKafkaFactory) MakeSubscriptionConsumer(groupID string, match func(topic string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (consumer types.GroupConsumer, err error) {
+	return f.MakeSubscriptionConsumerFunc(groupID, match, refreshInterval, subscriptionDelay, initialOffset)
 }
 
 func (f *dummyKafkaFactory) MakeSyncProducer() (producer sarama.SyncProducer, err error) {
 	return f.MakeSyncProducerFunc()
 }
 
-func (f *dummyKafkaFactory) MakeConsumerWorker(name string, consumer types.ClusterConsumer) types.ConsumerWorker {
+func (f *dummyKafkaFactory) MakeConsumerWorker(name string, consumer types.GroupConsumer) types.ConsumerWorker {
 	return f.MakeConsumerWorkerFunc(name, consumer)
 }
 
-func (f *dummyKafkaFactory) Expect_MakeClusterConsumer_AlwaysError(errMsg string) {
-	f.MakeClusterConsumerFunc = func(groupID, topic string, initialOffset int64) (types.ClusterConsumer, error) {
+func (f *dummyKafkaFactory) Expect_MakeGroupConsumer_AlwaysError(errMsg string) {
+	f.MakeGroupConsumerFunc = func(groupID, topic string, initialOffset int64) (types.GroupConsumer, error) {
 		return nil, fmt.Errorf(errMsg)
 	}
 }
 
-func (f *dummyKafkaFactory) Expect_MakeClusterConsumer_AlwaysSuccess(ctrl *gomock.Controller) {
-	f.MakeClusterConsumerFunc = func(groupID, topic string, initialOffset int64) (types.ClusterConsumer, error) {
-		consumer := mock.NewMockClusterConsumer(ctrl)
+func (f *dummyKafkaFactory) Expect_MakeGroupConsumer_AlwaysSuccess(ctrl *gomock.Controller) {
+	f.MakeGroupConsumerFunc = func(groupID, topic string, initialOffset int64) (types.GroupConsumer, error) {
+		consumer := mock.NewMockGroupConsumer(ctrl)
 		consumer.EXPECT().Messages().AnyTimes()
 		consumer.EXPECT().Notifications().AnyTimes()
 		consumer.EXPECT().Errors().AnyTimes()
@@ -65,10 +74,10 @@ func (f *dummyKafkaFactory) Expect_MakeClusterConsumer_AlwaysSuccess(ctrl *gomoc
 	}
 }
 
-func (f *dummyKafkaFactory) Expect_MakeClusterConsumer_ConsumerSpawnWorkerErrorCase(ctrl *gomock.Controller, newTopicEventName, errMsg string) {
-	f.MakeClusterConsumerFunc = func(groupID, topic string, initialOffset int64) (types.ClusterConsumer, error) {
+func (f *dummyKafkaFactory) Expect_MakeGroupConsumer_ConsumerSpawnWorkerErrorCase(ctrl *gomock.Controller, newTopicEventName, errMsg string) {
+	f.MakeGroupConsumerFunc = func(groupID, topic string, initialOffset int64) (types.GroupConsumer, error) {
 		if topic == newTopicEventName {
-			consumer := mock.NewMockClusterConsumer(ctrl)
+			consumer := mock.NewMockGroupConsumer(ctrl)
 			consumer.EXPECT().Messages().AnyTimes()
 			consumer.EXPECT().Notifications().AnyTimes()
 			consumer.EXPECT().Errors().AnyTimes()
@@ -2,6 +2,7 @@ package flow
 
 import (
 	"testing"
+	"time"
 
 	"github.com/BaritoLog/barito-flow/flow/types"
 	. "github.com/BaritoLog/go-boilerplate/testkit"
@@ -14,8 +15,11 @@ func TestDummyKafkaFactory(t *testing.T) {
 
 	FatalIf(t, !ok, "factory must be implement fo KafkaFactory")
 
-	consumer, err := factory.MakeClusterConsumer("groupID", "topic", sarama.OffsetNewest)
-	FatalIf(t, consumer != nil || err != nil, "MakeClusterConsumer return wrong value")
+	consumer, err := factory.MakeGroupConsumer("groupID", "topic", sarama.OffsetNewest)
+	FatalIf(t, consumer != nil || err != nil, "MakeGroupConsumer return wrong value")
+
+	consumer, err = factory.MakeSubscriptionConsumer("groupID", func(string) bool { return true }, time.Second, time.Minute, sarama.OffsetOldest)
+	FatalIf(t, consumer != nil || err != nil, "MakeSubscriptionConsumer return wrong value")
 
 	admin, err := factory.MakeKafkaAdmin()
 	FatalIf(t, admin != nil || err != nil, "MakeKafkaAdmin return wrong value")
@@ -4,15 +4,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
 
 	"time"
 
+	"github.com/Shopify/sarama"
 	pb "github.com/bentol/barito-proto/producer"
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/olivere/elastic/v7"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/zekroTJA/timedmap"
 )
@@ -25,6 +29,9 @@ const (
 	IndexMethodBulkProcessor = "BulkProcessor"
 	IndexMethodDatastream    = "DataStream"
 	IndexMethodSingleInsert  = "SingleInsert"
+	IndexMethodRollover      = "Rollover"
+
+	ErrBulkItemMissing = errkit.Error("Bulk response has no item of the request")
 )
 
 type Elastic interface {
@@ -34,16 +41,23 @@ type Elastic interface {
 }
 
 type elasticClient struct {
-	client                             *elastic.Client
-	bulkProcessor                      *elastic.BulkProcessor
+	backend                            esBackend
+	bulkProcessor                      bulkProcessor
+	documentType                       string
 	onFailureFunc                      func(*pb.Timber)
-	onStoreFunc                        func(ctx context.Context, indexName, documentType, document string) (err error)
+	onStoreFunc                        func(e *elasticClient, ctx context.Context, indexName, documentType, document string) (err error)
 	jspbMarshaler                      *jsonpb.Marshaler
 	indexExistsCache                   *timedmap.TimedMap
 	useDataStream                      bool
 	dataStreamDefaultComponentTemplate string
-
-	redactor Redactor
+	useRollover                        bool
+	rollover                           RolloverConfig
+
+	redactor       Redactor
+	deadLetter     *deadLetterQueue
+	bulkRetrier    *bulkRetrier
+	indexNamer     *IndexNamer
+	indexTemplates []IndexTemplate
 }
 
 type Redactor interface {
@@ -62,6 +76,13 @@ type esConfig struct {
 	flushMs                            time.Duration
 	printTPS                           bool
 	dataStreamDefaultComponentTemplate string
+	bulkMaxRetries                     int
+	bulkRetryMinBackoffMs              time.Duration
+	bulkRetryMaxBackoffMs              time.Duration
+	indexNamer                         *IndexNamer
+	rollover                           RolloverConfig
+	indexTemplates                     []IndexTemplate
+	client                             string
 }
 
 func NewEsConfig(indexMethod string, bulkSize int, flushMs time.Duration, printTPS bool, dataStreamDefaultComponentTemplate string) esConfig {
@@ -72,75 +93,236 @@ func NewEsConfig(indexMethod string, bulkSize int, flushMs time.Duration, printT
 		flushMs:                            flushMs,
 		printTPS:                           printTPS,
 		dataStreamDefaultComponentTemplate: dataStreamDefaultComponentTemplate,
+		bulkMaxRetries:                     DefaultEsBulkMaxRetries,
+		bulkRetryMinBackoffMs:              DefaultEsBulkRetryMinBackoffMs,
+		bulkRetryMaxBackoffMs:              DefaultEsBulkRetryMaxBackoffMs,
+		indexNamer:                         NewDefaultIndexNamer(),
+		rollover:                           DefaultRolloverConfig(),
+		client:                             EsClientES7,
 	}
 }
 
+// WithClient sets the client talking to the cluster, EsClientES7, EsClientES8 or EsClientOpenSearch
+func (c esConfig) WithClient(client string) esConfig {
+	c.client = client
+	return c
+}
+
+// WithRollover sets the ILM policy and index settings bootstrapped for every index prefix by the rollover index method
+func (c esConfig) WithRollover(rollover RolloverConfig) esConfig {
+	c.rollover = rollover
+	return c
+}
+
+// WithIndexTemplates sets the index templates of the regular indices installed by EnsureIndexTemplates
+func (c esConfig) WithIndexTemplates(indexTemplates []IndexTemplate) esConfig {
+	c.indexTemplates = indexTemplates
+	return c
+}
+
+// WithIndexNamer sets the naming of the indices, it is ignored by datastream and rollover which are named after the prefix
+func (c esConfig) WithIndexNamer(indexNamer *IndexNamer) esConfig {
+	c.indexNamer = indexNamer
+	return c
+}
+
+// WithBulkRetry sets the retry budget and backoff of the bulk items rejected with a retryable status, e.g. 429
+func (c esConfig) WithBulkRetry(maxRetries int, minBackoffMs, maxBackoffMs time.Duration) esConfig {
+	c.bulkMaxRetries = maxRetries
+	c.bulkRetryMinBackoffMs = minBackoffMs
+	c.bulkRetryMaxBackoffMs = maxBackoffMs
+	return c
+}
+
 func NewElastic(retrierFunc *ElasticRetrier, esConfig esConfig, urls []string, elasticUsername string, elasticPassword string, httpClient *http.Client) (client elasticClient, err error) {
 	if httpClient == nil {
 		httpClient = &http.Client{}
 	}
 
-	c, err := elastic.NewClient(
-		elastic.SetURL(urls...),
-		elastic.SetSniff(false),
-		elastic.SetHealthcheck(false),
-		elastic.SetRetrier(retrierFunc),
-		elastic.SetBasicAuth(elasticUsername, elasticPassword),
-		elastic.SetGzip(true),
-		elastic.SetHttpClient(httpClient),
-	)
-
+	backend, err := newEsBackend(esConfig.client, retrierFunc, urls, elasticUsername, elasticPassword, httpClient)
 	if err != nil {
 		return
 	}
+	if esConfig.client == EsClientOpenSearch && esConfig.indexMethod == IndexMethodRollover {
+		err = ErrRolloverNotSupported
+		return
+	}
 
-	beforeBulkFunc, afterBulkFunc := getCommitCallback()
+	retrier := newBulkRetrier(esConfig.bulkMaxRetries, esConfig.bulkRetryMinBackoffMs*time.Millisecond, esConfig.bulkRetryMaxBackoffMs*time.Millisecond)
+	indexPrefix := esConfig.indexNamer.Prefix
+	if esConfig.indexMethod == IndexMethodRollover {
+		indexPrefix = rolloverAlias
+	}
+	beforeBulkFunc, afterBulkFunc := getCommitCallback(retrier, indexPrefix)
 
-	p, err := c.BulkProcessor().
-		BulkActions(esConfig.bulkSize).
-		FlushInterval(esConfig.flushMs * time.Millisecond).
-		Before(beforeBulkFunc).
-		After(afterBulkFunc).
-		Do(context.Background())
+	p, err := backend.BulkProcessor(esConfig.bulkSize, esConfig.flushMs*time.Millisecond, beforeBulkFunc, afterBulkFunc)
+	retrier.processor = p
+
+	// ES 8 and OpenSearch removed the mapping types
+	documentType := DEFAULT_ELASTIC_DOCUMENT_TYPE
+	if esConfig.client == EsClientES8 || esConfig.client == EsClientOpenSearch {
+		documentType = ""
+	}
 
 	if esConfig.printTPS {
 		printThroughputPerSecond()
 	}
 
 	client = elasticClient{
-		client:                             c,
+		backend:                            backend,
 		bulkProcessor:                      p,
+		documentType:                       documentType,
+		bulkRetrier:                        retrier,
+		indexNamer:                         esConfig.indexNamer,
+		indexTemplates:                     esConfig.indexTemplates,
 		jspbMarshaler:                      &jsonpb.Marshaler{},
 		indexExistsCache:                   timedmap.New(10 * time.Minute),
 		redactor:                           &DummyRedactor{},
 		useDataStream:                      false,
 		dataStreamDefaultComponentTemplate: esConfig.dataStreamDefaultComponentTemplate,
+		rollover:                           esConfig.rollover,
 	}
 
+	// method expressions rather than method values, the client is returned by value
+	// and the copy of the caller may be configured afterward, e.g. WithDeadLetter
 	if esConfig.indexMethod == IndexMethodBulkProcessor {
-		client.onStoreFunc = client.bulkInsert
+		client.onStoreFunc = (*elasticClient).bulkInsert
 	} else if esConfig.indexMethod == IndexMethodDatastream {
-		client.onStoreFunc = client.bulkInsertDataStream
+		client.onStoreFunc = (*elasticClient).bulkInsertDataStream
 		client.useDataStream = true
 	} else if esConfig.indexMethod == IndexMethodSingleInsert {
-		client.onStoreFunc = client.singleInsert
+		client.onStoreFunc = (*elasticClient).singleInsert
+	} else if esConfig.indexMethod == IndexMethodRollover {
+		// documents are written through the alias named after the prefix, ILM rolls over the index behind it
+		client.onStoreFunc = (*elasticClient).bulkInsert
+		client.useRollover = true
 	}
 
 	return
 }
 
-func getCommitCallback() (func(int64, []elastic.BulkableRequest), func(int64, []elastic.BulkableRequest, *elastic.BulkResponse, error)) {
+// bulkRequest keeps the span context of the document, so the bulk flush carrying it can be linked to its trace,
+// and the ack of the message it is taken from, called with the result of the document
+type bulkRequest struct {
+	elastic.BulkableRequest
+	spanContext trace.SpanContext
+	ack         func(error)
+
+	index      string
+	document   string
+	source     *sarama.ConsumerMessage
+	deadLetter *deadLetterQueue
+	attempt    int
+}
+
+func (e *elasticClient) newBulkRequest(ctx context.Context, indexName, document string, r elastic.BulkableRequest) elastic.BulkableRequest {
+	return bulkRequest{
+		BulkableRequest: r,
+		spanContext:     trace.SpanContextFromContext(ctx),
+		ack:             deliveryAck(ctx),
+		index:           indexName,
+		document:        document,
+		source:          trackedSourceMessage(ctx),
+		deadLetter:      e.deadLetter,
+	}
+}
+
+// deliver acknowledges the result of the i-th request of the bulk. A document elasticsearch failed to store is published into
+// the dead letter topic when there is one, or dropped since storing it again fails the same way. The message of a failed bulk
+// request, or of a document which failed to be published, is redelivered.
+func (r bulkRequest) deliver(response *elastic.BulkResponse, i int, err error) {
+	if err != nil {
+		r.ack(err)
+		return
+	}
+
+	err = bulkItemError(response, i)
+	if err != nil && r.deadLetter != nil {
+		errorType := ""
+		if item, ok := failedBulkItem(response, i); ok {
+			errorType = prome.LogStoredErrorType(item.Error)
+		}
+		if dlqErr := r.deadLetter.PublishDocument(r.source, r.index, r.document, errorType, err); dlqErr != nil {
+			log.Errorf("%s", dlqErr)
+			r.ack(dlqErr)
+			return
+		}
+		err = nil
+	}
+	r.ack(undeliverable(err))
+}
+
+// bulkItemError returns the error of the i-th request of the bulk, the items of the response are in the order of the requests
+func bulkItemError(response *elastic.BulkResponse, i int) error {
+	if response == nil || i >= len(response.Items) {
+		return ErrBulkItemMissing
+	}
+
+	for _, item := range response.Items[i] {
+		if item.Status < 200 || item.Status > 299 {
+			if item.Error != nil {
+				return fmt.Errorf("%s: %s", item.Error.Type, item.Error.Reason)
+			}
+			return fmt.Errorf("bulk item status %d", item.Status)
+		}
+	}
+	return nil
+}
+
+// getCommitCallback returns the callbacks of the bulk processor, indexPrefix returns the prefix an index is named after for the metrics
+func getCommitCallback(retrier *bulkRetrier, indexPrefix func(index string) string) (func(int64, []elastic.BulkableRequest), func(int64, []elastic.BulkableRequest, *elastic.BulkResponse, error)) {
 	var start time.Time
+	var spansMu sync.Mutex
+	spans := make(map[int64]trace.Span)
+
 	beforeCommitCallback := func(executionId int64, requests []elastic.BulkableRequest) {
 		start = time.Now()
+
+		var links []trace.Link
+		for _, r := range requests {
+			if br, ok := r.(bulkRequest); ok && br.spanContext.IsValid() {
+				links = append(links, trace.Link{SpanContext: br.spanContext})
+			}
+		}
+		if len(links) > 0 {
+			_, span := tracer.Start(context.Background(), "elasticsearch.bulk", trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithLinks(links...), trace.WithAttributes(AttributeItems.Int(len(requests))))
+			spansMu.Lock()
+			spans[executionId] = span
+			spansMu.Unlock()
+		}
 	}
 	afterCommitCallback := func(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
 		diff := float64(time.Now().Sub(start).Nanoseconds()) / float64(1000000000)
 		prome.ObserveBulkProcessTime(diff)
 
+		spansMu.Lock()
+		span, ok := spans[executionId]
+		delete(spans, executionId)
+		spansMu.Unlock()
+		if ok {
+			if err == nil && response != nil && response.Errors {
+				err = fmt.Errorf("%d of %d bulk items failed", len(response.Failed()), len(requests))
+			}
+			endSpan(span, err)
+		}
+
+		for i, r := range requests {
+			if br, ok := r.(bulkRequest); ok {
+				if item, retryable := retryableBulkItem(response, i); err == nil && retryable &&
+					retrier.Retry(br, indexPrefix(item.Index), item.Status) {
+					continue
+				}
+				br.deliver(response, i, err)
+			}
+		}
+
+		if response == nil {
+			return
+		}
 		for _, response := range response.Items {
 			for _, responseItem := range response {
-				prome.IncreaseLogStoredCounter(responseItem.Index, responseItem.Result, responseItem.Status, responseItem.Error)
+				prome.IncreaseLogStoredCounter(indexPrefix(responseItem.Index), responseItem.Result, responseItem.Status, responseItem.Error)
 			}
 		}
 	}
@@ -167,9 +349,25 @@ func (e *elasticClient) WithRedactor(r Redactor) *elasticClient {
 	return e
 }
 
+// Close flushes the bulk processor, the pending retries are dropped
+func (e *elasticClient) Close() {
+	if e.bulkRetrier != nil {
+		e.bulkRetrier.Close()
+	}
+	if e.bulkProcessor != nil {
+		e.bulkProcessor.Close()
+	}
+}
+
+// WithDeadLetter publishes the documents rejected by bulk request into the dead letter topic instead of dropping them
+func (e *elasticClient) WithDeadLetter(q *deadLetterQueue) *elasticClient {
+	e.deadLetter = q
+	return e
+}
+
 func (e *elasticClient) ensureIndexIsExistsRegularIndex(ctx context.Context, indexName string) bool {
 	log.Warnf("ES index '%s' is not exist", indexName)
-	_, err := e.client.CreateIndex(indexName).Do(ctx)
+	err := e.backend.CreateIndex(ctx, indexName, nil)
 	instruESCreateIndex(err)
 	if err != nil {
 		log.Errorf("Error creating index: %s", err)
@@ -186,10 +384,7 @@ func (e *elasticClient) ensureIndexIsExistsDataStream(ctx context.Context, datas
 		datastreamName,
 		e.dataStreamDefaultComponentTemplate,
 	)
-	_, err := e.client.IndexPutIndexTemplate(datastreamName).
-		Name(datastreamName + "-template").
-		BodyString(payload).
-		Do(ctx)
+	err := e.backend.PutIndexTemplate(ctx, datastreamName+"-template", payload, false)
 
 	if err != nil {
 		log.Errorf("Error creating index template %s: %s", datastreamName, err)
@@ -206,7 +401,7 @@ func (e *elasticClient) ensureIndexIsExists(ctx context.Context, indexName strin
 		return true
 	}
 
-	exists, err := e.client.IndexExists(indexName).Do(ctx)
+	exists, err := e.backend.IndexExists(ctx, indexName)
 	if err != nil {
 		log.Errorf("Error checking if index exists: %s", err)
 		return false
@@ -216,19 +411,40 @@ func (e *elasticClient) ensureIndexIsExists(ctx context.Context, indexName strin
 		if e.useDataStream {
 			return e.ensureIndexIsExistsDataStream(ctx, indexName)
 		}
+		if e.useRollover {
+			return e.ensureIndexIsExistsRollover(ctx, indexName)
+		}
 		return e.ensureIndexIsExistsRegularIndex(ctx, indexName)
 	}
 	e.indexExistsCache.Set(indexName, true, 10*time.Minute)
 	return true
 }
 
+// eventTimeIndexName names the index of timber, after the time it happened when event time routing is enabled
+func (e *elasticClient) eventTimeIndexName(indexPrefix string, timber pb.Timber) string {
+	if !e.indexNamer.EventTime {
+		return e.indexNamer.IndexName(indexPrefix, time.Now())
+	}
+
+	eventTime, ok := TimberEventTime(timber)
+	indexName, route := e.indexNamer.EventTimeIndexName(indexPrefix, eventTime, ok, time.Now())
+	prome.IncreaseConsumerEventTimeRoute(indexPrefix, route)
+	return indexName
+}
+
 func (e *elasticClient) Store(ctx context.Context, timber pb.Timber) (err error) {
 	indexPrefix := timber.GetContext().GetEsIndexPrefix()
-	indexName := fmt.Sprintf("%s-%s", indexPrefix, time.Now().Format("2006.01.02"))
-	if e.useDataStream {
-		indexName = indexPrefix
+	indexName := indexPrefix
+	if !e.useDataStream && !e.useRollover {
+		indexName = e.eventTimeIndexName(indexPrefix, timber)
 	}
-	documentType := DEFAULT_ELASTIC_DOCUMENT_TYPE
+
+	ctx, span := tracer.Start(ctx, "elasticsearch.store", trace.WithAttributes(
+		AttributeApp.String(indexPrefix),
+		AttributeIndex.String(indexName),
+	))
+	defer func() { endSpan(span, err) }()
+	documentType := e.documentType
 	appSecret := timber.GetContext().GetAppSecret()
 
 	// ensure index is exists before push the logs
@@ -243,16 +459,18 @@ func (e *elasticClient) Store(ctx context.Context, timber pb.Timber) (err error)
 	document, err := ConvertTimberToEsDocumentString(timber, e.jspbMarshaler)
 	if err != nil {
 		prome.IncreaseConsumerTimberConvertError(indexPrefix)
+		err = undeliverable(err)
 		return
 	}
 
 	redactDocument, err := e.redactor.Redact(indexPrefix, document)
 	if err != nil {
 		log.Error("Error redacting document: ", err)
+		err = undeliverable(err)
 		return
 	}
 
-	err = e.onStoreFunc(ctx, indexName, documentType, redactDocument)
+	err = e.onStoreFunc(e, ctx, indexName, documentType, redactDocument)
 	counter++
 	instruESStore(appSecret, err)
 
@@ -263,30 +481,34 @@ func (e *elasticClient) OnFailure(f func(*pb.Timber)) {
 	e.onFailureFunc = f
 }
 
-func (e *elasticClient) bulkInsert(_ context.Context, indexName, documentType, document string) (err error) {
+func (e *elasticClient) bulkInsert(ctx context.Context, indexName, documentType, document string) (err error) {
 	r := elastic.NewBulkIndexRequest().
 		Index(indexName).
 		Type(documentType).
 		Doc(document)
-	e.bulkProcessor.Add(r)
+	e.bulkProcessor.Add(e.newBulkRequest(ctx, indexName, document, r))
 	return
 }
 
-func (e *elasticClient) bulkInsertDataStream(_ context.Context, indexName, documentType, document string) (err error) {
+func (e *elasticClient) bulkInsertDataStream(ctx context.Context, indexName, documentType, document string) (err error) {
 	r := elastic.NewBulkIndexRequest().
 		OpType("create").
 		Index(indexName).
 		Type(documentType).
 		Doc(document)
-	e.bulkProcessor.Add(r)
+	e.bulkProcessor.Add(e.newBulkRequest(ctx, indexName, document, r))
 	return
 }
 
+// IndexDocument indexes document synchronously, used to redrive the documents of the dead letter topic
+func (e *elasticClient) IndexDocument(ctx context.Context, indexName, document string) (err error) {
+	if !e.ensureIndexIsExists(ctx, indexName) {
+		return fmt.Errorf("index %s is not available", indexName)
+	}
+
+	return e.backend.Index(ctx, indexName, "", document, e.useDataStream)
+}
+
 func (e *elasticClient) singleInsert(ctx context.Context, indexName, documentType, document string) (err error) {
-	_, err = e.client.Index().
-		Index(indexName).
-		Type(documentType).
-		BodyString(document).
-		Do(ctx)
-	return
+	return e.backend.Index(ctx, indexName, documentType, document, false)
 }
@@ -0,0 +1,243 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/olivere/elastic/v7"
+)
+
+const (
+	ErrEsClient              = errkit.Error("Unknown elasticsearch client")
+	ErrRolloverNotSupported  = errkit.Error("Rollover index method is not supported by OpenSearch")
+	ErrIndexTemplateNotFound = errkit.Error("Index template not found")
+
+	EsClientES7        = "ES7"
+	EsClientES8        = "ES8"
+	EsClientOpenSearch = "OpenSearch"
+)
+
+// esBackend is the elasticsearch API used by elasticClient, implemented by the clients of ES 7, ES 8 and OpenSearch.
+// The error responses of every backend are *elastic.Error, so elastic.IsNotFound and alreadyExists apply to all of them.
+type esBackend interface {
+	IndexExists(ctx context.Context, index string) (bool, error)
+	// CreateIndex creates index with body, e.g. its aliases, or with the matching templates only when body is nil
+	CreateIndex(ctx context.Context, index string, body interface{}) error
+	GetIndexTemplate(ctx context.Context, name string) (*elastic.IndicesGetIndexTemplate, error)
+	PutIndexTemplate(ctx context.Context, name string, body interface{}, create bool) error
+	GetLifecyclePolicy(ctx context.Context, name string) error
+	PutLifecyclePolicy(ctx context.Context, name string, body interface{}) error
+	// Index indexes document synchronously, documentType is empty for the typeless clients
+	Index(ctx context.Context, index, documentType, document string, create bool) error
+	BulkProcessor(bulkActions int, flushInterval time.Duration, before elastic.BulkBeforeFunc, after elastic.BulkAfterFunc) (bulkProcessor, error)
+}
+
+// bulkProcessor batches the bulkable requests, committed one bulk at a time
+type bulkProcessor interface {
+	Add(request elastic.BulkableRequest)
+	Flush() error
+	Close() error
+}
+
+// newEsBackend returns the backend of client, the requests failed by the network are retried by retrier
+func newEsBackend(client string, retrier *ElasticRetrier, urls []string, username, password string, httpClient *http.Client) (esBackend, error) {
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	switch client {
+	case EsClientES7, "":
+		return newES7Backend(retrier, urls, username, password, httpClient)
+	case EsClientES8:
+		return newES8Backend(urls, username, password, &retryTransport{base: transport, retrier: retrier})
+	case EsClientOpenSearch:
+		return newOpenSearchBackend(urls, username, password, &retryTransport{base: transport, retrier: retrier})
+	}
+	return nil, errkit.Concat(ErrEsClient, errkit.Error(client))
+}
+
+type es7Backend struct {
+	client *elastic.Client
+}
+
+func newES7Backend(retrier *ElasticRetrier, urls []string, username, password string, httpClient *http.Client) (*es7Backend, error) {
+	c, err := elastic.NewClient(
+		elastic.SetURL(urls...),
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+		elastic.SetRetrier(retrier),
+		elastic.SetBasicAuth(username, password),
+		elastic.SetGzip(true),
+		elastic.SetHttpClient(httpClient),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &es7Backend{client: c}, nil
+}
+
+func (b *es7Backend) IndexExists(ctx context.Context, index string) (bool, error) {
+	return b.client.IndexExists(index).Do(ctx)
+}
+
+func (b *es7Backend) CreateIndex(ctx context.Context, index string, body interface{}) error {
+	s := b.client.CreateIndex(index)
+	if body != nil {
+		s = s.BodyJson(body)
+	}
+	_, err := s.Do(ctx)
+	return err
+}
+
+func (b *es7Backend) GetIndexTemplate(ctx context.Context, name string) (*elastic.IndicesGetIndexTemplate, error) {
+	response, err := b.client.IndexGetIndexTemplate(name).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findIndexTemplate(response, name)
+}
+
+func (b *es7Backend) PutIndexTemplate(ctx context.Context, name string, body interface{}, create bool) error {
+	s := b.client.IndexPutIndexTemplate(name)
+	if create {
+		s = s.Create(true)
+	}
+	if payload, ok := body.(string); ok {
+		s = s.BodyString(payload)
+	} else {
+		s = s.BodyJson(body)
+	}
+	_, err := s.Do(ctx)
+	return err
+}
+
+func (b *es7Backend) GetLifecyclePolicy(ctx context.Context, name string) error {
+	_, err := b.client.XPackIlmGetLifecycle().Policy(name).Do(ctx)
+	return err
+}
+
+func (b *es7Backend) PutLifecyclePolicy(ctx context.Context, name string, body interface{}) error {
+	_, err := b.client.XPackIlmPutLifecycle().Policy(name).BodyJson(body).Do(ctx)
+	return err
+}
+
+func (b *es7Backend) Index(ctx context.Context, index, documentType, document string, create bool) error {
+	s := b.client.Index().Index(index).BodyString(document)
+	if documentType != "" {
+		s = s.Type(documentType)
+	}
+	if create {
+		s = s.OpType("create")
+	}
+	_, err := s.Do(ctx)
+	return err
+}
+
+func (b *es7Backend) BulkProcessor(bulkActions int, flushInterval time.Duration, before elastic.BulkBeforeFunc, after elastic.BulkAfterFunc) (bulkProcessor, error) {
+	// the retryable items are re-queued by the after callback instead of retried by the bulk processor,
+	// which blocks the worker and passes only the response of the last attempt to the callback
+	return b.client.BulkProcessor().
+		BulkActions(bulkActions).
+		FlushInterval(flushInterval).
+		RetryItemStatusCodes().
+		Before(before).
+		After(after).
+		Do(context.Background())
+}
+
+// findIndexTemplate returns the template name of response, a 404 *elastic.Error when it is missing
+func findIndexTemplate(response *elastic.IndicesGetIndexTemplateResponse, name string) (*elastic.IndicesGetIndexTemplate, error) {
+	for _, t := range response.IndexTemplates {
+		if t.Name == name && t.IndexTemplate != nil {
+			return t.IndexTemplate, nil
+		}
+	}
+	return nil, &elastic.Error{Status: http.StatusNotFound, Details: &elastic.ErrorDetails{
+		Type:   "resource_not_found_exception",
+		Reason: errkit.Concat(ErrIndexTemplateNotFound, errkit.Error(name)).Error(),
+	}}
+}
+
+// decodeResponse decodes the body of a successful response into v unless v is nil, an error response is returned as *elastic.Error
+func decodeResponse(statusCode int, body io.ReadCloser, v interface{}) error {
+	if body == nil {
+		body = http.NoBody
+	}
+	defer body.Close()
+
+	if statusCode < 200 || statusCode > 299 {
+		b, _ := io.ReadAll(body)
+		e := &elastic.Error{}
+		if err := json.Unmarshal(b, e); err != nil || e.Details == nil {
+			e.Details = &elastic.ErrorDetails{Reason: string(b)}
+		}
+		e.Status = statusCode
+		return e
+	}
+
+	if v == nil {
+		_, err := io.Copy(io.Discard, body)
+		return err
+	}
+	return json.NewDecoder(body).Decode(v)
+}
+
+// jsonBody encodes body into json, a string body is sent as is
+func jsonBody(body interface{}) (io.Reader, error) {
+	if s, ok := body.(string); ok {
+		return strings.NewReader(s), nil
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// retryTransport retries the requests failed by the network like the retrier of the ES 7 client,
+// the ES 8 and OpenSearch clients are configured not to retry themselves
+type retryTransport struct {
+	base    http.RoundTripper
+	retrier *ElasticRetrier
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for retry := 1; ; retry++ {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil || ctx.Err() != nil {
+			return resp, err
+		}
+
+		wait, ok, _ := t.retrier.Retry(ctx, retry, req, resp, err)
+		if !ok {
+			return nil, err
+		}
+
+		// the body is consumed by the failed attempt
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
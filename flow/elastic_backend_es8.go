@@ -0,0 +1,112 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/olivere/elastic/v7"
+)
+
+// es8Backend talks to Elasticsearch 8 with the official client, documents are typeless
+type es8Backend struct {
+	client *elasticsearch.Client
+}
+
+func newES8Backend(urls []string, username, password string, transport http.RoundTripper) (*es8Backend, error) {
+	c, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:           urls,
+		Username:            username,
+		Password:            password,
+		Transport:           transport,
+		CompressRequestBody: true,
+		DisableRetry:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &es8Backend{client: c}, nil
+}
+
+func (b *es8Backend) do(ctx context.Context, req esapi.Request, v interface{}) error {
+	res, err := req.Do(ctx, b.client)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(res.StatusCode, res.Body, v)
+}
+
+func (b *es8Backend) IndexExists(ctx context.Context, index string) (bool, error) {
+	err := b.do(ctx, esapi.IndicesExistsRequest{Index: []string{index}}, nil)
+	if elastic.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *es8Backend) CreateIndex(ctx context.Context, index string, body interface{}) error {
+	req := esapi.IndicesCreateRequest{Index: index}
+	if body != nil {
+		r, err := jsonBody(body)
+		if err != nil {
+			return err
+		}
+		req.Body = r
+	}
+	return b.do(ctx, req, nil)
+}
+
+func (b *es8Backend) GetIndexTemplate(ctx context.Context, name string) (*elastic.IndicesGetIndexTemplate, error) {
+	var response elastic.IndicesGetIndexTemplateResponse
+	if err := b.do(ctx, esapi.IndicesGetIndexTemplateRequest{Name: name}, &response); err != nil {
+		return nil, err
+	}
+	return findIndexTemplate(&response, name)
+}
+
+func (b *es8Backend) PutIndexTemplate(ctx context.Context, name string, body interface{}, create bool) error {
+	r, err := jsonBody(body)
+	if err != nil {
+		return err
+	}
+	req := esapi.IndicesPutIndexTemplateRequest{Name: name, Body: r}
+	if create {
+		req.Create = &create
+	}
+	return b.do(ctx, req, nil)
+}
+
+func (b *es8Backend) GetLifecyclePolicy(ctx context.Context, name string) error {
+	return b.do(ctx, esapi.ILMGetLifecycleRequest{Policy: name}, nil)
+}
+
+func (b *es8Backend) PutLifecyclePolicy(ctx context.Context, name string, body interface{}) error {
+	r, err := jsonBody(body)
+	if err != nil {
+		return err
+	}
+	return b.do(ctx, esapi.ILMPutLifecycleRequest{Policy: name, Body: r}, nil)
+}
+
+func (b *es8Backend) Index(ctx context.Context, index, documentType, document string, create bool) error {
+	req := esapi.IndexRequest{Index: index, Body: bytes.NewReader([]byte(document))}
+	if create {
+		req.OpType = "create"
+	}
+	return b.do(ctx, req, nil)
+}
+
+func (b *es8Backend) bulk(ctx context.Context, body []byte) (*elastic.BulkResponse, error) {
+	var response elastic.BulkResponse
+	if err := b.do(ctx, esapi.BulkRequest{Body: bytes.NewReader(body)}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (b *es8Backend) BulkProcessor(bulkActions int, flushInterval time.Duration, before elastic.BulkBeforeFunc, after elastic.BulkAfterFunc) (bulkProcessor, error) {
+	return newEsBulkProcessor(b.bulk, bulkActions, flushInterval, before, after), nil
+}
@@ -0,0 +1,108 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// openSearchBackend talks to OpenSearch 2, documents are typeless and the index lifecycle is managed by ISM instead of ILM
+type openSearchBackend struct {
+	client *opensearch.Client
+}
+
+func newOpenSearchBackend(urls []string, username, password string, transport http.RoundTripper) (*openSearchBackend, error) {
+	c, err := opensearch.NewClient(opensearch.Config{
+		Addresses:           urls,
+		Username:            username,
+		Password:            password,
+		Transport:           transport,
+		CompressRequestBody: true,
+		DisableRetry:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &openSearchBackend{client: c}, nil
+}
+
+func (b *openSearchBackend) do(ctx context.Context, req opensearchapi.Request, v interface{}) error {
+	res, err := req.Do(ctx, b.client)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(res.StatusCode, res.Body, v)
+}
+
+func (b *openSearchBackend) IndexExists(ctx context.Context, index string) (bool, error) {
+	err := b.do(ctx, opensearchapi.IndicesExistsRequest{Index: []string{index}}, nil)
+	if elastic.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *openSearchBackend) CreateIndex(ctx context.Context, index string, body interface{}) error {
+	req := opensearchapi.IndicesCreateRequest{Index: index}
+	if body != nil {
+		r, err := jsonBody(body)
+		if err != nil {
+			return err
+		}
+		req.Body = r
+	}
+	return b.do(ctx, req, nil)
+}
+
+func (b *openSearchBackend) GetIndexTemplate(ctx context.Context, name string) (*elastic.IndicesGetIndexTemplate, error) {
+	var response elastic.IndicesGetIndexTemplateResponse
+	if err := b.do(ctx, opensearchapi.IndicesGetIndexTemplateRequest{Name: []string{name}}, &response); err != nil {
+		return nil, err
+	}
+	return findIndexTemplate(&response, name)
+}
+
+func (b *openSearchBackend) PutIndexTemplate(ctx context.Context, name string, body interface{}, create bool) error {
+	r, err := jsonBody(body)
+	if err != nil {
+		return err
+	}
+	req := opensearchapi.IndicesPutIndexTemplateRequest{Name: name, Body: r}
+	if create {
+		req.Create = &create
+	}
+	return b.do(ctx, req, nil)
+}
+
+func (b *openSearchBackend) GetLifecyclePolicy(ctx context.Context, name string) error {
+	return ErrRolloverNotSupported
+}
+
+func (b *openSearchBackend) PutLifecyclePolicy(ctx context.Context, name string, body interface{}) error {
+	return ErrRolloverNotSupported
+}
+
+func (b *openSearchBackend) Index(ctx context.Context, index, documentType, document string, create bool) error {
+	req := opensearchapi.IndexRequest{Index: index, Body: bytes.NewReader([]byte(document))}
+	if create {
+		req.OpType = "create"
+	}
+	return b.do(ctx, req, nil)
+}
+
+func (b *openSearchBackend) bulk(ctx context.Context, body []byte) (*elastic.BulkResponse, error) {
+	var response elastic.BulkResponse
+	if err := b.do(ctx, opensearchapi.BulkRequest{Body: bytes.NewReader(body)}, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (b *openSearchBackend) BulkProcessor(bulkActions int, flushInterval time.Duration, before elastic.BulkBeforeFunc, after elastic.BulkAfterFunc) (bulkProcessor, error) {
+	return newEsBulkProcessor(b.bulk, bulkActions, flushInterval, before, after), nil
+}
@@ -0,0 +1,276 @@
+package flow
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/olivere/elastic/v7"
+)
+
+// esTestServer records the requests, responses are looked up by "<method> <path>" and are 200 {} otherwise
+type esTestServer struct {
+	mu        sync.Mutex
+	requests  []string
+	bodies    map[string]string
+	responses map[string]func(w http.ResponseWriter)
+}
+
+func (s *esTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		body, _ = gzip.NewReader(r.Body)
+	}
+	b, _ := io.ReadAll(body)
+
+	// checked by the ES 8 client
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
+	key := r.Method + " " + r.URL.Path
+	s.mu.Lock()
+	s.requests = append(s.requests, key)
+	s.bodies[key] = string(b)
+	s.mu.Unlock()
+
+	if respond, ok := s.responses[key]; ok {
+		respond(w)
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+func (s *esTestServer) body(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies[key]
+}
+
+func respondWith(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func newClientTestElastic(t *testing.T, client, indexMethod string, responses map[string]func(w http.ResponseWriter)) (*elasticClient, *esTestServer) {
+	server := &esTestServer{bodies: map[string]string{}, responses: responses}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	esConfig := NewEsConfig(indexMethod, 1, time.Duration(1000), false, "barito-default-replica").WithClient(client)
+	c, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+	t.Cleanup(c.Close)
+
+	return &c, server
+}
+
+func TestElasticStore_Clients(t *testing.T) {
+	indexName := NewDefaultIndexNamer().IndexName("some-type", time.Now())
+
+	testCases := []struct {
+		client   string
+		withType bool
+	}{
+		{EsClientES7, true},
+		{EsClientES8, false},
+		{EsClientOpenSearch, false},
+	}
+
+	for _, tc := range testCases {
+		resetPrometheusMetrics()
+
+		client, server := newClientTestElastic(t, tc.client, IndexMethodBulkProcessor, map[string]func(w http.ResponseWriter){
+			"HEAD /" + indexName: respondWith(http.StatusNotFound, ``),
+			"POST /_bulk":        respondWith(http.StatusOK, `{"errors":false,"items":[{"index":{"_index":"`+indexName+`","status":201,"result":"created"}}]}`),
+		})
+
+		FatalIfError(t, client.Store(context.Background(), *pb.SampleTimberProto()))
+
+		waitMetrics(t, `
+			# HELP barito_consumer_log_stored_total Number log stored to ES
+			# TYPE barito_consumer_log_stored_total counter
+			barito_consumer_log_stored_total{error="",index="some-type",result="201",status="created"} 1
+		`, "barito_consumer_log_stored_total")
+
+		server.mu.Lock()
+		requests := strings.Join(server.requests, ", ")
+		server.mu.Unlock()
+		expected := "HEAD /" + indexName + ", PUT /" + indexName + ", POST /_bulk"
+		FatalIf(t, requests != expected, "%s: expected %s, got %s", tc.client, expected, requests)
+
+		bulk := server.body("POST /_bulk")
+		FatalIf(t, !strings.Contains(bulk, `"_index":"`+indexName+`"`), "%s: wrong bulk %s", tc.client, bulk)
+		FatalIf(t, strings.Contains(bulk, `"_type"`) != tc.withType, "%s: document type should be sent by ES 7 only, got %s", tc.client, bulk)
+	}
+}
+
+func TestElasticStore_ClientsDataStream(t *testing.T) {
+	for _, c := range []string{EsClientES8, EsClientOpenSearch} {
+		resetPrometheusMetrics()
+
+		client, server := newClientTestElastic(t, c, IndexMethodDatastream, map[string]func(w http.ResponseWriter){
+			"HEAD /some-type": respondWith(http.StatusNotFound, ``),
+			"POST /_bulk":     respondWith(http.StatusOK, `{"errors":false,"items":[{"create":{"_index":".ds-some-type-000001","status":201,"result":"created"}}]}`),
+		})
+
+		FatalIfError(t, client.Store(context.Background(), *pb.SampleTimberProto()))
+		FatalIfError(t, client.bulkProcessor.Flush())
+
+		template := server.body("PUT /_index_template/some-type-template")
+		FatalIf(t, !strings.Contains(template, `"data_stream":{}`) || !strings.Contains(template, `"composed_of":["barito-default-replica"]`),
+			"%s: wrong data stream template %s", c, template)
+
+		bulk := server.body("POST /_bulk")
+		FatalIf(t, !strings.HasPrefix(bulk, `{"create":{"_index":"some-type"}}`), "%s: data stream documents should be created, got %s", c, bulk)
+	}
+}
+
+func TestElasticStore_ClientsSingleInsert(t *testing.T) {
+	indexName := NewDefaultIndexNamer().IndexName("some-type", time.Now())
+
+	for _, c := range []string{EsClientES8, EsClientOpenSearch} {
+		client, server := newClientTestElastic(t, c, IndexMethodSingleInsert, map[string]func(w http.ResponseWriter){
+			"POST /" + indexName + "/_doc": respondWith(http.StatusCreated, `{"_index":"`+indexName+`","result":"created"}`),
+		})
+
+		FatalIfError(t, client.Store(context.Background(), *pb.SampleTimberProto()))
+
+		document := server.body("POST /" + indexName + "/_doc")
+		FatalIf(t, !strings.Contains(document, `"message":"some-message"`), "%s: wrong document %s", c, document)
+	}
+}
+
+func TestElasticStore_ClientsError(t *testing.T) {
+	for _, c := range []string{EsClientES7, EsClientES8, EsClientOpenSearch} {
+		client, _ := newClientTestElastic(t, c, IndexMethodSingleInsert, map[string]func(w http.ResponseWriter){
+			"HEAD /some-type-index": respondWith(http.StatusInternalServerError, ``),
+			"PUT /abc-000001": respondWith(http.StatusBadRequest,
+				`{"error":{"type":"resource_already_exists_exception","reason":"index [abc-000001] already exists"},"status":400}`),
+			"GET /_index_template/abc": respondWith(http.StatusNotFound,
+				`{"error":{"type":"resource_not_found_exception","reason":"index template matching [abc] not found"},"status":404}`),
+		})
+
+		_, err := client.backend.IndexExists(context.Background(), "some-type-index")
+		FatalIf(t, err == nil, "%s: expected error of status 500", c)
+
+		err = client.backend.CreateIndex(context.Background(), "abc-000001", nil)
+		FatalIf(t, !alreadyExists(err), "%s: expected already exists error, got %v", c, err)
+
+		_, err = client.backend.GetIndexTemplate(context.Background(), "abc")
+		FatalIf(t, !elastic.IsNotFound(err), "%s: expected not found error, got %v", c, err)
+	}
+}
+
+func TestNewElastic_Client(t *testing.T) {
+	retrier := NewElasticRetrier(time.Millisecond, 1, nil, nil)
+
+	_, err := NewElastic(retrier, NewEsConfig(IndexMethodBulkProcessor, 1, 1000, false, "").WithClient("ES5"), []string{"http://localhost:9200"}, "", "", nil)
+	FatalIfWrongError(t, err, string(ErrEsClient)+": ES5")
+
+	_, err = NewElastic(retrier, NewEsConfig(IndexMethodRollover, 1, 1000, false, "").WithClient(EsClientOpenSearch), []string{"http://localhost:9200"}, "", "", nil)
+	FatalIfWrongError(t, err, string(ErrRolloverNotSupported))
+}
+
+type failingRoundTripper struct {
+	failures int
+	calls    int
+	bodies   []string
+}
+
+func (rt *failingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.calls++
+	b, _ := io.ReadAll(req.Body)
+	rt.bodies = append(rt.bodies, string(b))
+	if rt.calls <= rt.failures {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	retries, maxRetryReached := 0, false
+	retrier := NewElasticRetrier(time.Millisecond, 3, func(err error) { retries++ }, func() { maxRetryReached = true })
+
+	base := &failingRoundTripper{failures: 2}
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:9200/_bulk", strings.NewReader("documents"))
+	_, err := (&retryTransport{base: base, retrier: retrier}).RoundTrip(req)
+	FatalIfError(t, err)
+	FatalIf(t, base.calls != 3 || retries != 2, "expected 2 retries, got %d calls and %d retries", base.calls, retries)
+	FatalIf(t, base.bodies[2] != "documents", "body should be replayed, got %q", base.bodies[2])
+
+	base = &failingRoundTripper{failures: 10}
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost:9200/_bulk", strings.NewReader("documents"))
+	_, err = (&retryTransport{base: base, retrier: retrier}).RoundTrip(req)
+	FatalIfWrongError(t, err, "connection refused")
+	FatalIf(t, !maxRetryReached || base.calls != 3, "expected to give up after 3 calls, got %d", base.calls)
+}
+
+func TestEsBulkProcessor(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	var executions []int64
+	var acked int
+
+	bulk := func(ctx context.Context, body []byte) (*elastic.BulkResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		return &elastic.BulkResponse{}, nil
+	}
+	before := func(executionId int64, requests []elastic.BulkableRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		executions = append(executions, executionId)
+	}
+	after := func(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		acked += len(requests)
+	}
+
+	p := newEsBulkProcessor(bulk, 2, time.Hour, before, after)
+	for _, doc := range []string{`{"a":1}`, `{"a":2}`, `{"a":3}`} {
+		p.Add(elastic.NewBulkIndexRequest().Index("abc").Doc(doc))
+	}
+
+	mu.Lock()
+	FatalIf(t, len(bodies) != 1 || acked != 2, "full bulk should be committed, got %d bulks and %d acked", len(bodies), acked)
+	FatalIf(t, bodies[0] != "{\"index\":{\"_index\":\"abc\"}}\n{\"a\":1}\n{\"index\":{\"_index\":\"abc\"}}\n{\"a\":2}\n", "wrong bulk body %q", bodies[0])
+	mu.Unlock()
+
+	FatalIfError(t, p.Close())
+	FatalIf(t, len(bodies) != 2 || acked != 3, "queued requests should be committed on close, got %d bulks and %d acked", len(bodies), acked)
+	FatalIf(t, executions[0] != 1 || executions[1] != 2, "wrong execution ids %v", executions)
+}
+
+func TestEsBulkProcessor_FlushInterval(t *testing.T) {
+	committed := make(chan int, 1)
+	bulk := func(ctx context.Context, body []byte) (*elastic.BulkResponse, error) {
+		return &elastic.BulkResponse{}, nil
+	}
+	after := func(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+		committed <- len(requests)
+	}
+
+	p := newEsBulkProcessor(bulk, 100, 10*time.Millisecond, nil, after)
+	defer p.Close()
+	p.Add(elastic.NewBulkIndexRequest().Index("abc").Doc(`{"a":1}`))
+
+	select {
+	case n := <-committed:
+		FatalIf(t, n != 1, "expected 1 request committed, got %d", n)
+	case <-time.After(time.Second):
+		t.Fatal("queued request should be committed by the flush interval")
+	}
+}
@@ -0,0 +1,145 @@
+package flow
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+var rolloverIndexPattern = regexp.MustCompile(`-\d{6,}$`)
+
+const (
+	DefaultRolloverMaxPrimaryShardSize = "50gb"
+	DefaultRolloverMaxAge              = "1d"
+	DefaultRolloverShards              = 1
+	DefaultRolloverReplicas            = 1
+)
+
+// RolloverConfig is the ILM policy and index settings bootstrapped for every index prefix by the rollover index method.
+// The policy and the template are created once, so they can be tuned per prefix afterward.
+type RolloverConfig struct {
+	MaxPrimaryShardSize string
+	MaxAge              string
+	// DeleteAfter is the age of the rolled over indices to be deleted, they are kept when it is empty
+	DeleteAfter string
+	Shards      int
+	Replicas    int
+}
+
+func DefaultRolloverConfig() RolloverConfig {
+	return RolloverConfig{
+		MaxPrimaryShardSize: DefaultRolloverMaxPrimaryShardSize,
+		MaxAge:              DefaultRolloverMaxAge,
+		Shards:              DefaultRolloverShards,
+		Replicas:            DefaultRolloverReplicas,
+	}
+}
+
+func rolloverPolicyName(alias string) string {
+	return alias + "-policy"
+}
+
+func rolloverTemplateName(alias string) string {
+	return alias + "-rollover-template"
+}
+
+// rolloverBootstrapIndex is the first index of alias, the next ones are named by rollover, i.e. alias-000002
+func rolloverBootstrapIndex(alias string) string {
+	return alias + "-000001"
+}
+
+// rolloverAlias returns the alias of an index named by rollover, i.e. alias-000002
+func rolloverAlias(index string) string {
+	return rolloverIndexPattern.ReplaceAllString(index, "")
+}
+
+func (c RolloverConfig) policy() map[string]interface{} {
+	rollover := map[string]interface{}{}
+	if c.MaxPrimaryShardSize != "" {
+		rollover["max_primary_shard_size"] = c.MaxPrimaryShardSize
+	}
+	if c.MaxAge != "" {
+		rollover["max_age"] = c.MaxAge
+	}
+
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{"rollover": rollover},
+		},
+	}
+	if c.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": c.DeleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+
+	return map[string]interface{}{"policy": map[string]interface{}{"phases": phases}}
+}
+
+// template matches alias-000001 and the next indices, but not the indices of another prefix starting with alias, e.g. alias-api-000001
+func (c RolloverConfig) template(alias string) map[string]interface{} {
+	return map[string]interface{}{
+		"index_patterns": []string{alias + "-0*"},
+		"priority":       150,
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index.number_of_shards":         c.Shards,
+				"index.number_of_replicas":       c.Replicas,
+				"index.lifecycle.name":           rolloverPolicyName(alias),
+				"index.lifecycle.rollover_alias": alias,
+			},
+		},
+		"_meta": map[string]interface{}{"description": "barito rollover template"},
+	}
+}
+
+// ensureIndexIsExistsRollover bootstraps the ILM policy, the index template and the first index holding the write alias.
+// It is safe to run concurrently by several consumers, the resources created by another one are kept.
+func (e *elasticClient) ensureIndexIsExistsRollover(ctx context.Context, alias string) bool {
+	log.Warnf("ES rollover alias '%s' is not exist", alias)
+
+	policyName := rolloverPolicyName(alias)
+	if err := e.backend.GetLifecyclePolicy(ctx, policyName); elastic.IsNotFound(err) {
+		err = e.backend.PutLifecyclePolicy(ctx, policyName, e.rollover.policy())
+		if err != nil {
+			log.Errorf("Error creating ILM policy %s: %s", policyName, err)
+			return false
+		}
+	} else if err != nil {
+		log.Errorf("Error getting ILM policy %s: %s", policyName, err)
+		return false
+	}
+
+	err := e.backend.PutIndexTemplate(ctx, rolloverTemplateName(alias), e.rollover.template(alias), true)
+	if err != nil && !alreadyExists(err) {
+		log.Errorf("Error creating index template %s: %s", rolloverTemplateName(alias), err)
+		return false
+	}
+
+	err = e.backend.CreateIndex(ctx, rolloverBootstrapIndex(alias), map[string]interface{}{
+		"aliases": map[string]interface{}{
+			alias: map[string]interface{}{"is_write_index": true},
+		},
+	})
+	instruESCreateIndex(err)
+	if err != nil && !alreadyExists(err) {
+		log.Errorf("Error creating index: %s", err)
+		return false
+	}
+
+	e.indexExistsCache.Set(alias, true, 10*time.Minute)
+	return true
+}
+
+func alreadyExists(err error) bool {
+	e, ok := err.(*elastic.Error)
+	if !ok || e.Details == nil {
+		return false
+	}
+	return e.Details.Type == "resource_already_exists_exception" || strings.Contains(e.Details.Reason, "already exists")
+}
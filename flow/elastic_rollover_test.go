@@ -0,0 +1,99 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+)
+
+func newRolloverTestClient(t *testing.T, responses map[string]func(w http.ResponseWriter)) (*elasticClient, *esTestServer) {
+	server := &esTestServer{bodies: map[string]string{}, responses: responses}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	esConfig := NewEsConfig(IndexMethodRollover, 1, time.Duration(1000), false, "").WithRollover(RolloverConfig{
+		MaxPrimaryShardSize: "10gb",
+		MaxAge:              "12h",
+		DeleteAfter:         "7d",
+		Shards:              3,
+		Replicas:            1,
+	})
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+	t.Cleanup(client.Close)
+
+	return &client, server
+}
+
+func TestElasticStore_RolloverBootstrap(t *testing.T) {
+	resetPrometheusMetrics()
+
+	client, server := newRolloverTestClient(t, map[string]func(w http.ResponseWriter){
+		"HEAD /some-type":                   respondWith(http.StatusNotFound, ``),
+		"GET /_ilm/policy/some-type-policy": respondWith(http.StatusNotFound, `{"error":{"type":"resource_not_found_exception","reason":"Lifecycle policy not found"},"status":404}`),
+		"POST /_bulk":                       respondWith(http.StatusOK, `{"errors":false,"items":[{"index":{"_index":"some-type-000003","status":201,"result":"created"}}]}`),
+	})
+
+	FatalIfError(t, client.Store(context.Background(), *pb.SampleTimberProto()))
+
+	server.mu.Lock()
+	requests := strings.Join(server.requests, ", ")
+	server.mu.Unlock()
+	expected := "HEAD /some-type, GET /_ilm/policy/some-type-policy, PUT /_ilm/policy/some-type-policy, " +
+		"PUT /_index_template/some-type-rollover-template, PUT /some-type-000001"
+	FatalIf(t, !strings.HasPrefix(requests, expected), "expected bootstrap %s, got %s", expected, requests)
+
+	policy := server.body("PUT /_ilm/policy/some-type-policy")
+	FatalIf(t, !strings.Contains(policy, `"max_primary_shard_size":"10gb"`) || !strings.Contains(policy, `"max_age":"12h"`) ||
+		!strings.Contains(policy, `"min_age":"7d"`), "wrong policy %s", policy)
+
+	template := server.body("PUT /_index_template/some-type-rollover-template")
+	FatalIf(t, !strings.Contains(template, `"index_patterns":["some-type-0*"]`) ||
+		!strings.Contains(template, `"index.lifecycle.rollover_alias":"some-type"`) ||
+		!strings.Contains(template, `"index.number_of_shards":3`), "wrong template %s", template)
+
+	index := server.body("PUT /some-type-000001")
+	FatalIf(t, index != `{"aliases":{"some-type":{"is_write_index":true}}}`, "wrong bootstrap index %s", index)
+
+	waitMetrics(t, `
+		# HELP barito_consumer_log_stored_total Number log stored to ES
+		# TYPE barito_consumer_log_stored_total counter
+		barito_consumer_log_stored_total{error="",index="some-type",result="201",status="created"} 1
+	`, "barito_consumer_log_stored_total")
+
+	bulk := server.body("POST /_bulk")
+	FatalIf(t, !strings.Contains(bulk, `"_index":"some-type"`), "documents should be written through the alias, got %s", bulk)
+}
+
+func TestElasticStore_RolloverAlreadyBootstrapped(t *testing.T) {
+	client, server := newRolloverTestClient(t, map[string]func(w http.ResponseWriter){
+		"HEAD /some-type": respondWith(http.StatusNotFound, ``),
+		"PUT /_index_template/some-type-rollover-template": respondWith(http.StatusBadRequest,
+			`{"error":{"type":"illegal_argument_exception","reason":"index template [some-type-rollover-template] already exists"},"status":400}`),
+		"PUT /some-type-000001": respondWith(http.StatusBadRequest,
+			`{"error":{"type":"resource_already_exists_exception","reason":"index [some-type-000001] already exists"},"status":400}`),
+	})
+
+	FatalIf(t, !client.ensureIndexIsExists(context.Background(), "some-type"), "alias bootstrapped by another consumer should exist")
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	expected := "HEAD /some-type, GET /_ilm/policy/some-type-policy, PUT /_index_template/some-type-rollover-template, PUT /some-type-000001"
+	FatalIf(t, strings.Join(server.requests, ", ") != expected, "existing policy should not be updated, expected %s, got %s", expected, strings.Join(server.requests, ", "))
+}
+
+func TestElasticStore_RolloverTemplateError(t *testing.T) {
+	client, _ := newRolloverTestClient(t, map[string]func(w http.ResponseWriter){
+		"HEAD /some-type": respondWith(http.StatusNotFound, ``),
+		"PUT /_index_template/some-type-rollover-template": respondWith(http.StatusBadRequest,
+			`{"error":{"type":"illegal_argument_exception","reason":"unknown setting"},"status":400}`),
+	})
+
+	FatalIf(t, client.ensureIndexIsExists(context.Background(), "some-type"), "alias should not exist when the template failed")
+}
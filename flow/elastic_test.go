@@ -9,9 +9,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/BaritoLog/barito-flow/mock"
 	. "github.com/BaritoLog/go-boilerplate/testkit"
 	"github.com/BaritoLog/instru"
+	"github.com/Shopify/sarama"
 	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	"github.com/olivere/elastic/v7"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 )
@@ -177,3 +181,48 @@ func TestElasticStore_ExportMetrics(t *testing.T) {
 	`
 	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_log_stored_total"))
 }
+
+func TestBulkRequest_deliver(t *testing.T) {
+	response := &elastic.BulkResponse{Items: []map[string]*elastic.BulkResponseItem{
+		{"index": {Status: 201}},
+		{"index": {Status: 400, Error: &elastic.ErrorDetails{Type: "mapper_parsing_exception", Reason: "failed to parse"}}},
+		{"index": {Status: 429}},
+	}}
+
+	testCases := []struct {
+		name          string
+		response      *elastic.BulkResponse
+		i             int
+		err           error
+		undeliverable bool
+	}{
+		{"created", response, 0, nil, false},
+		{"rejected", response, 1, nil, true},
+		{"retry exhausted", response, 2, nil, true},
+		{"missing item", response, 3, nil, true},
+		{"bulk failed", nil, 0, fmt.Errorf("connection refused"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var acked error
+			bulkRequest{ack: func(err error) { acked = err }}.deliver(tc.response, tc.i, tc.err)
+			FatalIf(t, tc.name == "created" && acked != nil, "expected no error, got %s", acked)
+			FatalIf(t, tc.name != "created" && acked == nil, "expected error")
+			FatalIf(t, isUndeliverable(acked) != tc.undeliverable, "undeliverable of %v should be %v", acked, tc.undeliverable)
+		})
+	}
+
+	// the document is redelivered when it can not be published into the dead letter topic
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), fmt.Errorf("some-error"))
+
+	var acked error
+	request := bulkRequest{ack: func(err error) { acked = err }, deadLetter: newDeadLetterQueue("dead_letter", producer),
+		source: &sarama.ConsumerMessage{Topic: "some_topic"}}
+	request.deliver(response, 1, nil)
+	FatalIf(t, acked == nil || isUndeliverable(acked), "failed dead letter should be redelivered, got %v", acked)
+}
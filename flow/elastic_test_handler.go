@@ -19,6 +19,9 @@ func (handler *ELasticTestHandler) getResponseBody() (body []byte) {
 }
 
 func (handler *ELasticTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// checked by the ES 8 client
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
 	if handler.CustomHandler == nil {
 		if r.Method == "HEAD" { // check if index exist
 			w.WriteHeader(handler.ExistAPIStatus)
@@ -0,0 +1,365 @@
+package flow
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	pb "github.com/bentol/barito-proto/producer"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	ErrEsBulkRule = errkit.Error("Invalid Elasticsearch bulk rule")
+
+	EsBulkPath = "/_bulk"
+
+	EsBulkResultAccepted = "accepted"
+	EsBulkResultRejected = "rejected"
+	EsBulkResultNoApp    = "no_app"
+	EsBulkResultInvalid  = "invalid"
+
+	esBulkActionIndex  = "index"
+	esBulkActionCreate = "create"
+	esBulkActionUpdate = "update"
+	esBulkActionDelete = "delete"
+)
+
+// EsBulkRule maps Elasticsearch index into app, Index is a glob pattern
+type EsBulkRule struct {
+	Index string
+	App   string
+}
+
+// ParseEsBulkRule parses "<index glob>=<app>"
+func ParseEsBulkRule(s string) (rule EsBulkRule, err error) {
+	i := strings.LastIndex(s, "=")
+	if i < 1 || i == len(s)-1 {
+		err = errkit.Concat(ErrEsBulkRule, errkit.Error(s))
+		return
+	}
+
+	rule.Index, rule.App = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	if _, err = path.Match(rule.Index, ""); err != nil {
+		err = errkit.Concat(ErrEsBulkRule, err)
+	}
+	return
+}
+
+// EsBulkConfig enables the Elasticsearch bulk compatible endpoint of the producer.
+// Version is the Elasticsearch version reported to the clients, Beats and Logstash check it before sending.
+type EsBulkConfig struct {
+	Addr       string
+	Version    string
+	Rules      []EsBulkRule
+	DefaultApp string
+	AppMaxTps  int32
+}
+
+// esBulkReceiver accepts Elasticsearch `_bulk` NDJSON requests, writes the documents through ProduceBatch,
+// one collection per app, and answers with bulk response so Beats and Logstash outputs work unchanged
+type esBulkReceiver struct {
+	producer *producerService
+	config   EsBulkConfig
+	hostname string
+}
+
+func newEsBulkReceiver(producer *producerService, config EsBulkConfig) *esBulkReceiver {
+	hostname, _ := os.Hostname()
+	return &esBulkReceiver{
+		producer: producer,
+		config:   config,
+		hostname: hostname,
+	}
+}
+
+type esBulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type esBulkItemResult struct {
+	Index   string       `json:"_index"`
+	Id      string       `json:"_id"`
+	Version int          `json:"_version,omitempty"`
+	Result  string       `json:"result,omitempty"`
+	Status  int          `json:"status"`
+	Error   *esBulkError `json:"error,omitempty"`
+}
+
+type esBulkResponse struct {
+	Took   int64                          `json:"took"`
+	Errors bool                           `json:"errors"`
+	Items  []map[string]*esBulkItemResult `json:"items"`
+}
+
+// esBulkItem is one action of the bulk request, timber is nil when the action already failed
+type esBulkItem struct {
+	action string
+	result *esBulkItemResult
+	app    string
+	timber *pb.Timber
+}
+
+func (r *esBulkReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/" {
+		r.serveInfo(w, req)
+		return
+	}
+
+	index, ok := esBulkIndexOf(req.URL.Path)
+	if !ok {
+		writeEsError(w, http.StatusNotFound, "no_handler_found_exception", fmt.Sprintf("no handler found for uri [%s]", req.URL.Path))
+		return
+	}
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		writeEsError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("method [%s] is not allowed", req.Method))
+		return
+	}
+
+	start := time.Now()
+	body, err := readHTTPBody(req, int64(r.producer.grpcMaxRecvMsgSize))
+	if err != nil {
+		writeEsError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+
+	items, err := r.parse(body, index)
+	if err != nil {
+		writeEsError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	resp := r.produce(req, items)
+	resp.Took = time.Since(start).Milliseconds()
+	writeEsJson(w, http.StatusOK, resp)
+}
+
+// serveInfo answers the root endpoint which the clients use to check the cluster version
+func (r *esBulkReceiver) serveInfo(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeEsError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("method [%s] is not allowed", req.Method))
+		return
+	}
+
+	writeEsJson(w, http.StatusOK, map[string]interface{}{
+		"name":         r.hostname,
+		"cluster_name": "barito",
+		"version": map[string]interface{}{
+			"number":       r.config.Version,
+			"build_flavor": "default",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+// esBulkIndexOf returns the default index of `/_bulk`, `/<index>/_bulk` and `/<index>/<type>/_bulk`
+func esBulkIndexOf(urlPath string) (index string, ok bool) {
+	if !strings.HasSuffix(urlPath, EsBulkPath) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(urlPath, EsBulkPath), "/"), "/")
+	if len(parts) > 2 {
+		return
+	}
+	return parts[0], true
+}
+
+// parse reads NDJSON body into items, only malformed action lines fail the whole request like Elasticsearch does
+func (r *esBulkReceiver) parse(body []byte, defaultIndex string) (items []*esBulkItem, err error) {
+	lines := bytes.Split(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+
+		var action map[string]struct {
+			Index string `json:"_index"`
+			Id    string `json:"_id"`
+		}
+		if err = json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			return nil, fmt.Errorf("malformed action/metadata line [%d], expected a single action object", i+1)
+		}
+
+		item := &esBulkItem{}
+		for name, meta := range action {
+			item.action = name
+			item.result = &esBulkItemResult{Index: meta.Index, Id: meta.Id}
+		}
+		if item.result.Index == "" {
+			item.result.Index = defaultIndex
+		}
+		items = append(items, item)
+
+		switch item.action {
+		case esBulkActionIndex, esBulkActionCreate:
+		case esBulkActionUpdate:
+			i++
+			item.reject(http.StatusBadRequest, "action_request_validation_exception", "update action is not supported")
+			continue
+		case esBulkActionDelete:
+			item.reject(http.StatusBadRequest, "action_request_validation_exception", "delete action is not supported")
+			continue
+		default:
+			return nil, fmt.Errorf("malformed action/metadata line [%d], unknown action [%s]", i+1, item.action)
+		}
+
+		i++
+		if i >= len(lines) || len(bytes.TrimSpace(lines[i])) == 0 {
+			return nil, fmt.Errorf("the %s action at line [%d] has no source", item.action, i)
+		}
+		if item.result.Index == "" {
+			item.reject(http.StatusBadRequest, "action_request_validation_exception", "index is missing")
+			continue
+		}
+
+		content := &stpb.Struct{}
+		if err := protojson.Unmarshal(lines[i], content); err != nil {
+			item.reject(http.StatusBadRequest, "mapper_parsing_exception", "failed to parse document: "+err.Error())
+			continue
+		}
+
+		item.app = r.app(item.result.Index)
+		if item.app == "" {
+			item.fail(http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no app for index [%s]", item.result.Index))
+			prome.IncreaseProducerEsBulkItems(EsBulkResultNoApp, 1)
+			continue
+		}
+		item.timber = &pb.Timber{Content: content}
+	}
+
+	return items, nil
+}
+
+// produce writes the parsed items, an invalid item is rejected alone and every valid item of an app shares the result of its ProduceBatch
+func (r *esBulkReceiver) produce(req *http.Request, items []*esBulkItem) *esBulkResponse {
+	var apps []string
+	byApp := make(map[string]*pb.TimberCollection)
+	for _, item := range items {
+		if item.timber == nil {
+			continue
+		}
+		if err := r.producer.validateBatchItem(item.app, item.timber); err != nil {
+			st := status.Convert(err)
+			item.reject(httpStatusOf(st.Code()), esErrorType(st.Code()), st.Message())
+			item.timber = nil
+			continue
+		}
+
+		timberCollection, ok := byApp[item.app]
+		if !ok {
+			timberCollection = &pb.TimberCollection{
+				Context: newAppTimberContext(item.app, r.config.AppMaxTps),
+			}
+			byApp[item.app] = timberCollection
+			apps = append(apps, item.app)
+		}
+		timberCollection.Items = append(timberCollection.Items, item.timber)
+	}
+
+	errs := make(map[string]error)
+	for _, app := range apps {
+		timberCollection := byApp[app]
+		if _, err := r.producer.ProduceBatch(req.Context(), timberCollection); err != nil {
+			errs[app] = err
+			prome.IncreaseProducerEsBulkItems(EsBulkResultRejected, len(timberCollection.Items))
+			continue
+		}
+		prome.IncreaseProducerEsBulkItems(EsBulkResultAccepted, len(timberCollection.Items))
+	}
+
+	resp := &esBulkResponse{Items: make([]map[string]*esBulkItemResult, 0, len(items))}
+	for _, item := range items {
+		if item.timber != nil {
+			if err, ok := errs[item.app]; ok {
+				st := status.Convert(err)
+				item.fail(httpStatusOf(st.Code()), esErrorType(st.Code()), st.Message())
+			} else {
+				item.succeed()
+			}
+		}
+
+		resp.Errors = resp.Errors || item.result.Error != nil
+		resp.Items = append(resp.Items, map[string]*esBulkItemResult{item.action: item.result})
+	}
+	return resp
+}
+
+func (r *esBulkReceiver) app(index string) string {
+	for _, rule := range r.config.Rules {
+		if ok, _ := path.Match(rule.Index, index); ok {
+			return rule.App
+		}
+	}
+	return r.config.DefaultApp
+}
+
+func (item *esBulkItem) fail(code int, errType, reason string) {
+	item.result.Status = code
+	item.result.Error = &esBulkError{Type: errType, Reason: reason}
+}
+
+// reject fails the item which is invalid by itself
+func (item *esBulkItem) reject(code int, errType, reason string) {
+	item.fail(code, errType, reason)
+	prome.IncreaseProducerEsBulkItems(EsBulkResultInvalid, 1)
+}
+
+func (item *esBulkItem) succeed() {
+	if item.result.Id == "" {
+		item.result.Id = newEsDocumentId()
+	}
+	item.result.Version = 1
+	item.result.Result = "created"
+	item.result.Status = http.StatusCreated
+}
+
+// esErrorType names the error like Elasticsearch does, clients retry es_rejected_execution_exception
+func esErrorType(code codes.Code) string {
+	switch code {
+	case codes.InvalidArgument:
+		return "mapper_parsing_exception"
+	case codes.ResourceExhausted:
+		return "es_rejected_execution_exception"
+	case codes.PermissionDenied:
+		return "security_exception"
+	case codes.Unavailable:
+		return "unavailable_shards_exception"
+	}
+	return "exception"
+}
+
+// newEsDocumentId generates 20 characters URL-safe id like Elasticsearch auto-generated ids
+func newEsDocumentId() string {
+	b := make([]byte, 15)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeEsError(w http.ResponseWriter, code int, errType, reason string) {
+	writeEsJson(w, code, map[string]interface{}{
+		"error":  esBulkError{Type: errType, Reason: reason},
+		"status": code,
+	})
+}
+
+func writeEsJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,245 @@
+package flow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+)
+
+func newEsBulkTestProducer(t *testing.T, sendCount int) (*producerService, *gomock.Controller, chan *sarama.ProducerMessage) {
+	ctrl := gomock.NewController(t)
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+
+	sent := make(chan *sarama.ProducerMessage, sendCount)
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Times(sendCount).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		sent <- msg
+		return 0, 0, nil
+	})
+
+	srv := &producerService{
+		producer:           producer,
+		topicSuffix:        "_logs",
+		admin:              admin,
+		limiter:            NewDummyRateLimiter(),
+		kafkaMessageFormat: TimberCollectionMessageFormat,
+	}
+	srv.esBulk = newEsBulkReceiver(srv, EsBulkConfig{
+		Version: "7.17.0",
+		Rules: []EsBulkRule{
+			{Index: "filebeat-*", App: "filebeat"},
+			{Index: "logstash-*", App: "logstash"},
+		},
+		AppMaxTps: 100,
+	})
+	return srv, ctrl, sent
+}
+
+func serveEsBulk(srv *producerService, method, target, body string) (*httptest.ResponseRecorder, *esBulkResponse) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	srv.esBulk.ServeHTTP(rec, req)
+
+	resp := &esBulkResponse{}
+	json.Unmarshal(rec.Body.Bytes(), resp)
+	return rec, resp
+}
+
+func TestParseEsBulkRule(t *testing.T) {
+	rule, err := ParseEsBulkRule("filebeat-*=filebeat")
+	FatalIfError(t, err)
+	FatalIf(t, rule.Index != "filebeat-*" || rule.App != "filebeat", "wrong rule %v", rule)
+
+	_, err = ParseEsBulkRule("filebeat")
+	FatalIfWrongError(t, err, string(ErrEsBulkRule)+": filebeat")
+}
+
+func TestEsBulkIndexOf(t *testing.T) {
+	testCases := []struct {
+		path  string
+		index string
+		ok    bool
+	}{
+		{"/_bulk", "", true},
+		{"/filebeat-7.17.0/_bulk", "filebeat-7.17.0", true},
+		{"/filebeat-7.17.0/_doc/_bulk", "filebeat-7.17.0", true},
+		{"/a/b/c/_bulk", "", false},
+		{"/_search", "", false},
+	}
+
+	for _, tc := range testCases {
+		index, ok := esBulkIndexOf(tc.path)
+		FatalIf(t, index != tc.index || ok != tc.ok, "%s: expected %q %v, got %q %v", tc.path, tc.index, tc.ok, index, ok)
+	}
+}
+
+func TestEsBulkReceiver_ServeHTTP(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, sent := newEsBulkTestProducer(t, 2)
+	defer ctrl.Finish()
+
+	body := `{"index":{"_index":"filebeat-7.17.0-2024.06.01"}}
+{"@timestamp":"2024-06-01T12:00:00Z","message":"first"}
+{"create":{"_index":"logstash-2024.06.01","_id":"some-id"}}
+{"@timestamp":"2024-06-01T12:00:00Z","message":"second"}
+{"index":{}}
+{"message":"third"}
+`
+	rec, resp := serveEsBulk(srv, http.MethodPost, "/filebeat-7.17.0-2024.06.01/_bulk", body)
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d: %s", rec.Code, rec.Body.String())
+	FatalIf(t, resp.Errors, "expected no errors: %s", rec.Body.String())
+	FatalIf(t, len(resp.Items) != 3, "expected 3 items, got %d", len(resp.Items))
+
+	first := resp.Items[0]["index"]
+	FatalIf(t, first.Status != http.StatusCreated || first.Id == "", "expected created with generated id, got %v", first)
+	second := resp.Items[1]["create"]
+	FatalIf(t, second.Status != http.StatusCreated || second.Id != "some-id", "expected created with given id, got %v", second)
+	third := resp.Items[2]["index"]
+	FatalIf(t, third.Index != "filebeat-7.17.0-2024.06.01", "default index should come from the path, got %s", third.Index)
+
+	topics := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		topics[(<-sent).Topic] = true
+	}
+	FatalIf(t, !topics["filebeat_logs"] || !topics["logstash_logs"], "expected one record per app, got %v", topics)
+}
+
+func TestEsBulkReceiver_ServeHTTP_ItemErrors(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, _ := newEsBulkTestProducer(t, 0)
+	defer ctrl.Finish()
+
+	body := `{"index":{"_index":"unknown-index"}}
+{"message":"no app"}
+{"index":{"_index":"filebeat-1"}}
+not a json
+{"update":{"_index":"filebeat-1","_id":"1"}}
+{"doc":{"message":"updated"}}
+{"delete":{"_index":"filebeat-1","_id":"1"}}
+`
+	rec, resp := serveEsBulk(srv, http.MethodPost, "/_bulk", body)
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d", rec.Code)
+	FatalIf(t, !resp.Errors, "expected errors")
+
+	expected := []struct {
+		action string
+		status int
+	}{
+		{"index", http.StatusNotFound},
+		{"index", http.StatusBadRequest},
+		{"update", http.StatusBadRequest},
+		{"delete", http.StatusBadRequest},
+	}
+	FatalIf(t, len(resp.Items) != len(expected), "expected %d items, got %d", len(expected), len(resp.Items))
+	for i, e := range expected {
+		item := resp.Items[i][e.action]
+		FatalIf(t, item == nil || item.Status != e.status || item.Error == nil, "item %d: expected %s %d, got %v", i, e.action, e.status, item)
+	}
+}
+
+func TestEsBulkReceiver_ServeHTTP_MixedValidity(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, sent := newEsBulkTestProducer(t, 1)
+	defer ctrl.Finish()
+	srv.validator = NewTimberValidator(0, 10, 0)
+
+	body := `{"index":{"_index":"filebeat-1"}}
+{"message":"first"}
+{"index":{"_index":"filebeat-1"}}
+{}
+{"index":{"_index":"filebeat-1"}}
+{"message":"oversized field"}
+{"index":{"_index":"filebeat-1"}}
+{"message":"fourth"}
+`
+	rec, resp := serveEsBulk(srv, http.MethodPost, "/_bulk", body)
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d", rec.Code)
+	FatalIf(t, !resp.Errors, "expected errors")
+
+	expected := []int{http.StatusCreated, http.StatusBadRequest, http.StatusBadRequest, http.StatusCreated}
+	FatalIf(t, len(resp.Items) != len(expected), "expected %d items, got %d", len(expected), len(resp.Items))
+	for i, status := range expected {
+		item := resp.Items[i]["index"]
+		FatalIf(t, item.Status != status, "item %d: expected %d, got %v", i, status, item)
+		FatalIf(t, status == http.StatusBadRequest && item.Error.Type != "mapper_parsing_exception", "item %d: wrong error %v", i, item.Error)
+	}
+
+	value, _ := (<-sent).Value.Encode()
+	timberCollection, err := ConvertKafkaMessageToTimberCollection(&sarama.ConsumerMessage{Value: value})
+	FatalIfError(t, err)
+	FatalIf(t, len(timberCollection.Items) != 2, "only the valid items should be produced, got %d", len(timberCollection.Items))
+}
+
+func TestEsBulkReceiver_ServeHTTP_RateLimited(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, _ := newEsBulkTestProducer(t, 0)
+	defer ctrl.Finish()
+	limiter := NewDummyRateLimiter()
+	limiter.Expect_IsHitLimit_AlwaysTrue()
+	srv.limiter = limiter
+
+	rec, resp := serveEsBulk(srv, http.MethodPost, "/_bulk", "{\"index\":{\"_index\":\"filebeat-1\"}}\n{\"message\":\"first\"}\n")
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d", rec.Code)
+
+	item := resp.Items[0]["index"]
+	FatalIf(t, item.Status != http.StatusTooManyRequests, "expected 429, got %d", item.Status)
+	FatalIf(t, item.Error.Type != "es_rejected_execution_exception", "wrong error type %s", item.Error.Type)
+}
+
+func TestEsBulkReceiver_ServeHTTP_Error(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, _ := newEsBulkTestProducer(t, 0)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"malformed action", http.MethodPost, "/_bulk", "not a json\n", http.StatusBadRequest},
+		{"unknown action", http.MethodPost, "/_bulk", "{\"upsert\":{}}\n{}\n", http.StatusBadRequest},
+		{"missing source", http.MethodPost, "/_bulk", "{\"index\":{\"_index\":\"filebeat-1\"}}\n", http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "/_bulk", "", http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodPut, "/_index_template/filebeat", "{}", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, _ := serveEsBulk(srv, tc.method, tc.target, tc.body)
+			FatalIf(t, rec.Code != tc.code, "expected %d, got %d", tc.code, rec.Code)
+		})
+	}
+}
+
+func TestEsBulkReceiver_ServeInfo(t *testing.T) {
+	srv, ctrl, _ := newEsBulkTestProducer(t, 0)
+	defer ctrl.Finish()
+
+	rec, _ := serveEsBulk(srv, http.MethodGet, "/", "")
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d", rec.Code)
+
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	FatalIfError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	FatalIf(t, info.Version.Number != "7.17.0", "wrong version %s", info.Version.Number)
+}
@@ -0,0 +1,620 @@
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	pb "github.com/bentol/barito-proto/producer"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/hashicorp/go-msgpack/codec"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrFluentRule        = errkit.Error("Invalid fluent forward rule")
+	ErrFluentMessage     = errkit.Error("Invalid fluent forward message")
+	ErrFluentEventTime   = errkit.Error("Invalid fluent forward event time")
+	ErrFluentCompression = errkit.Error("Unsupported fluent forward compression")
+	ErrFluentAuth        = errkit.Error("Fluent forward authentication failed")
+	ErrFluentMessageSize = errkit.Error("Fluent forward message is too large")
+
+	// DefaultFluentForwardMaxMessageSize bounds one forward message and the decompressed entries of a CompressedPackedForward
+	DefaultFluentForwardMaxMessageSize = 8 << 20
+
+	FluentForwardTransportTCP = "tcp"
+	FluentForwardTransportTLS = "tls"
+
+	FluentForwardResultAccepted     = "accepted"
+	FluentForwardResultRejected     = "rejected"
+	FluentForwardResultNoApp        = "no_app"
+	FluentForwardResultInvalid      = "invalid"
+	FluentForwardResultUnauthorized = "unauthorized"
+
+	// fluent EventTime is msgpack extension type 0 with seconds and nanoseconds as big endian uint32
+	fluentEventTimeExtType = 0
+
+	// fluentMaxContainerLen bounds the length of one msgpack array or map
+	fluentMaxContainerLen = 1 << 20
+)
+
+var fluentMsgpackHandle = &codec.MsgpackHandle{RawToString: true, WriteExt: true}
+
+// FluentForwardRule maps fluent tag into app, Tag is a glob pattern
+type FluentForwardRule struct {
+	Tag string
+	App string
+}
+
+// ParseFluentForwardRule parses "<tag glob>=<app>"
+func ParseFluentForwardRule(s string) (rule FluentForwardRule, err error) {
+	i := strings.LastIndex(s, "=")
+	if i < 1 || i == len(s)-1 {
+		err = errkit.Concat(ErrFluentRule, errkit.Error(s))
+		return
+	}
+
+	rule.Tag, rule.App = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	if _, err = path.Match(rule.Tag, ""); err != nil {
+		err = errkit.Concat(ErrFluentRule, err)
+	}
+	return
+}
+
+// FluentForwardConfig enables the fluent forward listener of the producer.
+// Authentication is required when SharedKey is set, and the connection is TLS when TLSConfig is set.
+// MaxMessageSize is DefaultFluentForwardMaxMessageSize when it is not set.
+type FluentForwardConfig struct {
+	Addr           string
+	TLSConfig      *tls.Config
+	SharedKey      string
+	Rules          []FluentForwardRule
+	DefaultApp     string
+	AppMaxTps      int32
+	MaxMessageSize int
+}
+
+// fluentForwardReceiver accepts Fluentd and Fluent Bit forward protocol and writes the events through ProduceBatch
+type fluentForwardReceiver struct {
+	connServer
+	producer *producerService
+	config   FluentForwardConfig
+	hostname string
+}
+
+func newFluentForwardReceiver(producer *producerService, config FluentForwardConfig) *fluentForwardReceiver {
+	var peerGuard *PeerGuard
+	if producer != nil {
+		peerGuard = producer.peerGuard
+	}
+
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DefaultFluentForwardMaxMessageSize
+	}
+
+	hostname, _ := os.Hostname()
+	return &fluentForwardReceiver{
+		connServer: newConnServer("Fluent forward", peerGuard),
+		producer:   producer,
+		config:     config,
+		hostname:   hostname,
+	}
+}
+
+// Start listens to config.Addr and serves it in background
+func (r *fluentForwardReceiver) Start() (err error) {
+	transport := FluentForwardTransportTCP
+	var lis net.Listener
+	if r.config.TLSConfig != nil {
+		transport = FluentForwardTransportTLS
+		lis, err = tls.Listen("tcp", r.config.Addr, r.config.TLSConfig)
+	} else {
+		lis, err = net.Listen("tcp", r.config.Addr)
+	}
+	if err != nil {
+		return
+	}
+
+	r.serveListener(lis, transport, r.serveConn)
+	return
+}
+
+func (r *fluentForwardReceiver) serveConn(conn net.Conn, _ string) {
+	reader := newFluentMessageReader(conn, r.config.MaxMessageSize)
+	enc := codec.NewEncoder(conn, fluentMsgpackHandle)
+
+	if r.config.SharedKey != "" {
+		if err := r.handshake(reader, enc); err != nil {
+			prome.IncreaseProducerFluentForwardEvents(FluentForwardResultUnauthorized, 1)
+			log.Debugf("Fluent forward connection from %s closed: %s", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	for {
+		var msg interface{}
+		if err := reader.Decode(&msg); err != nil {
+			if err != io.EOF {
+				log.Debugf("Fluent forward connection from %s closed: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		tag, entries, option, err := decodeFluentMessage(msg, r.config.MaxMessageSize)
+		if err != nil {
+			prome.IncreaseProducerFluentForwardEvents(FluentForwardResultInvalid, 1)
+			log.Debugf("Invalid fluent forward message from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		if r.handle(tag, entries) && option.chunk != "" {
+			if err = enc.Encode(map[string]interface{}{"ack": option.chunk}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handshake runs the shared key authentication of forward protocol: HELO, PING and PONG
+func (r *fluentForwardReceiver) handshake(reader *fluentMessageReader, enc *codec.Encoder) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	err := enc.Encode([]interface{}{"HELO", map[string]interface{}{
+		"nonce":     nonce,
+		"auth":      []byte{},
+		"keepalive": true,
+	}})
+	if err != nil {
+		return err
+	}
+
+	var ping []interface{}
+	if err = reader.Decode(&ping); err != nil {
+		return err
+	}
+	if len(ping) < 4 || fluentString(ping[0]) != "PING" {
+		return errkit.Concat(ErrFluentAuth, errkit.Error("expected PING"))
+	}
+
+	clientHostname, salt, digest := fluentString(ping[1]), fluentString(ping[2]), fluentString(ping[3])
+	expected := fluentSharedKeyDigest(salt, clientHostname, nonce, r.config.SharedKey)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) != 1 {
+		enc.Encode([]interface{}{"PONG", false, "shared_key mismatch", r.hostname, ""})
+		return errkit.Concat(ErrFluentAuth, errkit.Error("shared_key mismatch"))
+	}
+
+	return enc.Encode([]interface{}{"PONG", true, "", r.hostname,
+		fluentSharedKeyDigest(salt, r.hostname, nonce, r.config.SharedKey)})
+}
+
+// handle writes entries of tag, returns true when every entry is accepted and the chunk can be acknowledged
+func (r *fluentForwardReceiver) handle(tag string, entries []fluentEntry) bool {
+	if len(entries) == 0 {
+		return true
+	}
+
+	app := r.app(tag)
+	if app == "" {
+		prome.IncreaseProducerFluentForwardEvents(FluentForwardResultNoApp, len(entries))
+		return true
+	}
+
+	timberCollection := &pb.TimberCollection{
+		Context: newAppTimberContext(app, r.config.AppMaxTps),
+	}
+	for _, entry := range entries {
+		timberCollection.Items = append(timberCollection.Items, ConvertFluentRecordToTimber(entry.time, entry.record))
+	}
+
+	if _, err := r.producer.ProduceBatch(context.Background(), timberCollection); err != nil {
+		prome.IncreaseProducerFluentForwardEvents(FluentForwardResultRejected, len(entries))
+		return false
+	}
+	prome.IncreaseProducerFluentForwardEvents(FluentForwardResultAccepted, len(entries))
+	return true
+}
+
+func (r *fluentForwardReceiver) app(tag string) string {
+	for _, rule := range r.config.Rules {
+		if ok, _ := path.Match(rule.Tag, tag); ok {
+			return rule.App
+		}
+	}
+	return r.config.DefaultApp
+}
+
+type fluentEntry struct {
+	time   time.Time
+	record map[interface{}]interface{}
+}
+
+type fluentOption struct {
+	chunk      string
+	compressed string
+}
+
+// decodeFluentMessage supports every forward protocol mode:
+// Message [tag, time, record, option], Forward [tag, [[time, record], ...], option],
+// PackedForward and CompressedPackedForward [tag, entries as msgpack stream, option].
+// The decompressed entries are bounded by maxSize.
+func decodeFluentMessage(msg interface{}, maxSize int) (tag string, entries []fluentEntry, option fluentOption, err error) {
+	arr, ok := msg.([]interface{})
+	if !ok || len(arr) < 2 {
+		err = ErrFluentMessage
+		return
+	}
+
+	tag = fluentString(arr[0])
+	if tag == "" {
+		err = errkit.Concat(ErrFluentMessage, errkit.Error("tag is required"))
+		return
+	}
+
+	switch second := arr[1].(type) {
+	case []interface{}:
+		option = decodeFluentOption(arr, 2)
+		for _, item := range second {
+			var entry fluentEntry
+			if entry, err = decodeFluentEntry(item); err != nil {
+				return
+			}
+			entries = append(entries, entry)
+		}
+	case string, []byte:
+		option = decodeFluentOption(arr, 2)
+		entries, err = decodeFluentPackedEntries(fluentBytes(second), option.compressed, maxSize)
+	default:
+		if len(arr) < 3 {
+			err = ErrFluentMessage
+			return
+		}
+		option = decodeFluentOption(arr, 3)
+		var entry fluentEntry
+		entry, err = decodeFluentEntry([]interface{}{arr[1], arr[2]})
+		entries = []fluentEntry{entry}
+	}
+	return
+}
+
+func decodeFluentOption(arr []interface{}, i int) (option fluentOption) {
+	if len(arr) <= i {
+		return
+	}
+	m, ok := arr[i].(map[interface{}]interface{})
+	if !ok {
+		return
+	}
+	option.chunk = fluentString(m["chunk"])
+	option.compressed = fluentString(m["compressed"])
+	return
+}
+
+func decodeFluentPackedEntries(b []byte, compressed string, maxSize int) (entries []fluentEntry, err error) {
+	switch compressed {
+	case "", "text":
+	case "gzip":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return
+		}
+		defer gz.Close()
+
+		if b, err = ioutil.ReadAll(io.LimitReader(gz, int64(maxSize)+1)); err != nil {
+			return
+		}
+		if len(b) > maxSize {
+			err = ErrFluentMessageSize
+			return
+		}
+	default:
+		err = errkit.Concat(ErrFluentCompression, errkit.Error(compressed))
+		return
+	}
+
+	reader := newFluentMessageReader(bytes.NewReader(b), maxSize)
+	for {
+		var item interface{}
+		if err = reader.Decode(&item); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+
+		var entry fluentEntry
+		if entry, err = decodeFluentEntry(item); err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// fluentMessageReader reads msgpack objects of at most maxSize bytes. The decoder allocates arrays, maps, strings and binaries
+// by their length header, so the headers are checked against the limits and the remaining bytes before the object is decoded.
+type fluentMessageReader struct {
+	r       *bufio.Reader
+	maxSize int
+	buf     []byte
+}
+
+func newFluentMessageReader(r io.Reader, maxSize int) *fluentMessageReader {
+	return &fluentMessageReader{r: bufio.NewReader(r), maxSize: maxSize}
+}
+
+// Decode reads the next object into v, it returns io.EOF when there is no more object
+func (m *fluentMessageReader) Decode(v interface{}) error {
+	if err := m.next(); err != nil {
+		return err
+	}
+	return codec.NewDecoderBytes(m.buf, fluentMsgpackHandle).Decode(v)
+}
+
+// next reads the bytes of the next object into buf
+func (m *fluentMessageReader) next() (err error) {
+	m.buf = m.buf[:0]
+	if _, err = m.r.Peek(1); err != nil {
+		return
+	}
+
+	for pending := 1; pending > 0; pending-- {
+		var b byte
+		if b, err = m.readByte(); err != nil {
+			return
+		}
+
+		switch {
+		case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+		case b&0xf0 == 0x80:
+			pending, err = m.container(pending, int(b&0x0f), 2)
+		case b&0xf0 == 0x90:
+			pending, err = m.container(pending, int(b&0x0f), 1)
+		case b&0xe0 == 0xa0:
+			err = m.read(int(b & 0x1f))
+		case b == 0xc4, b == 0xd9:
+			err = m.readWithLen(1, 0)
+		case b == 0xc5, b == 0xda:
+			err = m.readWithLen(2, 0)
+		case b == 0xc6, b == 0xdb:
+			err = m.readWithLen(4, 0)
+		case b == 0xc7:
+			err = m.readWithLen(1, 1)
+		case b == 0xc8:
+			err = m.readWithLen(2, 1)
+		case b == 0xc9:
+			err = m.readWithLen(4, 1)
+		case b == 0xca:
+			err = m.read(4)
+		case b == 0xcb:
+			err = m.read(8)
+		case b >= 0xcc && b <= 0xd3:
+			err = m.read(1 << ((b - 0xcc) % 4))
+		case b >= 0xd4 && b <= 0xd8:
+			err = m.read(1 + 1<<(b-0xd4))
+		case b == 0xdc:
+			pending, err = m.readContainer(pending, 2, 1)
+		case b == 0xdd:
+			pending, err = m.readContainer(pending, 4, 1)
+		case b == 0xde:
+			pending, err = m.readContainer(pending, 2, 2)
+		case b == 0xdf:
+			pending, err = m.readContainer(pending, 4, 2)
+		default:
+			err = errkit.Concat(ErrFluentMessage, errkit.Error(fmt.Sprintf("invalid msgpack type 0x%x", b)))
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// container adds the objects of a container of length n to pending, an array item is one object and a map item is two.
+// Every object takes one byte at least.
+func (m *fluentMessageReader) container(pending, n, objects int) (int, error) {
+	if n > fluentMaxContainerLen || n*objects > m.maxSize-len(m.buf) {
+		return 0, ErrFluentMessageSize
+	}
+	return pending + n*objects, nil
+}
+
+// readContainer reads a container length of size bytes and adds its objects to pending
+func (m *fluentMessageReader) readContainer(pending, size, objects int) (int, error) {
+	n, err := m.readLen(size)
+	if err != nil {
+		return 0, err
+	}
+	return m.container(pending, n, objects)
+}
+
+// readWithLen reads a big endian length of size bytes, then extra bytes and the data of that length
+func (m *fluentMessageReader) readWithLen(size, extra int) error {
+	n, err := m.readLen(size)
+	if err != nil {
+		return err
+	}
+	return m.read(extra + n)
+}
+
+func (m *fluentMessageReader) readLen(size int) (int, error) {
+	if err := m.read(size); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range m.buf[len(m.buf)-size:] {
+		n = n<<8 | uint64(b)
+	}
+	if n > uint64(m.maxSize) {
+		return 0, ErrFluentMessageSize
+	}
+	return int(n), nil
+}
+
+func (m *fluentMessageReader) readByte() (byte, error) {
+	if err := m.read(1); err != nil {
+		return 0, err
+	}
+	return m.buf[len(m.buf)-1], nil
+}
+
+func (m *fluentMessageReader) read(n int) error {
+	if n > m.maxSize-len(m.buf) {
+		return ErrFluentMessageSize
+	}
+	start := len(m.buf)
+	m.buf = append(m.buf, make([]byte, n)...)
+	if _, err := io.ReadFull(m.r, m.buf[start:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func decodeFluentEntry(item interface{}) (entry fluentEntry, err error) {
+	arr, ok := item.([]interface{})
+	if !ok || len(arr) < 2 {
+		err = errkit.Concat(ErrFluentMessage, errkit.Error("entry must be [time, record]"))
+		return
+	}
+
+	if entry.time, err = decodeFluentTime(arr[0]); err != nil {
+		return
+	}
+
+	if entry.record, ok = arr[1].(map[interface{}]interface{}); !ok {
+		err = errkit.Concat(ErrFluentMessage, errkit.Error("record must be a map"))
+	}
+	return
+}
+
+func decodeFluentTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case int64:
+		return time.Unix(t, 0), nil
+	case uint64:
+		return time.Unix(int64(t), 0), nil
+	case float64:
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case codec.RawExt:
+		return decodeFluentEventTime(&t)
+	case *codec.RawExt:
+		return decodeFluentEventTime(t)
+	}
+	return time.Time{}, ErrFluentEventTime
+}
+
+func decodeFluentEventTime(ext *codec.RawExt) (time.Time, error) {
+	if ext.Tag != fluentEventTimeExtType || len(ext.Data) != 8 {
+		return time.Time{}, ErrFluentEventTime
+	}
+	sec := binary.BigEndian.Uint32(ext.Data[:4])
+	nsec := binary.BigEndian.Uint32(ext.Data[4:])
+	return time.Unix(int64(sec), int64(nsec)), nil
+}
+
+// ConvertFluentRecordToTimber converts fluent record into timber content, event time is used as @timestamp
+// when the record does not have it
+func ConvertFluentRecordToTimber(t time.Time, record map[interface{}]interface{}) *pb.Timber {
+	content := fluentMapToStruct(record)
+	if _, ok := content.Fields["@timestamp"]; !ok {
+		content.Fields["@timestamp"] = stringValue(t.UTC().Format(time.RFC3339Nano))
+	}
+
+	return &pb.Timber{
+		Content: content,
+	}
+}
+
+func fluentMapToStruct(m map[interface{}]interface{}) *stpb.Struct {
+	s := &stpb.Struct{Fields: make(map[string]*stpb.Value, len(m))}
+	for key, value := range m {
+		s.Fields[fluentString(key)] = fluentValueToProto(value)
+	}
+	return s
+}
+
+func fluentValueToProto(v interface{}) *stpb.Value {
+	switch value := v.(type) {
+	case nil:
+		return &stpb.Value{Kind: &stpb.Value_NullValue{}}
+	case string:
+		return stringValue(value)
+	case []byte:
+		return stringValue(string(value))
+	case bool:
+		return &stpb.Value{Kind: &stpb.Value_BoolValue{BoolValue: value}}
+	case int64:
+		return &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: float64(value)}}
+	case uint64:
+		return &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: float64(value)}}
+	case float32:
+		return &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: float64(value)}}
+	case float64:
+		return &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: value}}
+	case []interface{}:
+		list := &stpb.ListValue{}
+		for _, item := range value {
+			list.Values = append(list.Values, fluentValueToProto(item))
+		}
+		return &stpb.Value{Kind: &stpb.Value_ListValue{ListValue: list}}
+	case map[interface{}]interface{}:
+		return structValue(fluentMapToStruct(value))
+	case codec.RawExt, *codec.RawExt:
+		if t, err := decodeFluentTime(value); err == nil {
+			return stringValue(t.UTC().Format(time.RFC3339Nano))
+		}
+	}
+	return stringValue(fmt.Sprint(v))
+}
+
+func fluentString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+func fluentBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case string:
+		return []byte(b)
+	case []byte:
+		return b
+	}
+	return nil
+}
+
+func fluentSharedKeyDigest(salt, hostname string, nonce []byte, sharedKey string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,300 @@
+package flow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+func fluentEventTime(t time.Time) codec.RawExt {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Nanosecond()))
+	return codec.RawExt{Tag: fluentEventTimeExtType, Data: data}
+}
+
+func encodeFluent(t *testing.T, values ...interface{}) []byte {
+	var b []byte
+	enc := codec.NewEncoderBytes(&b, fluentMsgpackHandle)
+	for _, v := range values {
+		FatalIfError(t, enc.Encode(v))
+	}
+	return b
+}
+
+func decodeFluent(t *testing.T, b []byte) (msg interface{}) {
+	FatalIfError(t, newFluentMessageReader(bytes.NewReader(b), DefaultFluentForwardMaxMessageSize).Decode(&msg))
+	return
+}
+
+func TestParseFluentForwardRule(t *testing.T) {
+	rule, err := ParseFluentForwardRule("kube.payment.*=payment")
+	FatalIfError(t, err)
+	FatalIf(t, rule.Tag != "kube.payment.*" || rule.App != "payment", "wrong rule %v", rule)
+
+	_, err = ParseFluentForwardRule("payment")
+	FatalIfWrongError(t, err, string(ErrFluentRule)+": payment")
+
+	_, err = ParseFluentForwardRule("kube.[=payment")
+	FatalIf(t, err == nil, "expected invalid glob error")
+}
+
+func TestFluentForwardReceiver_app(t *testing.T) {
+	r := newFluentForwardReceiver(nil, FluentForwardConfig{
+		Rules: []FluentForwardRule{
+			{Tag: "kube.payment.*", App: "payment"},
+			{Tag: "kube.*", App: "kube"},
+		},
+	})
+
+	FatalIf(t, r.app("kube.payment.api") != "payment", "first matching rule should win")
+	FatalIf(t, r.app("kube.search.api") != "kube", "second rule should match")
+	FatalIf(t, r.app("nginx.access") != "", "tag without rule should not have app")
+}
+
+func TestDecodeFluentMessage_Modes(t *testing.T) {
+	eventTime := time.Date(2024, 6, 1, 12, 0, 0, 500, time.UTC)
+	record := map[string]interface{}{"log": "some log"}
+	entry := []interface{}{fluentEventTime(eventTime), record}
+
+	packed := encodeFluent(t, entry, entry)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(packed)
+	gz.Close()
+
+	testCases := []struct {
+		name  string
+		msg   []interface{}
+		count int
+		chunk string
+	}{
+		{"message", []interface{}{"some.tag", eventTime.Unix(), record}, 1, ""},
+		{"message with option", []interface{}{"some.tag", fluentEventTime(eventTime), record, map[string]interface{}{"chunk": "abc"}}, 1, "abc"},
+		{"forward", []interface{}{"some.tag", []interface{}{entry, entry, entry}, map[string]interface{}{"chunk": "abc"}}, 3, "abc"},
+		{"packed forward", []interface{}{"some.tag", packed}, 2, ""},
+		{"compressed packed forward", []interface{}{"some.tag", compressed.Bytes(), map[string]interface{}{"compressed": "gzip", "chunk": "abc"}}, 2, "abc"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tag, entries, option, err := decodeFluentMessage(decodeFluent(t, encodeFluent(t, tc.msg)), DefaultFluentForwardMaxMessageSize)
+			FatalIfError(t, err)
+			FatalIf(t, tag != "some.tag", "wrong tag %s", tag)
+			FatalIf(t, len(entries) != tc.count, "expected %d entries, got %d", tc.count, len(entries))
+			FatalIf(t, option.chunk != tc.chunk, "wrong chunk %s", option.chunk)
+			FatalIf(t, fluentString(entries[0].record["log"]) != "some log", "wrong record %v", entries[0].record)
+			FatalIf(t, entries[0].time.Unix() != eventTime.Unix(), "wrong event time %s", entries[0].time)
+		})
+	}
+}
+
+func TestDecodeFluentMessage_Invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		msg  interface{}
+	}{
+		{"not an array", map[string]interface{}{"tag": "some.tag"}},
+		{"missing tag", []interface{}{"", int64(0), map[string]interface{}{}}},
+		{"invalid time", []interface{}{"some.tag", "yesterday", map[string]interface{}{}}},
+		{"invalid record", []interface{}{"some.tag", int64(0), "some log"}},
+		{"unsupported compression", []interface{}{"some.tag", []byte{0x01}, map[string]interface{}{"compressed": "zstd"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, _, err := decodeFluentMessage(decodeFluent(t, encodeFluent(t, tc.msg)), DefaultFluentForwardMaxMessageSize)
+			FatalIf(t, err == nil, "expected error")
+		})
+	}
+}
+
+func TestFluentMessageReader_Limits(t *testing.T) {
+	testCases := []struct {
+		name string
+		b    []byte
+	}{
+		{"array32 longer than the message", []byte{0xdd, 0x7f, 0xff, 0xff, 0xff}},
+		{"map32 longer than the message", []byte{0xdf, 0x7f, 0xff, 0xff, 0xff}},
+		{"str32 longer than the message", []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{"bin32 longer than the message", []byte{0xc6, 0x7f, 0xff, 0xff, 0xff}},
+		{"container longer than the max container length", append([]byte{0xdd, 0x00, 0x10, 0x00, 0x01}, make([]byte, 1<<20+1)...)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var msg interface{}
+			err := newFluentMessageReader(bytes.NewReader(tc.b), 1<<21).Decode(&msg)
+			FatalIfWrongError(t, err, string(ErrFluentMessageSize))
+		})
+	}
+
+	var msg interface{}
+	err := newFluentMessageReader(bytes.NewReader(encodeFluent(t, []interface{}{"some.tag", "some log"})), 8).Decode(&msg)
+	FatalIfWrongError(t, err, string(ErrFluentMessageSize))
+
+	err = newFluentMessageReader(bytes.NewReader([]byte{0x92, 0x01}), 8).Decode(&msg)
+	FatalIf(t, err != io.ErrUnexpectedEOF, "expected unexpected EOF, got %v", err)
+}
+
+func TestDecodeFluentMessage_CompressedTooLarge(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(make([]byte, 1<<20))
+	gz.Close()
+
+	msg := decodeFluent(t, encodeFluent(t, []interface{}{"some.tag", compressed.Bytes(), map[string]interface{}{"compressed": "gzip"}}))
+	_, _, _, err := decodeFluentMessage(msg, 1<<16)
+	FatalIfWrongError(t, err, string(ErrFluentMessageSize))
+}
+
+func TestConvertFluentRecordToTimber(t *testing.T) {
+	eventTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	record := decodeFluent(t, encodeFluent(t, map[string]interface{}{
+		"log":    "some log",
+		"status": 200,
+		"kubernetes": map[string]interface{}{
+			"labels": []interface{}{"a", "b"},
+		},
+	})).(map[interface{}]interface{})
+
+	fields := ConvertFluentRecordToTimber(eventTime, record).GetContent().GetFields()
+	FatalIf(t, fields["log"].GetStringValue() != "some log", "wrong log")
+	FatalIf(t, fields["status"].GetNumberValue() != 200, "wrong status")
+	FatalIf(t, len(fields["kubernetes"].GetStructValue().GetFields()["labels"].GetListValue().GetValues()) != 2, "wrong nested record")
+	FatalIf(t, fields["@timestamp"].GetStringValue() != "2024-06-01T12:00:00Z", "wrong @timestamp")
+}
+
+func startFluentForwardTestReceiver(t *testing.T, ctrl *gomock.Controller, sent chan *sarama.ProducerMessage) *producerService {
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).AnyTimes().DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		sent <- msg
+		return 0, 0, nil
+	})
+
+	srv := &producerService{
+		producer:           producer,
+		topicSuffix:        "_logs",
+		admin:              admin,
+		limiter:            NewDummyRateLimiter(),
+		kafkaMessageFormat: TimberCollectionMessageFormat,
+	}
+	srv.forward = newFluentForwardReceiver(srv, FluentForwardConfig{
+		Addr:       "127.0.0.1:0",
+		SharedKey:  "some-secret",
+		Rules:      []FluentForwardRule{{Tag: "kube.*", App: "kube"}},
+		DefaultApp: "fluent",
+		AppMaxTps:  100,
+	})
+	FatalIfError(t, srv.forward.Start())
+	return srv
+}
+
+func fluentHandshake(t *testing.T, conn net.Conn, sharedKey string) []interface{} {
+	dec := codec.NewDecoder(conn, fluentMsgpackHandle)
+	enc := codec.NewEncoder(conn, fluentMsgpackHandle)
+
+	var helo []interface{}
+	FatalIfError(t, dec.Decode(&helo))
+	FatalIf(t, fluentString(helo[0]) != "HELO", "expected HELO, got %v", helo)
+	nonce := fluentBytes(helo[1].(map[interface{}]interface{})["nonce"])
+
+	digest := fluentSharedKeyDigest("some-salt", "client", nonce, sharedKey)
+	FatalIfError(t, enc.Encode([]interface{}{"PING", "client", "some-salt", digest, "", ""}))
+
+	var pong []interface{}
+	FatalIfError(t, dec.Decode(&pong))
+	return pong
+}
+
+func TestFluentForwardReceiver_AuthAndAck(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sent := make(chan *sarama.ProducerMessage, 1)
+	srv := startFluentForwardTestReceiver(t, ctrl, sent)
+	defer srv.forward.Close()
+
+	addr := srv.forward.listeners[0].(net.Listener).Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	FatalIfError(t, err)
+	defer conn.Close()
+
+	pong := fluentHandshake(t, conn, "some-secret")
+	FatalIf(t, fluentString(pong[0]) != "PONG" || pong[1] != true, "expected successful PONG, got %v", pong)
+	FatalIf(t, fluentString(pong[3]) != srv.forward.hostname, "wrong server hostname %v", pong[3])
+
+	entry := []interface{}{fluentEventTime(time.Now()), map[string]interface{}{"log": "some log"}}
+	enc := codec.NewEncoder(conn, fluentMsgpackHandle)
+	FatalIfError(t, enc.Encode([]interface{}{"kube.api", []interface{}{entry, entry}, map[string]interface{}{"chunk": "some-chunk"}}))
+
+	select {
+	case message := <-sent:
+		FatalIf(t, message.Topic != "kube_logs", "wrong topic %s", message.Topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for kafka message")
+	}
+
+	var ack map[interface{}]interface{}
+	FatalIfError(t, codec.NewDecoder(conn, fluentMsgpackHandle).Decode(&ack))
+	FatalIf(t, fluentString(ack["ack"]) != "some-chunk", "wrong ack %v", ack)
+}
+
+func TestFluentForwardReceiver_WrongSharedKey(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	srv := startFluentForwardTestReceiver(t, ctrl, make(chan *sarama.ProducerMessage, 1))
+	defer srv.forward.Close()
+
+	addr := srv.forward.listeners[0].(net.Listener).Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	FatalIfError(t, err)
+	defer conn.Close()
+
+	pong := fluentHandshake(t, conn, "wrong-secret")
+	FatalIf(t, pong[1] != false, "expected failed PONG, got %v", pong)
+}
+
+func TestFluentForwardReceiver_OversizedHeaderBeforeAuth(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	srv := startFluentForwardTestReceiver(t, ctrl, make(chan *sarama.ProducerMessage, 1))
+	defer srv.forward.Close()
+
+	addr := srv.forward.listeners[0].(net.Listener).Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	FatalIfError(t, err)
+	defer conn.Close()
+
+	var helo []interface{}
+	FatalIfError(t, codec.NewDecoder(conn, fluentMsgpackHandle).Decode(&helo))
+
+	_, err = conn.Write([]byte{0xdd, 0x7f, 0xff, 0xff, 0xff})
+	FatalIfError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	FatalIf(t, err != io.EOF, "connection should be closed, got %v", err)
+}
@@ -0,0 +1,370 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// groupConsumer consumes topics with a sarama consumer group, the messages of every claim are passed through a single channel.
+//
+// The channels are unbuffered so a rebalance is ordered with the reader: a message received from Messages is sent before its claim ends,
+// and the notification releasing the partitions is sent when the next session starts, before any message of it, so the reader revokes
+// the released partitions after it handled their last message. The rebalance of sarama ends every claim of the session, the partitions
+// claimed again by the next session are not released: their messages are kept and the offsets marked between the sessions are marked
+// in the next one. Offsets are marked within the session owning the partition only, a message of a released partition is never marked.
+//
+// With a subscription, the topics are refreshed every refreshInterval. Ending the session rebalances every member of the group,
+// so a changed subscription is applied by the next rebalance of the group, or by ending the session once it stayed changed
+// for subscriptionDelay: the changes within the delay are applied by one rebalance.
+type groupConsumer struct {
+	group             sarama.ConsumerGroup
+	subscription      func() ([]string, error)
+	refreshInterval   time.Duration
+	subscriptionDelay time.Duration
+	retryBackoff      time.Duration
+
+	messages      chan *sarama.ConsumerMessage
+	notifications chan *types.Notification
+	errors        chan error
+
+	mu            sync.Mutex
+	topics        []string
+	sessionTopics []string
+	changedAt     time.Time
+	session       sarama.ConsumerGroupSession
+	claims        map[topicPartition]bool
+	previous      map[topicPartition]bool
+	pendingMarks  map[topicPartition]pendingMark
+	endSession    context.CancelFunc
+	subscribed    chan struct{}
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// pendingMark is an offset marked between the sessions, it is marked in the next session when the partition is claimed again
+type pendingMark struct {
+	message  *sarama.ConsumerMessage
+	metadata string
+}
+
+func newGroupConsumer(group sarama.ConsumerGroup, topics []string, retryBackoff time.Duration) *groupConsumer {
+	c := makeGroupConsumer(group, retryBackoff)
+	c.topics = topics
+
+	c.wg.Add(2)
+	go c.loopConsume()
+	go c.loopErrors()
+	return c
+}
+
+// newSubscriptionGroupConsumer consumes the topics returned by subscription, it fails when the first subscription fails
+func newSubscriptionGroupConsumer(group sarama.ConsumerGroup, subscription func() ([]string, error), refreshInterval, subscriptionDelay, retryBackoff time.Duration) (*groupConsumer, error) {
+	topics, err := subscription()
+	if err != nil {
+		return nil, err
+	}
+
+	c := makeGroupConsumer(group, retryBackoff)
+	c.subscription = subscription
+	c.refreshInterval = refreshInterval
+	c.subscriptionDelay = subscriptionDelay
+	sort.Strings(topics)
+	c.topics = topics
+	prome.SetConsumerSubscribedTopics(len(topics))
+
+	c.wg.Add(3)
+	go c.loopConsume()
+	go c.loopErrors()
+	go c.loopSubscription()
+	return c, nil
+}
+
+func makeGroupConsumer(group sarama.ConsumerGroup, retryBackoff time.Duration) *groupConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &groupConsumer{
+		group:         group,
+		retryBackoff:  retryBackoff,
+		messages:      make(chan *sarama.ConsumerMessage),
+		notifications: make(chan *types.Notification),
+		errors:        make(chan error),
+		pendingMarks:  make(map[topicPartition]pendingMark),
+		subscribed:    make(chan struct{}, 1),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+func (c *groupConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func (c *groupConsumer) Notifications() <-chan *types.Notification {
+	return c.notifications
+}
+
+func (c *groupConsumer) Errors() <-chan error {
+	return c.errors
+}
+
+// MarkOffset marks message in the current session, it is ignored when the partition of message is not claimed by the session.
+// Between the sessions, the offset of a partition of the previous session is marked when the next session claims it again.
+func (c *groupConsumer) MarkOffset(message *sarama.ConsumerMessage, metadata string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := topicPartition{message.Topic, message.Partition}
+	switch {
+	case c.session != nil && c.claims[key]:
+		c.session.MarkMessage(message, metadata)
+	case c.session == nil && c.previous[key]:
+		c.pendingMarks[key] = pendingMark{message, metadata}
+	}
+}
+
+// CommitOffsets commits the offsets marked in the current session
+func (c *groupConsumer) CommitOffsets() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil {
+		c.session.Commit()
+	}
+	return nil
+}
+
+// Close leaves the consumer group, the channels are closed once the last session ended
+func (c *groupConsumer) Close() (err error) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		err = c.group.Close()
+		c.wg.Wait()
+		close(c.errors)
+	})
+	return
+}
+
+func (c *groupConsumer) loopConsume() {
+	defer c.wg.Done()
+	defer close(c.notifications)
+	defer close(c.messages)
+
+	for {
+		topics, ctx, endSession := c.nextSession()
+		if len(topics) == 0 {
+			endSession()
+			c.mu.Lock()
+			released := c.release(nil)
+			c.mu.Unlock()
+			if len(released) > 0 {
+				c.notify(&types.Notification{Type: types.RebalanceOK, Released: released, Current: map[string][]int32{}})
+			}
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-c.subscribed:
+				continue
+			}
+		}
+
+		// Consume returns at the end of every session, e.g. on rebalance or when the subscription changed
+		err := c.group.Consume(ctx, topics, c)
+		endSession()
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.sendError(err)
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(c.retryBackoff):
+			}
+		}
+	}
+}
+
+func (c *groupConsumer) nextSession() ([]string, context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.endSession = cancel
+	c.sessionTopics = c.topics
+	c.changedAt = time.Time{}
+	return c.topics, ctx, cancel
+}
+
+func (c *groupConsumer) loopSubscription() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			topics, err := c.subscription()
+			if err != nil {
+				c.sendError(err)
+				continue
+			}
+			c.subscribe(topics)
+		}
+	}
+}
+
+// subscribe sets the topics of the next session. The current session is ended once its topics differed from the subscription
+// for subscriptionDelay, or at once when it consumes no topic.
+func (c *groupConsumer) subscribe(topics []string) {
+	sort.Strings(topics)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !equalTopics(c.topics, topics) {
+		log.Warnf("Subscription of consumer group changed to %d topics", len(topics))
+		prome.SetConsumerSubscribedTopics(len(topics))
+		c.topics = topics
+	}
+
+	if equalTopics(c.sessionTopics, topics) {
+		c.changedAt = time.Time{}
+		return
+	}
+	if c.changedAt.IsZero() {
+		c.changedAt = time.Now()
+	}
+	if len(c.sessionTopics) > 0 && time.Since(c.changedAt) < c.subscriptionDelay {
+		return
+	}
+
+	if c.endSession != nil {
+		c.endSession()
+	}
+	select {
+	case c.subscribed <- struct{}{}:
+	default:
+	}
+}
+
+// equalTopics returns whether the sorted topics a and b are the same
+func equalTopics(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func (c *groupConsumer) loopErrors() {
+	defer c.wg.Done()
+
+	for err := range c.group.Errors() {
+		c.sendError(err)
+	}
+}
+
+func (c *groupConsumer) sendError(err error) {
+	select {
+	case c.errors <- err:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *groupConsumer) notify(notification *types.Notification) {
+	select {
+	case c.notifications <- notification:
+	case <-c.ctx.Done():
+	}
+}
+
+// Setup starts the session with the claimed partitions, and releases the partitions of the previous session which are not claimed again
+func (c *groupConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	current := session.Claims()
+	claims := make(map[topicPartition]bool)
+	for topic, partitions := range current {
+		for _, partition := range partitions {
+			claims[topicPartition{topic, partition}] = true
+		}
+	}
+
+	c.mu.Lock()
+	c.session = session
+	c.claims = claims
+	claimed := partitionsOf(claims, c.previous)
+	for key, mark := range c.pendingMarks {
+		if claims[key] {
+			session.MarkMessage(mark.message, mark.metadata)
+		}
+	}
+	released := c.release(claims)
+	c.mu.Unlock()
+
+	c.notify(&types.Notification{Type: types.RebalanceOK, Claimed: claimed, Released: released, Current: current})
+	return nil
+}
+
+// Cleanup ends the session once every claim ended, the offsets marked before it returns are committed by the session.
+// Its partitions are released when the next session starts, unless they are claimed again.
+func (c *groupConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.notify(&types.Notification{Type: types.RebalanceStart, Current: session.Claims()})
+
+	c.mu.Lock()
+	c.previous = c.claims
+	c.session = nil
+	c.claims = nil
+	c.mu.Unlock()
+	return nil
+}
+
+// release returns the partitions of the previous session which are not in claims and forgets the previous session,
+// caller must hold the lock
+func (c *groupConsumer) release(claims map[topicPartition]bool) map[string][]int32 {
+	released := partitionsOf(c.previous, claims)
+	c.previous = nil
+	c.pendingMarks = make(map[topicPartition]pendingMark)
+	return released
+}
+
+// partitionsOf returns the partitions by topic of set which are not in except
+func partitionsOf(set, except map[topicPartition]bool) map[string][]int32 {
+	partitions := make(map[string][]int32)
+	for key := range set {
+		if !except[key] {
+			partitions[key.topic] = append(partitions[key.topic], key.partition)
+		}
+	}
+	for _, p := range partitions {
+		sort.Slice(p, func(i, j int) bool { return p[i] < p[j] })
+	}
+	return partitions
+}
+
+// ConsumeClaim passes the messages of claim until the session ends
+func (c *groupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			select {
+			case c.messages <- message:
+			case <-session.Context().Done():
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testConsumerGroup runs the sessions sent by the test, a nil session fails Consume
+type testConsumerGroup struct {
+	sessions chan *testGroupSession
+	topics   chan []string
+	errors   chan error
+	closed   chan struct{}
+}
+
+func newTestConsumerGroup() *testConsumerGroup {
+	return &testConsumerGroup{
+		sessions: make(chan *testGroupSession, 1),
+		topics:   make(chan []string, 10),
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (g *testConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	g.topics <- topics
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-g.closed:
+		return sarama.ErrClosedConsumerGroup
+	case s := <-g.sessions:
+		if s == nil {
+			return fmt.Errorf("some-error")
+		}
+		s.ctx, s.cancel = context.WithCancel(ctx)
+		handler.Setup(s)
+
+		var wg sync.WaitGroup
+		for _, claim := range s.claims {
+			wg.Add(1)
+			go func(claim *testGroupClaim) {
+				defer wg.Done()
+				handler.ConsumeClaim(s, claim)
+			}(claim)
+		}
+		wg.Wait()
+
+		handler.Cleanup(s)
+		return nil
+	}
+}
+
+func (g *testConsumerGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *testConsumerGroup) Close() error {
+	close(g.closed)
+	close(g.errors)
+	return nil
+}
+
+type testGroupSession struct {
+	claims    []*testGroupClaim
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	marked    []int64
+	committed int
+}
+
+func newTestGroupSession(claims ...*testGroupClaim) *testGroupSession {
+	return &testGroupSession{claims: claims}
+}
+
+func (s *testGroupSession) Claims() map[string][]int32 {
+	claims := make(map[string][]int32)
+	for _, c := range s.claims {
+		claims[c.topic] = append(claims[c.topic], c.partition)
+	}
+	return claims
+}
+
+func (s *testGroupSession) MemberID() string         { return "member" }
+func (s *testGroupSession) GenerationID() int32      { return 1 }
+func (s *testGroupSession) Context() context.Context { return s.ctx }
+func (s *testGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (s *testGroupSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+
+func (s *testGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *testGroupSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed++
+}
+
+func (s *testGroupSession) markedOffsets() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprint(s.marked)
+}
+
+type testGroupClaim struct {
+	topic     string
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+func newTestGroupClaim(topic string, partition int32, messages ...*sarama.ConsumerMessage) *testGroupClaim {
+	c := &testGroupClaim{topic: topic, partition: partition, messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, m := range messages {
+		c.messages <- m
+	}
+	return c
+}
+
+func (c *testGroupClaim) Topic() string                            { return c.topic }
+func (c *testGroupClaim) Partition() int32                         { return c.partition }
+func (c *testGroupClaim) InitialOffset() int64                     { return 0 }
+func (c *testGroupClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *testGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func receiveNotification(t *testing.T, c *groupConsumer) *types.Notification {
+	select {
+	case n := <-c.Notifications():
+		return n
+	case <-time.After(time.Second):
+		t.Fatalf("no notification")
+	}
+	return nil
+}
+
+func TestGroupConsumer(t *testing.T) {
+	group := newTestConsumerGroup()
+	want := &sarama.ConsumerMessage{Topic: "some_topic", Partition: 0, Offset: 1}
+	session := newTestGroupSession(newTestGroupClaim("some_topic", 0, want))
+	group.sessions <- session
+
+	c := newGroupConsumer(group, []string{"some_topic"}, time.Millisecond)
+
+	n := receiveNotification(t, c)
+	FatalIf(t, n.Type != types.RebalanceOK, "wrong notification type %d", n.Type)
+	FatalIf(t, fmt.Sprint(n.Claimed) != "map[some_topic:[0]]", "wrong claimed partitions %v", n.Claimed)
+
+	got := <-c.Messages()
+	FatalIf(t, got != want, "wrong message")
+
+	c.MarkOffset(got, "")
+	c.MarkOffset(&sarama.ConsumerMessage{Topic: "some_topic", Partition: 1, Offset: 7}, "")
+	FatalIf(t, session.markedOffsets() != "[1]", "only the claimed partition should be marked, got %s", session.markedOffsets())
+
+	FatalIfError(t, c.CommitOffsets())
+	FatalIf(t, session.committed != 1, "offsets should be committed by the session")
+
+	// rebalance
+	session.cancel()
+	n = receiveNotification(t, c)
+	FatalIf(t, n.Type != types.RebalanceStart, "wrong notification type %d", n.Type)
+	FatalIf(t, len(n.Released) != 0, "partitions should be released when the next session starts, got %v", n.Released)
+
+	next := newTestGroupSession(newTestGroupClaim("some_topic", 1))
+	group.sessions <- next
+	n = receiveNotification(t, c)
+	FatalIf(t, n.Type != types.RebalanceOK, "wrong notification type %d", n.Type)
+	FatalIf(t, fmt.Sprint(n.Released) != "map[some_topic:[0]]", "wrong released partitions %v", n.Released)
+	FatalIf(t, fmt.Sprint(n.Claimed) != "map[some_topic:[1]]", "wrong claimed partitions %v", n.Claimed)
+
+	// the message of the released partition is not marked in the next session
+	c.MarkOffset(got, "")
+	FatalIf(t, session.markedOffsets() != "[1]" || next.markedOffsets() != "[]",
+		"released partition should not be marked, got %s and %s", session.markedOffsets(), next.markedOffsets())
+
+	FatalIfError(t, c.Close())
+	_, ok := <-c.Messages()
+	FatalIf(t, ok, "messages should be closed")
+	_, ok = <-c.Errors()
+	FatalIf(t, ok, "errors should be closed")
+}
+
+func TestGroupConsumer_ClaimedAgain(t *testing.T) {
+	group := newTestConsumerGroup()
+	want := &sarama.ConsumerMessage{Topic: "some_topic", Partition: 0, Offset: 1}
+	session := newTestGroupSession(newTestGroupClaim("some_topic", 0, want), newTestGroupClaim("some_topic", 1))
+	group.sessions <- session
+
+	c := newGroupConsumer(group, []string{"some_topic"}, time.Millisecond)
+	defer c.Close()
+
+	receiveNotification(t, c)
+	got := <-c.Messages()
+
+	session.cancel()
+	receiveNotification(t, c)
+
+	// the message is handled between the sessions, its offset is marked once the next session claims the partition again
+	c.MarkOffset(got, "")
+	FatalIf(t, session.markedOffsets() != "[]", "ended session should not be marked, got %s", session.markedOffsets())
+
+	next := newTestGroupSession(newTestGroupClaim("some_topic", 0))
+	group.sessions <- next
+	n := receiveNotification(t, c)
+	FatalIf(t, fmt.Sprint(n.Released) != "map[some_topic:[1]]", "only the partition not claimed again should be released, got %v", n.Released)
+	FatalIf(t, len(n.Claimed) != 0, "partition claimed again should not be newly claimed, got %v", n.Claimed)
+	FatalIf(t, next.markedOffsets() != "[1]", "offset marked between the sessions should be marked in the next one, got %s", next.markedOffsets())
+}
+
+func TestGroupConsumer_Errors(t *testing.T) {
+	group := newTestConsumerGroup()
+	group.sessions <- nil
+
+	c := newGroupConsumer(group, []string{"some_topic"}, time.Millisecond)
+	defer c.Close()
+
+	FatalIfWrongError(t, <-c.Errors(), "some-error")
+
+	go func() { group.errors <- fmt.Errorf("group-error") }()
+	FatalIfWrongError(t, <-c.Errors(), "group-error")
+}
+
+func TestGroupConsumer_Subscription(t *testing.T) {
+	resetPrometheusMetrics()
+
+	var mu sync.Mutex
+	subscribed := []string{"a_logs"}
+	subscription := func() ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), subscribed...), nil
+	}
+
+	group := newTestConsumerGroup()
+	group.sessions <- newTestGroupSession(newTestGroupClaim("a_logs", 0))
+
+	c, err := newSubscriptionGroupConsumer(group, subscription, time.Millisecond, time.Millisecond, time.Millisecond)
+	FatalIfError(t, err)
+	defer c.Close()
+
+	receiveNotification(t, c)
+	FatalIf(t, fmt.Sprint(<-group.topics) != "[a_logs]", "wrong topics of the first session")
+
+	// the session ends once the new topic is pending for the delay and the next one consumes it
+	mu.Lock()
+	subscribed = []string{"b_logs", "a_logs"}
+	mu.Unlock()
+
+	n := receiveNotification(t, c)
+	FatalIf(t, n.Type != types.RebalanceStart, "session should end when the subscription changed")
+	FatalIf(t, fmt.Sprint(<-group.topics) != "[a_logs b_logs]", "next session should consume the new topics")
+
+	expected := `
+		# HELP barito_consumer_subscribed_topics Number of topics subscribed by the consumer group of the single group mode
+		# TYPE barito_consumer_subscribed_topics gauge
+		barito_consumer_subscribed_topics 2
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_subscribed_topics"))
+}
+
+func TestGroupConsumer_SubscriptionDelay(t *testing.T) {
+	var mu sync.Mutex
+	subscribed := []string{"a_logs"}
+	subscription := func() ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), subscribed...), nil
+	}
+
+	group := newTestConsumerGroup()
+	session := newTestGroupSession(newTestGroupClaim("a_logs", 0))
+	group.sessions <- session
+
+	c, err := newSubscriptionGroupConsumer(group, subscription, time.Millisecond, time.Hour, time.Millisecond)
+	FatalIfError(t, err)
+	defer c.Close()
+
+	receiveNotification(t, c)
+	<-group.topics
+
+	mu.Lock()
+	subscribed = []string{"a_logs", "b_logs"}
+	mu.Unlock()
+
+	// the new topic does not end the session within the delay
+	select {
+	case n := <-c.Notifications():
+		t.Fatalf("session should not end within the subscription delay, got notification %d", n.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the next rebalance of the group consumes the new topic
+	session.cancel()
+	receiveNotification(t, c)
+	FatalIf(t, fmt.Sprint(<-group.topics) != "[a_logs b_logs]", "next session should consume the new topics")
+}
+
+func TestGroupConsumer_SubscriptionError(t *testing.T) {
+	subscription := func() ([]string, error) { return nil, fmt.Errorf("some-error") }
+
+	_, err := newSubscriptionGroupConsumer(newTestConsumerGroup(), subscription, time.Millisecond, time.Millisecond, time.Millisecond)
+	FatalIfWrongError(t, err, "some-error")
+}
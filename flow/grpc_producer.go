@@ -12,6 +12,9 @@ import (
 	"github.com/Shopify/sarama"
 	pb "github.com/bentol/barito-proto/producer"
 	log "github.com/sirupsen/logrus"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	"google.golang.org/grpc"
 
 	_ "github.com/mostynb/go-grpc-compression/zstd"
@@ -22,6 +25,11 @@ const (
 	ErrKafkaRetryLimitReached = errkit.Error("Error connecting to kafka, retry limit reached")
 	ErrInitGrpc               = errkit.Error("Failed to listen to gRPC address")
 	ErrRegisterGrpc           = errkit.Error("Error registering gRPC server endpoint into reverse proxy")
+	ErrInitOtlpHttp           = errkit.Error("Failed to listen to OTLP HTTP address")
+	ErrInitSyslog             = errkit.Error("Failed to listen to syslog address")
+	ErrInitFluentForward      = errkit.Error("Failed to listen to fluent forward address")
+	ErrInitEsBulkHttp         = errkit.Error("Failed to listen to Elasticsearch bulk address")
+	ErrInitTailHttp           = errkit.Error("Failed to listen to tail address")
 
 	RateLimitKeyAppGroup = "app_group"
 
@@ -52,12 +60,29 @@ type producerService struct {
 	admin    types.KafkaAdmin
 	limiter  RateLimiter
 
+	peerGuard *PeerGuard
+	validator *TimberValidator
+	schema    *SchemaValidator
+	batcher   *microBatcher
+
+	sharedTopic SharedTopicConfig
+	otlp        *otlpLogsService
+	syslog      *syslogReceiver
+	forward     *fluentForwardReceiver
+	esBulk      *esBulkReceiver
+	tail        *tailService
+	mirror      *kafkaMirror
+	failover    *kafkaFailover
+
 	grpcServer   *grpc.Server
 	reverseProxy *http.Server
+	otlpServer   *http.Server
+	esBulkServer *http.Server
+	tailServer   *http.Server
 }
 
 func NewProducerService(params map[string]interface{}) *producerService {
-	return &producerService{
+	s := &producerService{
 		UnimplementedProducerServer: pb.UnimplementedProducerServer{},
 		factory:                     params["factory"].(types.KafkaFactory),
 		grpcAddr:                    params["grpcAddr"].(string),
@@ -71,6 +96,59 @@ func NewProducerService(params map[string]interface{}) *producerService {
 		kafkaMessageFormat:          params["kafkaMessageFormat"].(string),
 		limiter:                     params["limiter"].(RateLimiter),
 	}
+
+	if peerGuard, ok := params["peerGuard"]; ok {
+		s.peerGuard = peerGuard.(*PeerGuard)
+	}
+
+	if validator, ok := params["validator"]; ok {
+		s.validator = validator.(*TimberValidator)
+	}
+
+	if schema, ok := params["schemaValidator"]; ok {
+		s.schema = schema.(*SchemaValidator)
+	}
+
+	if sharedTopic, ok := params["sharedTopic"]; ok {
+		s.sharedTopic = sharedTopic.(SharedTopicConfig)
+	}
+
+	if otlp, ok := params["otlp"]; ok {
+		s.otlp = newOtlpLogsService(s, otlp.(OtlpConfig))
+	}
+
+	if syslog, ok := params["syslog"]; ok {
+		s.syslog = newSyslogReceiver(s, syslog.(SyslogConfig))
+	}
+
+	if forward, ok := params["fluentForward"]; ok {
+		s.forward = newFluentForwardReceiver(s, forward.(FluentForwardConfig))
+	}
+
+	if esBulk, ok := params["esBulk"]; ok {
+		s.esBulk = newEsBulkReceiver(s, esBulk.(EsBulkConfig))
+	}
+
+	if tail, ok := params["tail"]; ok {
+		s.tail = newTailService(s, tail.(TailConfig))
+	}
+
+	if failover, ok := params["kafkaFailover"]; ok {
+		s.failover = newKafkaFailover(failover.(KafkaFailoverConfig), s.factory)
+	}
+
+	if mirror, ok := params["mirror"]; ok {
+		s.mirror = newKafkaMirror(mirror.(MirrorConfig), s.newEventTopic)
+	}
+
+	if microBatch, ok := params["microBatch"]; ok {
+		config := microBatch.(MicroBatchConfig)
+		if config.Window > 0 && s.kafkaMessageFormat == TimberCollectionMessageFormat {
+			s.batcher = newMicroBatcher(config, s.handleProduceBatch)
+		}
+	}
+
+	return s
 }
 
 func (s *producerService) initProducer() (err error) {
@@ -124,33 +202,145 @@ func (s *producerService) initKafkaAdmin() (err error) {
 	return
 }
 
+// initFailover connects the primary or the standby kafka cluster, it retries like initProducer while neither is reachable
+func (s *producerService) initFailover() (err error) {
+	if err = s.failover.config.Validate(); err != nil {
+		return
+	}
+
+	retry := 0
+	for {
+		retry += 1
+		err = s.failover.Connect()
+		if err == nil {
+			break
+		}
+
+		prome.IncreaseProducerKafkaClientFailed()
+		if (s.kafkaMaxRetry != 0) && (retry >= s.kafkaMaxRetry) {
+			return errkit.Concat(ErrMakeSyncProducer, ErrKafkaRetryLimitReached)
+		}
+		log.Warnf("Cannot connect to kafka: %s, retrying in %d seconds", err, s.kafkaRetryInterval)
+		time.Sleep(time.Duration(s.kafkaRetryInterval) * time.Second)
+	}
+
+	s.failover.Start()
+	s.producer, s.admin = &failoverProducer{failover: s.failover}, &failoverAdmin{failover: s.failover}
+	return
+}
+
 func (s *producerService) initGrpcServer() (lis net.Listener, srv *grpc.Server, err error) {
 	lis, err = net.Listen("tcp", s.grpcAddr)
 	if err != nil {
 		return
 	}
 
-	srv = grpc.NewServer(grpc.MaxRecvMsgSize(s.grpcMaxRecvMsgSize))
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(s.grpcMaxRecvMsgSize)}
+	if s.peerGuard != nil {
+		lis = s.peerGuard.Listener(lis)
+		opts = append(opts, s.peerGuard.ServerOptions()...)
+	}
+
+	srv = grpc.NewServer(opts...)
 	pb.RegisterProducerServer(srv, s)
+	if s.otlp != nil {
+		collogspb.RegisterLogsServiceServer(srv, s.otlp)
+	}
 
 	s.grpcServer = srv
 	return
 }
 
-func (s *producerService) Start() (err error) {
-	err = s.initProducer()
+// initHttpServer listens to addr for plain HTTP receivers, the peer limits apply when peer guard is enabled
+func (s *producerService) initHttpServer(addr string, handler http.Handler) (lis net.Listener, srv *http.Server, err error) {
+	lis, err = net.Listen("tcp", addr)
 	if err != nil {
-		err = errkit.Concat(ErrMakeSyncProducer, err)
 		return
 	}
 
-	err = s.initKafkaAdmin()
-	if err != nil {
-		err = errkit.Concat(ErrMakeKafkaAdmin, err)
-		return
+	if s.peerGuard != nil {
+		lis = s.peerGuard.Listener(lis)
+		handler = guardHTTP(s.peerGuard, handler)
+	}
+
+	srv = &http.Server{Handler: handler}
+	return
+}
+
+func (s *producerService) Start() (err error) {
+	if s.failover != nil {
+		err = s.initFailover()
+		if err != nil {
+			return
+		}
+	} else {
+		err = s.initProducer()
+		if err != nil {
+			err = errkit.Concat(ErrMakeSyncProducer, err)
+			return
+		}
+
+		err = s.initKafkaAdmin()
+		if err != nil {
+			err = errkit.Concat(ErrMakeKafkaAdmin, err)
+			return
+		}
+	}
+
+	if s.mirror != nil {
+		s.mirror.Start()
+	}
+
+	if s.schema != nil {
+		s.schema.Start()
 	}
 
 	s.limiter.Start()
+	if s.peerGuard != nil {
+		s.peerGuard.Start()
+	}
+
+	if s.otlp != nil && s.otlp.config.HttpAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle(OtlpLogsPath, s.otlp)
+
+		otlpLis, otlpSrv, err := s.initHttpServer(s.otlp.config.HttpAddr, mux)
+		if err != nil {
+			return errkit.Concat(ErrInitOtlpHttp, err)
+		}
+		s.otlpServer = otlpSrv
+		go serveHTTP("OTLP", otlpSrv, otlpLis)
+	}
+
+	if s.esBulk != nil {
+		esBulkLis, esBulkSrv, err := s.initHttpServer(s.esBulk.config.Addr, s.esBulk)
+		if err != nil {
+			return errkit.Concat(ErrInitEsBulkHttp, err)
+		}
+		s.esBulkServer = esBulkSrv
+		go serveHTTP("Elasticsearch bulk", esBulkSrv, esBulkLis)
+	}
+
+	if s.tail != nil {
+		tailLis, tailSrv, err := s.initHttpServer(s.tail.config.Addr, s.tail)
+		if err != nil {
+			return errkit.Concat(ErrInitTailHttp, err)
+		}
+		s.tailServer = tailSrv
+		go serveHTTP("Tail", tailSrv, tailLis)
+	}
+
+	if s.syslog != nil {
+		if err = s.syslog.Start(); err != nil {
+			return errkit.Concat(ErrInitSyslog, err)
+		}
+	}
+
+	if s.forward != nil {
+		if err = s.forward.Start(); err != nil {
+			return errkit.Concat(ErrInitFluentForward, err)
+		}
+	}
 
 	lis, grpcSrv, err := s.initGrpcServer()
 	if err != nil {
@@ -166,14 +356,54 @@ func (s *producerService) Close() {
 		s.reverseProxy.Close()
 	}
 
+	if s.otlpServer != nil {
+		s.otlpServer.Close()
+	}
+
+	if s.esBulkServer != nil {
+		s.esBulkServer.Close()
+	}
+
+	if s.tailServer != nil {
+		s.tailServer.Close()
+	}
+
 	if s.grpcServer != nil {
 		s.grpcServer.GracefulStop()
 	}
 
+	if s.syslog != nil {
+		s.syslog.Close()
+	}
+
+	if s.forward != nil {
+		s.forward.Close()
+	}
+
 	if s.limiter != nil {
 		s.limiter.Stop()
 	}
 
+	if s.peerGuard != nil {
+		s.peerGuard.Stop()
+	}
+
+	if s.batcher != nil {
+		s.batcher.Close()
+	}
+
+	if s.mirror != nil {
+		s.mirror.Close()
+	}
+
+	if s.failover != nil {
+		s.failover.Stop()
+	}
+
+	if s.schema != nil {
+		s.schema.Close()
+	}
+
 	if s.admin != nil {
 		s.admin.Close()
 	}
@@ -183,8 +413,27 @@ func (s *producerService) Close() {
 	}
 }
 
-func (s *producerService) Produce(_ context.Context, timber *pb.Timber) (resp *pb.ProduceResult, err error) {
+func (s *producerService) Produce(ctx context.Context, timber *pb.Timber) (resp *pb.ProduceResult, err error) {
 	topic := s.topicPrefix + timber.GetContext().GetKafkaTopic() + s.topicSuffix
+
+	ctx, span := tracer.Start(ctx, "Produce", trace.WithAttributes(
+		AttributeApp.String(timber.GetContext().GetEsIndexPrefix()),
+		semconv.MessagingDestinationName(topic),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if verr := s.timberValidator().ValidateTimber(timber, topic); verr != nil {
+		err = s.onInvalidRequest(verr)
+		return
+	}
+
+	if s.schema != nil {
+		if verr := s.schema.CheckTimber(timber.GetContext().GetKafkaTopic(), timber); verr != nil {
+			err = s.onInvalidRequest(verr)
+			return
+		}
+	}
+
 	rateLimitKey, maxToken := s.getRateLimitInfo(timber.GetContext())
 
 	if s.limiter.IsHitLimit(rateLimitKey, 1, maxToken) {
@@ -197,18 +446,24 @@ func (s *producerService) Produce(_ context.Context, timber *pb.Timber) (resp *p
 	}
 
 	timber.Timestamp = time.Now().UTC().Format(time.RFC3339)
-	if s.kafkaMessageFormat == TimberCollectionMessageFormat {
+	if s.batcher != nil {
+		err = s.batcher.Add(ctx, topic, timber)
+		if err != nil {
+			log.Infof("Failed send logs to kafka: %s", err)
+			return
+		}
+	} else if s.kafkaMessageFormat == TimberCollectionMessageFormat {
 		timberCollection := &pb.TimberCollection{
 			Items:   []*pb.Timber{timber},
 			Context: timber.GetContext(),
 		}
-		err = s.handleProduceBatch(timberCollection, topic)
+		err = s.handleProduceBatch(ctx, timberCollection, topic)
 		if err != nil {
 			log.Infof("Failed send logs to kafka: %s", err)
 			return
 		}
 	} else {
-		err = s.handleProduce(timber, topic)
+		err = s.handleProduce(ctx, timber, topic)
 		if err != nil {
 			log.Infof("Failed send logs to kafka: %s", err)
 			return
@@ -221,8 +476,28 @@ func (s *producerService) Produce(_ context.Context, timber *pb.Timber) (resp *p
 	return
 }
 
-func (s *producerService) ProduceBatch(_ context.Context, timberCollection *pb.TimberCollection) (resp *pb.ProduceResult, err error) {
+func (s *producerService) ProduceBatch(ctx context.Context, timberCollection *pb.TimberCollection) (resp *pb.ProduceResult, err error) {
 	topic := s.topicPrefix + timberCollection.GetContext().GetKafkaTopic() + s.topicSuffix
+
+	ctx, span := tracer.Start(ctx, "ProduceBatch", trace.WithAttributes(
+		AttributeApp.String(timberCollection.GetContext().GetEsIndexPrefix()),
+		AttributeItems.Int(len(timberCollection.GetItems())),
+		semconv.MessagingDestinationName(topic),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if verr := s.timberValidator().ValidateTimberCollection(timberCollection, topic); verr != nil {
+		err = s.onInvalidRequest(verr)
+		return
+	}
+
+	if s.schema != nil {
+		if verr := s.schema.CheckTimberCollection(timberCollection.GetContext().GetKafkaTopic(), timberCollection); verr != nil {
+			err = s.onInvalidRequest(verr)
+			return
+		}
+	}
+
 	rateLimitKey, maxToken := s.getRateLimitInfo(timberCollection.GetContext())
 
 	lengthMessages := len(timberCollection.GetItems())
@@ -242,7 +517,7 @@ func (s *producerService) ProduceBatch(_ context.Context, timberCollection *pb.T
 		for _, timber := range timberCollection.GetItems() {
 			timber.Timestamp = time.Now().UTC().Format(time.RFC3339)
 		}
-		err = s.handleProduceBatch(timberCollection, topic)
+		err = s.handleProduceBatch(ctx, timberCollection, topic)
 		if err != nil {
 			log.Infof("Failed send logs to kafka: %s", err)
 			return
@@ -252,7 +527,7 @@ func (s *producerService) ProduceBatch(_ context.Context, timberCollection *pb.T
 			timber.Context = timberCollection.GetContext()
 			timber.Timestamp = time.Now().UTC().Format(time.RFC3339)
 
-			err = s.handleProduce(timber, topic)
+			err = s.handleProduce(ctx, timber, topic)
 			if err != nil {
 				log.Infof("Failed send logs to kafka: %s", err)
 				return
@@ -266,26 +541,42 @@ func (s *producerService) ProduceBatch(_ context.Context, timberCollection *pb.T
 	return
 }
 
-func (s *producerService) sendLogs(topic string, timber *pb.Timber) (err error) {
-	message := ConvertTimberToKafkaMessage(timber, topic)
+func (s *producerService) sendLogs(ctx context.Context, topic string, timber *pb.Timber) (err error) {
+	return s.sendMessage(ctx, ConvertTimberToKafkaMessage(timber, topic))
+}
 
-	startTime := time.Now()
-	_, _, err = s.producer.SendMessage(message)
-	timeDifference := float64(time.Now().Sub(startTime).Nanoseconds()) / float64(1000000000)
-	prome.ObserveSendToKafkaTime(topic, timeDifference)
-	return
+func (s *producerService) sendLogsTimberCollection(ctx context.Context, topic string, timberCollection *pb.TimberCollection) (err error) {
+	return s.sendMessage(ctx, ConvertTimberCollectionToKafkaMessage(timberCollection, topic))
 }
 
-func (s *producerService) sendLogsTimberCollection(topic string, timberCollection *pb.TimberCollection) (err error) {
-	message := ConvertTimberCollectionToKafkaMessage(timberCollection, topic)
+// sendMessage writes message within a producer span, whose context is carried in the record headers
+func (s *producerService) sendMessage(ctx context.Context, message *sarama.ProducerMessage) (err error) {
+	ctx, span := tracer.Start(ctx, "send "+message.Topic, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		semconv.MessagingSystemKafka,
+		semconv.MessagingDestinationName(message.Topic),
+	))
+	defer func() { endSpan(span, err) }()
+	injectTraceContext(ctx, message)
 
 	startTime := time.Now()
 	_, _, err = s.producer.SendMessage(message)
 	timeDifference := float64(time.Now().Sub(startTime).Nanoseconds()) / float64(1000000000)
-	prome.ObserveSendToKafkaTime(topic, timeDifference)
+	prome.ObserveSendToKafkaTime(message.Topic, timeDifference)
+
+	if err == nil && s.mirror != nil {
+		s.mirror.Mirror(message)
+	}
 	return
 }
 
+// activeFactory returns the factory of the kafka cluster the producer writes into
+func (s *producerService) activeFactory() types.KafkaFactory {
+	if s.failover != nil {
+		return s.failover.factory()
+	}
+	return s.factory
+}
+
 func (s *producerService) sendCreateTopicEvents(topic string) (err error) {
 	message := &sarama.ProducerMessage{
 		Topic: s.newEventTopic,
@@ -327,46 +618,86 @@ func (s *producerService) createTopicIfNotExist(timberContext *pb.TimberContext,
 	return nil
 }
 
-func (s *producerService) handleProduce(timber *pb.Timber, topic string) (err error) {
-	timberContext := timber.GetContext()
+// destinationTopic returns the kafka topic the logs of topic are written to, and the context used to create it
+func (s *producerService) destinationTopic(timberContext *pb.TimberContext, topic string) (string, *pb.TimberContext) {
+	if sharedTopic, ok := s.sharedTopic.Route(timberContext, s.topicPrefix, s.topicSuffix); ok {
+		return sharedTopic, s.sharedTopic.TopicContext()
+	}
+	return topic, timberContext
+}
+
+func (s *producerService) handleProduce(ctx context.Context, timber *pb.Timber, topic string) (err error) {
+	destination, topicContext := s.destinationTopic(timber.GetContext(), topic)
 
-	err = s.createTopicIfNotExist(timberContext, topic)
+	err = s.createTopicIfNotExist(topicContext, destination)
 	if err != nil {
 		return
 	}
 
-	err = s.sendLogs(topic, timber)
+	err = s.sendLogs(ctx, destination, timber)
 	if err != nil {
 		err = onStoreErrorGrpc(err)
 		prome.IncreaseKafkaMessagesStoredTotalWithError(topic, "send_log")
 		return
 	}
 	prome.ObserveByteIngestion(topic, s.topicSuffix, timber)
+	if s.tail != nil {
+		s.tail.remember(timber.GetContext())
+	}
 
 	prome.IncreaseKafkaMessagesStoredTotal(topic)
 	return
 }
 
-func (s *producerService) handleProduceBatch(timberCollection *pb.TimberCollection, topic string) (err error) {
-	timberContext := timberCollection.GetContext()
+func (s *producerService) handleProduceBatch(ctx context.Context, timberCollection *pb.TimberCollection, topic string) (err error) {
+	destination, topicContext := s.destinationTopic(timberCollection.GetContext(), topic)
 
-	err = s.createTopicIfNotExist(timberContext, topic)
+	err = s.createTopicIfNotExist(topicContext, destination)
 	if err != nil {
 		return
 	}
 
-	err = s.sendLogsTimberCollection(topic, timberCollection)
+	err = s.sendLogsTimberCollection(ctx, destination, timberCollection)
 	if err != nil {
 		err = onStoreErrorGrpc(err)
 		prome.IncreaseKafkaMessagesStoredTotalWithError(topic, "send_log")
 		return
 	}
 	prome.ObserveByteIngestionCollection(topic, s.topicSuffix, timberCollection)
+	if s.tail != nil {
+		s.tail.remember(timberCollection.GetContext())
+	}
 
 	prome.IncreaseKafkaMessagesStoredTotal(topic)
 	return
 }
 
+func (s *producerService) timberValidator() *TimberValidator {
+	if s.validator == nil {
+		return defaultTimberValidator
+	}
+	return s.validator
+}
+
+// validateBatchItem validates the content of timber before it is batched with the items of app, so an invalid item is rejected alone
+// rather than failing ProduceBatch of the whole batch. The schema is checked here in enforce mode only, the other modes do not reject.
+func (s *producerService) validateBatchItem(app string, timber *pb.Timber) error {
+	verr := s.timberValidator().validateContent(timber)
+	if verr == nil && s.schema != nil && s.schema.config.Mode == SchemaModeEnforce {
+		verr = s.schema.CheckTimber(app, timber)
+	}
+	if verr != nil {
+		return s.onInvalidRequest(verr)
+	}
+	return nil
+}
+
+func (s *producerService) onInvalidRequest(verr *ValidationError) error {
+	prome.IncreaseProducerInvalidRequest(verr.Reason)
+	log.Debugf("Invalid request: %s", verr)
+	return onBadRequestGrpc(verr)
+}
+
 func (s *producerService) getRateLimitInfo(context *pb.TimberContext) (string, int32) {
 	if context.GetDisableAppTps() {
 		return RateLimitKeyAppGroup, context.GetAppGroupMaxTps()
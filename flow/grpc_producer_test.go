@@ -1,6 +1,7 @@
 package flow
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/BaritoLog/barito-flow/mock"
 	. "github.com/BaritoLog/go-boilerplate/testkit"
 	"github.com/BaritoLog/go-boilerplate/timekit"
+	"github.com/Shopify/sarama"
 	pb "github.com/bentol/barito-proto/producer"
 	"github.com/golang/mock/gomock"
 	"github.com/golang/protobuf/proto"
@@ -40,7 +42,7 @@ func TestProducerService_Produce_OnLimitExceeded(t *testing.T) {
 		limiter: limiter,
 	}
 
-	_, err := srv.Produce(nil, pb.SampleTimberProto())
+	_, err := srv.Produce(context.Background(), pb.SampleTimberProto())
 	FatalIfWrongGrpcError(t, onLimitExceededGrpc(), err)
 
 	expected := `
@@ -64,7 +66,7 @@ func TestProducerService_ProduceBatch_OnLimitExceeded(t *testing.T) {
 		limiter: limiter,
 	}
 
-	_, err := srv.ProduceBatch(nil, pb.SampleTimberCollectionProto())
+	_, err := srv.ProduceBatch(context.Background(), pb.SampleTimberCollectionProto())
 	FatalIfWrongGrpcError(t, onLimitExceededGrpc(), err)
 
 	expected := `
@@ -97,7 +99,7 @@ func TestProducerService_Produce_OnCreateTopicError(t *testing.T) {
 		limiter:     limiter,
 	}
 
-	_, err := srv.Produce(nil, pb.SampleTimberProto())
+	_, err := srv.Produce(context.Background(), pb.SampleTimberProto())
 	FatalIfWrongGrpcError(t, onCreateTopicErrorGrpc(fmt.Errorf("")), err)
 
 	expected := `
@@ -130,7 +132,7 @@ func TestProducerService_Produce_OnStoreError(t *testing.T) {
 		limiter:     limiter,
 	}
 
-	_, err := srv.Produce(nil, pb.SampleTimberProto())
+	_, err := srv.Produce(context.Background(), pb.SampleTimberProto())
 	FatalIfWrongGrpcError(t, onStoreErrorGrpc(fmt.Errorf("")), err)
 
 	expected := `
@@ -168,7 +170,7 @@ func TestProducerService_Produce_OnSuccess(t *testing.T) {
 		kafkaRetryInterval: 10,
 	}
 
-	resp, err := srv.Produce(nil, pb.SampleTimberProto())
+	resp, err := srv.Produce(context.Background(), pb.SampleTimberProto())
 	FatalIfError(t, err)
 	FatalIf(t, resp.GetTopic() != "some_topic_logs", "wrong result.Topic")
 
@@ -207,7 +209,7 @@ func TestProducerService_Produce_IgnoreKafkaOptions(t *testing.T) {
 		kafkaRetryInterval: 10,
 		ignoreKafkaOptions: true,
 	}
-	srv.Produce(nil, pb.SampleTimberProto())
+	srv.Produce(context.Background(), pb.SampleTimberProto())
 }
 
 func TestProducerService_ProduceBatch_OnSuccess(t *testing.T) {
@@ -235,10 +237,12 @@ func TestProducerService_ProduceBatch_OnSuccess(t *testing.T) {
 		admin:       admin,
 		limiter:     limiter,
 	}
+	srv.tail = newTailService(srv, TailConfig{})
 
-	resp, err := srv.ProduceBatch(nil, pb.SampleTimberCollectionProto())
+	resp, err := srv.ProduceBatch(context.Background(), pb.SampleTimberCollectionProto())
 	FatalIfError(t, err)
 	FatalIf(t, resp.GetTopic() != "some_topic_logs", "wrong result.Topic")
+	FatalIf(t, srv.tail.authorize("some_topic", HashAppSecret("some-secret-1234")) != 0, "produced app should be tailed with its secret")
 }
 
 func TestProducerService_Start_ErrorMakeSyncProducer(t *testing.T) {
@@ -303,6 +307,30 @@ func TestProducerService_Start_ErrorMakeKafkaAdmin(t *testing.T) {
 	FatalIfWrongError(t, err, "Make kafka admin failed: Error connecting to kafka, retry limit reached")
 }
 
+func TestProducerService_Start_InvalidKafkaFailover(t *testing.T) {
+	producerParams := map[string]interface{}{
+		"factory":                NewDummyKafkaFactory(),
+		"grpcAddr":               "grpc",
+		"restAddr":               "rest",
+		"rateLimitResetInterval": 1,
+		"topicSuffix":            "_logs",
+		"topicPrefix":            "",
+		"kafkaMaxRetry":          1,
+		"kafkaRetryInterval":     10,
+		"newEventTopic":          "new_topic_events",
+		"grpcMaxRecvMsgSize":     20000000,
+		"ignoreKafkaOptions":     false,
+		"kafkaMessageFormat":     TimberMessageFormat,
+		"limiter":                NewDummyRateLimiter(),
+		"kafkaFailover":          KafkaFailoverConfig{Standby: NewDummyKafkaFactory(), ErrorThreshold: 0, FailbackThreshold: 3},
+	}
+
+	service := NewProducerService(producerParams)
+	err := service.Start()
+
+	FatalIfWrongError(t, err, string(ErrKafkaFailoverThreshold))
+}
+
 func TestProducerService_Start(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -361,7 +389,7 @@ func TestProducerService_Produce_OnByteIngested(t *testing.T) {
 	sampleTimber := pb.SampleTimberProto()
 	sampleTimber.Timestamp = time.Now().UTC().Format(time.RFC3339)
 
-	resp, err := srv.Produce(nil, sampleTimber)
+	resp, err := srv.Produce(context.Background(), sampleTimber)
 	FatalIfError(t, err)
 	FatalIf(t, resp.GetTopic() != "some_topic_logs", "wrong result.Topic")
 
@@ -415,7 +443,7 @@ func TestProducerService_ProduceBatch_OnByteIngested(t *testing.T) {
 		expectedByteSize += float64(len(expectedByte))
 	}
 
-	resp, err := srv.ProduceBatch(nil, sampleTimberCollection)
+	resp, err := srv.ProduceBatch(context.Background(), sampleTimberCollection)
 	FatalIfError(t, err)
 	FatalIf(t, resp.GetTopic() != "some_topic_logs", "wrong result.Topic")
 
@@ -450,7 +478,7 @@ func TestProducerService_Produce_TPSExceededBytes(t *testing.T) {
 	sampleTimber := pb.SampleTimberProto()
 	sampleTimber.Timestamp = time.Now().UTC().Format(time.RFC3339)
 
-	_, err := srv.Produce(nil, sampleTimber)
+	_, err := srv.Produce(context.Background(), sampleTimber)
 	FatalIfWrongGrpcError(t, onLimitExceededGrpc(), err)
 
 	expectedByte, _ := proto.Marshal(sampleTimber)
@@ -494,7 +522,7 @@ func TestProducerService_ProduceBatch_TPSExceededBytes(t *testing.T) {
 		expectedByteSize += float64(len(expectedByte))
 	}
 
-	_, err := srv.ProduceBatch(nil, sampleTimberCollection)
+	_, err := srv.ProduceBatch(context.Background(), sampleTimberCollection)
 	FatalIfWrongGrpcError(t, onLimitExceededGrpc(), err)
 
 	expected := fmt.Sprintf(`
@@ -506,6 +534,76 @@ func TestProducerService_ProduceBatch_TPSExceededBytes(t *testing.T) {
 	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_tps_exceeded_log_bytes"))
 }
 
+func TestProducerService_Produce_OnInvalidRequest(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv := &producerService{
+		topicSuffix: "_logs",
+		limiter:     NewDummyRateLimiter(),
+	}
+
+	timber := pb.SampleTimberProto()
+	timber.Context.EsIndexPrefix = ""
+
+	_, err := srv.Produce(context.Background(), timber)
+	FatalIfWrongGrpcError(t, onBadRequestGrpc(defaultTimberValidator.ValidateTimber(timber, "some_topic_logs")), err)
+
+	timberCollection := pb.SampleTimberCollectionProto()
+	timberCollection.Context.KafkaTopic = ""
+
+	_, err = srv.ProduceBatch(context.Background(), timberCollection)
+	FatalIfWrongGrpcError(t, onBadRequestGrpc(defaultTimberValidator.ValidateTimberCollection(timberCollection, "_logs")), err)
+
+	expected := `
+		# HELP barito_producer_invalid_request_total Number of requests rejected by validation
+		# TYPE barito_producer_invalid_request_total counter
+		barito_producer_invalid_request_total{reason="es_index_prefix_missing"} 1
+		barito_producer_invalid_request_total{reason="kafka_topic_missing"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_invalid_request_total"))
+}
+
+func TestProducerService_Produce_SharedTopic(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sharedTopic := SharedTopicConfig{Count: 1, MaxTps: 10, Name: "shared", Partitions: 6, ReplicationFactor: 2}
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist("shared_0_logs").Return(false)
+	admin.EXPECT().CreateTopic("shared_0_logs", int32(6), int16(2)).Return(nil)
+	admin.EXPECT().AddTopic("shared_0_logs")
+
+	var sent []*sarama.ProducerMessage
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).AnyTimes().DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		sent = append(sent, msg)
+		return 0, 0, nil
+	})
+
+	srv := &producerService{
+		producer:    producer,
+		topicSuffix: "_logs",
+		admin:       admin,
+		limiter:     NewDummyRateLimiter(),
+		sharedTopic: sharedTopic,
+	}
+
+	resp, err := srv.Produce(context.Background(), pb.SampleTimberProto())
+	FatalIfError(t, err)
+	FatalIf(t, resp.GetTopic() != "some_topic_logs", "wrong result.Topic")
+
+	FatalIf(t, len(sent) != 2, "expected create topic event and log message, got %d", len(sent))
+	FatalIf(t, sent[1].Topic != "shared_0_logs", "log should be sent to shared topic, got %s", sent[1].Topic)
+
+	b, _ := sent[1].Value.Encode()
+	timber := &pb.Timber{}
+	FatalIfError(t, proto.Unmarshal(b, timber))
+	FatalIf(t, timber.GetContext().GetKafkaTopic() != "some_topic", "app identity should be kept in the record")
+}
+
 func FatalIfWrongGrpcError(t *testing.T, expected error, actual error) {
 	expFields := strings.Fields(expected.Error())[:5]
 	expStr := strings.Join(expFields, " ")
@@ -0,0 +1,77 @@
+package flow
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpStatusOf maps the status code of a produce error into HTTP status, it follows the OTLP/HTTP spec:
+// 429 and 503 are retried by the exporters
+func httpStatusOf(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// readHTTPBody reads the request body, maxBytes limits the decompressed size when it is positive
+func readHTTPBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	reader := io.Reader(r.Body)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+	}
+
+	if maxBytes <= 0 {
+		return io.ReadAll(reader)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err == nil && int64(len(b)) > maxBytes {
+		err = fmt.Errorf("request body is larger than %d bytes", maxBytes)
+	}
+	return b, err
+}
+
+// guardHTTP applies the peer limits of guard to plain HTTP requests, the peer is the host of the remote address like gRPC
+func guardHTTP(guard *PeerGuard, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := guard.admit(hostOfAddress(r.RemoteAddr))
+		if err != nil {
+			st := status.Convert(err)
+			http.Error(w, st.Message(), httpStatusOf(st.Code()))
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func serveHTTP(name string, srv *http.Server, lis net.Listener) {
+	err := srv.Serve(lis)
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("%s HTTP server stopped: %s", name, err)
+	}
+}
@@ -0,0 +1,269 @@
+package flow
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/go-boilerplate/errkit"
+)
+
+const (
+	ErrIndexNameTemplate = errkit.Error("Invalid index name template")
+	ErrIndexNameRule     = errkit.Error("Invalid index name rule")
+
+	DefaultIndexNameTemplate = "{prefix}-{date:2006.01.02}"
+
+	DefaultCatchAllIndexNameTemplate = "{prefix}-catch-all-{date:2006.01.02}"
+	DefaultEventTimeMaxLateness      = 7 * 24 * time.Hour
+	DefaultEventTimeMaxAhead         = 5 * time.Minute
+
+	EventTimeRouteOnTime  = "on_time"
+	EventTimeRouteLate    = "late"
+	EventTimeRouteAhead   = "ahead"
+	EventTimeRouteInvalid = "invalid"
+
+	indexNameDateLayout = "2006.01.02"
+	indexNameHourLayout = "2006.01.02.15"
+)
+
+// indexNamePart is a literal of the template, or a placeholder when layout or prefix is set
+type indexNamePart struct {
+	literal string
+	layout  string
+	prefix  bool
+}
+
+// IndexNameTemplate renders the index name of a prefix at a time, the placeholders are
+//
+//	{prefix}         EsIndexPrefix of the timber
+//	{date}           the day, same as {date:2006.01.02}
+//	{date:<layout>}  the time formatted with the go layout, e.g. {date:2006.01} for monthly indices
+//	{hour}           the hour, same as {date:2006.01.02.15}
+//
+// The time is converted into location before formatting, so indices are cut at the midnight of location.
+type IndexNameTemplate struct {
+	template string
+	location *time.Location
+	parts    []indexNamePart
+	pattern  *regexp.Regexp
+}
+
+// NewIndexNameTemplate parses template, location is time.Local when nil
+func NewIndexNameTemplate(template string, location *time.Location) (*IndexNameTemplate, error) {
+	if location == nil {
+		location = time.Local
+	}
+
+	t := &IndexNameTemplate{template: template, location: location}
+	hasPrefix := false
+	expr := "^"
+
+	for s := template; s != ""; {
+		start := strings.Index(s, "{")
+		if start < 0 {
+			t.parts = append(t.parts, indexNamePart{literal: s})
+			expr += regexp.QuoteMeta(s)
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, indexNamePart{literal: s[:start]})
+			expr += regexp.QuoteMeta(s[:start])
+		}
+
+		end := strings.Index(s, "}")
+		if end < start {
+			return nil, errkit.Concat(ErrIndexNameTemplate, errkit.Error(template))
+		}
+
+		placeholder := s[start+1 : end]
+		switch {
+		case placeholder == "prefix":
+			t.parts = append(t.parts, indexNamePart{prefix: true})
+			expr += "(.+?)"
+			hasPrefix = true
+		case placeholder == "date":
+			t.parts = append(t.parts, indexNamePart{layout: indexNameDateLayout})
+			expr += layoutPattern(indexNameDateLayout)
+		case placeholder == "hour":
+			t.parts = append(t.parts, indexNamePart{layout: indexNameHourLayout})
+			expr += layoutPattern(indexNameHourLayout)
+		case strings.HasPrefix(placeholder, "date:") && len(placeholder) > len("date:"):
+			layout := strings.TrimPrefix(placeholder, "date:")
+			t.parts = append(t.parts, indexNamePart{layout: layout})
+			expr += layoutPattern(layout)
+		default:
+			return nil, errkit.Concat(ErrIndexNameTemplate, errkit.Error(template))
+		}
+		s = s[end+1:]
+	}
+
+	// indices of different apps would collide without the prefix
+	if !hasPrefix {
+		return nil, errkit.Concat(ErrIndexNameTemplate, errkit.Error(template))
+	}
+
+	t.pattern = regexp.MustCompile(expr + "$")
+	return t, nil
+}
+
+// layoutPattern matches the time formatted with a numeric layout, e.g. 2006.01.02
+func layoutPattern(layout string) string {
+	var b strings.Builder
+	for _, r := range layout {
+		if r >= '0' && r <= '9' {
+			b.WriteString(`\d`)
+		} else {
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+func (t *IndexNameTemplate) Render(prefix string, at time.Time) string {
+	at = at.In(t.location)
+
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch {
+		case part.prefix:
+			b.WriteString(prefix)
+		case part.layout != "":
+			b.WriteString(at.Format(part.layout))
+		default:
+			b.WriteString(part.literal)
+		}
+	}
+	return b.String()
+}
+
+// Prefix returns the prefix of index rendered by the template
+func (t *IndexNameTemplate) Prefix(index string) (string, bool) {
+	match := t.pattern.FindStringSubmatch(index)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+func (t *IndexNameTemplate) String() string {
+	return t.template
+}
+
+// IndexNameRule overrides the template of the prefixes matching Pattern, a path.Match glob
+type IndexNameRule struct {
+	Pattern  string
+	Template *IndexNameTemplate
+}
+
+// ParseIndexNameRule parses "<prefix glob>=<template>[@<timezone>]", the timezone is time.Local when omitted
+func ParseIndexNameRule(s string) (rule IndexNameRule, err error) {
+	i := strings.Index(s, "=")
+	if i < 1 || i == len(s)-1 {
+		err = errkit.Concat(ErrIndexNameRule, errkit.Error(s))
+		return
+	}
+
+	rule.Pattern = strings.TrimSpace(s[:i])
+	if _, err = path.Match(rule.Pattern, ""); err != nil {
+		err = errkit.Concat(ErrIndexNameRule, errkit.Error(s))
+		return
+	}
+
+	template, timezone := strings.TrimSpace(s[i+1:]), ""
+	if j := strings.LastIndex(template, "@"); j >= 0 {
+		template, timezone = template[:j], template[j+1:]
+	}
+
+	location, err := LoadIndexNameLocation(timezone)
+	if err != nil {
+		err = errkit.Concat(ErrIndexNameRule, err)
+		return
+	}
+
+	rule.Template, err = NewIndexNameTemplate(template, location)
+	return
+}
+
+// LoadIndexNameLocation returns time.Local when name is empty or "Local"
+func LoadIndexNameLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// IndexNamer names the index of a prefix by the first rule matching the prefix, or by the default template
+type IndexNamer struct {
+	Default *IndexNameTemplate
+	Rules   []IndexNameRule
+
+	// EventTime names the index after the time the log happened rather than the time it is stored,
+	// logs older than MaxLateness, ahead of MaxAhead or without valid time are stored into the CatchAll index named at the time it is stored
+	EventTime   bool
+	MaxLateness time.Duration
+	MaxAhead    time.Duration
+	CatchAll    *IndexNameTemplate
+}
+
+// NewDefaultIndexNamer names daily indices in local time, i.e. prefix-2006.01.02
+func NewDefaultIndexNamer() *IndexNamer {
+	template, _ := NewIndexNameTemplate(DefaultIndexNameTemplate, time.Local)
+	return &IndexNamer{Default: template}
+}
+
+func (n *IndexNamer) template(prefix string) *IndexNameTemplate {
+	for _, rule := range n.Rules {
+		if ok, _ := path.Match(rule.Pattern, prefix); ok {
+			return rule.Template
+		}
+	}
+	return n.Default
+}
+
+func (n *IndexNamer) IndexName(prefix string, at time.Time) string {
+	return n.template(prefix).Render(prefix, at)
+}
+
+// EventTimeIndexName names the index of a log happened at eventTime, ok is false when the log has no valid time.
+// It returns the route of the log, the index is named at now unless the route is EventTimeRouteOnTime.
+func (n *IndexNamer) EventTimeIndexName(prefix string, eventTime time.Time, ok bool, now time.Time) (index, route string) {
+	if !n.EventTime {
+		return n.IndexName(prefix, now), EventTimeRouteOnTime
+	}
+
+	switch {
+	case !ok:
+		route = EventTimeRouteInvalid
+	case now.Sub(eventTime) > n.MaxLateness:
+		route = EventTimeRouteLate
+	case eventTime.Sub(now) > n.MaxAhead:
+		route = EventTimeRouteAhead
+	default:
+		return n.IndexName(prefix, eventTime), EventTimeRouteOnTime
+	}
+
+	return n.CatchAll.Render(prefix, now), route
+}
+
+// Prefix returns the prefix index is named after, or index itself when no template renders it.
+// The catch all and the rules are tried first, the prefix must match the rule whose template renders index.
+func (n *IndexNamer) Prefix(index string) string {
+	if n.CatchAll != nil {
+		if prefix, ok := n.CatchAll.Prefix(index); ok {
+			return prefix
+		}
+	}
+	for _, rule := range n.Rules {
+		if prefix, ok := rule.Template.Prefix(index); ok {
+			if matched, _ := path.Match(rule.Pattern, prefix); matched {
+				return prefix
+			}
+		}
+	}
+	if prefix, ok := n.Default.Prefix(index); ok {
+		return prefix
+	}
+	return index
+}
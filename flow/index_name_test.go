@@ -0,0 +1,218 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIndexNameTemplate_Render(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	FatalIfError(t, err)
+	at := time.Date(2024, 1, 2, 17, 30, 0, 0, time.UTC)
+
+	testCases := []struct {
+		template string
+		location *time.Location
+		expected string
+	}{
+		{DefaultIndexNameTemplate, time.UTC, "abc-2024.01.02"},
+		{"{prefix}-{date}", jakarta, "abc-2024.01.03"},
+		{"{prefix}-{hour}", time.UTC, "abc-2024.01.02.17"},
+		{"{prefix}-{hour}", jakarta, "abc-2024.01.03.00"},
+		{"logs-{prefix}-{date:2006.01}", time.UTC, "logs-abc-2024.01"},
+		{"{prefix}", time.UTC, "abc"},
+	}
+
+	for _, tc := range testCases {
+		template, err := NewIndexNameTemplate(tc.template, tc.location)
+		FatalIfError(t, err)
+
+		index := template.Render("abc", at)
+		FatalIf(t, index != tc.expected, "%s: expected %s, got %s", tc.template, tc.expected, index)
+
+		prefix, ok := template.Prefix(index)
+		FatalIf(t, !ok || prefix != "abc", "%s: prefix of %s should be abc, got %s", tc.template, index, prefix)
+	}
+}
+
+func TestNewIndexNameTemplate_Invalid(t *testing.T) {
+	for _, template := range []string{"", "{date}", "{prefix}-{week}", "{prefix}-{date:}", "{prefix}-{date"} {
+		_, err := NewIndexNameTemplate(template, time.UTC)
+		FatalIfWrongError(t, err, string(ErrIndexNameTemplate)+": "+template)
+	}
+}
+
+func TestParseIndexNameRule(t *testing.T) {
+	rule, err := ParseIndexNameRule("payment-*={prefix}-{hour}@Asia/Jakarta")
+	FatalIfError(t, err)
+	FatalIf(t, rule.Pattern != "payment-*", "wrong pattern %s", rule.Pattern)
+	FatalIf(t, rule.Template.String() != "{prefix}-{hour}", "wrong template %s", rule.Template)
+	FatalIf(t, rule.Template.location.String() != "Asia/Jakarta", "wrong location %s", rule.Template.location)
+
+	rule, err = ParseIndexNameRule("abc={prefix}-{date}")
+	FatalIfError(t, err)
+	FatalIf(t, rule.Template.location != time.Local, "location should be local when omitted, got %s", rule.Template.location)
+
+	_, err = ParseIndexNameRule("abc")
+	FatalIfWrongError(t, err, string(ErrIndexNameRule)+": abc")
+
+	_, err = ParseIndexNameRule("abc={prefix}@Mars/Olympus")
+	FatalIf(t, err == nil || !strings.HasPrefix(err.Error(), string(ErrIndexNameRule)), "expected invalid timezone error, got %v", err)
+}
+
+func TestIndexNamer(t *testing.T) {
+	hourly, err := ParseIndexNameRule("payment-*={prefix}-{hour}@UTC")
+	FatalIfError(t, err)
+	template, err := NewIndexNameTemplate(DefaultIndexNameTemplate, time.UTC)
+	FatalIfError(t, err)
+
+	namer := &IndexNamer{Default: template, Rules: []IndexNameRule{hourly}}
+	at := time.Date(2024, 1, 2, 17, 30, 0, 0, time.UTC)
+
+	FatalIf(t, namer.IndexName("payment-api", at) != "payment-api-2024.01.02.17", "rule should override the default, got %s", namer.IndexName("payment-api", at))
+	FatalIf(t, namer.IndexName("abc", at) != "abc-2024.01.02", "default should be used without matching rule, got %s", namer.IndexName("abc", at))
+
+	testCases := map[string]string{
+		"payment-api-2024.01.02.17": "payment-api",
+		"abc-2024.01.02":            "abc",
+		"abc-def-2024.01.02":        "abc-def",
+		"some-type":                 "some-type",
+	}
+	for index, expected := range testCases {
+		FatalIf(t, namer.Prefix(index) != expected, "prefix of %s should be %s, got %s", index, expected, namer.Prefix(index))
+	}
+}
+
+func newEventTimeIndexNamer(t *testing.T) *IndexNamer {
+	template, err := NewIndexNameTemplate(DefaultIndexNameTemplate, time.UTC)
+	FatalIfError(t, err)
+	catchAll, err := NewIndexNameTemplate(DefaultCatchAllIndexNameTemplate, time.UTC)
+	FatalIfError(t, err)
+
+	return &IndexNamer{
+		Default:     template,
+		EventTime:   true,
+		MaxLateness: 48 * time.Hour,
+		MaxAhead:    5 * time.Minute,
+		CatchAll:    catchAll,
+	}
+}
+
+func TestIndexNamer_EventTimeIndexName(t *testing.T) {
+	namer := newEventTimeIndexNamer(t)
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		eventTime     time.Time
+		ok            bool
+		expected      string
+		expectedRoute string
+	}{
+		{now.Add(-time.Minute), true, "abc-2024.01.10", EventTimeRouteOnTime},
+		{now.Add(-36 * time.Hour), true, "abc-2024.01.09", EventTimeRouteOnTime},
+		{now.Add(time.Minute), true, "abc-2024.01.10", EventTimeRouteOnTime},
+		{now.Add(-72 * time.Hour), true, "abc-catch-all-2024.01.10", EventTimeRouteLate},
+		{now.Add(time.Hour), true, "abc-catch-all-2024.01.10", EventTimeRouteAhead},
+		{time.Time{}, false, "abc-catch-all-2024.01.10", EventTimeRouteInvalid},
+	}
+
+	for _, tc := range testCases {
+		index, route := namer.EventTimeIndexName("abc", tc.eventTime, tc.ok, now)
+		FatalIf(t, index != tc.expected || route != tc.expectedRoute, "%s: expected %s %s, got %s %s",
+			tc.eventTime, tc.expected, tc.expectedRoute, index, route)
+	}
+
+	FatalIf(t, namer.Prefix("abc-catch-all-2024.01.10") != "abc", "catch all index should be named after abc, got %s", namer.Prefix("abc-catch-all-2024.01.10"))
+
+	namer.EventTime = false
+	index, route := namer.EventTimeIndexName("abc", now.Add(-72*time.Hour), true, now)
+	FatalIf(t, index != "abc-2024.01.10" || route != EventTimeRouteOnTime, "index should be named at now when disabled, got %s %s", index, route)
+}
+
+func TestElasticStore_EventTime(t *testing.T) {
+	resetPrometheusMetrics()
+
+	var checked []string
+	ts := httptest.NewServer(&ELasticTestHandler{
+		CustomHandler: func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				checked = append(checked, r.URL.Path)
+				return
+			}
+			w.Write([]byte(`{}`))
+		},
+	})
+	defer ts.Close()
+
+	esConfig := NewEsConfig(IndexMethodSingleInsert, 1, time.Duration(1000), false, "").WithIndexNamer(newEventTimeIndexNamer(t))
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+
+	timber := *pb.SampleTimberProto()
+	timber.Content.Fields["@timestamp"] = &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)}}
+	FatalIfError(t, client.Store(context.Background(), timber))
+
+	timber = *pb.SampleTimberProto()
+	timber.Content.Fields["@timestamp"] = &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: "2001-01-01T00:00:00Z"}}
+	FatalIfError(t, client.Store(context.Background(), timber))
+
+	FatalIf(t, len(checked) != 2, "expected 2 indices to be checked, got %v", checked)
+	FatalIf(t, checked[0] != "/some-type-"+time.Now().Add(-24*time.Hour).UTC().Format("2006.01.02"), "late log should be stored into the index of its day, got %s", checked[0])
+	FatalIf(t, !strings.HasPrefix(checked[1], "/some-type-catch-all-"), "too late log should be stored into catch all index, got %s", checked[1])
+
+	expected := `
+		# HELP barito_consumer_event_time_route_total Number of timbers routed by event time, late, ahead and invalid ones are stored into the catch all index
+		# TYPE barito_consumer_event_time_route_total counter
+		barito_consumer_event_time_route_total{index="some-type",route="late"} 1
+		barito_consumer_event_time_route_total{index="some-type",route="on_time"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_event_time_route_total"))
+}
+
+func TestElasticStore_IndexNamer(t *testing.T) {
+	resetPrometheusMetrics()
+
+	var checked []string
+	ts := httptest.NewServer(&ELasticTestHandler{
+		CustomHandler: func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				checked = append(checked, r.URL.Path)
+				return
+			}
+			w.Write([]byte(`{"errors":false,"items":[{"index":{"_index":"some-type-` + time.Now().UTC().Format("2006.01.02.15") + `","status":201,"result":"created"}}]}`))
+		},
+	})
+	defer ts.Close()
+
+	hourly, err := ParseIndexNameRule("some-*={prefix}-{hour}@UTC")
+	FatalIfError(t, err)
+
+	esConfig := NewEsConfig(IndexMethodBulkProcessor, 1, time.Duration(1000), false, "").
+		WithIndexNamer(&IndexNamer{Default: NewDefaultIndexNamer().Default, Rules: []IndexNameRule{hourly}})
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+	defer client.Close()
+
+	before := time.Now().UTC().Format("2006.01.02.15")
+	FatalIfError(t, client.Store(context.Background(), *pb.SampleTimberProto()))
+	after := time.Now().UTC().Format("2006.01.02.15")
+
+	FatalIf(t, len(checked) != 1 || (checked[0] != "/some-type-"+before && checked[0] != "/some-type-"+after),
+		"expected hourly index to be checked, got %v", checked)
+
+	waitMetrics(t, `
+		# HELP barito_consumer_log_stored_total Number log stored to ES
+		# TYPE barito_consumer_log_stored_total counter
+		barito_consumer_log_stored_total{error="",index="some-type",result="201",status="created"} 1
+	`, "barito_consumer_log_stored_total")
+}
@@ -0,0 +1,362 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrIndexTemplate    = errkit.Error("Invalid index template")
+	ErrIndexTemplateDir = errkit.Error("Failed to load index templates")
+
+	DefaultIndexTemplatePriority = 100
+	// IndexTemplateOverridePriority is added to the priority of _default.json for the files without priority,
+	// elasticsearch rejects templates of overlapping patterns with the same priority
+	IndexTemplateOverridePriority = 10
+
+	// IndexTemplateDefaultFile holds the defaults of the other template files of the directory
+	IndexTemplateDefaultFile = "_default.json"
+	IndexTemplateNamePrefix  = "barito-"
+
+	IndexTemplateResultCreated   = "created"
+	IndexTemplateResultUpdated   = "updated"
+	IndexTemplateResultUnchanged = "unchanged"
+	IndexTemplateResultFailed    = "failed"
+)
+
+// IndexTemplateSpec is a file of the index template directory, e.g. payment.json
+//
+//	{
+//	  "index_patterns": ["payment-*"],
+//	  "priority": 110,
+//	  "shards": 3,
+//	  "replicas": 1,
+//	  "refresh_interval": "30s",
+//	  "total_fields_limit": 2000,
+//	  "settings": {"index.codec": "best_compression"},
+//	  "mappings": {"properties": {"amount": {"type": "double"}}}
+//	}
+//
+// Unset fields are taken from _default.json, the properties of the mappings and the settings are merged field by field.
+// The priority is not: it defaults to the priority of _default.json plus IndexTemplateOverridePriority.
+type IndexTemplateSpec struct {
+	IndexPatterns    []string               `json:"index_patterns"`
+	Priority         int                    `json:"priority"`
+	ComposedOf       []string               `json:"composed_of"`
+	Shards           int                    `json:"shards"`
+	Replicas         *int                   `json:"replicas"`
+	RefreshInterval  string                 `json:"refresh_interval"`
+	TotalFieldsLimit int                    `json:"total_fields_limit"`
+	Settings         map[string]interface{} `json:"settings"`
+	Mappings         map[string]interface{} `json:"mappings"`
+}
+
+// merge returns the spec with its unset fields taken from defaults
+func (s IndexTemplateSpec) merge(defaults IndexTemplateSpec) IndexTemplateSpec {
+	if s.Priority == 0 {
+		s.Priority = defaults.priority() + IndexTemplateOverridePriority
+	}
+	if s.ComposedOf == nil {
+		s.ComposedOf = defaults.ComposedOf
+	}
+	if s.Shards == 0 {
+		s.Shards = defaults.Shards
+	}
+	if s.Replicas == nil {
+		s.Replicas = defaults.Replicas
+	}
+	if s.RefreshInterval == "" {
+		s.RefreshInterval = defaults.RefreshInterval
+	}
+	if s.TotalFieldsLimit == 0 {
+		s.TotalFieldsLimit = defaults.TotalFieldsLimit
+	}
+	s.Settings = mergeMap(defaults.Settings, s.Settings)
+
+	mappings := mergeMap(defaults.Mappings, s.Mappings)
+	defaultProperties, _ := defaults.Mappings["properties"].(map[string]interface{})
+	properties, _ := s.Mappings["properties"].(map[string]interface{})
+	if defaultProperties != nil || properties != nil {
+		mappings["properties"] = mergeMap(defaultProperties, properties)
+	}
+	s.Mappings = mappings
+
+	return s
+}
+
+// priority returns the priority of the spec, DefaultIndexTemplatePriority when unset
+func (s IndexTemplateSpec) priority() int {
+	if s.Priority == 0 {
+		return DefaultIndexTemplatePriority
+	}
+	return s.Priority
+}
+
+func mergeMap(defaults, overrides map[string]interface{}) map[string]interface{} {
+	if defaults == nil && overrides == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		m[k] = v
+	}
+	for k, v := range overrides {
+		m[k] = v
+	}
+	return m
+}
+
+// template renders the composable index template of the spec
+func (s IndexTemplateSpec) template(name string) (t IndexTemplate, err error) {
+	if len(s.IndexPatterns) == 0 {
+		err = errkit.Concat(ErrIndexTemplate, fmt.Errorf("%s: index_patterns is required", name))
+		return
+	}
+
+	t = IndexTemplate{
+		Name:          name,
+		IndexPatterns: s.IndexPatterns,
+		Priority:      s.priority(),
+		ComposedOf:    s.ComposedOf,
+		Settings:      map[string]string{},
+		Mappings:      normalizeJSON(s.Mappings),
+	}
+
+	flattenSettings("", normalizeJSON(s.Settings), t.Settings)
+	if s.Shards > 0 {
+		t.Settings["index.number_of_shards"] = fmt.Sprint(s.Shards)
+	}
+	if s.Replicas != nil {
+		t.Settings["index.number_of_replicas"] = fmt.Sprint(*s.Replicas)
+	}
+	if s.RefreshInterval != "" {
+		t.Settings["index.refresh_interval"] = s.RefreshInterval
+	}
+	if s.TotalFieldsLimit > 0 {
+		t.Settings["index.mapping.total_fields.limit"] = fmt.Sprint(s.TotalFieldsLimit)
+	}
+
+	return
+}
+
+// IndexTemplate is a composable index template of regular indices kept by the consumer
+type IndexTemplate struct {
+	Name          string
+	IndexPatterns []string
+	Priority      int
+	ComposedOf    []string
+	// Settings are flattened with the index. prefix, e.g. index.number_of_shards, as returned by elasticsearch
+	Settings map[string]string
+	Mappings map[string]interface{}
+}
+
+func (t IndexTemplate) body() map[string]interface{} {
+	template := map[string]interface{}{}
+	if len(t.Settings) > 0 {
+		template["settings"] = t.Settings
+	}
+	if t.Mappings != nil {
+		template["mappings"] = t.Mappings
+	}
+
+	body := map[string]interface{}{
+		"index_patterns": t.IndexPatterns,
+		"priority":       t.Priority,
+		"template":       template,
+		"_meta":          map[string]interface{}{"description": "barito index template"},
+	}
+	if len(t.ComposedOf) > 0 {
+		body["composed_of"] = t.ComposedOf
+	}
+	return body
+}
+
+// drifted tells whether the template installed in elasticsearch differs from t, its _meta and version are ignored
+func (t IndexTemplate) drifted(installed *elastic.IndicesGetIndexTemplate) bool {
+	if installed == nil {
+		return true
+	}
+	if !stringsEqual(t.IndexPatterns, installed.IndexPatterns) || !stringsEqual(t.ComposedOf, installed.ComposedOf) || t.Priority != installed.Priority {
+		return true
+	}
+
+	settings, mappings := map[string]string{}, map[string]interface{}(nil)
+	if installed.Template != nil {
+		flattenSettings("", normalizeJSON(installed.Template.Settings), settings)
+		mappings = normalizeJSON(installed.Template.Mappings)
+	}
+	if !reflect.DeepEqual(t.Settings, settings) {
+		return true
+	}
+	return (len(t.Mappings) > 0 || len(mappings) > 0) && !reflect.DeepEqual(t.Mappings, mappings)
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenSettings flattens the nested settings into dotted keys with the index. prefix and string values,
+// so {"index":{"number_of_shards":"3"}} and {"number_of_shards":3} are the same
+func flattenSettings(prefix string, settings map[string]interface{}, flat map[string]string) {
+	for k, v := range settings {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenSettings(key, nested, flat)
+			continue
+		}
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		flat[key] = fmt.Sprint(v)
+	}
+}
+
+// normalizeJSON round trips m through json, so numbers are float64 on both sides of a comparison
+func normalizeJSON(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return m
+	}
+	var normalized map[string]interface{}
+	if err = json.Unmarshal(b, &normalized); err != nil {
+		return m
+	}
+	return normalized
+}
+
+// LoadIndexTemplates reads the *.json template files of dir, each file is rendered into the template barito-<file name>.
+// _default.json holds the defaults of the other files, it is installed too when it has index_patterns,
+// then a file with its priority is rejected since their patterns usually overlap.
+func LoadIndexTemplates(dir string) (templates []IndexTemplate, err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		err = errkit.Concat(ErrIndexTemplateDir, err)
+		return
+	}
+	sort.Strings(paths)
+
+	specs := map[string]IndexTemplateSpec{}
+	var defaults IndexTemplateSpec
+	for _, path := range paths {
+		var spec IndexTemplateSpec
+		if spec, err = readIndexTemplateSpec(path); err != nil {
+			return
+		}
+		if filepath.Base(path) == IndexTemplateDefaultFile {
+			defaults = spec
+			continue
+		}
+		specs[path] = spec
+	}
+
+	if len(defaults.IndexPatterns) > 0 {
+		var t IndexTemplate
+		if t, err = defaults.template(indexTemplateName(IndexTemplateDefaultFile)); err != nil {
+			return
+		}
+		templates = append(templates, t)
+	}
+
+	for _, path := range paths {
+		spec, ok := specs[path]
+		if !ok {
+			continue
+		}
+		var t IndexTemplate
+		if t, err = spec.merge(defaults).template(indexTemplateName(filepath.Base(path))); err != nil {
+			return
+		}
+		if len(defaults.IndexPatterns) > 0 && t.Priority == defaults.priority() {
+			err = errkit.Concat(ErrIndexTemplate, fmt.Errorf("%s: priority %d is the priority of %s", t.Name, t.Priority, IndexTemplateDefaultFile))
+			return
+		}
+		templates = append(templates, t)
+	}
+
+	return
+}
+
+func readIndexTemplateSpec(path string) (spec IndexTemplateSpec, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		err = errkit.Concat(ErrIndexTemplateDir, err)
+		return
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(b)))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&spec); err != nil {
+		err = errkit.Concat(ErrIndexTemplate, fmt.Errorf("%s: %w", filepath.Base(path), err))
+	}
+	return
+}
+
+// indexTemplateName names the template of a file, e.g. barito-payment for payment.json and barito-default for _default.json
+func indexTemplateName(file string) string {
+	return IndexTemplateNamePrefix + strings.TrimPrefix(strings.TrimSuffix(file, ".json"), "_")
+}
+
+// EnsureIndexTemplates installs the index templates which are missing or drifted from their file, e.g. edited by hand.
+// Every template is tried, the error of the last failed one is returned.
+func (e *elasticClient) EnsureIndexTemplates(ctx context.Context) (err error) {
+	if len(e.indexTemplates) > 0 && (e.useDataStream || e.useRollover) {
+		log.Warnf("Index templates are ignored, they apply to regular indices only")
+		return
+	}
+
+	for _, t := range e.indexTemplates {
+		result, ensureErr := e.ensureIndexTemplate(ctx, t)
+		prome.IncreaseConsumerIndexTemplate(t.Name, result)
+		if ensureErr != nil {
+			log.Errorf("Error ensuring index template %s: %s", t.Name, ensureErr)
+			err = ensureErr
+		}
+	}
+	return
+}
+
+func (e *elasticClient) ensureIndexTemplate(ctx context.Context, t IndexTemplate) (result string, err error) {
+	result = IndexTemplateResultUpdated
+
+	installed, err := e.backend.GetIndexTemplate(ctx, t.Name)
+	if elastic.IsNotFound(err) {
+		result = IndexTemplateResultCreated
+	} else if err != nil {
+		return IndexTemplateResultFailed, err
+	} else if !t.drifted(installed) {
+		return IndexTemplateResultUnchanged, nil
+	}
+
+	if result == IndexTemplateResultUpdated {
+		log.Warnf("ES index template '%s' has drifted, updating it", t.Name)
+	}
+	if err = e.backend.PutIndexTemplate(ctx, t.Name, t.body(), false); err != nil {
+		return IndexTemplateResultFailed, err
+	}
+	return
+}
@@ -0,0 +1,185 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func writeIndexTemplateFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		FatalIfError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestLoadIndexTemplates(t *testing.T) {
+	dir := writeIndexTemplateFiles(t, map[string]string{
+		"_default.json": `{
+			"index_patterns": ["*-20*"],
+			"shards": 1,
+			"replicas": 1,
+			"refresh_interval": "5s",
+			"total_fields_limit": 1000,
+			"mappings": {"dynamic": true, "properties": {"@timestamp": {"type": "date"}}}
+		}`,
+		"payment.json": `{
+			"index_patterns": ["payment-*"],
+			"priority": 110,
+			"shards": 3,
+			"replicas": 0,
+			"settings": {"index": {"codec": "best_compression"}},
+			"mappings": {"properties": {"amount": {"type": "double"}}}
+		}`,
+		"README.md": `not a template`,
+	})
+
+	templates, err := LoadIndexTemplates(dir)
+	FatalIfError(t, err)
+	FatalIf(t, len(templates) != 2, "expected 2 templates, got %d", len(templates))
+
+	defaults := templates[0]
+	FatalIf(t, defaults.Name != "barito-default" || defaults.Priority != DefaultIndexTemplatePriority, "wrong default template %+v", defaults)
+
+	payment := templates[1]
+	FatalIf(t, payment.Name != "barito-payment" || payment.Priority != 110, "wrong payment template %+v", payment)
+	expectedSettings := map[string]string{
+		"index.number_of_shards":           "3",
+		"index.number_of_replicas":         "0",
+		"index.refresh_interval":           "5s",
+		"index.mapping.total_fields.limit": "1000",
+		"index.codec":                      "best_compression",
+	}
+	FatalIf(t, !reflect.DeepEqual(payment.Settings, expectedSettings), "expected settings %v, got %v", expectedSettings, payment.Settings)
+
+	expectedMappings := normalizeJSON(map[string]interface{}{
+		"dynamic": true,
+		"properties": map[string]interface{}{
+			"@timestamp": map[string]interface{}{"type": "date"},
+			"amount":     map[string]interface{}{"type": "double"},
+		},
+	})
+	FatalIf(t, !reflect.DeepEqual(payment.Mappings, expectedMappings), "expected mappings %v, got %v", expectedMappings, payment.Mappings)
+}
+
+func TestLoadIndexTemplates_OverridePriority(t *testing.T) {
+	dir := writeIndexTemplateFiles(t, map[string]string{
+		"_default.json": `{"index_patterns": ["*-20*"], "priority": 200}`,
+		"payment.json":  `{"index_patterns": ["payment-*"]}`,
+	})
+
+	templates, err := LoadIndexTemplates(dir)
+	FatalIfError(t, err)
+	FatalIf(t, templates[0].Priority != 200, "wrong default priority %d", templates[0].Priority)
+	FatalIf(t, templates[1].Priority != 200+IndexTemplateOverridePriority, "file without priority should be above the default, got %d", templates[1].Priority)
+
+	dir = writeIndexTemplateFiles(t, map[string]string{
+		"_default.json": `{"index_patterns": ["*-20*"]}`,
+		"payment.json":  `{"index_patterns": ["payment-*"]}`,
+	})
+
+	templates, err = LoadIndexTemplates(dir)
+	FatalIfError(t, err)
+	FatalIf(t, templates[0].Priority != DefaultIndexTemplatePriority || templates[1].Priority != DefaultIndexTemplatePriority+IndexTemplateOverridePriority,
+		"wrong priorities %d and %d", templates[0].Priority, templates[1].Priority)
+
+	dir = writeIndexTemplateFiles(t, map[string]string{
+		"_default.json": `{"index_patterns": ["*-20*"]}`,
+		"payment.json":  `{"index_patterns": ["payment-*"], "priority": 100}`,
+	})
+
+	_, err = LoadIndexTemplates(dir)
+	FatalIfWrongError(t, err, string(ErrIndexTemplate)+": barito-payment: priority 100 is the priority of _default.json")
+}
+
+func TestLoadIndexTemplates_Invalid(t *testing.T) {
+	dir := writeIndexTemplateFiles(t, map[string]string{"payment.json": `{"shards": 3}`})
+	_, err := LoadIndexTemplates(dir)
+	FatalIfWrongError(t, err, string(ErrIndexTemplate)+": barito-payment: index_patterns is required")
+
+	dir = writeIndexTemplateFiles(t, map[string]string{"payment.json": `{"index_patterns": ["payment-*"], "shard": 3}`})
+	_, err = LoadIndexTemplates(dir)
+	FatalIf(t, err == nil || !strings.HasPrefix(err.Error(), string(ErrIndexTemplate)+": payment.json"), "expected unknown field error, got %v", err)
+}
+
+func TestElasticClient_EnsureIndexTemplates(t *testing.T) {
+	resetPrometheusMetrics()
+
+	installed := `{"index_templates":[{"name":"%s","index_template":{"index_patterns":["%s-*"],"priority":100,
+		"template":{"settings":{"index":{"number_of_shards":"%s","mapping":{"total_fields":{"limit":"2000"}}}},
+		"mappings":{"properties":{"amount":{"type":"double"}}}},
+		"_meta":{"description":"barito index template"}}}]}`
+
+	ts := httptest.NewServer(&esTestServer{bodies: map[string]string{}, responses: map[string]func(w http.ResponseWriter){
+		"GET /_index_template/barito-missing": respondWith(http.StatusNotFound, `{"error":{"type":"resource_not_found_exception","reason":"index template matching [barito-missing] not found"},"status":404}`),
+		"GET /_index_template/barito-kept":    respondWith(http.StatusOK, fmt.Sprintf(installed, "barito-kept", "kept", "2")),
+		"GET /_index_template/barito-drifted": respondWith(http.StatusOK, fmt.Sprintf(installed, "barito-drifted", "drifted", "1")),
+	}})
+	defer ts.Close()
+
+	var templates []IndexTemplate
+	for _, name := range []string{"missing", "kept", "drifted"} {
+		template, err := IndexTemplateSpec{
+			IndexPatterns:    []string{name + "-*"},
+			Shards:           2,
+			TotalFieldsLimit: 2000,
+			Mappings:         map[string]interface{}{"properties": map[string]interface{}{"amount": map[string]interface{}{"type": "double"}}},
+		}.template("barito-" + name)
+		FatalIfError(t, err)
+		templates = append(templates, template)
+	}
+
+	esConfig := NewEsConfig(IndexMethodSingleInsert, 1, time.Duration(1000), false, "").WithIndexTemplates(templates)
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+
+	FatalIfError(t, client.EnsureIndexTemplates(context.Background()))
+
+	expected := `
+		# HELP barito_consumer_index_template_total Number of index templates checked at startup by result, updated ones had drifted from their template file
+		# TYPE barito_consumer_index_template_total counter
+		barito_consumer_index_template_total{result="created",template="barito-missing"} 1
+		barito_consumer_index_template_total{result="unchanged",template="barito-kept"} 1
+		barito_consumer_index_template_total{result="updated",template="barito-drifted"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_index_template_total"))
+}
+
+func TestElasticClient_EnsureIndexTemplatesError(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ts := httptest.NewServer(&esTestServer{bodies: map[string]string{}, responses: map[string]func(w http.ResponseWriter){
+		"GET /_index_template/barito-payment": respondWith(http.StatusNotFound, `{"status":404}`),
+		"PUT /_index_template/barito-payment": respondWith(http.StatusBadRequest, `{"error":{"type":"illegal_argument_exception","reason":"unknown setting [index.shard]"},"status":400}`),
+	}})
+	defer ts.Close()
+
+	template, err := IndexTemplateSpec{IndexPatterns: []string{"payment-*"}}.template("barito-payment")
+	FatalIfError(t, err)
+
+	esConfig := NewEsConfig(IndexMethodSingleInsert, 1, time.Duration(1000), false, "").WithIndexTemplates([]IndexTemplate{template})
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+
+	err = client.EnsureIndexTemplates(context.Background())
+	FatalIf(t, err == nil, "expected error installing the template")
+
+	expected := `
+		# HELP barito_consumer_index_template_total Number of index templates checked at startup by result, updated ones had drifted from their template file
+		# TYPE barito_consumer_index_template_total counter
+		barito_consumer_index_template_total{result="failed",template="barito-payment"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_index_template_total"))
+}
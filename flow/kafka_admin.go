@@ -75,6 +75,11 @@ func (a *kafkaAdmin) AddTopic(topic string) {
 	a.topics = append(a.topics, topic)
 }
 
+// Ping fetches the metadata from the brokers, it fails when the cluster is unreachable
+func (a *kafkaAdmin) Ping() error {
+	return a.client.RefreshMetadata()
+}
+
 func (a *kafkaAdmin) Close() {
 	a.client.Close()
 }
@@ -1,9 +1,11 @@
 package flow
 
 import (
+	"sort"
+	"time"
+
 	"github.com/BaritoLog/barito-flow/flow/types"
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 )
 
 type kafkaFactory struct {
@@ -32,18 +34,62 @@ func (f kafkaFactory) MakeKafkaAdmin() (admin types.KafkaAdmin, err error) {
 	return
 }
 
-func (f kafkaFactory) MakeClusterConsumer(groupID, topic string, initialOffset int64) (consumer types.ClusterConsumer, err error) {
+func (f kafkaFactory) MakeGroupConsumer(groupID, topic string, initialOffset int64) (consumer types.GroupConsumer, err error) {
 
 	config := *f.config
 	config.Consumer.Offsets.Initial = initialOffset
+	config.Consumer.Return.Errors = true
 
-	clusterConfig := cluster.NewConfig()
-	clusterConfig.Config = config
-	clusterConfig.Consumer.Return.Errors = true
-	clusterConfig.Group.Return.Notifications = true
+	group, err := sarama.NewConsumerGroup(f.brokers, groupID, &config)
+	if err != nil {
+		return nil, err
+	}
 
 	topics := []string{topic}
-	consumer, err = cluster.NewConsumer(f.brokers, groupID, topics, clusterConfig)
+	consumer = newGroupConsumer(group, topics, config.Consumer.Retry.Backoff)
+	return
+}
+
+func (f kafkaFactory) MakeSubscriptionConsumer(groupID string, match func(topic string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (consumer types.GroupConsumer, err error) {
+
+	config := *f.config
+	config.Consumer.Offsets.Initial = initialOffset
+	config.Consumer.Return.Errors = true
+
+	client, err := sarama.NewClient(f.brokers, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	subscription := func() (topics []string, err error) {
+		if err = client.RefreshMetadata(); err != nil {
+			return
+		}
+		all, err := client.Topics()
+		if err != nil {
+			return
+		}
+		for _, topic := range all {
+			if match(topic) {
+				topics = append(topics, topic)
+			}
+		}
+		sort.Strings(topics)
+		return
+	}
+
+	consumer, err = newSubscriptionGroupConsumer(&clientConsumerGroup{ConsumerGroup: group, client: client}, subscription, refreshInterval, subscriptionDelay, config.Consumer.Retry.Backoff)
+	if err != nil {
+		group.Close()
+		client.Close()
+		return nil, err
+	}
 	return
 }
 
@@ -52,6 +98,20 @@ func (f kafkaFactory) MakeSyncProducer() (producer sarama.SyncProducer, err erro
 	return
 }
 
-func (f kafkaFactory) MakeConsumerWorker(name string, consumer types.ClusterConsumer) types.ConsumerWorker {
+func (f kafkaFactory) MakeConsumerWorker(name string, consumer types.GroupConsumer) types.ConsumerWorker {
 	return NewConsumerWorker(name, consumer)
 }
+
+// clientConsumerGroup closes the client of the consumer group along with it
+type clientConsumerGroup struct {
+	sarama.ConsumerGroup
+	client sarama.Client
+}
+
+func (g *clientConsumerGroup) Close() error {
+	err := g.ConsumerGroup.Close()
+	if clientErr := g.client.Close(); err == nil {
+		err = clientErr
+	}
+	return err
+}
@@ -0,0 +1,315 @@
+package flow
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrKafkaFailoverThreshold = errkit.Error("Kafka failover and failback thresholds must be at least 1")
+
+	KafkaClusterPrimary = "primary"
+	KafkaClusterStandby = "standby"
+)
+
+// KafkaFailoverConfig enables failover of the producer into the standby kafka cluster.
+// Writes and topic creation fail over when ErrorThreshold consecutive writes or health checks of the active cluster failed,
+// and fail back when the primary cluster passed FailbackThreshold consecutive health checks.
+type KafkaFailoverConfig struct {
+	Standby             types.KafkaFactory
+	ErrorThreshold      int
+	FailbackThreshold   int
+	HealthCheckInterval time.Duration
+}
+
+// Validate returns an error when a threshold is below 1, such threshold would switch the cluster without any failure or healthy check
+func (c KafkaFailoverConfig) Validate() error {
+	if c.ErrorThreshold < 1 || c.FailbackThreshold < 1 {
+		return ErrKafkaFailoverThreshold
+	}
+	return nil
+}
+
+type kafkaCluster struct {
+	name     string
+	factory  types.KafkaFactory
+	producer sarama.SyncProducer
+	admin    types.KafkaAdmin
+
+	errors    int // consecutive failed writes or health checks
+	successes int // consecutive healthy checks
+}
+
+func (c *kafkaCluster) connected() bool {
+	return c.producer != nil && c.admin != nil
+}
+
+// kafkaFailover holds the primary and standby kafka cluster, the producer writes into the active one
+type kafkaFailover struct {
+	config   KafkaFailoverConfig
+	clusters [2]*kafkaCluster
+
+	mu     sync.Mutex
+	active int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newKafkaFailover(config KafkaFailoverConfig, primary types.KafkaFactory) *kafkaFailover {
+	return &kafkaFailover{
+		config: config,
+		clusters: [2]*kafkaCluster{
+			{name: KafkaClusterPrimary, factory: primary},
+			{name: KafkaClusterStandby, factory: config.Standby},
+		},
+		stop: make(chan struct{}),
+	}
+}
+
+// Connect connects the primary cluster, or the standby cluster when the primary is unreachable so the producer starts on it.
+// The other cluster is connected by the health checks, so the producer fails back once the primary is healthy.
+func (f *kafkaFailover) Connect() error {
+	primary, standby := f.clusters[0], f.clusters[1]
+
+	err := f.connect(primary)
+	if err == nil {
+		prome.SetProducerKafkaActiveCluster(primary.name, standby.name)
+		return nil
+	}
+	log.Warnf("Cannot connect to kafka %s cluster: %s, connecting to %s cluster", primary.name, err, standby.name)
+
+	if standbyErr := f.connect(standby); standbyErr != nil {
+		return errkit.Concat(err, standbyErr)
+	}
+
+	f.mu.Lock()
+	f.active = 1
+	f.mu.Unlock()
+	log.Warnf("Kafka producer starts on %s cluster", standby.name)
+	prome.SetProducerKafkaActiveCluster(standby.name, primary.name)
+	return nil
+}
+
+// Start starts the health checks once a cluster is connected
+func (f *kafkaFailover) Start() {
+	f.wg.Add(1)
+	go f.loop()
+}
+
+func (f *kafkaFailover) Stop() {
+	close(f.stop)
+	f.wg.Wait()
+}
+
+func (f *kafkaFailover) loop() {
+	defer f.wg.Done()
+
+	f.healthCheck()
+
+	ticker := time.NewTicker(f.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.healthCheck()
+		}
+	}
+}
+
+func (f *kafkaFailover) healthCheck() {
+	for _, c := range f.clusters {
+		f.mu.Lock()
+		connected := c.connected()
+		admin := c.admin
+		f.mu.Unlock()
+
+		var err error
+		if connected {
+			err = admin.Ping()
+		} else {
+			err = f.connect(c)
+		}
+		if err != nil {
+			log.Warnf("Kafka %s cluster is unhealthy: %s", c.name, err)
+		}
+		f.report(c, err, true)
+	}
+}
+
+func (f *kafkaFailover) connect(c *kafkaCluster) (err error) {
+	admin, err := c.factory.MakeKafkaAdmin()
+	if err != nil {
+		return
+	}
+	producer, err := c.factory.MakeSyncProducer()
+	if err != nil {
+		admin.Close()
+		return
+	}
+
+	f.mu.Lock()
+	c.admin, c.producer = admin, producer
+	f.mu.Unlock()
+	return
+}
+
+// current returns the active cluster
+func (f *kafkaFailover) current() *kafkaCluster {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.clusters[f.active]
+}
+
+// factory returns the factory of the active cluster, e.g. to consume the topics checked by the failover admin
+func (f *kafkaFailover) factory() types.KafkaFactory {
+	return f.current().factory
+}
+
+// report counts the result of a write or health check of c, and switches the active cluster when a threshold is crossed
+func (f *kafkaFailover) report(c *kafkaCluster, err error, healthCheck bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if kafkaClusterFailure(err) {
+		c.errors++
+		c.successes = 0
+	} else {
+		c.errors = 0
+		if healthCheck {
+			c.successes++
+		}
+	}
+
+	active, other := f.clusters[f.active], f.clusters[1-f.active]
+	switch {
+	case c == active && c.errors >= f.config.ErrorThreshold && other.connected() && other.errors == 0:
+		f.switchTo(1 - f.active)
+	case c == f.clusters[0] && active != c && c.successes >= f.config.FailbackThreshold:
+		f.switchTo(0)
+	}
+}
+
+// switchTo makes the cluster of index active, caller must hold the lock
+func (f *kafkaFailover) switchTo(index int) {
+	from, to := f.clusters[f.active], f.clusters[index]
+	f.active = index
+	from.successes, to.errors = 0, 0
+
+	log.Warnf("Kafka producer fails over from %s to %s cluster", from.name, to.name)
+	prome.SetProducerKafkaActiveCluster(to.name, from.name)
+	prome.IncreaseProducerKafkaFailover(to.name)
+}
+
+// kafkaClusterFailure returns false when err is nil or caused by the request itself, only cluster failures count toward failover
+func kafkaClusterFailure(err error) bool {
+	var topicErr *sarama.TopicError
+	if errors.As(err, &topicErr) {
+		err = topicErr.Err
+	}
+
+	switch {
+	case err == nil,
+		errors.Is(err, sarama.ErrTopicAlreadyExists),
+		errors.Is(err, sarama.ErrInvalidTopic),
+		errors.Is(err, sarama.ErrMessageSizeTooLarge),
+		errors.Is(err, sarama.ErrInvalidMessage):
+		return false
+	}
+	return true
+}
+
+func (f *kafkaFailover) close() {
+	for _, c := range f.clusters {
+		if c.producer != nil {
+			c.producer.Close()
+		}
+		if c.admin != nil {
+			c.admin.Close()
+		}
+	}
+}
+
+// failoverProducer writes into the active cluster, a failed write is retried once when it made the producer fail over
+type failoverProducer struct {
+	failover *kafkaFailover
+}
+
+func (p *failoverProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	c := p.failover.current()
+	partition, offset, err = c.producer.SendMessage(msg)
+	p.failover.report(c, err, false)
+
+	if next := p.failover.current(); err != nil && next != c {
+		partition, offset, err = next.producer.SendMessage(msg)
+		p.failover.report(next, err, false)
+	}
+	return
+}
+
+func (p *failoverProducer) SendMessages(msgs []*sarama.ProducerMessage) (err error) {
+	c := p.failover.current()
+	err = c.producer.SendMessages(msgs)
+	p.failover.report(c, err, false)
+
+	if next := p.failover.current(); err != nil && next != c {
+		err = next.producer.SendMessages(msgs)
+		p.failover.report(next, err, false)
+	}
+	return
+}
+
+// Close closes the connections of both clusters
+func (p *failoverProducer) Close() error {
+	p.failover.close()
+	return nil
+}
+
+// failoverAdmin manages the topics of the active cluster
+type failoverAdmin struct {
+	failover *kafkaFailover
+}
+
+func (a *failoverAdmin) RefreshTopics() error {
+	return a.failover.current().admin.RefreshTopics()
+}
+
+func (a *failoverAdmin) SetTopics(topics []string) {
+	a.failover.current().admin.SetTopics(topics)
+}
+
+func (a *failoverAdmin) Topics() []string {
+	return a.failover.current().admin.Topics()
+}
+
+func (a *failoverAdmin) AddTopic(topic string) {
+	a.failover.current().admin.AddTopic(topic)
+}
+
+func (a *failoverAdmin) Exist(topic string) bool {
+	return a.failover.current().admin.Exist(topic)
+}
+
+func (a *failoverAdmin) CreateTopic(topic string, numPartitions int32, replicationFactor int16) (err error) {
+	c := a.failover.current()
+	err = c.admin.CreateTopic(topic, numPartitions, replicationFactor)
+	a.failover.report(c, err, false)
+	return
+}
+
+func (a *failoverAdmin) Ping() error {
+	return a.failover.current().admin.Ping()
+}
+
+// Close is a no-op, the connections are closed by the producer
+func (a *failoverAdmin) Close() {}
@@ -0,0 +1,196 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	"github.com/BaritoLog/barito-flow/prome"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newKafkaFailoverTest(ctrl *gomock.Controller, standby *mock.MockKafkaFactory) (*kafkaFailover, *mock.MockSyncProducer, *mock.MockKafkaAdmin) {
+	primaryProducer := mock.NewMockSyncProducer(ctrl)
+	primaryAdmin := mock.NewMockKafkaAdmin(ctrl)
+
+	f := newKafkaFailover(KafkaFailoverConfig{
+		Standby:           standby,
+		ErrorThreshold:    2,
+		FailbackThreshold: 2,
+	}, mock.NewMockKafkaFactory(ctrl))
+	f.clusters[0].producer, f.clusters[0].admin = primaryProducer, primaryAdmin
+	prome.SetProducerKafkaActiveCluster(KafkaClusterPrimary, KafkaClusterStandby)
+
+	return f, primaryProducer, primaryAdmin
+}
+
+func TestKafkaFailover_FailoverAndFailback(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	standbyProducer := mock.NewMockSyncProducer(ctrl)
+	standbyAdmin := mock.NewMockKafkaAdmin(ctrl)
+	standby := mock.NewMockKafkaFactory(ctrl)
+	standby.EXPECT().MakeKafkaAdmin().Return(standbyAdmin, nil)
+	standby.EXPECT().MakeSyncProducer().Return(standbyProducer, nil)
+
+	f, primaryProducer, primaryAdmin := newKafkaFailoverTest(ctrl, standby)
+	producer, admin := &failoverProducer{failover: f}, &failoverAdmin{failover: f}
+
+	primaryAdmin.EXPECT().Ping().Times(3).Return(nil)
+	f.healthCheck()
+
+	primaryProducer.EXPECT().SendMessage(gomock.Any()).Times(2).Return(int32(0), int64(0), sarama.ErrOutOfBrokers)
+	standbyProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(1), int64(42), nil)
+
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: "some_topic"})
+	FatalIfWrongError(t, err, sarama.ErrOutOfBrokers.Error())
+	FatalIf(t, f.current().name != KafkaClusterPrimary, "should stay on primary below the error threshold")
+
+	partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{Topic: "some_topic"})
+	FatalIfError(t, err)
+	FatalIf(t, partition != 1 || offset != 42, "write should be retried on standby")
+	FatalIf(t, f.current().name != KafkaClusterStandby, "should fail over to standby")
+
+	standbyAdmin.EXPECT().Exist("new_topic").Return(false)
+	standbyAdmin.EXPECT().CreateTopic("new_topic", int32(3), int16(1)).Return(nil)
+	FatalIf(t, admin.Exist("new_topic"), "topic should be checked on standby")
+	FatalIfError(t, admin.CreateTopic("new_topic", 3, 1))
+
+	standbyAdmin.EXPECT().Ping().Times(2).Return(nil)
+	f.healthCheck()
+	FatalIf(t, f.current().name != KafkaClusterStandby, "should stay on standby below the failback threshold")
+	f.healthCheck()
+	FatalIf(t, f.current().name != KafkaClusterPrimary, "should fail back to primary")
+
+	expected := `
+		# HELP barito_producer_kafka_active_cluster Kafka cluster the producer writes into, 1 when active
+		# TYPE barito_producer_kafka_active_cluster gauge
+		barito_producer_kafka_active_cluster{cluster="primary"} 1
+		barito_producer_kafka_active_cluster{cluster="standby"} 0
+		# HELP barito_producer_kafka_failover_total Number of times the producer switched its active kafka cluster
+		# TYPE barito_producer_kafka_failover_total counter
+		barito_producer_kafka_failover_total{cluster="primary"} 1
+		barito_producer_kafka_failover_total{cluster="standby"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected),
+		"barito_producer_kafka_active_cluster", "barito_producer_kafka_failover_total"))
+}
+
+func TestKafkaFailover_StandbyUnavailable(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	standby := mock.NewMockKafkaFactory(ctrl)
+	standby.EXPECT().MakeKafkaAdmin().Return(nil, fmt.Errorf("some-error"))
+
+	f, primaryProducer, primaryAdmin := newKafkaFailoverTest(ctrl, standby)
+	producer := &failoverProducer{failover: f}
+
+	primaryAdmin.EXPECT().Ping().Return(sarama.ErrOutOfBrokers)
+	f.healthCheck()
+
+	primaryProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), sarama.ErrOutOfBrokers)
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: "some_topic"})
+	FatalIfWrongError(t, err, sarama.ErrOutOfBrokers.Error())
+	FatalIf(t, f.current().name != KafkaClusterPrimary, "should not fail over to unavailable standby")
+}
+
+func TestKafkaFailover_StartOnStandby(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	primaryProducer := mock.NewMockSyncProducer(ctrl)
+	primaryAdmin := mock.NewMockKafkaAdmin(ctrl)
+	primary := mock.NewMockKafkaFactory(ctrl)
+	gomock.InOrder(
+		primary.EXPECT().MakeKafkaAdmin().Return(nil, sarama.ErrOutOfBrokers),
+		primary.EXPECT().MakeKafkaAdmin().Return(primaryAdmin, nil),
+	)
+	primary.EXPECT().MakeSyncProducer().Return(primaryProducer, nil)
+
+	standbyProducer := mock.NewMockSyncProducer(ctrl)
+	standbyAdmin := mock.NewMockKafkaAdmin(ctrl)
+	standby := mock.NewMockKafkaFactory(ctrl)
+	standby.EXPECT().MakeKafkaAdmin().Return(standbyAdmin, nil)
+	standby.EXPECT().MakeSyncProducer().Return(standbyProducer, nil)
+
+	f := newKafkaFailover(KafkaFailoverConfig{Standby: standby, ErrorThreshold: 2, FailbackThreshold: 2}, primary)
+	FatalIfError(t, f.Connect())
+	FatalIf(t, f.current().name != KafkaClusterStandby, "should start on standby when primary is unreachable")
+
+	standbyProducer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(1), nil)
+	_, _, err := (&failoverProducer{failover: f}).SendMessage(&sarama.ProducerMessage{Topic: "some_topic"})
+	FatalIfError(t, err)
+
+	// the health checks connect the primary and fail back once it is healthy
+	primaryAdmin.EXPECT().Ping().Return(nil)
+	standbyAdmin.EXPECT().Ping().Times(2).Return(nil)
+	f.healthCheck()
+	FatalIf(t, f.current().name != KafkaClusterStandby, "should stay on standby below the failback threshold")
+	f.healthCheck()
+	FatalIf(t, f.current().name != KafkaClusterPrimary, "should fail back to primary")
+}
+
+func TestKafkaFailover_ConnectError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	primary := mock.NewMockKafkaFactory(ctrl)
+	primary.EXPECT().MakeKafkaAdmin().Return(nil, fmt.Errorf("primary-error"))
+	standby := mock.NewMockKafkaFactory(ctrl)
+	standby.EXPECT().MakeKafkaAdmin().Return(nil, fmt.Errorf("standby-error"))
+
+	f := newKafkaFailover(KafkaFailoverConfig{Standby: standby, ErrorThreshold: 2, FailbackThreshold: 2}, primary)
+	FatalIfWrongError(t, f.Connect(), "primary-error: standby-error")
+}
+
+func TestKafkaFailoverConfig_Validate(t *testing.T) {
+	FatalIfError(t, KafkaFailoverConfig{ErrorThreshold: 1, FailbackThreshold: 1}.Validate())
+	FatalIfWrongError(t, KafkaFailoverConfig{ErrorThreshold: 0, FailbackThreshold: 1}.Validate(), string(ErrKafkaFailoverThreshold))
+	FatalIfWrongError(t, KafkaFailoverConfig{ErrorThreshold: 1, FailbackThreshold: -1}.Validate(), string(ErrKafkaFailoverThreshold))
+}
+
+func TestKafkaFailover_RequestError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f, primaryProducer, _ := newKafkaFailoverTest(ctrl, mock.NewMockKafkaFactory(ctrl))
+	f.clusters[1].producer, f.clusters[1].admin = mock.NewMockSyncProducer(ctrl), mock.NewMockKafkaAdmin(ctrl)
+	producer := &failoverProducer{failover: f}
+
+	primaryProducer.EXPECT().SendMessage(gomock.Any()).Times(3).Return(int32(0), int64(0), sarama.ErrMessageSizeTooLarge)
+	for i := 0; i < 3; i++ {
+		producer.SendMessage(&sarama.ProducerMessage{Topic: "some_topic"})
+	}
+	FatalIf(t, f.current().name != KafkaClusterPrimary, "request errors should not fail over")
+}
+
+func TestKafkaClusterFailure(t *testing.T) {
+	testCases := []struct {
+		err     error
+		failure bool
+	}{
+		{nil, false},
+		{sarama.ErrOutOfBrokers, true},
+		{sarama.ErrNotEnoughReplicas, true},
+		{fmt.Errorf("some-error"), true},
+		{sarama.ErrMessageSizeTooLarge, false},
+		{&sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}, false},
+	}
+
+	for _, tc := range testCases {
+		FatalIf(t, kafkaClusterFailure(tc.err) != tc.failure, "%v: expected %v", tc.err, tc.failure)
+	}
+}
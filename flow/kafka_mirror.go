@@ -0,0 +1,209 @@
+package flow
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrMirrorRule = errkit.Error("Invalid mirror rule")
+
+	MirrorResultSent        = "sent"
+	MirrorResultError       = "error"
+	MirrorResultQueueFull   = "queue_full"
+	MirrorResultUnavailable = "unavailable"
+
+	mirrorConnectInterval = 10 * time.Second
+)
+
+// MirrorRule overrides the mirrored percentage of the app traffic
+type MirrorRule struct {
+	App     string
+	Percent float64
+}
+
+// ParseMirrorRule parses "<app>=<percent>"
+func ParseMirrorRule(s string) (rule MirrorRule, err error) {
+	i := strings.LastIndex(s, "=")
+	if i < 1 || i == len(s)-1 {
+		err = errkit.Concat(ErrMirrorRule, errkit.Error(s))
+		return
+	}
+
+	rule.App = strings.TrimSpace(s[:i])
+	rule.Percent, err = strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+	if err != nil || rule.Percent < 0 || rule.Percent > 100 {
+		err = errkit.Concat(ErrMirrorRule, errkit.Error(s))
+	}
+	return
+}
+
+// MirrorConfig mirrors the records written by the producer into a secondary kafka cluster.
+// Percent of the records are mirrored unless the app has its own rule, records are dropped when the queue is full.
+type MirrorConfig struct {
+	Factory   types.KafkaFactory
+	Percent   float64
+	Rules     []MirrorRule
+	QueueSize int
+}
+
+// kafkaMirror writes copies of the producer records into the secondary cluster in background,
+// it never blocks nor fails the primary write
+type kafkaMirror struct {
+	config        MirrorConfig
+	percents      map[string]float64
+	newEventTopic string
+
+	producer    sarama.SyncProducer
+	admin       types.KafkaAdmin
+	lastConnect time.Time
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *sarama.ProducerMessage
+	wg     sync.WaitGroup
+}
+
+func newKafkaMirror(config MirrorConfig, newEventTopic string) *kafkaMirror {
+	m := &kafkaMirror{
+		config:        config,
+		percents:      make(map[string]float64),
+		newEventTopic: newEventTopic,
+		queue:         make(chan *sarama.ProducerMessage, config.QueueSize),
+	}
+	for _, rule := range config.Rules {
+		m.percents[rule.App] = rule.Percent
+	}
+	return m
+}
+
+func (m *kafkaMirror) Start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Close writes the queued records and closes the connection to the secondary cluster
+func (m *kafkaMirror) Close() {
+	m.mu.Lock()
+	m.closed = true
+	close(m.queue)
+	m.mu.Unlock()
+	m.wg.Wait()
+
+	if m.producer != nil {
+		m.producer.Close()
+	}
+	if m.admin != nil {
+		m.admin.Close()
+	}
+}
+
+// Mirror queues a copy of message when the app of message is sampled
+func (m *kafkaMirror) Mirror(message *sarama.ProducerMessage) {
+	if !m.sampled(producerMessageCarrier{message: message}.Get(AppNameHeaderKey)) {
+		return
+	}
+
+	mirrored := &sarama.ProducerMessage{
+		Topic:   message.Topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: append([]sarama.RecordHeader(nil), message.Headers...),
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return
+	}
+
+	select {
+	case m.queue <- mirrored:
+	default:
+		prome.IncreaseProducerMirrorMessage(MirrorResultQueueFull)
+	}
+}
+
+func (m *kafkaMirror) sampled(app string) bool {
+	percent, ok := m.percents[app]
+	if !ok {
+		percent = m.config.Percent
+	}
+	return percent >= 100 || rand.Float64()*100 < percent
+}
+
+func (m *kafkaMirror) run() {
+	defer m.wg.Done()
+
+	for message := range m.queue {
+		if !m.connect() {
+			prome.IncreaseProducerMirrorMessage(MirrorResultUnavailable)
+			continue
+		}
+
+		if err := m.send(message); err != nil {
+			log.Warnf("Failed to mirror record of %s: %s", message.Topic, err)
+			prome.IncreaseProducerMirrorMessage(MirrorResultError)
+			continue
+		}
+		prome.IncreaseProducerMirrorMessage(MirrorResultSent)
+	}
+}
+
+// connect returns true when the secondary cluster is connected, it retries at most once per mirrorConnectInterval
+func (m *kafkaMirror) connect() bool {
+	if m.producer != nil {
+		return true
+	}
+	if time.Since(m.lastConnect) < mirrorConnectInterval {
+		return false
+	}
+	m.lastConnect = time.Now()
+
+	admin, err := m.config.Factory.MakeKafkaAdmin()
+	if err != nil {
+		log.Warnf("Cannot connect to mirror kafka: %s", err)
+		return false
+	}
+	producer, err := m.config.Factory.MakeSyncProducer()
+	if err != nil {
+		log.Warnf("Cannot connect to mirror kafka: %s", err)
+		admin.Close()
+		return false
+	}
+
+	m.admin, m.producer = admin, producer
+	return true
+}
+
+// send writes message into the secondary cluster, the topic is created with the broker defaults when it does not exist
+func (m *kafkaMirror) send(message *sarama.ProducerMessage) (err error) {
+	if !m.admin.Exist(message.Topic) {
+		if err = m.admin.CreateTopic(message.Topic, -1, -1); err != nil {
+			return
+		}
+		m.admin.AddTopic(message.Topic)
+
+		if m.newEventTopic != "" {
+			_, _, err = m.producer.SendMessage(&sarama.ProducerMessage{
+				Topic: m.newEventTopic,
+				Value: sarama.ByteEncoder(message.Topic),
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	_, _, err = m.producer.SendMessage(message)
+	return
+}
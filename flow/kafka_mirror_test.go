@@ -0,0 +1,159 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseMirrorRule(t *testing.T) {
+	rule, err := ParseMirrorRule("some-app=12.5")
+	FatalIfError(t, err)
+	FatalIf(t, rule.App != "some-app" || rule.Percent != 12.5, "wrong rule %v", rule)
+
+	for _, s := range []string{"some-app", "some-app=", "some-app=abc", "some-app=101"} {
+		_, err = ParseMirrorRule(s)
+		FatalIfWrongError(t, err, string(ErrMirrorRule)+": "+s)
+	}
+}
+
+func TestKafkaMirror_sampled(t *testing.T) {
+	m := newKafkaMirror(MirrorConfig{
+		Percent: 100,
+		Rules: []MirrorRule{
+			{App: "muted-app", Percent: 0},
+		},
+	}, "")
+
+	FatalIf(t, !m.sampled("some-app"), "app without rule should follow the global percent")
+	FatalIf(t, m.sampled("muted-app"), "app rule should override the global percent")
+}
+
+func TestKafkaMirror_Mirror(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist("some_topic_logs").Return(false)
+	admin.EXPECT().Exist("some_topic_logs").Return(true)
+	admin.EXPECT().CreateTopic("some_topic_logs", int32(-1), int16(-1)).Return(nil)
+	admin.EXPECT().AddTopic("some_topic_logs")
+	admin.EXPECT().Close()
+
+	var topics []string
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Times(3).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		topics = append(topics, msg.Topic)
+		return 0, 0, nil
+	})
+	producer.EXPECT().Close()
+
+	factory := mock.NewMockKafkaFactory(ctrl)
+	factory.EXPECT().MakeKafkaAdmin().Return(admin, nil)
+	factory.EXPECT().MakeSyncProducer().Return(producer, nil)
+
+	m := newKafkaMirror(MirrorConfig{Factory: factory, Percent: 100, QueueSize: 10}, "new_topic_events")
+	m.Start()
+
+	message := ConvertTimberToKafkaMessage(pb.SampleTimberProto(), "some_topic_logs")
+	m.Mirror(message)
+	m.Mirror(message)
+	m.Close()
+
+	expectedTopics := "new_topic_events,some_topic_logs,some_topic_logs"
+	FatalIf(t, strings.Join(topics, ",") != expectedTopics, "expected %s, got %v", expectedTopics, topics)
+
+	expected := `
+		# HELP barito_producer_mirror_message_total Number of records mirrored into the secondary kafka cluster
+		# TYPE barito_producer_mirror_message_total counter
+		barito_producer_mirror_message_total{result="sent"} 2
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_mirror_message_total"))
+}
+
+func TestKafkaMirror_Mirror_Unavailable(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	factory := mock.NewMockKafkaFactory(ctrl)
+	factory.EXPECT().MakeKafkaAdmin().Return(nil, fmt.Errorf("some-error"))
+
+	m := newKafkaMirror(MirrorConfig{Factory: factory, Percent: 100, QueueSize: 10}, "")
+	m.Start()
+
+	message := ConvertTimberToKafkaMessage(pb.SampleTimberProto(), "some_topic_logs")
+	m.Mirror(message)
+	m.Mirror(message)
+	m.Close()
+
+	expected := `
+		# HELP barito_producer_mirror_message_total Number of records mirrored into the secondary kafka cluster
+		# TYPE barito_producer_mirror_message_total counter
+		barito_producer_mirror_message_total{result="unavailable"} 2
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_mirror_message_total"))
+}
+
+func TestKafkaMirror_Mirror_QueueFull(t *testing.T) {
+	resetPrometheusMetrics()
+
+	m := newKafkaMirror(MirrorConfig{Percent: 100, QueueSize: 1}, "")
+
+	message := ConvertTimberToKafkaMessage(pb.SampleTimberProto(), "some_topic_logs")
+	m.Mirror(message)
+	m.Mirror(message)
+	FatalIf(t, len(m.queue) != 1, "expected 1 queued record, got %d", len(m.queue))
+
+	expected := `
+		# HELP barito_producer_mirror_message_total Number of records mirrored into the secondary kafka cluster
+		# TYPE barito_producer_mirror_message_total counter
+		barito_producer_mirror_message_total{result="queue_full"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_mirror_message_total"))
+}
+
+func TestProducerService_Produce_Mirror(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), nil)
+	producer.EXPECT().SendMessage(gomock.Any()).Return(int32(0), int64(0), fmt.Errorf("some-error"))
+
+	srv := &producerService{
+		producer:    producer,
+		topicSuffix: "_logs",
+		admin:       admin,
+		limiter:     NewDummyRateLimiter(),
+		mirror:      newKafkaMirror(MirrorConfig{Percent: 100, QueueSize: 10}, ""),
+	}
+
+	_, err := srv.Produce(context.Background(), pb.SampleTimberProto())
+	FatalIfError(t, err)
+	FatalIf(t, len(srv.mirror.queue) != 1, "written record should be mirrored")
+
+	_, err = srv.Produce(context.Background(), pb.SampleTimberProto())
+	FatalIf(t, err == nil, "primary error should be returned")
+	FatalIf(t, len(srv.mirror.queue) != 1, "failed record should not be mirrored")
+
+	mirrored := <-srv.mirror.queue
+	FatalIf(t, mirrored.Topic != "some_topic_logs", "wrong topic %s", mirrored.Topic)
+}
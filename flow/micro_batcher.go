@@ -0,0 +1,153 @@
+package flow
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/protobuf/proto"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	MicroBatchFlushReasonWindow = "window"
+	MicroBatchFlushReasonItems  = "items"
+	MicroBatchFlushReasonBytes  = "bytes"
+	MicroBatchFlushReasonClose  = "close"
+)
+
+// MicroBatchConfig controls how unary Produce calls are coalesced into one TimberCollection record.
+// Batching is disabled when Window is zero.
+type MicroBatchConfig struct {
+	Window   time.Duration
+	MaxItems int
+	MaxBytes int
+}
+
+type microBatchFlushFunc func(ctx context.Context, timberCollection *pb.TimberCollection, topic string) error
+
+type microBatch struct {
+	topic      string
+	collection *pb.TimberCollection
+	bytes      int
+	waiters    []chan error
+	links      []trace.Link
+	timer      *time.Timer
+}
+
+// microBatcher collects timbers of the same topic and context,
+// and writes them as a single TimberCollection when the window elapsed or the batch is full
+type microBatcher struct {
+	config    MicroBatchConfig
+	flushFunc microBatchFlushFunc
+
+	mu      sync.Mutex
+	batches map[string]*microBatch
+}
+
+func newMicroBatcher(config MicroBatchConfig, flushFunc microBatchFlushFunc) *microBatcher {
+	return &microBatcher{
+		config:    config,
+		flushFunc: flushFunc,
+		batches:   make(map[string]*microBatch),
+	}
+}
+
+// Add puts timber into the batch of its topic and blocks until the batch is written,
+// the flush span of the batch is linked to the span of ctx
+func (b *microBatcher) Add(ctx context.Context, topic string, timber *pb.Timber) error {
+	done := make(chan error, 1)
+	size := proto.Size(timber)
+	key := microBatchKey(topic, timber.GetContext())
+
+	b.mu.Lock()
+	batch, ok := b.batches[key]
+	if ok && b.config.MaxBytes > 0 && batch.bytes+size > b.config.MaxBytes {
+		b.detach(key, batch)
+		go b.flush(batch, MicroBatchFlushReasonBytes)
+		ok = false
+	}
+	if !ok {
+		batch = &microBatch{
+			topic: topic,
+			collection: &pb.TimberCollection{
+				Context: timber.GetContext(),
+			},
+		}
+		batch.timer = time.AfterFunc(b.config.Window, func() {
+			if b.detachIfCurrent(key, batch) {
+				b.flush(batch, MicroBatchFlushReasonWindow)
+			}
+		})
+		b.batches[key] = batch
+	}
+
+	batch.collection.Items = append(batch.collection.Items, timber)
+	batch.bytes += size
+	batch.waiters = append(batch.waiters, done)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		batch.links = append(batch.links, trace.Link{SpanContext: spanContext})
+	}
+
+	if b.config.MaxItems > 0 && len(batch.collection.Items) >= b.config.MaxItems {
+		b.detach(key, batch)
+		b.mu.Unlock()
+		b.flush(batch, MicroBatchFlushReasonItems)
+	} else {
+		b.mu.Unlock()
+	}
+
+	return <-done
+}
+
+// Close writes every pending batch
+func (b *microBatcher) Close() {
+	b.mu.Lock()
+	batches := b.batches
+	b.batches = make(map[string]*microBatch)
+	b.mu.Unlock()
+
+	for _, batch := range batches {
+		batch.timer.Stop()
+		b.flush(batch, MicroBatchFlushReasonClose)
+	}
+}
+
+// detach removes batch from the pending batches, caller must hold the lock
+func (b *microBatcher) detach(key string, batch *microBatch) {
+	batch.timer.Stop()
+	delete(b.batches, key)
+}
+
+func (b *microBatcher) detachIfCurrent(key string, batch *microBatch) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.batches[key] != batch {
+		return false
+	}
+	delete(b.batches, key)
+	return true
+}
+
+func (b *microBatcher) flush(batch *microBatch, reason string) {
+	prome.ObserveProducerMicroBatch(reason, len(batch.collection.Items))
+
+	ctx, span := tracer.Start(context.Background(), "MicroBatchFlush", trace.WithLinks(batch.links...), trace.WithAttributes(
+		AttributeItems.Int(len(batch.collection.Items)),
+		AttributeFlushReason.String(reason),
+	))
+	err := b.flushFunc(ctx, batch.collection, batch.topic)
+	endSpan(span, err)
+
+	for _, waiter := range batch.waiters {
+		waiter <- err
+	}
+}
+
+func microBatchKey(topic string, timberContext *pb.TimberContext) string {
+	b, _ := proto.Marshal(timberContext)
+	return topic + "\x00" + string(b)
+}
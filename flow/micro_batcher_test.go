@@ -0,0 +1,190 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+)
+
+type flushRecorder struct {
+	mu          sync.Mutex
+	collections []*pb.TimberCollection
+	err         error
+}
+
+func (r *flushRecorder) flush(_ context.Context, timberCollection *pb.TimberCollection, topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collections = append(r.collections, timberCollection)
+	return r.err
+}
+
+func (r *flushRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.collections)
+}
+
+func addConcurrently(batcher *microBatcher, topic string, timbers ...*pb.Timber) []error {
+	errs := make([]error, len(timbers))
+	wg := sync.WaitGroup{}
+	for i, timber := range timbers {
+		wg.Add(1)
+		go func(i int, timber *pb.Timber) {
+			defer wg.Done()
+			errs[i] = batcher.Add(context.Background(), topic, timber)
+		}(i, timber)
+	}
+	wg.Wait()
+	return errs
+}
+
+func pendingBatches(batcher *microBatcher) int {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	return len(batcher.batches)
+}
+
+func TestMicroBatcher_FlushOnWindow(t *testing.T) {
+	resetPrometheusMetrics()
+
+	recorder := &flushRecorder{}
+	batcher := newMicroBatcher(MicroBatchConfig{Window: 20 * time.Millisecond, MaxItems: 100}, recorder.flush)
+
+	errs := addConcurrently(batcher, "some_topic_logs", pb.SampleTimberProto(), pb.SampleTimberProto(), pb.SampleTimberProto())
+	for _, err := range errs {
+		FatalIfError(t, err)
+	}
+
+	FatalIf(t, recorder.count() != 1, "expected 1 batch, got %d", recorder.count())
+	FatalIf(t, len(recorder.collections[0].GetItems()) != 3, "expected 3 items in batch")
+	FatalIf(t, recorder.collections[0].GetContext().GetKafkaTopic() != "some_topic", "batch context should be set")
+}
+
+func TestMicroBatcher_FlushOnMaxItems(t *testing.T) {
+	resetPrometheusMetrics()
+
+	recorder := &flushRecorder{}
+	batcher := newMicroBatcher(MicroBatchConfig{Window: time.Hour, MaxItems: 2}, recorder.flush)
+
+	errs := addConcurrently(batcher, "some_topic_logs", pb.SampleTimberProto(), pb.SampleTimberProto())
+	for _, err := range errs {
+		FatalIfError(t, err)
+	}
+
+	FatalIf(t, recorder.count() != 1, "expected 1 batch, got %d", recorder.count())
+	FatalIf(t, len(recorder.collections[0].GetItems()) != 2, "expected 2 items in batch")
+}
+
+func TestMicroBatcher_FlushOnMaxBytes(t *testing.T) {
+	resetPrometheusMetrics()
+
+	recorder := &flushRecorder{}
+	batcher := newMicroBatcher(MicroBatchConfig{Window: 20 * time.Millisecond, MaxBytes: 1}, recorder.flush)
+
+	errs := addConcurrently(batcher, "some_topic_logs", pb.SampleTimberProto(), pb.SampleTimberProto())
+	for _, err := range errs {
+		FatalIfError(t, err)
+	}
+
+	FatalIf(t, recorder.count() != 2, "expected 2 batches, got %d", recorder.count())
+}
+
+func TestMicroBatcher_SeparateContext(t *testing.T) {
+	resetPrometheusMetrics()
+
+	recorder := &flushRecorder{}
+	batcher := newMicroBatcher(MicroBatchConfig{Window: 20 * time.Millisecond}, recorder.flush)
+
+	other := pb.SampleTimberProto()
+	other.Context.EsIndexPrefix = "other-type"
+
+	addConcurrently(batcher, "some_topic_logs", pb.SampleTimberProto(), other)
+	FatalIf(t, recorder.count() != 2, "timbers with different context must not be batched together")
+}
+
+func TestMicroBatcher_ErrorIsReturnedToEveryCaller(t *testing.T) {
+	resetPrometheusMetrics()
+
+	recorder := &flushRecorder{err: fmt.Errorf("some-error")}
+	batcher := newMicroBatcher(MicroBatchConfig{Window: 20 * time.Millisecond}, recorder.flush)
+
+	errs := addConcurrently(batcher, "some_topic_logs", pb.SampleTimberProto(), pb.SampleTimberProto())
+	for _, err := range errs {
+		FatalIfWrongError(t, err, "some-error")
+	}
+}
+
+func TestMicroBatcher_Close(t *testing.T) {
+	resetPrometheusMetrics()
+
+	recorder := &flushRecorder{}
+	batcher := newMicroBatcher(MicroBatchConfig{Window: time.Hour}, recorder.flush)
+
+	done := make(chan error)
+	go func() {
+		done <- batcher.Add(context.Background(), "some_topic_logs", pb.SampleTimberProto())
+	}()
+
+	for i := 0; i < 100 && pendingBatches(batcher) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	batcher.Close()
+
+	FatalIfError(t, <-done)
+	FatalIf(t, recorder.count() != 1, "pending batch should be written on close")
+}
+
+func TestProducerService_Produce_MicroBatch(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).Return(true)
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Times(1)
+
+	srv := NewProducerService(map[string]interface{}{
+		"factory":            NewDummyKafkaFactory(),
+		"grpcAddr":           "",
+		"topicPrefix":        "",
+		"topicSuffix":        "_logs",
+		"kafkaMaxRetry":      0,
+		"kafkaRetryInterval": 0,
+		"newEventTopic":      "new_topic_events",
+		"grpcMaxRecvMsgSize": 0,
+		"ignoreKafkaOptions": false,
+		"kafkaMessageFormat": TimberCollectionMessageFormat,
+		"limiter":            NewDummyRateLimiter(),
+		"microBatch":         MicroBatchConfig{Window: 20 * time.Millisecond, MaxItems: 10},
+	})
+	srv.producer = producer
+	srv.admin = admin
+
+	results := make([]*pb.ProduceResult, 3)
+	errs := make([]error, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = srv.Produce(context.Background(), pb.SampleTimberProto())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		FatalIfError(t, errs[i])
+		FatalIf(t, results[i].GetTopic() != "some_topic_logs", "wrong result.Topic")
+	}
+}
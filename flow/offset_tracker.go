@@ -0,0 +1,306 @@
+package flow
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DefaultMaxUncommittedOffsets = 100000
+
+	DefaultRedeliveryMinBackoff = time.Second
+	DefaultRedeliveryMaxBackoff = time.Minute
+
+	UndeliveredResultRedelivered = "redelivered"
+	UndeliveredResultDropped     = "dropped"
+)
+
+type trackedMessageKey struct{}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// offsetTracker marks the offset of a message only when the message is delivered, i.e. every document taken from it is acknowledged,
+// along with every message before it on the same partition. A message which failed to be delivered holds the commit of its partition
+// while it is redelivered with redeliverFunc after a backoff, so the logs are not lost on a transient failure. A message which failed
+// with an undeliverable error, see undeliverable, would fail the same way again so it is dropped and the commit goes on.
+type offsetTracker struct {
+	mu             sync.Mutex
+	cond           *sync.Cond
+	markFunc       func(*sarama.ConsumerMessage)
+	redeliverFunc  func(*trackedMessage)
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	maxUncommitted int
+	uncommitted    int
+	topics         map[string]int
+	closed         bool
+	partitions     map[topicPartition][]*trackedMessage
+	available      chan struct{}
+}
+
+// trackedMessage is delivered when every reference is released without error,
+// the handler of the message holds one reference and every pending document holds another
+type trackedMessage struct {
+	tracker *offsetTracker
+	message *sarama.ConsumerMessage
+	refs    int
+	err     error
+	revoked bool
+	attempt int
+	// spanContext is the consumer span of the message, its redeliveries continue its trace
+	spanContext trace.SpanContext
+}
+
+// undeliverableError is the failure of a document which fails the same way when its message is redelivered,
+// e.g. a document rejected by the index mapping
+type undeliverableError struct {
+	error
+}
+
+// undeliverable marks err so the message is dropped instead of redelivered, nil stays nil
+func undeliverable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return undeliverableError{err}
+}
+
+func isUndeliverable(err error) bool {
+	switch e := err.(type) {
+	case undeliverableError:
+		return true
+	case errkit.Errors:
+		for _, err := range e {
+			if isUndeliverable(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newOffsetTracker returns the tracker of the messages passed to redeliverFunc again when they are not delivered
+func newOffsetTracker(maxUncommitted int, markFunc func(*sarama.ConsumerMessage), redeliverFunc func(*trackedMessage)) *offsetTracker {
+	if maxUncommitted <= 0 {
+		maxUncommitted = DefaultMaxUncommittedOffsets
+	}
+
+	t := &offsetTracker{
+		markFunc:       markFunc,
+		redeliverFunc:  redeliverFunc,
+		minBackoff:     DefaultRedeliveryMinBackoff,
+		maxBackoff:     DefaultRedeliveryMaxBackoff,
+		maxUncommitted: maxUncommitted,
+		topics:         make(map[string]int),
+		partitions:     make(map[topicPartition][]*trackedMessage),
+		available:      make(chan struct{}, 1),
+	}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// Track starts tracking message, it blocks while there are too many uncommitted messages and returns nil when the tracker is closed
+func (t *offsetTracker) Track(message *sarama.ConsumerMessage) *trackedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.uncommitted >= t.maxUncommitted && !t.closed {
+		t.cond.Wait()
+	}
+	if t.closed {
+		return nil
+	}
+
+	m := &trackedMessage{tracker: t, message: message, refs: 1}
+	key := topicPartition{message.Topic, message.Partition}
+	t.partitions[key] = append(t.partitions[key], m)
+	t.count(message.Topic, 1)
+
+	return m
+}
+
+// Full returns true while Track blocks
+func (t *offsetTracker) Full() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.uncommitted >= t.maxUncommitted && !t.closed
+}
+
+// Available is signaled when messages are committed, revoked or the tracker is closed, so a full tracker may accept messages again
+func (t *offsetTracker) Available() <-chan struct{} {
+	return t.available
+}
+
+// wakeUp unblocks Track and signals Available, caller must hold the lock
+func (t *offsetTracker) wakeUp() {
+	t.cond.Broadcast()
+	select {
+	case t.available <- struct{}{}:
+	default:
+	}
+}
+
+// Revoke stops tracking the partitions released by rebalance, their messages are consumed again by the new owner
+func (t *offsetTracker) Revoke(released map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for topic, partitions := range released {
+		for _, partition := range partitions {
+			key := topicPartition{topic, partition}
+			for _, m := range t.partitions[key] {
+				m.revoked = true
+			}
+			t.count(topic, -len(t.partitions[key]))
+			delete(t.partitions, key)
+		}
+	}
+	t.wakeUp()
+}
+
+// Close unblocks Track
+func (t *offsetTracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	t.wakeUp()
+}
+
+func (m *trackedMessage) acquire() {
+	m.tracker.mu.Lock()
+	defer m.tracker.mu.Unlock()
+
+	m.refs++
+}
+
+// release drops a reference, err means the document was not delivered
+func (m *trackedMessage) release(err error) {
+	t := m.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m.refs--
+	if err != nil && m.err == nil {
+		m.err = err
+	}
+	if m.refs > 0 || m.revoked {
+		return
+	}
+
+	if m.err != nil && !t.fail(m) {
+		return
+	}
+	t.commit(topicPartition{m.message.Topic, m.message.Partition})
+}
+
+// fail drops the undelivered message m when its error is undeliverable and returns true, otherwise m is redelivered after a backoff
+// and holds the commit of its partition meanwhile, caller must hold the lock
+func (t *offsetTracker) fail(m *trackedMessage) bool {
+	if isUndeliverable(m.err) {
+		prome.IncreaseConsumerUndeliveredMessage(m.message.Topic, UndeliveredResultDropped)
+		log.Errorf("Offset %d of %s[%d] is committed, message is dropped: %s",
+			m.message.Offset, m.message.Topic, m.message.Partition, m.err)
+		m.err = nil
+		return true
+	}
+
+	prome.IncreaseConsumerUndeliveredMessage(m.message.Topic, UndeliveredResultRedelivered)
+	m.attempt++
+	backoff := t.backoff(m.attempt)
+	log.Errorf("Offset %d of %s[%d] is not committed, message is redelivered in %s: %s",
+		m.message.Offset, m.message.Topic, m.message.Partition, backoff, m.err)
+
+	m.err = nil
+	m.refs = 1
+	time.AfterFunc(backoff, func() { t.redeliver(m) })
+	return false
+}
+
+// redeliver passes m to redeliverFunc unless its partition is revoked or the tracker is closed meanwhile
+func (t *offsetTracker) redeliver(m *trackedMessage) {
+	t.mu.Lock()
+	skip := m.revoked || t.closed
+	t.mu.Unlock()
+
+	if !skip {
+		t.redeliverFunc(m)
+	}
+}
+
+func (t *offsetTracker) backoff(attempt int) time.Duration {
+	d := t.minBackoff << uint(attempt-1)
+	if d <= 0 || d > t.maxBackoff {
+		d = t.maxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// commit marks the offset of the last delivered message of the partition before the first undelivered one, caller must hold the lock
+func (t *offsetTracker) commit(key topicPartition) {
+	queue := t.partitions[key]
+
+	n := 0
+	for n < len(queue) && queue[n].refs == 0 && queue[n].err == nil {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	t.markFunc(queue[n-1].message)
+	t.partitions[key] = queue[n:]
+	t.count(key.topic, -n)
+	t.wakeUp()
+}
+
+// count adds delta to the uncommitted messages of topic, the limit applies to the messages of every topic together
+// while the metric is reported per topic, caller must hold the lock
+func (t *offsetTracker) count(topic string, delta int) {
+	t.uncommitted += delta
+	t.topics[topic] += delta
+	prome.SetConsumerUncommittedOffsets(topic, t.topics[topic])
+	if t.topics[topic] == 0 {
+		delete(t.topics, topic)
+	}
+}
+
+func withTrackedMessage(ctx context.Context, m *trackedMessage) context.Context {
+	return context.WithValue(ctx, trackedMessageKey{}, m)
+}
+
+// trackedSourceMessage returns the message tracked in ctx, or nil
+func trackedSourceMessage(ctx context.Context) *sarama.ConsumerMessage {
+	if m, ok := ctx.Value(trackedMessageKey{}).(*trackedMessage); ok {
+		return m.message
+	}
+	return nil
+}
+
+// deliveryAck holds the delivery of the message tracked in ctx until the returned func is called with the result of the document.
+// It is a no-op when ctx carries no tracked message.
+func deliveryAck(ctx context.Context) func(error) {
+	m, ok := ctx.Value(trackedMessageKey{}).(*trackedMessage)
+	if !ok {
+		return func(error) {}
+	}
+
+	m.acquire()
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { m.release(err) })
+	}
+}
@@ -0,0 +1,236 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestOffsetTracker returns the tracker whose undelivered messages are held, they are not redelivered
+func newTestOffsetTracker(maxUncommitted int) (*offsetTracker, *[]int64) {
+	var marked []int64
+	return newOffsetTracker(maxUncommitted, func(message *sarama.ConsumerMessage) {
+		marked = append(marked, message.Offset)
+	}, func(*trackedMessage) {}), &marked
+}
+
+func trackedTestMessage(t *offsetTracker, offset int64) *trackedMessage {
+	return t.Track(&sarama.ConsumerMessage{Topic: "some_topic", Partition: 0, Offset: offset})
+}
+
+func TestOffsetTracker_CommitInOrder(t *testing.T) {
+	resetPrometheusMetrics()
+	tracker, marked := newTestOffsetTracker(0)
+
+	m1 := trackedTestMessage(tracker, 1)
+	ack := deliveryAck(withTrackedMessage(context.Background(), m1))
+	m1.release(nil)
+
+	m2 := trackedTestMessage(tracker, 2)
+	m2.release(nil)
+	FatalIf(t, len(*marked) != 0, "offset should not be marked before the pending document is acknowledged, got %v", *marked)
+
+	ack(nil)
+	ack(fmt.Errorf("some-error"))
+	FatalIf(t, fmt.Sprint(*marked) != "[2]", "expected offset 2 to be marked, got %v", *marked)
+
+	expected := `
+		# HELP barito_consumer_uncommitted_offsets Number of consumed messages waiting for their documents to be acknowledged before their offset is committed
+		# TYPE barito_consumer_uncommitted_offsets gauge
+		barito_consumer_uncommitted_offsets{topic="some_topic"} 0
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_uncommitted_offsets"))
+}
+
+func TestOffsetTracker_UncommittedPerTopic(t *testing.T) {
+	resetPrometheusMetrics()
+	tracker, _ := newTestOffsetTracker(3)
+
+	tracker.Track(&sarama.ConsumerMessage{Topic: "a_logs", Partition: 0, Offset: 1})
+	tracker.Track(&sarama.ConsumerMessage{Topic: "a_logs", Partition: 0, Offset: 2})
+	m := tracker.Track(&sarama.ConsumerMessage{Topic: "b_logs", Partition: 0, Offset: 1})
+	FatalIf(t, !tracker.Full(), "limit should apply to the messages of every topic together")
+	m.release(nil)
+
+	expected := `
+		# HELP barito_consumer_uncommitted_offsets Number of consumed messages waiting for their documents to be acknowledged before their offset is committed
+		# TYPE barito_consumer_uncommitted_offsets gauge
+		barito_consumer_uncommitted_offsets{topic="a_logs"} 2
+		barito_consumer_uncommitted_offsets{topic="b_logs"} 0
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_uncommitted_offsets"))
+}
+
+func TestOffsetTracker_UndeliveredHoldsCommit(t *testing.T) {
+	resetPrometheusMetrics()
+	tracker, marked := newTestOffsetTracker(0)
+
+	trackedTestMessage(tracker, 1).release(nil)
+	trackedTestMessage(tracker, 2).release(fmt.Errorf("some-error"))
+	trackedTestMessage(tracker, 3).release(nil)
+
+	FatalIf(t, fmt.Sprint(*marked) != "[1]", "commit should stop before the undelivered message, got %v", *marked)
+
+	other := tracker.Track(&sarama.ConsumerMessage{Topic: "some_topic", Partition: 1, Offset: 7})
+	other.release(nil)
+	FatalIf(t, fmt.Sprint(*marked) != "[1 7]", "other partitions should be committed, got %v", *marked)
+
+	expected := `
+		# HELP barito_consumer_undelivered_message_total Number of messages whose documents failed to be stored, redelivered or dropped when they would fail again
+		# TYPE barito_consumer_undelivered_message_total counter
+		barito_consumer_undelivered_message_total{result="redelivered",topic="some_topic"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_undelivered_message_total"))
+}
+
+func TestOffsetTracker_Redeliver(t *testing.T) {
+	marked := make(chan int64, 3)
+	redelivered := make(chan *trackedMessage, 1)
+	tracker := newOffsetTracker(0, func(message *sarama.ConsumerMessage) { marked <- message.Offset },
+		func(m *trackedMessage) { redelivered <- m })
+	tracker.minBackoff, tracker.maxBackoff = time.Millisecond, time.Millisecond
+
+	trackedTestMessage(tracker, 1).release(fmt.Errorf("some-error"))
+	trackedTestMessage(tracker, 2).release(nil)
+
+	var m *trackedMessage
+	select {
+	case m = <-redelivered:
+		FatalIf(t, m.message.Offset != 1, "wrong redelivered offset %d", m.message.Offset)
+	case <-time.After(time.Second):
+		t.Fatalf("undelivered message is not redelivered")
+	}
+
+	select {
+	case offset := <-marked:
+		t.Fatalf("offset %d should not be marked before the redelivered message is delivered", offset)
+	default:
+	}
+
+	m.release(nil)
+	FatalIf(t, <-marked != 2, "commit should go on once the redelivered message is delivered")
+}
+
+func TestOffsetTracker_DropUndeliverable(t *testing.T) {
+	resetPrometheusMetrics()
+	tracker, marked := newTestOffsetTracker(0)
+
+	trackedTestMessage(tracker, 1).release(undeliverable(fmt.Errorf("some-error")))
+	trackedTestMessage(tracker, 2).release(errkit.Concat(ErrStore, undeliverable(fmt.Errorf("some-error"))))
+	trackedTestMessage(tracker, 3).release(nil)
+	FatalIf(t, fmt.Sprint(*marked) != "[1 2 3]", "undeliverable messages should be dropped and committed, got %v", *marked)
+
+	expected := `
+		# HELP barito_consumer_undelivered_message_total Number of messages whose documents failed to be stored, redelivered or dropped when they would fail again
+		# TYPE barito_consumer_undelivered_message_total counter
+		barito_consumer_undelivered_message_total{result="dropped",topic="some_topic"} 2
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_consumer_undelivered_message_total"))
+}
+
+func TestOffsetTracker_Backpressure(t *testing.T) {
+	tracker, _ := newTestOffsetTracker(1)
+
+	m1 := trackedTestMessage(tracker, 1)
+
+	tracked := make(chan *trackedMessage)
+	go func() { tracked <- trackedTestMessage(tracker, 2) }()
+
+	select {
+	case <-tracked:
+		t.Fatalf("Track should block on max uncommitted offsets")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	m1.release(nil)
+	m2 := <-tracked
+	FatalIf(t, m2 == nil, "Track should be unblocked by the commit")
+
+	go func() { tracked <- trackedTestMessage(tracker, 3) }()
+	tracker.Close()
+	FatalIf(t, <-tracked != nil, "Track should return nil when closed")
+}
+
+func TestOffsetTracker_Available(t *testing.T) {
+	tracker, _ := newTestOffsetTracker(1)
+
+	m1 := trackedTestMessage(tracker, 1)
+	FatalIf(t, !tracker.Full(), "tracker should be full")
+
+	m1.release(nil)
+	FatalIf(t, tracker.Full(), "tracker should not be full after the commit")
+	select {
+	case <-tracker.Available():
+	default:
+		t.Fatalf("Available should be signaled by the commit")
+	}
+
+	trackedTestMessage(tracker, 2)
+	tracker.Revoke(map[string][]int32{"some_topic": {0}})
+	FatalIf(t, tracker.Full(), "tracker should not be full after the revoke")
+	select {
+	case <-tracker.Available():
+	default:
+		t.Fatalf("Available should be signaled by the revoke")
+	}
+}
+
+func TestOffsetTracker_Revoke(t *testing.T) {
+	tracker, marked := newTestOffsetTracker(1)
+
+	m1 := trackedTestMessage(tracker, 1)
+	tracker.Revoke(map[string][]int32{"some_topic": {0}})
+	m1.release(nil)
+	FatalIf(t, len(*marked) != 0, "revoked partition should not be marked, got %v", *marked)
+
+	m2 := trackedTestMessage(tracker, 5)
+	FatalIf(t, m2 == nil, "revoked messages should not count as uncommitted")
+	m2.release(nil)
+	FatalIf(t, fmt.Sprint(*marked) != "[5]", "expected offset 5 to be marked, got %v", *marked)
+}
+
+func TestOffsetTracker_BulkAck(t *testing.T) {
+	ts := httptest.NewServer(&ELasticTestHandler{
+		ExistAPIStatus: 200,
+		PostAPIStatus:  200,
+		ResponseBody: []byte(`{"errors":true,"items":[
+			{"index":{"_index":"some-type","status":201,"result":"created"}},
+			{"index":{"_index":"some-type","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field"}}}
+		]}`),
+	})
+	defer ts.Close()
+
+	esConfig := NewEsConfig(IndexMethodBulkProcessor, 2, time.Duration(1000), false, "")
+	client, err := NewElastic(NewElasticRetrier(time.Millisecond, 1, nil, nil), esConfig, []string{ts.URL}, "", "", nil)
+	FatalIfError(t, err)
+	defer client.bulkProcessor.Close()
+
+	marked := make(chan int64, 2)
+	tracker := newOffsetTracker(0, func(message *sarama.ConsumerMessage) { marked <- message.Offset }, func(*trackedMessage) {})
+
+	for offset := int64(1); offset <= 2; offset++ {
+		m := trackedTestMessage(tracker, offset)
+		FatalIfError(t, client.Store(withTrackedMessage(context.Background(), m), *pb.SampleTimberProto()))
+		m.release(nil)
+	}
+
+	// the rejected document is dropped without dead letter topic, it would be rejected again
+	for _, expected := range []int64{1, 2} {
+		select {
+		case offset := <-marked:
+			FatalIf(t, offset != expected, "expected offset %d to be marked, got %d", expected, offset)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("offset %d is not marked", expected)
+		}
+	}
+}
@@ -24,3 +24,11 @@ func onCreateTopicErrorGrpc(err error) error {
 func onSendCreateTopicErrorGrpc(err error) error {
 	return status.Errorf(codes.Unavailable, err.Error())
 }
+
+func onPeerLimitExceededGrpc(reason string) error {
+	return status.Errorf(codes.ResourceExhausted, "Peer Limit Exceeded: %s", reason)
+}
+
+func onPeerBannedGrpc() error {
+	return status.Errorf(codes.PermissionDenied, "Peer Temporarily Banned")
+}
@@ -0,0 +1,281 @@
+package flow
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "github.com/bentol/barito-proto/producer"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OtlpLogsPath = "/v1/logs"
+
+	otlpContentTypeProtobuf = "application/x-protobuf"
+	otlpContentTypeJson     = "application/json"
+)
+
+// OtlpConfig enables the OpenTelemetry logs endpoint of the producer.
+// App identity is taken from AppHeader when the request has it, otherwise from AppAttribute of the resource.
+type OtlpConfig struct {
+	HttpAddr     string
+	AppAttribute string
+	AppHeader    string
+	AppMaxTps    int32
+}
+
+// otlpLogsService translates OTLP export requests into TimberCollection,
+// one collection per app, and writes them through ProduceBatch
+type otlpLogsService struct {
+	collogspb.UnimplementedLogsServiceServer
+	producer *producerService
+	config   OtlpConfig
+}
+
+func newOtlpLogsService(producer *producerService, config OtlpConfig) *otlpLogsService {
+	return &otlpLogsService{
+		producer: producer,
+		config:   config,
+	}
+}
+
+func (o *otlpLogsService) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	app := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && o.config.AppHeader != "" {
+		if values := md.Get(o.config.AppHeader); len(values) > 0 {
+			app = values[0]
+		}
+	}
+
+	return o.export(ctx, req, app)
+}
+
+func (o *otlpLogsService) export(ctx context.Context, req *collogspb.ExportLogsServiceRequest, app string) (*collogspb.ExportLogsServiceResponse, error) {
+	collections := o.convertToTimberCollections(req, app)
+
+	var firstErr error
+	rejected, accepted := 0, 0
+	for _, timberCollection := range collections {
+		n := len(timberCollection.GetItems())
+		if _, err := o.producer.ProduceBatch(ctx, timberCollection); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			rejected += n
+			continue
+		}
+		accepted += n
+	}
+
+	if firstErr != nil && accepted == 0 {
+		return nil, firstErr
+	}
+
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if firstErr != nil {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: int64(rejected),
+			ErrorMessage:       status.Convert(firstErr).Message(),
+		}
+	}
+	return resp, nil
+}
+
+// convertToTimberCollections groups log records of req by app, app overrides the app attribute of every resource
+func (o *otlpLogsService) convertToTimberCollections(req *collogspb.ExportLogsServiceRequest, app string) (collections []*pb.TimberCollection) {
+	byApp := make(map[string]*pb.TimberCollection)
+
+	for _, resourceLogs := range req.GetResourceLogs() {
+		resource := otlpAttributesToStruct(resourceLogs.GetResource().GetAttributes())
+
+		resourceApp := app
+		if resourceApp == "" {
+			resourceApp = resource.GetFields()[o.config.AppAttribute].GetStringValue()
+		}
+
+		timberCollection, ok := byApp[resourceApp]
+		if !ok {
+			timberCollection = &pb.TimberCollection{
+				Context: newAppTimberContext(resourceApp, o.config.AppMaxTps),
+			}
+			byApp[resourceApp] = timberCollection
+			collections = append(collections, timberCollection)
+		}
+
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, logRecord := range scopeLogs.GetLogRecords() {
+				timber := otlpLogRecordToTimber(logRecord, resource, scopeLogs.GetScope())
+				timberCollection.Items = append(timberCollection.Items, timber)
+			}
+		}
+	}
+
+	return
+}
+
+func otlpLogRecordToTimber(logRecord *logspb.LogRecord, resource *stpb.Struct, scope *commonpb.InstrumentationScope) *pb.Timber {
+	fields := map[string]*stpb.Value{
+		"attributes": structValue(otlpAttributesToStruct(logRecord.GetAttributes())),
+		"resource":   structValue(resource),
+	}
+
+	if logRecord.GetBody() != nil {
+		fields["@message"] = otlpValueToMessage(logRecord.GetBody())
+	}
+
+	ts := logRecord.GetTimeUnixNano()
+	if ts == 0 {
+		ts = logRecord.GetObservedTimeUnixNano()
+	}
+	if ts > 0 {
+		fields["@timestamp"] = stringValue(time.Unix(0, int64(ts)).UTC().Format(time.RFC3339Nano))
+	}
+
+	if logRecord.GetSeverityText() != "" {
+		fields["severity"] = stringValue(logRecord.GetSeverityText())
+	}
+	if logRecord.GetSeverityNumber() != logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+		fields["severity_number"] = &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: float64(logRecord.GetSeverityNumber())}}
+	}
+	if len(logRecord.GetTraceId()) > 0 {
+		fields["trace_id"] = stringValue(hex.EncodeToString(logRecord.GetTraceId()))
+	}
+	if len(logRecord.GetSpanId()) > 0 {
+		fields["span_id"] = stringValue(hex.EncodeToString(logRecord.GetSpanId()))
+	}
+	if scope.GetName() != "" {
+		fields["scope"] = structValue(&stpb.Struct{Fields: map[string]*stpb.Value{
+			"name":    stringValue(scope.GetName()),
+			"version": stringValue(scope.GetVersion()),
+		}})
+	}
+
+	return &pb.Timber{
+		Content: &stpb.Struct{Fields: fields},
+	}
+}
+
+// otlpValueToMessage keeps string body as is, other body is encoded as JSON like the other barito clients do
+func otlpValueToMessage(value *commonpb.AnyValue) *stpb.Value {
+	if s, ok := value.GetValue().(*commonpb.AnyValue_StringValue); ok {
+		return stringValue(s.StringValue)
+	}
+
+	b, _ := protojson.Marshal(otlpValueToProto(value))
+	return stringValue(string(b))
+}
+
+func otlpAttributesToStruct(attributes []*commonpb.KeyValue) *stpb.Struct {
+	s := &stpb.Struct{Fields: make(map[string]*stpb.Value, len(attributes))}
+	for _, kv := range attributes {
+		s.Fields[kv.GetKey()] = otlpValueToProto(kv.GetValue())
+	}
+	return s
+}
+
+func otlpValueToProto(value *commonpb.AnyValue) *stpb.Value {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return stringValue(v.StringValue)
+	case *commonpb.AnyValue_BoolValue:
+		return &stpb.Value{Kind: &stpb.Value_BoolValue{BoolValue: v.BoolValue}}
+	case *commonpb.AnyValue_IntValue:
+		return &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: float64(v.IntValue)}}
+	case *commonpb.AnyValue_DoubleValue:
+		return &stpb.Value{Kind: &stpb.Value_NumberValue{NumberValue: v.DoubleValue}}
+	case *commonpb.AnyValue_BytesValue:
+		return stringValue(base64.StdEncoding.EncodeToString(v.BytesValue))
+	case *commonpb.AnyValue_ArrayValue:
+		list := &stpb.ListValue{}
+		for _, item := range v.ArrayValue.GetValues() {
+			list.Values = append(list.Values, otlpValueToProto(item))
+		}
+		return &stpb.Value{Kind: &stpb.Value_ListValue{ListValue: list}}
+	case *commonpb.AnyValue_KvlistValue:
+		return structValue(otlpAttributesToStruct(v.KvlistValue.GetValues()))
+	}
+	return &stpb.Value{Kind: &stpb.Value_NullValue{}}
+}
+
+func stringValue(s string) *stpb.Value {
+	return &stpb.Value{Kind: &stpb.Value_StringValue{StringValue: s}}
+}
+
+func structValue(s *stpb.Struct) *stpb.Value {
+	return &stpb.Value{Kind: &stpb.Value_StructValue{StructValue: s}}
+}
+
+// ServeHTTP implements OTLP/HTTP logs endpoint, both protobuf and JSON encoding are accepted
+func (o *otlpLogsService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	isJson := strings.HasPrefix(contentType, otlpContentTypeJson)
+	if !isJson && !strings.HasPrefix(contentType, otlpContentTypeProtobuf) {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := readHTTPBody(r, int64(o.producer.grpcMaxRecvMsgSize))
+	if err != nil {
+		o.writeHTTPError(w, isJson, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	req := &collogspb.ExportLogsServiceRequest{}
+	if isJson {
+		err = protojson.Unmarshal(body, req)
+	} else {
+		err = proto.Unmarshal(body, req)
+	}
+	if err != nil {
+		o.writeHTTPError(w, isJson, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	app := ""
+	if o.config.AppHeader != "" {
+		app = r.Header.Get(o.config.AppHeader)
+	}
+
+	resp, err := o.export(r.Context(), req, app)
+	if err != nil {
+		o.writeHTTPError(w, isJson, err)
+		return
+	}
+
+	o.writeHTTP(w, isJson, http.StatusOK, resp)
+}
+
+func (o *otlpLogsService) writeHTTPError(w http.ResponseWriter, isJson bool, err error) {
+	st := status.Convert(err)
+	o.writeHTTP(w, isJson, httpStatusOf(st.Code()), st.Proto())
+}
+
+func (o *otlpLogsService) writeHTTP(w http.ResponseWriter, isJson bool, code int, m proto.Message) {
+	var b []byte
+	if isJson {
+		w.Header().Set("Content-Type", otlpContentTypeJson)
+		b, _ = protojson.Marshal(m)
+	} else {
+		w.Header().Set("Content-Type", otlpContentTypeProtobuf)
+		b, _ = proto.Marshal(m)
+	}
+	w.WriteHeader(code)
+	w.Write(b)
+}
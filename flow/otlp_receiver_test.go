@@ -0,0 +1,235 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/golang/mock/gomock"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func sampleOtlpConfig() OtlpConfig {
+	return OtlpConfig{
+		AppAttribute: "service.name",
+		AppHeader:    "x-barito-app-name",
+		AppMaxTps:    100,
+	}
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func sampleOtlpResourceLogs(app string, bodies ...string) *logspb.ResourceLogs {
+	records := []*logspb.LogRecord{}
+	for _, body := range bodies {
+		records = append(records, &logspb.LogRecord{
+			TimeUnixNano: 1717243200000000000,
+			SeverityText: "INFO",
+			Body:         &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}},
+			Attributes: []*commonpb.KeyValue{
+				otlpString("http.method", "GET"),
+				{Key: "http.status", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 200}}},
+			},
+			TraceId: []byte{0x01, 0x02},
+		})
+	}
+
+	return &logspb.ResourceLogs{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpString("service.name", app)}},
+		ScopeLogs: []*logspb.ScopeLogs{
+			{
+				Scope:      &commonpb.InstrumentationScope{Name: "some-scope", Version: "1.0"},
+				LogRecords: records,
+			},
+		},
+	}
+}
+
+func newOtlpTestProducer(t *testing.T, sendCount int) (*producerService, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Times(sendCount)
+
+	srv := &producerService{
+		producer:           producer,
+		topicSuffix:        "_logs",
+		admin:              admin,
+		limiter:            NewDummyRateLimiter(),
+		kafkaMessageFormat: TimberCollectionMessageFormat,
+	}
+	srv.otlp = newOtlpLogsService(srv, sampleOtlpConfig())
+	return srv, ctrl
+}
+
+func TestOtlpLogsService_convertToTimberCollections(t *testing.T) {
+	o := newOtlpLogsService(nil, sampleOtlpConfig())
+
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{
+			sampleOtlpResourceLogs("app-a", "first"),
+			sampleOtlpResourceLogs("app-b", "second"),
+			sampleOtlpResourceLogs("app-a", "third"),
+		},
+	}
+
+	collections := o.convertToTimberCollections(req, "")
+	FatalIf(t, len(collections) != 2, "expected 2 collections, got %d", len(collections))
+	FatalIf(t, collections[0].GetContext().GetKafkaTopic() != "app-a", "wrong kafka topic")
+	FatalIf(t, collections[0].GetContext().GetEsIndexPrefix() != "app-a", "wrong index prefix")
+	FatalIf(t, collections[0].GetContext().GetAppMaxTps() != 100, "wrong app max tps")
+	FatalIf(t, len(collections[0].GetItems()) != 2, "logs of the same app should be grouped")
+
+	fields := collections[0].GetItems()[0].GetContent().GetFields()
+	FatalIf(t, fields["@message"].GetStringValue() != "first", "wrong @message")
+	FatalIf(t, fields["@timestamp"].GetStringValue() != "2024-06-01T12:00:00Z", "wrong @timestamp %s", fields["@timestamp"].GetStringValue())
+	FatalIf(t, fields["severity"].GetStringValue() != "INFO", "wrong severity")
+	FatalIf(t, fields["trace_id"].GetStringValue() != "0102", "wrong trace_id")
+	FatalIf(t, fields["attributes"].GetStructValue().GetFields()["http.status"].GetNumberValue() != 200, "wrong attributes")
+	FatalIf(t, fields["resource"].GetStructValue().GetFields()["service.name"].GetStringValue() != "app-a", "wrong resource")
+	FatalIf(t, fields["scope"].GetStructValue().GetFields()["name"].GetStringValue() != "some-scope", "wrong scope")
+}
+
+func TestOtlpLogsService_convertToTimberCollections_AppFromHeader(t *testing.T) {
+	o := newOtlpLogsService(nil, sampleOtlpConfig())
+
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{
+			sampleOtlpResourceLogs("app-a", "first"),
+			sampleOtlpResourceLogs("app-b", "second"),
+		},
+	}
+
+	collections := o.convertToTimberCollections(req, "some-app")
+	FatalIf(t, len(collections) != 1, "expected 1 collection, got %d", len(collections))
+	FatalIf(t, collections[0].GetContext().GetKafkaTopic() != "some-app", "app should be taken from header")
+}
+
+func TestOtlpLogsService_Export(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl := newOtlpTestProducer(t, 1)
+	defer ctrl.Finish()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-barito-app-name", "some-app"))
+	resp, err := srv.otlp.Export(ctx, &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{sampleOtlpResourceLogs("", "first", "second")},
+	})
+	FatalIfError(t, err)
+	FatalIf(t, resp.GetPartialSuccess() != nil, "expected full success")
+}
+
+func TestOtlpLogsService_Export_PartialSuccess(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl := newOtlpTestProducer(t, 1)
+	defer ctrl.Finish()
+
+	resp, err := srv.otlp.Export(context.Background(), &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{
+			sampleOtlpResourceLogs("some-app", "first"),
+			sampleOtlpResourceLogs("", "second", "third"),
+		},
+	})
+	FatalIfError(t, err)
+	FatalIf(t, resp.GetPartialSuccess().GetRejectedLogRecords() != 2, "logs without app should be rejected")
+}
+
+func TestOtlpLogsService_Export_RateLimited(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl := newOtlpTestProducer(t, 0)
+	defer ctrl.Finish()
+	limiter := NewDummyRateLimiter()
+	limiter.Expect_IsHitLimit_AlwaysTrue()
+	srv.limiter = limiter
+
+	_, err := srv.otlp.Export(context.Background(), &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{sampleOtlpResourceLogs("some-app", "first")},
+	})
+	FatalIf(t, status.Code(err) != codes.ResourceExhausted, "expected ResourceExhausted, got %v", err)
+}
+
+func TestOtlpLogsService_ServeHTTP(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl := newOtlpTestProducer(t, 1)
+	defer ctrl.Finish()
+
+	body, _ := proto.Marshal(&collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{sampleOtlpResourceLogs("some-app", "first")},
+	})
+	req := httptest.NewRequest(http.MethodPost, OtlpLogsPath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rec := httptest.NewRecorder()
+
+	srv.otlp.ServeHTTP(rec, req)
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d", rec.Code)
+
+	resp := &collogspb.ExportLogsServiceResponse{}
+	FatalIfError(t, proto.Unmarshal(rec.Body.Bytes(), resp))
+}
+
+func TestOtlpLogsService_ServeHTTP_Json(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl := newOtlpTestProducer(t, 1)
+	defer ctrl.Finish()
+
+	body := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"first"}}]}]}]}`
+	req := httptest.NewRequest(http.MethodPost, OtlpLogsPath, bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Barito-App-Name", "some-app")
+	rec := httptest.NewRecorder()
+
+	srv.otlp.ServeHTTP(rec, req)
+	FatalIf(t, rec.Code != http.StatusOK, "expected 200, got %d: %s", rec.Code, rec.Body.String())
+}
+
+func TestOtlpLogsService_ServeHTTP_Error(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl := newOtlpTestProducer(t, 0)
+	defer ctrl.Finish()
+	limiter := NewDummyRateLimiter()
+	limiter.Expect_IsHitLimit_AlwaysTrue()
+	srv.limiter = limiter
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        []byte
+		code        int
+	}{
+		{"unsupported content type", "text/plain", []byte("some-log"), http.StatusUnsupportedMediaType},
+		{"invalid body", "application/x-protobuf", []byte("invalid_proto"), http.StatusBadRequest},
+		{"rate limited", "application/json", []byte(`{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"some-app"}}]},"scopeLogs":[{"logRecords":[{"body":{"stringValue":"first"}}]}]}]}`), http.StatusTooManyRequests},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, OtlpLogsPath, bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rec := httptest.NewRecorder()
+
+			srv.otlp.ServeHTTP(rec, req)
+			FatalIf(t, rec.Code != tc.code, "expected %d, got %d", tc.code, rec.Code)
+		})
+	}
+}
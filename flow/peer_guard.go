@@ -0,0 +1,293 @@
+package flow
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	log "github.com/sirupsen/logrus"
+	"github.com/zekroTJA/timedmap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	PeerRejectReasonMaxConnections    = "max_connections"
+	PeerRejectReasonConcurrentStreams = "concurrent_streams"
+	PeerRejectReasonRequestRate       = "request_rate"
+	PeerRejectReasonBanned            = "banned"
+)
+
+// PeerGuardConfig holds the connection and peer-address level limits of the producer.
+// Zero value of each limit means the limit is disabled.
+type PeerGuardConfig struct {
+	MaxConnections       int
+	MaxConcurrentStreams int
+	MaxRequestsPerSecond int32
+	BanThreshold         int
+	BanDuration          time.Duration
+}
+
+// PeerGuard protects the gRPC server from a single misbehaving client,
+// independently of the app level RateLimiter
+type PeerGuard struct {
+	config PeerGuardConfig
+
+	mu          sync.Mutex
+	connections int
+	inflight    map[string]int
+	bucketMap   map[string]*LeakyBucket
+
+	strikes *timedmap.TimedMap
+	banned  *timedmap.TimedMap
+
+	ticker *time.Ticker
+	stop   chan int
+}
+
+func NewPeerGuard(config PeerGuardConfig) *PeerGuard {
+	return &PeerGuard{
+		config:    config,
+		inflight:  make(map[string]int),
+		bucketMap: make(map[string]*LeakyBucket),
+		strikes:   timedmap.New(time.Minute),
+		banned:    timedmap.New(time.Minute),
+		ticker:    time.NewTicker(time.Second),
+		stop:      make(chan int),
+	}
+}
+
+func (g *PeerGuard) Start() {
+	go g.loopRefillBuckets()
+}
+
+// Stop stops the bucket refill loop and the expiry cleaners of the strikes and bans
+func (g *PeerGuard) Stop() {
+	g.ticker.Stop()
+	g.strikes.StopCleaner()
+	g.banned.StopCleaner()
+	go func() {
+		g.stop <- 1
+	}()
+}
+
+// ServerOptions returns the grpc.ServerOption needed to enforce the guard
+func (g *PeerGuard) ServerOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(g.UnaryServerInterceptor),
+		grpc.ChainStreamInterceptor(g.StreamServerInterceptor),
+	}
+	if g.config.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(g.config.MaxConcurrentStreams)))
+	}
+	return opts
+}
+
+// Listener wraps lis so banned peers and connections above MaxConnections are closed right after accept
+func (g *PeerGuard) Listener(lis net.Listener) net.Listener {
+	return &peerGuardListener{Listener: lis, guard: g}
+}
+
+func (g *PeerGuard) UnaryServerInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	release, err := g.admit(peerAddress(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return handler(ctx, req)
+}
+
+func (g *PeerGuard) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	release, err := g.admit(peerAddress(ss.Context()))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return handler(srv, ss)
+}
+
+func (g *PeerGuard) IsBanned(addr string) bool {
+	return g.config.BanThreshold > 0 && g.banned.Contains(addr)
+}
+
+// admit checks every peer limits, on success the returned func must be called when the request is finished
+func (g *PeerGuard) admit(addr string) (release func(), err error) {
+	if g.IsBanned(addr) {
+		prome.IncreaseProducerPeerRejected(PeerRejectReasonBanned)
+		return nil, onPeerBannedGrpc()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.MaxRequestsPerSecond > 0 {
+		bucket, ok := g.bucketMap[addr]
+		if !ok {
+			bucket = NewLeakyBucket(g.config.MaxRequestsPerSecond)
+			g.bucketMap[addr] = bucket
+		}
+		if !bucket.Take(1) {
+			return nil, g.reject(addr, PeerRejectReasonRequestRate)
+		}
+	}
+
+	if g.config.MaxConcurrentStreams > 0 && g.inflight[addr] >= g.config.MaxConcurrentStreams {
+		return nil, g.reject(addr, PeerRejectReasonConcurrentStreams)
+	}
+
+	g.inflight[addr]++
+	prome.IncreaseProducerPeerInflightStreams(1)
+
+	release = func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		g.inflight[addr]--
+		if g.inflight[addr] <= 0 {
+			delete(g.inflight, addr)
+		}
+		prome.IncreaseProducerPeerInflightStreams(-1)
+	}
+	return
+}
+
+func (g *PeerGuard) reject(addr, reason string) error {
+	prome.IncreaseProducerPeerRejected(reason)
+	g.strike(addr)
+	return onPeerLimitExceededGrpc(reason)
+}
+
+// strike counts a rejection for addr, the peer is banned when it reach BanThreshold within BanDuration
+func (g *PeerGuard) strike(addr string) {
+	if g.config.BanThreshold <= 0 {
+		return
+	}
+
+	count := 1
+	if v, ok := g.strikes.GetValue(addr).(int); ok {
+		count = v + 1
+	}
+
+	if count < g.config.BanThreshold {
+		g.strikes.Set(addr, count, g.config.BanDuration)
+		return
+	}
+
+	g.strikes.Remove(addr)
+	g.banned.Set(addr, true, g.config.BanDuration)
+	prome.IncreaseProducerPeerBanned()
+	log.Warnf("Peer %s is banned for %s", addr, g.config.BanDuration)
+}
+
+func (g *PeerGuard) acceptConnection(addr string) bool {
+	if g.IsBanned(addr) {
+		prome.IncreaseProducerPeerRejected(PeerRejectReasonBanned)
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.MaxConnections > 0 && g.connections >= g.config.MaxConnections {
+		prome.IncreaseProducerPeerRejected(PeerRejectReasonMaxConnections)
+		g.strike(addr)
+		return false
+	}
+
+	g.connections++
+	prome.SetProducerActiveConnections(g.connections)
+	return true
+}
+
+func (g *PeerGuard) releaseConnection() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.connections--
+	prome.SetProducerActiveConnections(g.connections)
+}
+
+func (g *PeerGuard) loopRefillBuckets() {
+	for {
+		select {
+		case <-g.ticker.C:
+			g.refillBuckets()
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+// refillBuckets refills every bucket, the full one means the peer was idle so it can be forgotten
+func (g *PeerGuard) refillBuckets() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for addr, bucket := range g.bucketMap {
+		if bucket.IsFull() {
+			delete(g.bucketMap, addr)
+			continue
+		}
+		bucket.Refill()
+	}
+}
+
+type peerGuardListener struct {
+	net.Listener
+	guard *PeerGuard
+}
+
+func (l *peerGuardListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if !l.guard.acceptConnection(hostOf(conn.RemoteAddr())) {
+			conn.Close()
+			continue
+		}
+
+		return &peerGuardConn{Conn: conn, guard: l.guard}, nil
+	}
+}
+
+type peerGuardConn struct {
+	net.Conn
+	guard *PeerGuard
+	once  sync.Once
+}
+
+func (c *peerGuardConn) Close() error {
+	c.once.Do(c.guard.releaseConnection)
+	return c.Conn.Close()
+}
+
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return hostOf(p.Addr)
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return hostOfAddress(addr.String())
+}
+
+// hostOfAddress returns the host of "host:port", or addr when it has no port
+func hostOfAddress(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,164 @@
+package flow
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPeerGuard_RequestRate(t *testing.T) {
+	resetPrometheusMetrics()
+
+	guard := NewPeerGuard(PeerGuardConfig{MaxRequestsPerSecond: 2})
+
+	for i := 0; i < 2; i++ {
+		release, err := guard.admit("10.0.0.1")
+		FatalIfError(t, err)
+		release()
+	}
+
+	_, err := guard.admit("10.0.0.1")
+	FatalIfWrongGrpcError(t, onPeerLimitExceededGrpc(PeerRejectReasonRequestRate), err)
+
+	_, err = guard.admit("10.0.0.2")
+	FatalIfError(t, err)
+
+	guard.refillBuckets()
+	_, err = guard.admit("10.0.0.1")
+	FatalIfError(t, err)
+
+	expected := `
+		# HELP barito_producer_peer_rejected_total Number of connections or requests rejected by peer guard
+		# TYPE barito_producer_peer_rejected_total counter
+		barito_producer_peer_rejected_total{reason="request_rate"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_peer_rejected_total"))
+}
+
+func TestPeerGuard_ConcurrentStreams(t *testing.T) {
+	resetPrometheusMetrics()
+
+	guard := NewPeerGuard(PeerGuardConfig{MaxConcurrentStreams: 1})
+
+	release, err := guard.admit("10.0.0.1")
+	FatalIfError(t, err)
+
+	_, err = guard.admit("10.0.0.1")
+	FatalIfWrongGrpcError(t, onPeerLimitExceededGrpc(PeerRejectReasonConcurrentStreams), err)
+
+	release()
+	release, err = guard.admit("10.0.0.1")
+	FatalIfError(t, err)
+	release()
+}
+
+func TestPeerGuard_Ban(t *testing.T) {
+	resetPrometheusMetrics()
+
+	guard := NewPeerGuard(PeerGuardConfig{
+		MaxRequestsPerSecond: 1,
+		BanThreshold:         2,
+		BanDuration:          time.Minute,
+	})
+
+	_, err := guard.admit("10.0.0.1")
+	FatalIfError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = guard.admit("10.0.0.1")
+		FatalIfWrongGrpcError(t, onPeerLimitExceededGrpc(PeerRejectReasonRequestRate), err)
+	}
+	FatalIf(t, !guard.IsBanned("10.0.0.1"), "peer should be banned")
+
+	guard.refillBuckets()
+	_, err = guard.admit("10.0.0.1")
+	FatalIfWrongGrpcError(t, onPeerBannedGrpc(), err)
+
+	FatalIf(t, guard.IsBanned("10.0.0.2"), "other peer should not be banned")
+}
+
+func TestPeerGuard_MaxConnections(t *testing.T) {
+	resetPrometheusMetrics()
+
+	guard := NewPeerGuard(PeerGuardConfig{MaxConnections: 1})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	FatalIfError(t, err)
+	lis = guard.Listener(lis)
+	defer lis.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", lis.Addr().String())
+	FatalIfError(t, err)
+	defer first.Close()
+
+	conn := <-accepted
+
+	second, err := net.Dial("tcp", lis.Addr().String())
+	FatalIfError(t, err)
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = second.Read(make([]byte, 1))
+	FatalIf(t, err == nil, "connection above the limit should be closed")
+
+	conn.Close()
+	third, err := net.Dial("tcp", lis.Addr().String())
+	FatalIfError(t, err)
+	defer third.Close()
+
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("connection should be accepted after the other is closed")
+	}
+
+	expected := `
+		# HELP barito_producer_peer_rejected_total Number of connections or requests rejected by peer guard
+		# TYPE barito_producer_peer_rejected_total counter
+		barito_producer_peer_rejected_total{reason="max_connections"} 1
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_peer_rejected_total"))
+}
+
+func TestGuardHTTP_PeerIsHost(t *testing.T) {
+	resetPrometheusMetrics()
+
+	guard := NewPeerGuard(PeerGuardConfig{
+		MaxRequestsPerSecond: 1,
+		BanThreshold:         1,
+		BanDuration:          time.Minute,
+	})
+	handler := guardHTTP(guard, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// every request comes from another ephemeral port of the same host
+	FatalIf(t, serve("10.0.0.1:40001") != http.StatusOK, "first request should be admitted")
+	FatalIf(t, serve("10.0.0.1:40002") != http.StatusTooManyRequests, "request rate should be limited per host")
+	FatalIf(t, !guard.IsBanned("10.0.0.1"), "host should be banned")
+	FatalIf(t, guard.acceptConnection("10.0.0.1"), "connection of the banned host should be refused")
+}
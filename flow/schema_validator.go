@@ -0,0 +1,258 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	pb "github.com/bentol/barito-proto/producer"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrSchemaMode   = errkit.Error("Unknown schema mode")
+	ErrSchemaSource = errkit.Error("Failed to load schemas")
+
+	SchemaModeEnforce  = "enforce"
+	SchemaModeAnnotate = "annotate"
+	SchemaModeMetrics  = "metrics"
+
+	ValidationReasonSchemaViolation = "schema_violation"
+
+	// SchemaViolationsField is added into the content of violating timber on annotate mode
+	SchemaViolationsField = "_schema_violations"
+
+	// SchemaRootPath is the path of violations on the content itself, e.g. missing required field
+	SchemaRootPath = "$"
+
+	schemaFetchTimeout = 10 * time.Second
+)
+
+var schemaArrayIndexPattern = regexp.MustCompile(`^\d+$`)
+
+// SchemaConfig enables JSON schema validation of timber content per app.
+// Source is a file path or http(s) url of a JSON object mapping app name into its JSON schema,
+// it is reloaded every PollInterval unless PollInterval is zero.
+type SchemaConfig struct {
+	Mode         string
+	Source       string
+	PollInterval time.Duration
+}
+
+// SchemaViolation is a content field which does not match the schema of its app
+type SchemaViolation struct {
+	Path    string
+	Message string
+}
+
+func (v SchemaViolation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// SchemaValidator validates the content of timbers against the JSON schema of their app,
+// apps without schema are not validated
+type SchemaValidator struct {
+	config  SchemaConfig
+	schemas atomic.Value // map[string]*jsonschema.Schema
+	client  *http.Client
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSchemaValidator loads the schemas from source, it fails when the schemas can not be loaded
+func NewSchemaValidator(config SchemaConfig) (*SchemaValidator, error) {
+	switch config.Mode {
+	case SchemaModeEnforce, SchemaModeAnnotate, SchemaModeMetrics:
+	default:
+		return nil, errkit.Concat(ErrSchemaMode, errkit.Error(config.Mode))
+	}
+
+	v := &SchemaValidator{
+		config: config,
+		client: &http.Client{Timeout: schemaFetchTimeout},
+		stop:   make(chan struct{}),
+	}
+	if err := v.Load(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Start reloads the schemas every poll interval, the current schemas are kept when reload failed
+func (v *SchemaValidator) Start() {
+	if v.config.PollInterval <= 0 {
+		return
+	}
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		ticker := time.NewTicker(v.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-v.stop:
+				return
+			case <-ticker.C:
+				if err := v.Load(); err != nil {
+					log.Warnf("%s", err)
+				}
+			}
+		}
+	}()
+}
+
+func (v *SchemaValidator) Close() {
+	close(v.stop)
+	v.wg.Wait()
+}
+
+// Load reads and compiles the schemas of every app from source
+func (v *SchemaValidator) Load() (err error) {
+	b, err := v.read()
+	if err != nil {
+		return errkit.Concat(ErrSchemaSource, err)
+	}
+
+	var raws map[string]json.RawMessage
+	if err = json.Unmarshal(b, &raws); err != nil {
+		return errkit.Concat(ErrSchemaSource, err)
+	}
+
+	schemas := make(map[string]*jsonschema.Schema, len(raws))
+	for app, raw := range raws {
+		url := fmt.Sprintf("barito:///%s.json", app)
+
+		compiler := jsonschema.NewCompiler()
+		if err = compiler.AddResource(url, bytes.NewReader(raw)); err != nil {
+			return errkit.Concat(ErrSchemaSource, fmt.Errorf("schema of %s: %w", app, err))
+		}
+		if schemas[app], err = compiler.Compile(url); err != nil {
+			return errkit.Concat(ErrSchemaSource, fmt.Errorf("schema of %s: %w", app, err))
+		}
+	}
+
+	v.schemas.Store(schemas)
+	return nil
+}
+
+func (v *SchemaValidator) read() ([]byte, error) {
+	if !strings.HasPrefix(v.config.Source, "http://") && !strings.HasPrefix(v.config.Source, "https://") {
+		return os.ReadFile(v.config.Source)
+	}
+
+	resp, err := v.client.Get(v.config.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returns %s", v.config.Source, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Validate returns the violations of content against the schema of app, sorted by path
+func (v *SchemaValidator) Validate(app string, content *stpb.Struct) (violations []SchemaViolation) {
+	schemas, _ := v.schemas.Load().(map[string]*jsonschema.Schema)
+	schema, ok := schemas[app]
+	if !ok {
+		return nil
+	}
+
+	err := schema.Validate(content.AsMap())
+	if verr, ok := err.(*jsonschema.ValidationError); ok {
+		violations = schemaViolations(verr, violations)
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return
+}
+
+// CheckTimber validates timber content of app, and handles the violations according to the mode
+func (v *SchemaValidator) CheckTimber(app string, timber *pb.Timber) *ValidationError {
+	violations := v.Validate(app, timber.GetContent())
+	if len(violations) == 0 {
+		return nil
+	}
+
+	for _, violation := range violations {
+		prome.IncreaseProducerSchemaViolation(app, violation.Path)
+	}
+
+	switch v.config.Mode {
+	case SchemaModeEnforce:
+		return newValidationError(ValidationReasonSchemaViolation,
+			"content violates the schema of %s: %s", app, violations[0])
+	case SchemaModeAnnotate:
+		annotations := make([]*stpb.Value, len(violations))
+		for i, violation := range violations {
+			annotations[i] = &stpb.Value{Kind: &stpb.Value_StringValue{StringValue: violation.String()}}
+		}
+		timber.Content.Fields[SchemaViolationsField] = &stpb.Value{
+			Kind: &stpb.Value_ListValue{ListValue: &stpb.ListValue{Values: annotations}},
+		}
+	}
+	return nil
+}
+
+// CheckTimberCollection checks every item, the whole collection is rejected when an item is rejected
+func (v *SchemaValidator) CheckTimberCollection(app string, timberCollection *pb.TimberCollection) *ValidationError {
+	for i, timber := range timberCollection.GetItems() {
+		if err := v.CheckTimber(app, timber); err != nil {
+			err.Message = fmt.Sprintf("items[%d]: %s", i, err.Message)
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaViolations collects the leaf errors of err, the causes of a leaf error are not reported by the schema
+func schemaViolations(err *jsonschema.ValidationError, violations []SchemaViolation) []SchemaViolation {
+	if len(err.Causes) == 0 {
+		return append(violations, SchemaViolation{
+			Path:    schemaFieldPath(err.InstanceLocation),
+			Message: err.Message,
+		})
+	}
+
+	for _, cause := range err.Causes {
+		violations = schemaViolations(cause, violations)
+	}
+	return violations
+}
+
+// schemaFieldPath converts JSON pointer into dotted field path, array indexes are replaced by '*' to keep the metric cardinality low
+func schemaFieldPath(pointer string) string {
+	if pointer == "" {
+		return SchemaRootPath
+	}
+
+	segments := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, segment := range segments {
+		if schemaArrayIndexPattern.MatchString(segment) {
+			segment = "*"
+		}
+		segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+	}
+	return strings.Join(segments, ".")
+}
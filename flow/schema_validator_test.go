@@ -0,0 +1,150 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const sampleSchemas = `{
+	"some_topic": {
+		"type": "object",
+		"required": ["message"],
+		"properties": {
+			"message": {"type": "string"},
+			"status": {"type": "integer"},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}
+}`
+
+func newSchemaTestValidator(t *testing.T, mode string) *SchemaValidator {
+	source := filepath.Join(t.TempDir(), "schemas.json")
+	FatalIfError(t, os.WriteFile(source, []byte(sampleSchemas), 0644))
+
+	v, err := NewSchemaValidator(SchemaConfig{Mode: mode, Source: source})
+	FatalIfError(t, err)
+	return v
+}
+
+func violatingTimber() *pb.Timber {
+	timber := pb.SampleTimberProto()
+	timber.Content.Fields["status"] = &stpb.Value{Kind: &stpb.Value_StringValue{StringValue: "500"}}
+	return timber
+}
+
+func TestNewSchemaValidator_Error(t *testing.T) {
+	_, err := NewSchemaValidator(SchemaConfig{Mode: "drop"})
+	FatalIfWrongError(t, err, string(ErrSchemaMode)+": drop")
+
+	source := filepath.Join(t.TempDir(), "schemas.json")
+	FatalIfError(t, os.WriteFile(source, []byte(`{"some_topic": {"type": 12}}`), 0644))
+	_, err = NewSchemaValidator(SchemaConfig{Mode: SchemaModeEnforce, Source: source})
+	FatalIf(t, err == nil || !strings.HasPrefix(err.Error(), string(ErrSchemaSource)), "expected schema source error, got %v", err)
+}
+
+func TestSchemaValidator_Validate(t *testing.T) {
+	v := newSchemaTestValidator(t, SchemaModeMetrics)
+
+	content := &stpb.Struct{Fields: map[string]*stpb.Value{
+		"status": {Kind: &stpb.Value_StringValue{StringValue: "500"}},
+		"tags": {Kind: &stpb.Value_ListValue{ListValue: &stpb.ListValue{Values: []*stpb.Value{
+			{Kind: &stpb.Value_StringValue{StringValue: "ok"}},
+			{Kind: &stpb.Value_NumberValue{NumberValue: 1}},
+		}}}},
+	}}
+
+	violations := v.Validate("some_topic", content)
+	var paths []string
+	for _, violation := range violations {
+		paths = append(paths, violation.Path)
+	}
+	FatalIf(t, strings.Join(paths, ",") != "$,status,tags.*", "wrong violations %v", violations)
+
+	FatalIf(t, len(v.Validate("some_topic", pb.SampleTimberProto().GetContent())) != 0, "valid content should have no violation")
+	FatalIf(t, len(v.Validate("other_topic", content)) != 0, "app without schema should not be validated")
+}
+
+func TestSchemaValidator_CheckTimber(t *testing.T) {
+	resetPrometheusMetrics()
+
+	verr := newSchemaTestValidator(t, SchemaModeEnforce).CheckTimber("some_topic", violatingTimber())
+	FatalIf(t, verr == nil || verr.Reason != ValidationReasonSchemaViolation, "enforce mode should reject, got %v", verr)
+
+	timber := violatingTimber()
+	FatalIf(t, newSchemaTestValidator(t, SchemaModeAnnotate).CheckTimber("some_topic", timber) != nil, "annotate mode should not reject")
+	annotations := timber.GetContent().GetFields()[SchemaViolationsField].GetListValue().GetValues()
+	FatalIf(t, len(annotations) != 1 || !strings.HasPrefix(annotations[0].GetStringValue(), "status: "), "wrong annotations %v", annotations)
+
+	timber = violatingTimber()
+	FatalIf(t, newSchemaTestValidator(t, SchemaModeMetrics).CheckTimber("some_topic", timber) != nil, "metrics mode should not reject")
+	_, annotated := timber.GetContent().GetFields()[SchemaViolationsField]
+	FatalIf(t, annotated, "metrics mode should not annotate")
+
+	expected := `
+		# HELP barito_producer_schema_violation_total Number of content fields violating the JSON schema of their app
+		# TYPE barito_producer_schema_violation_total counter
+		barito_producer_schema_violation_total{app="some_topic",path="status"} 3
+	`
+	FatalIfError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "barito_producer_schema_violation_total"))
+}
+
+func TestSchemaValidator_Load_Http(t *testing.T) {
+	schemas := sampleSchemas
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if schemas == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(schemas))
+	}))
+	defer server.Close()
+
+	v, err := NewSchemaValidator(SchemaConfig{Mode: SchemaModeEnforce, Source: server.URL})
+	FatalIfError(t, err)
+	FatalIf(t, v.CheckTimber("some_topic", violatingTimber()) == nil, "schema should be loaded from url")
+
+	schemas = `{}`
+	FatalIfError(t, v.Load())
+	FatalIf(t, v.CheckTimber("some_topic", violatingTimber()) != nil, "reloaded schemas should be used")
+
+	schemas = ""
+	FatalIf(t, v.Load() == nil, "expected error on failed reload")
+	FatalIf(t, v.CheckTimber("some_topic", violatingTimber()) != nil, "schemas should be kept on failed reload")
+}
+
+func TestProducerService_ProduceBatch_SchemaEnforce(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	srv := &producerService{
+		producer:    mock.NewMockSyncProducer(ctrl),
+		topicSuffix: "_logs",
+		admin:       mock.NewMockKafkaAdmin(ctrl),
+		limiter:     NewDummyRateLimiter(),
+		schema:      newSchemaTestValidator(t, SchemaModeEnforce),
+	}
+
+	timberCollection := pb.SampleTimberCollectionProto()
+	timberCollection.Items[1] = violatingTimber()
+
+	_, err := srv.ProduceBatch(context.Background(), timberCollection)
+	FatalIf(t, status.Code(err) != codes.InvalidArgument, "expected InvalidArgument, got %v", err)
+	FatalIf(t, !strings.Contains(err.Error(), "items[1]: content violates the schema of some_topic: status"), "wrong error %v", err)
+}
@@ -0,0 +1,50 @@
+package flow
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	pb "github.com/bentol/barito-proto/producer"
+)
+
+// SharedTopicConfig routes apps with small TPS into a few shared topics instead of one topic per app.
+// The app identity is kept in the timber context, so consumer can still route each record to its own index.
+// Shared topic mode is disabled when Count is zero.
+type SharedTopicConfig struct {
+	Count             int
+	MaxTps            int32
+	Name              string
+	Partitions        int32
+	ReplicationFactor int32
+}
+
+// Route returns the shared topic of the app, ok is false when the app should use its own topic
+func (c SharedTopicConfig) Route(timberContext *pb.TimberContext, topicPrefix, topicSuffix string) (topic string, ok bool) {
+	if c.Count <= 0 {
+		return "", false
+	}
+
+	tps := timberContext.GetAppMaxTps()
+	if tps <= 0 || tps > c.MaxTps {
+		return "", false
+	}
+
+	return c.topicOf(timberContext.GetKafkaTopic(), topicPrefix, topicSuffix), true
+}
+
+// topicOf returns the shared topic which the app is hashed into
+func (c SharedTopicConfig) topicOf(app, topicPrefix, topicSuffix string) string {
+	h := fnv.New32a()
+	h.Write([]byte(app))
+	index := h.Sum32() % uint32(c.Count)
+
+	return fmt.Sprintf("%s%s_%d%s", topicPrefix, c.Name, index, topicSuffix)
+}
+
+// TopicContext returns the context used when creating shared topics
+func (c SharedTopicConfig) TopicContext() *pb.TimberContext {
+	return &pb.TimberContext{
+		KafkaPartition:         c.Partitions,
+		KafkaReplicationFactor: c.ReplicationFactor,
+	}
+}
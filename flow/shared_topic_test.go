@@ -0,0 +1,39 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+)
+
+func TestSharedTopicConfig_Route(t *testing.T) {
+	config := SharedTopicConfig{Count: 4, MaxTps: 10, Name: "shared"}
+
+	timberContext := pb.SampleTimberContextProto()
+	topic, ok := config.Route(timberContext, "prefix_", "_logs")
+	FatalIf(t, !ok, "app with small tps should use shared topic")
+
+	again, _ := config.Route(timberContext, "prefix_", "_logs")
+	FatalIf(t, topic != again, "app should always be routed to the same shared topic")
+
+	found := false
+	for i := 0; i < config.Count; i++ {
+		if topic == fmt.Sprintf("prefix_shared_%d_logs", i) {
+			found = true
+		}
+	}
+	FatalIf(t, !found, "unexpected shared topic %s", topic)
+
+	timberContext.AppMaxTps = 11
+	_, ok = config.Route(timberContext, "prefix_", "_logs")
+	FatalIf(t, ok, "app with big tps should use its own topic")
+
+	timberContext.AppMaxTps = 0
+	_, ok = config.Route(timberContext, "prefix_", "_logs")
+	FatalIf(t, ok, "app without tps should use its own topic")
+
+	_, ok = SharedTopicConfig{}.Route(pb.SampleTimberContextProto(), "prefix_", "_logs")
+	FatalIf(t, ok, "shared topic mode should be disabled by default")
+}
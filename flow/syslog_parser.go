@@ -0,0 +1,245 @@
+package flow
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/go-boilerplate/errkit"
+)
+
+const (
+	ErrSyslogPriority       = errkit.Error("Syslog message has invalid priority")
+	ErrSyslogHeader         = errkit.Error("Syslog message has invalid header")
+	ErrSyslogStructuredData = errkit.Error("Syslog message has invalid structured data")
+
+	syslogNilValue = "-"
+)
+
+var syslogSeverityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var syslogFacilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// SyslogMessage is a parsed RFC 5424 or RFC 3164 message, empty string means the field is not set
+type SyslogMessage struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+func (m *SyslogMessage) FacilityName() string {
+	if m.Facility >= 0 && m.Facility < len(syslogFacilityNames) {
+		return syslogFacilityNames[m.Facility]
+	}
+	return strconv.Itoa(m.Facility)
+}
+
+func (m *SyslogMessage) SeverityName() string {
+	if m.Severity >= 0 && m.Severity < len(syslogSeverityNames) {
+		return syslogSeverityNames[m.Severity]
+	}
+	return strconv.Itoa(m.Severity)
+}
+
+// ParseSyslog parses RFC 5424 message, and falls back to RFC 3164 when there is no version after the priority
+func ParseSyslog(b []byte) (*SyslogMessage, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+
+	m := &SyslogMessage{}
+	rest, err := parseSyslogPriority(b, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+		err = parseRFC5424(rest[2:], m)
+	} else {
+		parseRFC3164(rest, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseSyslogPriority(b []byte, m *SyslogMessage) ([]byte, error) {
+	if len(b) < 3 || b[0] != '<' {
+		return nil, ErrSyslogPriority
+	}
+
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return nil, ErrSyslogPriority
+	}
+
+	// PRI is 1 to 3 digits without sign, from 0 to 191
+	pri := 0
+	for _, c := range b[1:end] {
+		if c < '0' || c > '9' {
+			return nil, ErrSyslogPriority
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	if pri > 191 {
+		return nil, ErrSyslogPriority
+	}
+
+	m.Facility = pri / 8
+	m.Severity = pri % 8
+	return b[end+1:], nil
+}
+
+// parseRFC5424 parses everything after "<PRI>VERSION "
+func parseRFC5424(b []byte, m *SyslogMessage) (err error) {
+	fields := make([]string, 5)
+	for i := range fields {
+		end := bytes.IndexByte(b, ' ')
+		if end < 0 {
+			return ErrSyslogHeader
+		}
+		fields[i] = string(b[:end])
+		b = b[end+1:]
+	}
+
+	if fields[0] != syslogNilValue {
+		m.Timestamp, err = time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return errkit.Concat(ErrSyslogHeader, err)
+		}
+	}
+	m.Hostname = syslogValue(fields[1])
+	m.AppName = syslogValue(fields[2])
+	m.ProcID = syslogValue(fields[3])
+	m.MsgID = syslogValue(fields[4])
+
+	b, err = parseSyslogStructuredData(b, m)
+	if err != nil {
+		return
+	}
+
+	if len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	m.Message = strings.TrimPrefix(string(b), "\ufeff")
+	return
+}
+
+func parseSyslogStructuredData(b []byte, m *SyslogMessage) ([]byte, error) {
+	if len(b) > 0 && b[0] == '-' {
+		return b[1:], nil
+	}
+
+	for len(b) > 0 && b[0] == '[' {
+		end := bytes.IndexAny(b, " ]")
+		if end < 0 {
+			return nil, ErrSyslogStructuredData
+		}
+		id := string(b[1:end])
+		params := map[string]string{}
+		b = b[end:]
+
+		for len(b) > 0 && b[0] == ' ' {
+			b = b[1:]
+			eq := bytes.IndexByte(b, '=')
+			if eq < 1 || len(b) < eq+2 || b[eq+1] != '"' {
+				return nil, ErrSyslogStructuredData
+			}
+			name := string(b[:eq])
+			value, n, ok := parseSyslogParamValue(b[eq+2:])
+			if !ok {
+				return nil, ErrSyslogStructuredData
+			}
+			params[name] = value
+			b = b[eq+2+n:]
+		}
+
+		if len(b) == 0 || b[0] != ']' {
+			return nil, ErrSyslogStructuredData
+		}
+		b = b[1:]
+
+		if m.StructuredData == nil {
+			m.StructuredData = map[string]map[string]string{}
+		}
+		m.StructuredData[id] = params
+	}
+
+	return b, nil
+}
+
+// parseSyslogParamValue reads a quoted param value, returns the unescaped value and the number of bytes consumed
+func parseSyslogParamValue(b []byte) (string, int, bool) {
+	var sb strings.Builder
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			if i+1 < len(b) && (b[i+1] == '"' || b[i+1] == '\\' || b[i+1] == ']') {
+				i++
+			}
+			sb.WriteByte(b[i])
+		case '"':
+			return sb.String(), i + 1, true
+		default:
+			sb.WriteByte(b[i])
+		}
+	}
+	return "", 0, false
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG", anything that does not look like
+// the header is kept as message as recommended by RFC 3164
+func parseRFC3164(b []byte, m *SyslogMessage) {
+	const stampLength = len(time.Stamp)
+
+	if len(b) > stampLength && b[stampLength] == ' ' {
+		if ts, err := time.Parse(time.Stamp, string(b[:stampLength])); err == nil {
+			now := time.Now()
+			m.Timestamp = ts.AddDate(now.Year(), 0, 0)
+			// message from the last days of previous year
+			if m.Timestamp.After(now.Add(24 * time.Hour)) {
+				m.Timestamp = m.Timestamp.AddDate(-1, 0, 0)
+			}
+			b = b[stampLength+1:]
+
+			if end := bytes.IndexByte(b, ' '); end > 0 {
+				m.Hostname = string(b[:end])
+				b = b[end+1:]
+			}
+		}
+	}
+
+	if end := bytes.IndexAny(b, ":[ "); end > 0 && end <= 48 {
+		tag, rest, procID := b[:end], b[end:], ""
+		if rest[0] == '[' {
+			if pidEnd := bytes.IndexByte(rest, ']'); pidEnd > 0 {
+				procID = string(rest[1:pidEnd])
+				rest = rest[pidEnd+1:]
+			}
+		}
+		if len(rest) > 0 && rest[0] == ':' {
+			m.AppName = string(tag)
+			m.ProcID = procID
+			b = bytes.TrimPrefix(rest[1:], []byte(" "))
+		}
+	}
+
+	m.Message = string(b)
+}
+
+func syslogValue(s string) string {
+	if s == syslogNilValue {
+		return ""
+	}
+	return s
+}
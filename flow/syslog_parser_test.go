@@ -0,0 +1,85 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+)
+
+func TestParseSyslog_RFC5424(t *testing.T) {
+	m, err := ParseSyslog([]byte(`<165>1 2024-06-01T12:00:00.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high \"quoted\""] An application event log entry` + "\n"))
+	FatalIfError(t, err)
+
+	FatalIf(t, m.FacilityName() != "local4", "wrong facility %s", m.FacilityName())
+	FatalIf(t, m.SeverityName() != "notice", "wrong severity %s", m.SeverityName())
+	FatalIf(t, !m.Timestamp.Equal(time.Date(2024, 6, 1, 12, 0, 0, 3000000, time.UTC)), "wrong timestamp %s", m.Timestamp)
+	FatalIf(t, m.Hostname != "mymachine.example.com", "wrong hostname %s", m.Hostname)
+	FatalIf(t, m.AppName != "evntslog", "wrong app name %s", m.AppName)
+	FatalIf(t, m.ProcID != "", "nil proc id should be empty, got %s", m.ProcID)
+	FatalIf(t, m.MsgID != "ID47", "wrong msg id %s", m.MsgID)
+	FatalIf(t, m.StructuredData["exampleSDID@32473"]["eventSource"] != "Application", "wrong structured data %v", m.StructuredData)
+	FatalIf(t, m.StructuredData["examplePriority@32473"]["class"] != `high "quoted"`, "wrong escaped param %v", m.StructuredData)
+	FatalIf(t, m.Message != "An application event log entry", "wrong message %q", m.Message)
+}
+
+func TestParseSyslog_RFC5424_NilValues(t *testing.T) {
+	m, err := ParseSyslog([]byte(`<34>1 - - - - - -`))
+	FatalIfError(t, err)
+	FatalIf(t, !m.Timestamp.IsZero(), "timestamp should be empty")
+	FatalIf(t, m.Hostname != "" || m.AppName != "" || m.Message != "", "fields should be empty")
+	FatalIf(t, m.StructuredData != nil, "structured data should be empty")
+}
+
+func TestParseSyslog_RFC3164(t *testing.T) {
+	m, err := ParseSyslog([]byte(`<34>Oct 11 22:14:15 mymachine su[1234]: 'su root' failed for lonvick on /dev/pts/8`))
+	FatalIfError(t, err)
+
+	FatalIf(t, m.FacilityName() != "auth", "wrong facility %s", m.FacilityName())
+	FatalIf(t, m.SeverityName() != "crit", "wrong severity %s", m.SeverityName())
+	FatalIf(t, m.Timestamp.Month() != time.October || m.Timestamp.Day() != 11, "wrong timestamp %s", m.Timestamp)
+	FatalIf(t, m.Hostname != "mymachine", "wrong hostname %s", m.Hostname)
+	FatalIf(t, m.AppName != "su", "wrong app name %s", m.AppName)
+	FatalIf(t, m.ProcID != "1234", "wrong proc id %s", m.ProcID)
+	FatalIf(t, m.Message != "'su root' failed for lonvick on /dev/pts/8", "wrong message %q", m.Message)
+}
+
+func TestParseSyslog_RFC3164_WithoutHeader(t *testing.T) {
+	m, err := ParseSyslog([]byte(`<13>link down on port 3`))
+	FatalIfError(t, err)
+	FatalIf(t, m.Hostname != "" || m.AppName != "", "header should be empty")
+	FatalIf(t, m.Message != "link down on port 3", "wrong message %q", m.Message)
+}
+
+func TestParseSyslog_Invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		msg  string
+		err  string
+	}{
+		{"missing priority", "some message", string(ErrSyslogPriority)},
+		{"priority out of range", "<192>some message", string(ErrSyslogPriority)},
+		{"negative priority", "<-1>hello", string(ErrSyslogPriority)},
+		{"negative priority below severity", "<-9>hello", string(ErrSyslogPriority)},
+		{"signed priority", "<+1>hello", string(ErrSyslogPriority)},
+		{"truncated header", "<34>1 2024-06-01T12:00:00Z host", string(ErrSyslogHeader)},
+		{"invalid timestamp", "<34>1 yesterday host app - - - msg", string(ErrSyslogHeader)},
+		{"unterminated structured data", `<34>1 - host app - - [id a="b" msg`, string(ErrSyslogStructuredData)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseSyslog([]byte(tc.msg))
+			FatalIf(t, err == nil, "expected error")
+			FatalIf(t, len(err.Error()) < len(tc.err) || err.Error()[:len(tc.err)] != tc.err, "expected %s, got %s", tc.err, err)
+		})
+	}
+}
+
+func TestSyslogMessage_Names(t *testing.T) {
+	m := &SyslogMessage{Facility: 20, Severity: 5}
+	FatalIf(t, m.FacilityName() != "local4" || m.SeverityName() != "notice", "wrong names %s %s", m.FacilityName(), m.SeverityName())
+
+	m = &SyslogMessage{Facility: -1, Severity: -1}
+	FatalIf(t, m.FacilityName() != "-1" || m.SeverityName() != "-1", "out of range names should be the number, got %s %s", m.FacilityName(), m.SeverityName())
+}
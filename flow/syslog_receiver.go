@@ -0,0 +1,309 @@
+package flow
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	pb "github.com/bentol/barito-proto/producer"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ErrSyslogRule    = errkit.Error("Invalid syslog rule")
+	ErrSyslogFraming = errkit.Error("Invalid syslog octet counting frame")
+
+	SyslogTransportUDP = "udp"
+	SyslogTransportTCP = "tcp"
+	SyslogTransportTLS = "tls"
+
+	SyslogResultAccepted   = "accepted"
+	SyslogResultParseError = "parse_error"
+	SyslogResultNoApp      = "no_app"
+	SyslogResultRejected   = "rejected"
+
+	syslogMaxMessageBytes = 64 * 1024
+	syslogMaxUDPWorkers   = 64
+	syslogHostRulePrefix  = "host:"
+	syslogAppRulePrefix   = "app:"
+)
+
+// SyslogRule maps syslog source into app, the first matching rule wins.
+// A rule matches by source network, or by glob pattern of hostname or app-name of the message.
+type SyslogRule struct {
+	Network  *net.IPNet
+	Hostname string
+	AppName  string
+	App      string
+}
+
+// ParseSyslogRule parses "<cidr>=<app>", "host:<glob>=<app>" or "app:<glob>=<app>"
+func ParseSyslogRule(s string) (rule SyslogRule, err error) {
+	i := strings.LastIndex(s, "=")
+	if i < 1 || i == len(s)-1 {
+		err = errkit.Concat(ErrSyslogRule, errkit.Error(s))
+		return
+	}
+	matcher, app := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	rule.App = app
+
+	switch {
+	case strings.HasPrefix(matcher, syslogHostRulePrefix):
+		rule.Hostname = strings.TrimPrefix(matcher, syslogHostRulePrefix)
+	case strings.HasPrefix(matcher, syslogAppRulePrefix):
+		rule.AppName = strings.TrimPrefix(matcher, syslogAppRulePrefix)
+	default:
+		_, rule.Network, err = net.ParseCIDR(matcher)
+		if err != nil {
+			err = errkit.Concat(ErrSyslogRule, err)
+		}
+	}
+	return
+}
+
+func (r SyslogRule) match(source net.IP, m *SyslogMessage) bool {
+	switch {
+	case r.Network != nil:
+		return source != nil && r.Network.Contains(source)
+	case r.Hostname != "":
+		ok, _ := path.Match(r.Hostname, m.Hostname)
+		return ok
+	case r.AppName != "":
+		ok, _ := path.Match(r.AppName, m.AppName)
+		return ok
+	}
+	return false
+}
+
+// SyslogConfig enables the syslog listeners of the producer, empty address disables the listener
+type SyslogConfig struct {
+	UDPAddr    string
+	TCPAddr    string
+	TLSAddr    string
+	TLSConfig  *tls.Config
+	Rules      []SyslogRule
+	DefaultApp string
+	AppMaxTps  int32
+}
+
+// syslogReceiver accepts syslog messages and writes them through Produce
+type syslogReceiver struct {
+	connServer
+	producer *producerService
+	config   SyslogConfig
+}
+
+func newSyslogReceiver(producer *producerService, config SyslogConfig) *syslogReceiver {
+	var peerGuard *PeerGuard
+	if producer != nil {
+		peerGuard = producer.peerGuard
+	}
+
+	return &syslogReceiver{
+		connServer: newConnServer("Syslog", peerGuard),
+		producer:   producer,
+		config:     config,
+	}
+}
+
+// Start listens to every configured address and serves them in background
+func (r *syslogReceiver) Start() (err error) {
+	if r.config.UDPAddr != "" {
+		var conn net.PacketConn
+		conn, err = net.ListenPacket("udp", r.config.UDPAddr)
+		if err != nil {
+			return
+		}
+		r.track(conn)
+		r.wg.Add(1)
+		go r.serveUDP(conn)
+	}
+
+	if r.config.TCPAddr != "" {
+		var lis net.Listener
+		lis, err = net.Listen("tcp", r.config.TCPAddr)
+		if err != nil {
+			return
+		}
+		r.serveListener(lis, SyslogTransportTCP, r.serveConn)
+	}
+
+	if r.config.TLSAddr != "" {
+		var lis net.Listener
+		lis, err = tls.Listen("tcp", r.config.TLSAddr, r.config.TLSConfig)
+		if err != nil {
+			return
+		}
+		r.serveListener(lis, SyslogTransportTLS, r.serveConn)
+	}
+
+	return
+}
+
+func (r *syslogReceiver) serveConn(conn net.Conn, transport string) {
+	source := sourceIP(conn.RemoteAddr())
+	reader := bufio.NewReaderSize(conn, syslogMaxMessageBytes)
+	for {
+		frame, err := readSyslogFrame(reader)
+		if len(frame) > 0 {
+			r.handle(frame, source, transport)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("Syslog connection from %s closed: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+func (r *syslogReceiver) serveUDP(conn net.PacketConn) {
+	defer r.wg.Done()
+
+	workers := make(chan struct{}, syslogMaxUDPWorkers)
+	buf := make([]byte, syslogMaxMessageBytes)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			r.logListenerError(SyslogTransportUDP, err)
+			return
+		}
+
+		frame := make([]byte, n)
+		copy(frame, buf[:n])
+
+		workers <- struct{}{}
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			defer func() { <-workers }()
+			r.handle(frame, sourceIP(addr), SyslogTransportUDP)
+		}()
+	}
+}
+
+func (r *syslogReceiver) handle(frame []byte, source net.IP, transport string) {
+	m, err := ParseSyslog(frame)
+	if err != nil {
+		prome.IncreaseProducerSyslogMessage(transport, SyslogResultParseError)
+		log.Debugf("Failed to parse syslog message from %s: %s", source, err)
+		return
+	}
+
+	app := r.app(source, m)
+	if app == "" {
+		prome.IncreaseProducerSyslogMessage(transport, SyslogResultNoApp)
+		return
+	}
+
+	timber := ConvertSyslogToTimber(m, source)
+	timber.Context = newAppTimberContext(app, r.config.AppMaxTps)
+
+	if _, err = r.producer.Produce(context.Background(), timber); err != nil {
+		prome.IncreaseProducerSyslogMessage(transport, SyslogResultRejected)
+		return
+	}
+	prome.IncreaseProducerSyslogMessage(transport, SyslogResultAccepted)
+}
+
+func (r *syslogReceiver) app(source net.IP, m *SyslogMessage) string {
+	for _, rule := range r.config.Rules {
+		if rule.match(source, m) {
+			return rule.App
+		}
+	}
+	return r.config.DefaultApp
+}
+
+// readSyslogFrame reads one message with octet counting framing, and falls back to
+// non-transparent framing (one message per line) when the frame does not start with a digit
+func readSyslogFrame(reader *bufio.Reader) ([]byte, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] < '0' || first[0] > '9' {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = ErrSyslogFraming
+		}
+		return append([]byte(nil), line...), err
+	}
+
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil || n <= 0 || n > syslogMaxMessageBytes {
+		return nil, ErrSyslogFraming
+	}
+
+	frame := make([]byte, n)
+	_, err = io.ReadFull(reader, frame)
+	return frame, err
+}
+
+// ConvertSyslogToTimber converts m into timber content, context is left empty
+func ConvertSyslogToTimber(m *SyslogMessage, source net.IP) *pb.Timber {
+	fields := map[string]*stpb.Value{
+		"@message": stringValue(m.Message),
+		"severity": stringValue(m.SeverityName()),
+		"facility": stringValue(m.FacilityName()),
+	}
+
+	if !m.Timestamp.IsZero() {
+		fields["@timestamp"] = stringValue(m.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	if source != nil {
+		fields["source"] = stringValue(source.String())
+	}
+
+	optional := map[string]string{
+		"hostname": m.Hostname,
+		"app_name": m.AppName,
+		"proc_id":  m.ProcID,
+		"msg_id":   m.MsgID,
+	}
+	for key, value := range optional {
+		if value != "" {
+			fields[key] = stringValue(value)
+		}
+	}
+
+	if len(m.StructuredData) > 0 {
+		sd := &stpb.Struct{Fields: map[string]*stpb.Value{}}
+		for id, params := range m.StructuredData {
+			s := &stpb.Struct{Fields: map[string]*stpb.Value{}}
+			for name, value := range params {
+				s.Fields[name] = stringValue(value)
+			}
+			sd.Fields[id] = structValue(s)
+		}
+		fields["structured_data"] = structValue(sd)
+	}
+
+	return &pb.Timber{
+		Content: &stpb.Struct{Fields: fields},
+	}
+}
+
+func sourceIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return net.ParseIP(hostOf(addr))
+}
@@ -0,0 +1,142 @@
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestParseSyslogRule(t *testing.T) {
+	rule, err := ParseSyslogRule("10.0.0.0/8=network-gear")
+	FatalIfError(t, err)
+	FatalIf(t, rule.App != "network-gear", "wrong app %s", rule.App)
+	FatalIf(t, !rule.match(net.ParseIP("10.1.2.3"), &SyslogMessage{}), "network rule should match")
+	FatalIf(t, rule.match(net.ParseIP("192.168.1.1"), &SyslogMessage{}), "network rule should not match")
+
+	rule, err = ParseSyslogRule("host:db-*=legacy-db")
+	FatalIfError(t, err)
+	FatalIf(t, !rule.match(nil, &SyslogMessage{Hostname: "db-01"}), "hostname rule should match")
+
+	rule, err = ParseSyslogRule("app:sshd=auth-log")
+	FatalIfError(t, err)
+	FatalIf(t, !rule.match(nil, &SyslogMessage{AppName: "sshd"}), "app name rule should match")
+
+	_, err = ParseSyslogRule("network-gear")
+	FatalIfWrongError(t, err, string(ErrSyslogRule)+": network-gear")
+
+	_, err = ParseSyslogRule("10.0.0.0=network-gear")
+	FatalIf(t, err == nil, "expected invalid cidr error")
+}
+
+func TestSyslogReceiver_app(t *testing.T) {
+	r := newSyslogReceiver(nil, SyslogConfig{
+		Rules: []SyslogRule{
+			{Hostname: "db-*", App: "legacy-db"},
+			{AppName: "*", App: "other"},
+		},
+	})
+
+	FatalIf(t, r.app(nil, &SyslogMessage{Hostname: "db-01", AppName: "postgres"}) != "legacy-db", "first matching rule should win")
+	FatalIf(t, r.app(nil, &SyslogMessage{Hostname: "web-01", AppName: "nginx"}) != "other", "second rule should match")
+
+	r = newSyslogReceiver(nil, SyslogConfig{DefaultApp: "syslog"})
+	FatalIf(t, r.app(nil, &SyslogMessage{}) != "syslog", "default app should be used")
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	msg := "<34>1 - host app - - - hello\nworld"
+	input := fmt.Sprintf("%d %s<13>plain line\n", len(msg), msg)
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	frame, err := readSyslogFrame(reader)
+	FatalIfError(t, err)
+	FatalIf(t, string(frame) != msg, "octet counted frame may contain newline, got %q", frame)
+
+	frame, err = readSyslogFrame(reader)
+	FatalIfError(t, err)
+	FatalIf(t, string(frame) != "<13>plain line\n", "wrong non-transparent frame %q", frame)
+
+	_, err = readSyslogFrame(bufio.NewReader(strings.NewReader("99999999 <13>too long")))
+	FatalIfWrongError(t, err, string(ErrSyslogFraming))
+}
+
+func TestConvertSyslogToTimber(t *testing.T) {
+	m, _ := ParseSyslog([]byte(`<165>1 2024-06-01T12:00:00Z host app 42 ID47 [meta a="b"] some message`))
+
+	timber := ConvertSyslogToTimber(m, net.ParseIP("10.0.0.1"))
+	fields := timber.GetContent().GetFields()
+
+	FatalIf(t, fields["@message"].GetStringValue() != "some message", "wrong @message")
+	FatalIf(t, fields["@timestamp"].GetStringValue() != "2024-06-01T12:00:00Z", "wrong @timestamp")
+	FatalIf(t, fields["severity"].GetStringValue() != "notice", "wrong severity")
+	FatalIf(t, fields["facility"].GetStringValue() != "local4", "wrong facility")
+	FatalIf(t, fields["hostname"].GetStringValue() != "host", "wrong hostname")
+	FatalIf(t, fields["app_name"].GetStringValue() != "app", "wrong app_name")
+	FatalIf(t, fields["proc_id"].GetStringValue() != "42", "wrong proc_id")
+	FatalIf(t, fields["source"].GetStringValue() != "10.0.0.1", "wrong source")
+	FatalIf(t, fields["structured_data"].GetStructValue().GetFields()["meta"].GetStructValue().GetFields()["a"].GetStringValue() != "b", "wrong structured_data")
+}
+
+func TestSyslogReceiver_TCP(t *testing.T) {
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+
+	sent := make(chan *sarama.ProducerMessage, 2)
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).Times(2).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		sent <- msg
+		return 0, 0, nil
+	})
+
+	srv := &producerService{
+		producer:    producer,
+		topicSuffix: "_logs",
+		admin:       admin,
+		limiter:     NewDummyRateLimiter(),
+	}
+	srv.syslog = newSyslogReceiver(srv, SyslogConfig{
+		TCPAddr:    "127.0.0.1:0",
+		DefaultApp: "network-gear",
+		AppMaxTps:  100,
+	})
+	FatalIfError(t, srv.syslog.Start())
+	defer srv.syslog.Close()
+
+	addr := srv.syslog.listeners[0].(net.Listener).Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	FatalIfError(t, err)
+
+	msg := "<34>1 - router-01 - - - - link down"
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	fmt.Fprintf(conn, "<34>Oct 11 22:14:15 router-02 kernel: link up\n")
+	conn.Close()
+
+	for _, hostname := range []string{"router-01", "router-02"} {
+		select {
+		case message := <-sent:
+			FatalIf(t, message.Topic != "network-gear_logs", "wrong topic %s", message.Topic)
+
+			b, _ := message.Value.Encode()
+			timber := &pb.Timber{}
+			FatalIfError(t, proto.Unmarshal(b, timber))
+			FatalIf(t, timber.GetContent().GetFields()["hostname"].GetStringValue() != hostname, "wrong hostname")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for syslog message from %s", hostname)
+		}
+	}
+}
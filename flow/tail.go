@@ -0,0 +1,298 @@
+package flow
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BaritoLog/barito-flow/prome"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/protobuf/jsonpb"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TailPath            = "/tail/"
+	TailAppSecretHeader = "X-Barito-App-Secret"
+
+	tailHeartbeatInterval = 15 * time.Second
+)
+
+// TailConfig enables the live tail endpoint of the producer.
+// Every tail reads the topic from the newest offset of the active kafka cluster with its own ephemeral consumer group prefixed by GroupPrefix.
+type TailConfig struct {
+	Addr          string
+	MaxConcurrent int
+	GroupPrefix   string
+}
+
+// TailFilter matches timbers whose Field, a dotted path into the content, equals Value
+type TailFilter struct {
+	Field string
+	Value string
+}
+
+// ParseTailFilter parses "<field>=<value>"
+func ParseTailFilter(s string) (filter TailFilter, ok bool) {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return
+	}
+	return TailFilter{Field: s[:i], Value: s[i+1:]}, true
+}
+
+// Match returns true when the field of content equals the filter value, numbers and booleans are compared by their text
+func (f TailFilter) Match(content *stpb.Struct) bool {
+	var value *stpb.Value
+	fields := content.GetFields()
+	for _, key := range strings.Split(f.Field, ".") {
+		value = fields[key]
+		if value == nil {
+			return false
+		}
+		fields = value.GetStructValue().GetFields()
+	}
+
+	switch kind := value.GetKind().(type) {
+	case *stpb.Value_StringValue:
+		return kind.StringValue == f.Value
+	case *stpb.Value_NumberValue:
+		return strconv.FormatFloat(kind.NumberValue, 'f', -1, 64) == f.Value
+	case *stpb.Value_BoolValue:
+		return strconv.FormatBool(kind.BoolValue) == f.Value
+	}
+	return false
+}
+
+// tailService streams the logs of an app as server-sent events while they are written to kafka.
+// A tail is authorized by the secret hash of the last logs of the app produced by this producer.
+type tailService struct {
+	producer  *producerService
+	config    TailConfig
+	slots     chan struct{}
+	marshaler *jsonpb.Marshaler
+
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func newTailService(producer *producerService, config TailConfig) *tailService {
+	return &tailService{
+		producer:  producer,
+		config:    config,
+		slots:     make(chan struct{}, config.MaxConcurrent),
+		marshaler: &jsonpb.Marshaler{},
+		secrets:   make(map[string]string),
+	}
+}
+
+// remember keeps the secret hash of the app of timberContext, so its tails can be authorized
+func (t *tailService) remember(timberContext *pb.TimberContext) {
+	app, hash := timberContext.GetKafkaTopic(), HashAppSecret(timberContext.GetAppSecret())
+	if app == "" || hash == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.secrets[app] = hash
+}
+
+// authorize returns the status rejecting the tail of app, or 0 when appSecretHash is the secret hash of app.
+// The tail is forbidden when the app did not produce through this producer yet, since its secret is unknown.
+func (t *tailService) authorize(app, appSecretHash string) int {
+	if appSecretHash == "" {
+		return http.StatusUnauthorized
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.secrets[app] != appSecretHash {
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+// tailRequest is a running tail, only records of app are streamed when the topic is shared
+type tailRequest struct {
+	app           string
+	topic         string
+	shared        bool
+	appSecretHash string
+	filters       []TailFilter
+}
+
+// ServeHTTP serves `GET /tail/<app>?filter=<field>=<value>`, the secret header must hold the secret of the app
+func (t *tailService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	app := strings.Trim(strings.TrimPrefix(r.URL.Path, TailPath), "/")
+	if app == "" || strings.Contains(app, "/") {
+		http.Error(w, "app is required", http.StatusBadRequest)
+		return
+	}
+
+	req := &tailRequest{
+		app:           app,
+		appSecretHash: HashAppSecret(r.Header.Get(TailAppSecretHeader)),
+	}
+	for _, s := range r.URL.Query()["filter"] {
+		filter, ok := ParseTailFilter(s)
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid filter %q, expected <field>=<value>", s), http.StatusBadRequest)
+			return
+		}
+		req.filters = append(req.filters, filter)
+	}
+
+	if code := t.authorize(app, req.appSecretHash); code != 0 {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	req.topic, req.shared = t.topicOf(app)
+	if req.topic == "" {
+		http.Error(w, fmt.Sprintf("topic of app %q does not exist", app), http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+		defer func() { <-t.slots }()
+	default:
+		http.Error(w, "too many concurrent tails", http.StatusTooManyRequests)
+		return
+	}
+
+	consumer, err := t.producer.activeFactory().MakeGroupConsumer(t.groupID(app), req.topic, sarama.OffsetNewest)
+	if err != nil {
+		log.Errorf("Failed to tail %s: %s", req.topic, err)
+		http.Error(w, "failed to consume the topic", http.StatusServiceUnavailable)
+		return
+	}
+	defer consumer.Close()
+
+	prome.IncreaseProducerTailActive(1)
+	defer prome.IncreaseProducerTailActive(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message, ok := <-consumer.Messages():
+			if !ok {
+				return
+			}
+			if err := t.stream(w, req, message); err != nil {
+				return
+			}
+			flusher.Flush()
+		case err, ok := <-consumer.Errors():
+			if ok {
+				log.Warnf("Error while tailing %s: %s", req.topic, err)
+			}
+		case <-consumer.Notifications():
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// topicOf returns the app topic when it exists, otherwise the shared topic of the app when shared topic mode is enabled
+func (t *tailService) topicOf(app string) (topic string, shared bool) {
+	s := t.producer
+	topic = s.topicPrefix + app + s.topicSuffix
+	if s.admin.Exist(topic) {
+		return topic, false
+	}
+
+	if s.sharedTopic.Count > 0 {
+		if topic = s.sharedTopic.topicOf(app, s.topicPrefix, s.topicSuffix); s.admin.Exist(topic) {
+			return topic, true
+		}
+	}
+	return "", false
+}
+
+func (t *tailService) groupID(app string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s", t.config.GroupPrefix, app, hex.EncodeToString(b))
+}
+
+// stream writes the matching timbers of message as server-sent events
+func (t *tailService) stream(w http.ResponseWriter, req *tailRequest, message *sarama.ConsumerMessage) error {
+	metadata := ConvertKafkaHeadersToRecordMetadata(message.Headers)
+	if req.shared && metadata.AppName != req.app {
+		return nil
+	}
+	if metadata.AppSecretHash != req.appSecretHash {
+		return nil
+	}
+
+	var timbers []*pb.Timber
+	if metadata.MessageFormat == TimberCollectionMessageFormat {
+		timberCollection, err := ConvertKafkaMessageToTimberCollection(message)
+		if err != nil {
+			return nil
+		}
+		timbers = timberCollection.GetItems()
+	} else {
+		timber, err := ConvertKafkaMessageToTimber(message)
+		if err != nil {
+			return nil
+		}
+		timbers = []*pb.Timber{&timber}
+	}
+
+	for _, timber := range timbers {
+		if !req.match(timber.GetContent()) {
+			continue
+		}
+
+		document, err := t.marshaler.MarshalToString(timber.GetContent())
+		if err != nil {
+			continue
+		}
+		if _, err = fmt.Fprintf(w, "id: %d-%d\ndata: %s\n\n", message.Partition, message.Offset, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (req *tailRequest) match(content *stpb.Struct) bool {
+	for _, filter := range req.filters {
+		if !filter.Match(content) {
+			return false
+		}
+	}
+	return true
+}
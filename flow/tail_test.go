@@ -0,0 +1,286 @@
+package flow
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BaritoLog/barito-flow/flow/types"
+	"github.com/BaritoLog/barito-flow/mock"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+)
+
+type tailTestConsumer struct {
+	messages chan *sarama.ConsumerMessage
+	closed   chan struct{}
+	topic    string
+}
+
+func newTailTestProducer(t *testing.T, config TailConfig, topicExist bool) (*producerService, *gomock.Controller, *tailTestConsumer) {
+	ctrl := gomock.NewController(t)
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(topicExist)
+
+	tc := &tailTestConsumer{
+		messages: make(chan *sarama.ConsumerMessage, 10),
+		closed:   make(chan struct{}),
+	}
+	consumer := mock.NewMockGroupConsumer(ctrl)
+	consumer.EXPECT().Messages().AnyTimes().Return(tc.messages)
+	consumer.EXPECT().Errors().AnyTimes().Return(make(chan error))
+	consumer.EXPECT().Notifications().AnyTimes().Return(make(chan *types.Notification))
+	consumer.EXPECT().Close().MaxTimes(1).DoAndReturn(func() error {
+		close(tc.closed)
+		return nil
+	})
+
+	factory := mock.NewMockKafkaFactory(ctrl)
+	factory.EXPECT().MakeGroupConsumer(gomock.Any(), gomock.Any(), sarama.OffsetNewest).AnyTimes().DoAndReturn(
+		func(groupID, topic string, initialOffset int64) (types.GroupConsumer, error) {
+			FatalIf(t, !strings.HasPrefix(groupID, config.GroupPrefix+"-"), "group should be prefixed, got %s", groupID)
+			tc.topic = topic
+			return consumer, nil
+		})
+
+	srv := &producerService{
+		factory:     factory,
+		topicSuffix: "_logs",
+		admin:       admin,
+	}
+	srv.tail = newTailService(srv, config)
+	srv.tail.remember(pb.SampleTimberContextProto())
+	return srv, ctrl, tc
+}
+
+func tailTestMessage(msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	message := producerToConsumerMessage(msg)
+	message.Value, _ = msg.Value.Encode()
+	message.Offset = offset
+	return message
+}
+
+func readTailEvent(t *testing.T, reader *bufio.Reader) (id, data string) {
+	for {
+		line, err := reader.ReadString('\n')
+		FatalIfError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestParseTailFilter(t *testing.T) {
+	filter, ok := ParseTailFilter("kubernetes.namespace=default")
+	FatalIf(t, !ok, "filter should be parsed")
+	FatalIf(t, filter.Field != "kubernetes.namespace" || filter.Value != "default", "wrong filter %v", filter)
+
+	_, ok = ParseTailFilter("=default")
+	FatalIf(t, ok, "filter without field should be rejected")
+	_, ok = ParseTailFilter("level")
+	FatalIf(t, ok, "filter without value should be rejected")
+}
+
+func TestTailFilter_Match(t *testing.T) {
+	content := &stpb.Struct{Fields: map[string]*stpb.Value{
+		"level":  {Kind: &stpb.Value_StringValue{StringValue: "error"}},
+		"status": {Kind: &stpb.Value_NumberValue{NumberValue: 500}},
+		"kubernetes": {Kind: &stpb.Value_StructValue{StructValue: &stpb.Struct{Fields: map[string]*stpb.Value{
+			"namespace": {Kind: &stpb.Value_StringValue{StringValue: "default"}},
+		}}}},
+	}}
+
+	testCases := []struct {
+		filter TailFilter
+		match  bool
+	}{
+		{TailFilter{"level", "error"}, true},
+		{TailFilter{"level", "info"}, false},
+		{TailFilter{"status", "500"}, true},
+		{TailFilter{"kubernetes.namespace", "default"}, true},
+		{TailFilter{"kubernetes.pod", "default"}, false},
+		{TailFilter{"level.name", "error"}, false},
+		{TailFilter{"kubernetes", "default"}, false},
+	}
+
+	for _, tc := range testCases {
+		FatalIf(t, tc.filter.Match(content) != tc.match, "%v: expected %v", tc.filter, tc.match)
+	}
+}
+
+func TestTailService_ServeHTTP(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, consumer := newTailTestProducer(t, TailConfig{MaxConcurrent: 1, GroupPrefix: "barito-tail"}, true)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(srv.tail)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/tail/some_topic?filter=message=some-message", nil)
+	req.Header.Set(TailAppSecretHeader, "some-secret-1234")
+	resp, err := http.DefaultClient.Do(req)
+	FatalIfError(t, err)
+	FatalIf(t, resp.StatusCode != http.StatusOK, "expected 200, got %d", resp.StatusCode)
+	FatalIf(t, resp.Header.Get("Content-Type") != "text/event-stream", "wrong content type %s", resp.Header.Get("Content-Type"))
+	FatalIf(t, consumer.topic != "some_topic_logs", "wrong topic %s", consumer.topic)
+
+	skipped := pb.SampleTimberProto()
+	skipped.Content.Fields["message"] = &stpb.Value{Kind: &stpb.Value_StringValue{StringValue: "other-message"}}
+	consumer.messages <- tailTestMessage(ConvertTimberToKafkaMessage(skipped, "some_topic_logs"), 1)
+	consumer.messages <- tailTestMessage(ConvertTimberCollectionToKafkaMessage(pb.SampleTimberCollectionProto(), "some_topic_logs"), 2)
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 2; i++ {
+		id, data := readTailEvent(t, reader)
+		FatalIf(t, id != "0-2", "expected event of offset 2, got %s", id)
+		FatalIf(t, !strings.Contains(data, `"message":"some-message"`), "wrong data %s", data)
+	}
+
+	resp.Body.Close()
+	<-consumer.closed
+}
+
+func TestTailService_ServeHTTP_AppSecret(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, consumer := newTailTestProducer(t, TailConfig{MaxConcurrent: 1, GroupPrefix: "barito-tail"}, true)
+	defer ctrl.Finish()
+
+	server := httptest.NewServer(srv.tail)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/tail/some_topic", nil)
+	req.Header.Set(TailAppSecretHeader, "some-secret-1234")
+	resp, err := http.DefaultClient.Do(req)
+	FatalIfError(t, err)
+
+	public := pb.SampleTimberProto()
+	public.Context.AppSecret = ""
+	other := pb.SampleTimberProto()
+	other.Context.AppSecret = "other-secret"
+	consumer.messages <- tailTestMessage(ConvertTimberToKafkaMessage(public, "some_topic_logs"), 1)
+	consumer.messages <- tailTestMessage(ConvertTimberToKafkaMessage(other, "some_topic_logs"), 2)
+	consumer.messages <- tailTestMessage(ConvertTimberToKafkaMessage(pb.SampleTimberProto(), "some_topic_logs"), 3)
+
+	id, _ := readTailEvent(t, bufio.NewReader(resp.Body))
+	FatalIf(t, id != "0-3", "records without the secret of the tail should be skipped, got %s", id)
+
+	resp.Body.Close()
+	<-consumer.closed
+}
+
+func TestTailService_ServeHTTP_Unauthorized(t *testing.T) {
+	testCases := []struct {
+		name   string
+		app    string
+		secret string
+		code   int
+	}{
+		{"missing secret", "some_topic", "", http.StatusUnauthorized},
+		{"wrong secret", "some_topic", "wrong-secret", http.StatusForbidden},
+		{"unknown app", "other_topic", "some-secret-1234", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, ctrl, consumer := newTailTestProducer(t, TailConfig{MaxConcurrent: 1, GroupPrefix: "barito-tail"}, true)
+			defer ctrl.Finish()
+
+			req := httptest.NewRequest(http.MethodGet, "/tail/"+tc.app, nil)
+			if tc.secret != "" {
+				req.Header.Set(TailAppSecretHeader, tc.secret)
+			}
+			rec := httptest.NewRecorder()
+			srv.tail.ServeHTTP(rec, req)
+			FatalIf(t, rec.Code != tc.code, "expected %d, got %d", tc.code, rec.Code)
+			FatalIf(t, consumer.topic != "", "rejected tail should not consume, got %s", consumer.topic)
+		})
+	}
+}
+
+func TestTailService_ServeHTTP_Error(t *testing.T) {
+	testCases := []struct {
+		name       string
+		method     string
+		target     string
+		config     TailConfig
+		topicExist bool
+		code       int
+	}{
+		{"wrong method", http.MethodPost, "/tail/some_topic", TailConfig{MaxConcurrent: 1}, true, http.StatusMethodNotAllowed},
+		{"missing app", http.MethodGet, "/tail/", TailConfig{MaxConcurrent: 1}, true, http.StatusBadRequest},
+		{"invalid filter", http.MethodGet, "/tail/some_topic?filter=level", TailConfig{MaxConcurrent: 1}, true, http.StatusBadRequest},
+		{"unknown topic", http.MethodGet, "/tail/some_topic", TailConfig{MaxConcurrent: 1}, false, http.StatusNotFound},
+		{"too many tails", http.MethodGet, "/tail/some_topic", TailConfig{MaxConcurrent: 0}, true, http.StatusTooManyRequests},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, ctrl, _ := newTailTestProducer(t, tc.config, tc.topicExist)
+			defer ctrl.Finish()
+
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			req.Header.Set(TailAppSecretHeader, "some-secret-1234")
+			rec := httptest.NewRecorder()
+			srv.tail.ServeHTTP(rec, req)
+			FatalIf(t, rec.Code != tc.code, "expected %d, got %d", tc.code, rec.Code)
+		})
+	}
+}
+
+func TestTailService_topicOf_SharedTopic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sharedTopic := SharedTopicConfig{Count: 4, MaxTps: 100, Name: "shared"}
+	expected, _ := sharedTopic.Route(pb.SampleTimberContextProto(), "", "_logs")
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().DoAndReturn(func(topic string) bool {
+		return topic == expected
+	})
+
+	srv := &producerService{topicSuffix: "_logs", admin: admin, sharedTopic: sharedTopic}
+	topic, shared := newTailService(srv, TailConfig{}).topicOf("some_topic")
+	FatalIf(t, topic != expected || !shared, "expected shared topic %s, got %s %v", expected, topic, shared)
+}
+
+func TestTailService_ServeHTTP_ActiveCluster(t *testing.T) {
+	resetPrometheusMetrics()
+
+	srv, ctrl, consumer := newTailTestProducer(t, TailConfig{MaxConcurrent: 1, GroupPrefix: "barito-tail"}, true)
+	defer ctrl.Finish()
+
+	// the producer failed over, the standby factory consumes the topic checked by the failover admin
+	standby := srv.factory
+	primary := mock.NewMockKafkaFactory(ctrl)
+	srv.factory = primary
+	srv.failover = newKafkaFailover(KafkaFailoverConfig{Standby: standby, ErrorThreshold: 1, FailbackThreshold: 1}, primary)
+	srv.failover.active = 1
+
+	server := httptest.NewServer(srv.tail)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/tail/some_topic", nil)
+	req.Header.Set(TailAppSecretHeader, "some-secret-1234")
+	resp, err := http.DefaultClient.Do(req)
+	FatalIfError(t, err)
+	FatalIf(t, resp.StatusCode != http.StatusOK, "expected 200, got %d", resp.StatusCode)
+	FatalIf(t, consumer.topic != "some_topic_logs", "tail should consume from the active cluster")
+
+	resp.Body.Close()
+	<-consumer.closed
+}
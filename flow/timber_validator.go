@@ -0,0 +1,223 @@
+package flow
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/protobuf/proto"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+)
+
+const (
+	ValidationReasonContextMissing     = "context_missing"
+	ValidationReasonKafkaTopicMissing  = "kafka_topic_missing"
+	ValidationReasonKafkaTopicInvalid  = "kafka_topic_invalid"
+	ValidationReasonIndexPrefixMissing = "es_index_prefix_missing"
+	ValidationReasonIndexPrefixInvalid = "es_index_prefix_invalid"
+	ValidationReasonContentMissing     = "content_missing"
+	ValidationReasonEmptyBatch         = "empty_batch"
+	ValidationReasonTooManyFields      = "too_many_fields"
+	ValidationReasonFieldTooLarge      = "field_too_large"
+	ValidationReasonTimberTooLarge     = "timber_too_large"
+
+	// kafka refuses topic name longer than this
+	maxKafkaTopicLength = 249
+	// elasticsearch refuses index name longer than this, leave room for the date suffix
+	maxEsIndexPrefixLength = 200
+)
+
+var kafkaTopicPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// defaultTimberValidator only checks the required fields
+var defaultTimberValidator = &TimberValidator{}
+
+// ValidationError is returned when a request is rejected by TimberValidator
+type ValidationError struct {
+	Reason  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func newValidationError(reason string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// TimberValidator checks produce requests before they reach kafka.
+// Zero value of each limit means the limit is disabled.
+type TimberValidator struct {
+	maxFields      int
+	maxFieldBytes  int
+	maxTimberBytes int
+}
+
+func NewTimberValidator(maxFields, maxFieldBytes, maxTimberBytes int) *TimberValidator {
+	return &TimberValidator{
+		maxFields:      maxFields,
+		maxFieldBytes:  maxFieldBytes,
+		maxTimberBytes: maxTimberBytes,
+	}
+}
+
+// ValidateTimber validates a single timber, topic is the full kafka topic name it will be written to
+func (v *TimberValidator) ValidateTimber(timber *pb.Timber, topic string) *ValidationError {
+	if err := v.validateContext(timber.GetContext(), topic); err != nil {
+		return err
+	}
+
+	return v.validateContent(timber)
+}
+
+// ValidateTimberCollection validates the collection context and every item of it
+func (v *TimberValidator) ValidateTimberCollection(timberCollection *pb.TimberCollection, topic string) *ValidationError {
+	if err := v.validateContext(timberCollection.GetContext(), topic); err != nil {
+		return err
+	}
+
+	if len(timberCollection.GetItems()) == 0 {
+		return newValidationError(ValidationReasonEmptyBatch, "items must not be empty")
+	}
+
+	for i, timber := range timberCollection.GetItems() {
+		if err := v.validateContent(timber); err != nil {
+			err.Message = fmt.Sprintf("items[%d]: %s", i, err.Message)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *TimberValidator) validateContext(timberContext *pb.TimberContext, topic string) *ValidationError {
+	if timberContext == nil {
+		return newValidationError(ValidationReasonContextMissing, "context is required")
+	}
+
+	kafkaTopic := timberContext.GetKafkaTopic()
+	if kafkaTopic == "" {
+		return newValidationError(ValidationReasonKafkaTopicMissing, "context.kafka_topic is required")
+	}
+	if !kafkaTopicPattern.MatchString(topic) {
+		return newValidationError(ValidationReasonKafkaTopicInvalid,
+			"context.kafka_topic %q may only contain letters, digits, '.', '_' and '-'", kafkaTopic)
+	}
+	if len(topic) > maxKafkaTopicLength {
+		return newValidationError(ValidationReasonKafkaTopicInvalid,
+			"context.kafka_topic %q is too long, topic name must not exceed %d characters", kafkaTopic, maxKafkaTopicLength)
+	}
+
+	indexPrefix := timberContext.GetEsIndexPrefix()
+	if indexPrefix == "" {
+		return newValidationError(ValidationReasonIndexPrefixMissing, "context.es_index_prefix is required")
+	}
+	if reason := invalidEsIndexPrefix(indexPrefix); reason != "" {
+		return newValidationError(ValidationReasonIndexPrefixInvalid, "context.es_index_prefix %q %s", indexPrefix, reason)
+	}
+
+	return nil
+}
+
+func (v *TimberValidator) validateContent(timber *pb.Timber) *ValidationError {
+	content := timber.GetContent()
+	if content == nil || len(content.GetFields()) == 0 {
+		return newValidationError(ValidationReasonContentMissing, "content is required")
+	}
+
+	if v.maxFields > 0 {
+		if n := countFields(content); n > v.maxFields {
+			return newValidationError(ValidationReasonTooManyFields,
+				"content has %d fields, maximum is %d", n, v.maxFields)
+		}
+	}
+
+	if v.maxFieldBytes > 0 {
+		if path, n := largestField(content, ""); n > v.maxFieldBytes {
+			return newValidationError(ValidationReasonFieldTooLarge,
+				"content field %q has %d bytes, maximum is %d", path, n, v.maxFieldBytes)
+		}
+	}
+
+	if v.maxTimberBytes > 0 {
+		if n := proto.Size(timber); n > v.maxTimberBytes {
+			return newValidationError(ValidationReasonTimberTooLarge,
+				"timber has %d bytes, maximum is %d", n, v.maxTimberBytes)
+		}
+	}
+
+	return nil
+}
+
+func invalidEsIndexPrefix(indexPrefix string) string {
+	if len(indexPrefix) > maxEsIndexPrefixLength {
+		return fmt.Sprintf("must not exceed %d characters", maxEsIndexPrefixLength)
+	}
+	if strings.ToLower(indexPrefix) != indexPrefix {
+		return "must be lowercase"
+	}
+	if strings.ContainsAny(indexPrefix, `\/*?"<>| ,#:`) {
+		return `must not contain '\', '/', '*', '?', '"', '<', '>', '|', ' ', ',', '#' or ':'`
+	}
+	if strings.HasPrefix(indexPrefix, "-") || strings.HasPrefix(indexPrefix, "_") || strings.HasPrefix(indexPrefix, "+") {
+		return "must not start with '-', '_' or '+'"
+	}
+	if indexPrefix == "." || indexPrefix == ".." {
+		return "must not be '.' or '..'"
+	}
+	return ""
+}
+
+// countFields counts every key of s, including the keys of nested struct
+func countFields(s *stpb.Struct) (n int) {
+	for _, value := range s.GetFields() {
+		n += 1 + countValueFields(value)
+	}
+	return
+}
+
+func countValueFields(value *stpb.Value) (n int) {
+	switch kind := value.GetKind().(type) {
+	case *stpb.Value_StructValue:
+		n += countFields(kind.StructValue)
+	case *stpb.Value_ListValue:
+		for _, item := range kind.ListValue.GetValues() {
+			n += countValueFields(item)
+		}
+	}
+	return
+}
+
+// largestField returns the path and length of the longest string value of s
+func largestField(s *stpb.Struct, prefix string) (path string, n int) {
+	for key, value := range s.GetFields() {
+		p, size := largestValue(value, prefix+key)
+		if size > n {
+			path, n = p, size
+		}
+	}
+	return
+}
+
+func largestValue(value *stpb.Value, path string) (string, int) {
+	switch kind := value.GetKind().(type) {
+	case *stpb.Value_StringValue:
+		return path, len(kind.StringValue)
+	case *stpb.Value_StructValue:
+		return largestField(kind.StructValue, path+".")
+	case *stpb.Value_ListValue:
+		largestPath, n := path, 0
+		for _, item := range kind.ListValue.GetValues() {
+			if p, size := largestValue(item, path); size > n {
+				largestPath, n = p, size
+			}
+		}
+		return largestPath, n
+	}
+	return path, 0
+}
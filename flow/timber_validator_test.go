@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	pb "github.com/bentol/barito-proto/producer"
+	stpb "github.com/golang/protobuf/ptypes/struct"
+)
+
+func TestTimberValidator_ValidateTimber(t *testing.T) {
+	validator := NewTimberValidator(3, 16, 0)
+
+	testCases := []struct {
+		name   string
+		modify func(timber *pb.Timber)
+		reason string
+	}{
+		{"valid", func(timber *pb.Timber) {}, ""},
+		{"missing context", func(timber *pb.Timber) { timber.Context = nil }, ValidationReasonContextMissing},
+		{"missing kafka topic", func(timber *pb.Timber) { timber.Context.KafkaTopic = "" }, ValidationReasonKafkaTopicMissing},
+		{"invalid kafka topic", func(timber *pb.Timber) { timber.Context.KafkaTopic = "some topic" }, ValidationReasonKafkaTopicInvalid},
+		{"too long kafka topic", func(timber *pb.Timber) { timber.Context.KafkaTopic = strings.Repeat("a", 250) }, ValidationReasonKafkaTopicInvalid},
+		{"missing index prefix", func(timber *pb.Timber) { timber.Context.EsIndexPrefix = "" }, ValidationReasonIndexPrefixMissing},
+		{"uppercase index prefix", func(timber *pb.Timber) { timber.Context.EsIndexPrefix = "Some-Type" }, ValidationReasonIndexPrefixInvalid},
+		{"index prefix start with dash", func(timber *pb.Timber) { timber.Context.EsIndexPrefix = "-some" }, ValidationReasonIndexPrefixInvalid},
+		{"missing content", func(timber *pb.Timber) { timber.Content = nil }, ValidationReasonContentMissing},
+		{"empty content", func(timber *pb.Timber) { timber.Content = &stpb.Struct{} }, ValidationReasonContentMissing},
+		{"too many fields", func(timber *pb.Timber) {
+			timber.Content.Fields["nested"] = &stpb.Value{Kind: &stpb.Value_StructValue{StructValue: &stpb.Struct{
+				Fields: map[string]*stpb.Value{"a": {Kind: &stpb.Value_NumberValue{NumberValue: 1}}},
+			}}}
+		}, ValidationReasonTooManyFields},
+		{"field too large", func(timber *pb.Timber) {
+			timber.Content.Fields["message"] = &stpb.Value{Kind: &stpb.Value_StringValue{StringValue: "some-very-long-message"}}
+		}, ValidationReasonFieldTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			timber := pb.SampleTimberProto()
+			timber.Content.Fields["message"] = &stpb.Value{Kind: &stpb.Value_StringValue{StringValue: "msg"}}
+			tc.modify(timber)
+
+			topic := "prefix_" + timber.GetContext().GetKafkaTopic() + "_logs"
+			err := validator.ValidateTimber(timber, topic)
+			if tc.reason == "" {
+				FatalIf(t, err != nil, "expected valid, got %v", err)
+				return
+			}
+			FatalIf(t, err == nil, "expected error with reason %s", tc.reason)
+			FatalIf(t, err.Reason != tc.reason, "expected reason %s, got %s", tc.reason, err.Reason)
+		})
+	}
+}
+
+func TestTimberValidator_TimberTooLarge(t *testing.T) {
+	validator := NewTimberValidator(0, 0, 10)
+
+	err := validator.ValidateTimber(pb.SampleTimberProto(), "some_topic_logs")
+	FatalIf(t, err == nil || err.Reason != ValidationReasonTimberTooLarge, "expected timber too large, got %v", err)
+}
+
+func TestTimberValidator_ValidateTimberCollection(t *testing.T) {
+	validator := NewTimberValidator(0, 0, 0)
+
+	timberCollection := pb.SampleTimberCollectionProto()
+	FatalIf(t, validator.ValidateTimberCollection(timberCollection, "some_topic_logs") != nil, "expected valid")
+
+	timberCollection.Items[1].Content = nil
+	err := validator.ValidateTimberCollection(timberCollection, "some_topic_logs")
+	FatalIf(t, err == nil || err.Reason != ValidationReasonContentMissing, "expected content missing, got %v", err)
+	FatalIf(t, !strings.HasPrefix(err.Error(), "items[1]: "), "error should point to the item, got %s", err)
+
+	timberCollection.Items = nil
+	err = validator.ValidateTimberCollection(timberCollection, "some_topic_logs")
+	FatalIf(t, err == nil || err.Reason != ValidationReasonEmptyBatch, "expected empty batch, got %v", err)
+}
@@ -0,0 +1,166 @@
+package flow
+
+import (
+	"context"
+	"os"
+
+	"github.com/BaritoLog/go-boilerplate/errkit"
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ErrTracingExporter = errkit.Error("Unknown tracing exporter")
+
+	TracingExporterOtlp = "otlp"
+	TracingExporterFile = "file"
+
+	tracerName = "github.com/BaritoLog/barito-flow"
+
+	AttributeApp            = attribute.Key("barito.app")
+	AttributeItems          = attribute.Key("barito.items")
+	AttributeFlushReason    = attribute.Key("barito.flush_reason")
+	AttributeIndex          = attribute.Key("barito.es_index")
+	AttributeKafkaPartition = attribute.Key("messaging.kafka.destination.partition")
+	AttributeKafkaOffset    = attribute.Key("messaging.kafka.message.offset")
+)
+
+// tracer is a no-op until InitTracing installs the tracer provider, so the spans cost nothing when tracing is disabled
+var tracer = otel.Tracer(tracerName)
+
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// TracingConfig enables OpenTelemetry tracing.
+// Spans are exported to Endpoint with OTLP/gRPC, or written as JSON lines to File with the file exporter.
+type TracingConfig struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	File        string
+	ServiceName string
+	SampleRatio float64
+}
+
+// InitTracing installs the global tracer provider and propagator, shutdown flushes the pending spans
+func InitTracing(config TracingConfig) (shutdown func(context.Context) error, err error) {
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case TracingExporterOtlp:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), opts...)
+	case TracingExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		err = errkit.Concat(ErrTracingExporter, errkit.Error(config.Exporter))
+	}
+	if err != nil {
+		return
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.ServiceName)))
+	if err != nil {
+		return
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracePropagator)
+
+	return provider.Shutdown, nil
+}
+
+// endSpan records err on span before ending it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// producerMessageCarrier carries trace context in the headers of a kafka record being produced
+type producerMessageCarrier struct {
+	message *sarama.ProducerMessage
+}
+
+func (c producerMessageCarrier) Get(key string) string {
+	for _, h := range c.message.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c producerMessageCarrier) Set(key, value string) {
+	for i, h := range c.message.Headers {
+		if string(h.Key) == key {
+			c.message.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.message.Headers = append(c.message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c producerMessageCarrier) Keys() (keys []string) {
+	for _, h := range c.message.Headers {
+		keys = append(keys, string(h.Key))
+	}
+	return
+}
+
+// consumerMessageCarrier carries trace context in the headers of a consumed kafka record
+type consumerMessageCarrier struct {
+	message *sarama.ConsumerMessage
+}
+
+func (c consumerMessageCarrier) Get(key string) string {
+	for _, h := range c.message.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set is a no-op, the consumed record is never changed since its headers are copied, e.g. into the dead letter topic
+func (c consumerMessageCarrier) Set(key, value string) {}
+
+func (c consumerMessageCarrier) Keys() (keys []string) {
+	for _, h := range c.message.Headers {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return
+}
+
+// MessageTraceContext returns ctx continuing the trace carried in the headers of message
+func MessageTraceContext(ctx context.Context, message *sarama.ConsumerMessage) context.Context {
+	return tracePropagator.Extract(ctx, consumerMessageCarrier{message: message})
+}
+
+func injectTraceContext(ctx context.Context, message *sarama.ProducerMessage) {
+	tracePropagator.Inject(ctx, producerMessageCarrier{message: message})
+}
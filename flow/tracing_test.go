@@ -0,0 +1,217 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/BaritoLog/barito-flow/mock"
+	"github.com/BaritoLog/barito-flow/prome"
+	. "github.com/BaritoLog/go-boilerplate/testkit"
+	"github.com/Shopify/sarama"
+	pb "github.com/bentol/barito-proto/producer"
+	"github.com/golang/mock/gomock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+)
+
+var (
+	testSpanRecorder     *tracetest.SpanRecorder
+	testSpanRecorderOnce sync.Once
+)
+
+// recordSpans installs the span recorder once, the global tracer only delegates to the first tracer provider
+func recordSpans() *tracetest.SpanRecorder {
+	testSpanRecorderOnce.Do(func() {
+		testSpanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpanRecorder)))
+	})
+	return testSpanRecorder
+}
+
+func endedSpans(recorder *tracetest.SpanRecorder, traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+func producerToConsumerMessage(message *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	consumerMessage := &sarama.ConsumerMessage{Topic: message.Topic}
+	for i := range message.Headers {
+		consumerMessage.Headers = append(consumerMessage.Headers, &message.Headers[i])
+	}
+	return consumerMessage
+}
+
+func TestTraceContext_KafkaHeaders(t *testing.T) {
+	recordSpans()
+
+	ctx, span := tracer.Start(context.Background(), "some-span")
+	defer span.End()
+
+	message := &sarama.ProducerMessage{
+		Topic:   "some_topic",
+		Headers: []sarama.RecordHeader{{Key: []byte(AppNameHeaderKey), Value: []byte("some-app")}},
+	}
+	injectTraceContext(ctx, message)
+	FatalIf(t, producerMessageCarrier{message: message}.Get("traceparent") == "", "traceparent header should be set")
+	FatalIf(t, producerMessageCarrier{message: message}.Get(AppNameHeaderKey) != "some-app", "other headers should be kept")
+
+	extracted := trace.SpanContextFromContext(MessageTraceContext(context.Background(), producerToConsumerMessage(message)))
+	FatalIf(t, extracted.TraceID() != span.SpanContext().TraceID(), "trace id should be carried")
+	FatalIf(t, extracted.SpanID() != span.SpanContext().SpanID(), "span id should be carried")
+	FatalIf(t, !extracted.IsRemote(), "extracted span context should be remote")
+}
+
+func TestProducerService_Produce_Tracing(t *testing.T) {
+	recorder := recordSpans()
+	resetPrometheusMetrics()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	admin := mock.NewMockKafkaAdmin(ctrl)
+	admin.EXPECT().Exist(gomock.Any()).AnyTimes().Return(true)
+
+	var sent *sarama.ProducerMessage
+	producer := mock.NewMockSyncProducer(ctrl)
+	producer.EXPECT().SendMessage(gomock.Any()).DoAndReturn(func(msg *sarama.ProducerMessage) (int32, int64, error) {
+		sent = msg
+		return 0, 0, nil
+	})
+
+	srv := &producerService{
+		producer:           producer,
+		topicSuffix:        "_logs",
+		admin:              admin,
+		limiter:            NewDummyRateLimiter(),
+		kafkaMessageFormat: TimberCollectionMessageFormat,
+	}
+
+	ctx, root := tracer.Start(context.Background(), "client")
+	_, err := srv.Produce(ctx, pb.SampleTimberProto())
+	FatalIfError(t, err)
+	root.End()
+
+	spans := endedSpans(recorder, root.SpanContext().TraceID())
+	produce, send := spans["Produce"], spans["send some_topic_logs"]
+	FatalIf(t, produce == nil || send == nil, "expected Produce and send spans, got %v", spans)
+	FatalIf(t, send.Parent().SpanID() != produce.SpanContext().SpanID(), "send span should be the child of Produce span")
+	FatalIf(t, send.SpanKind() != trace.SpanKindProducer, "send span should be producer span")
+
+	carried := trace.SpanContextFromContext(MessageTraceContext(context.Background(), producerToConsumerMessage(sent)))
+	FatalIf(t, carried.SpanID() != send.SpanContext().SpanID(), "kafka record should carry the send span")
+}
+
+func TestConsumerWorker_startSpan(t *testing.T) {
+	recorder := recordSpans()
+
+	ctx, root := tracer.Start(context.Background(), "producer")
+	produced := &sarama.ProducerMessage{Topic: "some_topic"}
+	injectTraceContext(ctx, produced)
+	root.End()
+
+	message := producerToConsumerMessage(produced)
+	message.Partition, message.Offset = 1, 42
+
+	w := &consumerWorker{}
+	handlerCtx, span := w.startSpan(message)
+	span.End()
+
+	consume := endedSpans(recorder, root.SpanContext().TraceID())["consume some_topic"]
+	FatalIf(t, consume == nil, "expected consume span")
+	FatalIf(t, consume.Parent().SpanID() != root.SpanContext().SpanID(), "consume span should continue the producer trace")
+
+	handler := trace.SpanContextFromContext(handlerCtx)
+	FatalIf(t, handler.SpanID() != consume.SpanContext().SpanID(), "handler context should carry the consume span")
+
+	carried := trace.SpanContextFromContext(MessageTraceContext(context.Background(), message))
+	FatalIf(t, carried.SpanID() != root.SpanContext().SpanID() || len(message.Headers) != len(produced.Headers),
+		"message headers should still carry the producer span")
+}
+
+func TestMicroBatcher_FlushSpanLinks(t *testing.T) {
+	recorder := recordSpans()
+
+	var flushed trace.SpanContext
+	batcher := newMicroBatcher(MicroBatchConfig{Window: time.Hour, MaxItems: 2}, func(ctx context.Context, _ *pb.TimberCollection, _ string) error {
+		flushed = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	var roots []trace.Span
+	for i := 0; i < 2; i++ {
+		ctx, root := tracer.Start(context.Background(), "client")
+		roots = append(roots, root)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batcher.Add(ctx, "some_topic_logs", pb.SampleTimberProto())
+		}()
+	}
+	wg.Wait()
+
+	flush := endedSpans(recorder, flushed.TraceID())["MicroBatchFlush"]
+	FatalIf(t, flush == nil, "expected MicroBatchFlush span")
+	FatalIf(t, len(flush.Links()) != 2, "expected 2 links, got %d", len(flush.Links()))
+
+	linked := map[trace.TraceID]bool{}
+	for _, link := range flush.Links() {
+		linked[link.SpanContext.TraceID()] = true
+	}
+	for _, root := range roots {
+		FatalIf(t, !linked[root.SpanContext().TraceID()], "flush span should link every produce span")
+		root.End()
+	}
+}
+
+func TestGCS_UploadSpanLinks(t *testing.T) {
+	resetPrometheusMetrics()
+	prome.InitGCSConsumerInstrumentation()
+	recorder := recordSpans()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"bucket":"foo","name":"bar"}`))
+	}))
+	defer ts.Close()
+
+	g := newTestGCS()
+	g.bucketName = "foo"
+	g.storageClient, _ = storage.NewClient(context.Background(), option.WithoutAuthentication(), option.WithEndpoint(ts.URL))
+	g.clock = &DummyClock{now: time.Now()}
+
+	var roots []trace.Span
+	for i := 0; i < 2; i++ {
+		ctx, root := tracer.Start(context.Background(), "consume")
+		roots = append(roots, root)
+		FatalIfError(t, g.OnMessage(ctx, []byte("some-log")))
+	}
+	g.Flush()
+	FatalIf(t, len(g.links) != 0, "links should be reset by the flush")
+
+	var upload sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "gcs.upload" && len(span.Links()) > 0 && span.Links()[0].SpanContext.TraceID() == roots[0].SpanContext().TraceID() {
+			upload = span
+		}
+	}
+	FatalIf(t, upload == nil, "expected gcs.upload span linked to the buffered records")
+	FatalIf(t, len(upload.Links()) != 2, "expected 2 links, got %d", len(upload.Links()))
+	for i, root := range roots {
+		FatalIf(t, upload.Links()[i].SpanContext.SpanID() != root.SpanContext().SpanID(), "upload span should link every buffered record")
+		root.End()
+	}
+}
@@ -1,15 +1,20 @@
 package types
 
 import (
+	"context"
+	"time"
+
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 )
 
 type KafkaFactory interface {
 	MakeKafkaAdmin() (admin KafkaAdmin, err error)
-	MakeClusterConsumer(groupID, topic string, initialOffset int64) (worker ClusterConsumer, err error)
+	MakeGroupConsumer(groupID, topic string, initialOffset int64) (consumer GroupConsumer, err error)
+	// MakeSubscriptionConsumer consumes every topic matching match, the topics are refreshed from metadata every refreshInterval
+	// and a changed subscription is applied at the latest after subscriptionDelay
+	MakeSubscriptionConsumer(groupID string, match func(topic string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (consumer GroupConsumer, err error)
 	MakeSyncProducer() (producer sarama.SyncProducer, err error)
-	MakeConsumerWorker(name string, consumer ClusterConsumer) ConsumerWorker
+	MakeConsumerWorker(name string, consumer GroupConsumer) ConsumerWorker
 }
 
 type KafkaAdmin interface {
@@ -19,12 +24,15 @@ type KafkaAdmin interface {
 	AddTopic(topic string)
 	Exist(topic string) bool
 	CreateTopic(topic string, numPartitions int32, replicationFactor int16) error
+	Ping() error
 	Close()
 }
 
-type ClusterConsumer interface {
+// GroupConsumer consumes the partitions claimed by the consumer group, the offsets are marked and committed within the current session only
+type GroupConsumer interface {
 	Messages() <-chan *sarama.ConsumerMessage
-	Notifications() <-chan *cluster.Notification
+	// Notifications must be read, the rebalance waits until its notification is received
+	Notifications() <-chan *Notification
 	Errors() <-chan error
 	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
 	CommitOffsets() error
@@ -39,7 +47,26 @@ type ConsumerWorker interface {
 	OnError(f func(error))
 	OnConsumerFlush() error
 	OnSuccess(f func(*sarama.ConsumerMessage))
-	OnNotification(f func(*cluster.Notification))
+	OnNotification(f func(*Notification))
+}
+
+// NotificationType is the type of a rebalance notification
+type NotificationType uint8
+
+const (
+	UnknownNotification NotificationType = iota
+	// RebalanceStart is sent when the session ends
+	RebalanceStart
+	// RebalanceOK is sent when the next session starts, the partitions of the previous session which are not claimed again are released
+	RebalanceOK
+)
+
+// Notification reports the partitions by topic claimed or released by a rebalance of the consumer group
+type Notification struct {
+	Type     NotificationType
+	Claimed  map[string][]int32
+	Released map[string][]int32
+	Current  map[string][]int32
 }
 
 type ConsumerOutputFactory interface {
@@ -49,6 +76,7 @@ type ConsumerOutputFactory interface {
 type ConsumerOutput interface {
 	Start() error
 	Stop()
-	OnMessage([]byte) error
+	// OnMessage buffers the message, the span of ctx is linked to the flush carrying it
+	OnMessage(context.Context, []byte) error
 	AddOnFlushFunc(func() error)
 }
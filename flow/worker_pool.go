@@ -0,0 +1,44 @@
+package flow
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+const workerPoolQueueSize = 64
+
+// workerPool runs jobs on a bounded number of goroutines. The jobs of a partition always run on the same goroutine,
+// so the messages of a partition are handled in order.
+type workerPool struct {
+	queues []chan func()
+}
+
+func newWorkerPool(size int) *workerPool {
+	p := &workerPool{queues: make([]chan func(), size)}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), workerPoolQueueSize)
+		go p.loop(p.queues[i])
+	}
+	return p
+}
+
+func (p *workerPool) loop(queue chan func()) {
+	for job := range queue {
+		job()
+	}
+}
+
+// Dispatch queues job of the partition, it blocks while the queue of the partition is full
+func (p *workerPool) Dispatch(key topicPartition, job func()) {
+	h := fnv.New32a()
+	h.Write([]byte(key.topic))
+	h.Write([]byte(strconv.Itoa(int(key.partition))))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- job
+}
+
+// Close stops the goroutines once their queued jobs are done, Dispatch must not be called afterward
+func (p *workerPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+}
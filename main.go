@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"net/http"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/urfave/cli"
 
 	_ "net/http/pprof"
+	// the image is built from scratch without zoneinfo, the index timezone is loaded from the embedded database
+	_ "time/tzdata"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -71,6 +74,31 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:   "redrive-dlq",
+				Usage:  "redrive the consumer dead letter topic into kafka and elasticsearch",
+				Action: cmds.ActionRedriveDeadLetter,
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "verbose, V",
+						Usage: "Enable verbose mode",
+					},
+					cli.StringFlag{
+						Name:  "error",
+						Usage: "Only redrive dead letters of this error type, e.g. mapping_failed",
+					},
+					cli.StringFlag{
+						Name:  "group",
+						Usage: "Consumer group id of the dead letter topic, suffixed by the error type with --error",
+						Value: "barito-dlq-redrive",
+					},
+					cli.DurationFlag{
+						Name:  "idle",
+						Usage: "Stop when no dead letter is received for this duration",
+						Value: 10 * time.Second,
+					},
+				},
+			},
 		},
 		UsageText: "barito-flow [commands]",
 		Before: func(c *cli.Context) error {
@@ -7,9 +7,9 @@ package mock
 import (
 	types "github.com/BaritoLog/barito-flow/flow/types"
 	sarama "github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockKafkaFactory is a mock of KafkaFactory interface
@@ -50,19 +50,34 @@ func (mr *MockKafkaFactoryMockRecorder) MakeKafkaAdmin() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeKafkaAdmin", reflect.TypeOf((*MockKafkaFactory)(nil).MakeKafkaAdmin))
 }
 
-// MakeClusterConsumer mocks base method
-func (m *MockKafkaFactory) MakeClusterConsumer(groupID, topic string, initialOffset int64) (types.ClusterConsumer, error) {
+// MakeGroupConsumer mocks base method
+func (m *MockKafkaFactory) MakeGroupConsumer(groupID, topic string, initialOffset int64) (types.GroupConsumer, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "MakeClusterConsumer", groupID, topic, initialOffset)
-	ret0, _ := ret[0].(types.ClusterConsumer)
+	ret := m.ctrl.Call(m, "MakeGroupConsumer", groupID, topic, initialOffset)
+	ret0, _ := ret[0].(types.GroupConsumer)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// MakeClusterConsumer indicates an expected call of MakeClusterConsumer
-func (mr *MockKafkaFactoryMockRecorder) MakeClusterConsumer(groupID, topic, initialOffset interface{}) *gomock.Call {
+// MakeGroupConsumer indicates an expected call of MakeGroupConsumer
+func (mr *MockKafkaFactoryMockRecorder) MakeGroupConsumer(groupID, topic, initialOffset interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeClusterConsumer", reflect.TypeOf((*MockKafkaFactory)(nil).MakeClusterConsumer), groupID, topic, initialOffset)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeGroupConsumer", reflect.TypeOf((*MockKafkaFactory)(nil).MakeGroupConsumer), groupID, topic, initialOffset)
+}
+
+// MakeSubscriptionConsumer mocks base method
+func (m *MockKafkaFactory) MakeSubscriptionConsumer(groupID string, match func(string) bool, refreshInterval, subscriptionDelay time.Duration, initialOffset int64) (types.GroupConsumer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MakeSubscriptionConsumer", groupID, match, refreshInterval, subscriptionDelay, initialOffset)
+	ret0, _ := ret[0].(types.GroupConsumer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MakeSubscriptionConsumer indicates an expected call of MakeSubscriptionConsumer
+func (mr *MockKafkaFactoryMockRecorder) MakeSubscriptionConsumer(groupID, match, refreshInterval, subscriptionDelay, initialOffset interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakeSubscriptionConsumer", reflect.TypeOf((*MockKafkaFactory)(nil).MakeSubscriptionConsumer), groupID, match, refreshInterval, subscriptionDelay, initialOffset)
 }
 
 // MakeSyncProducer mocks base method
@@ -81,7 +96,7 @@ func (mr *MockKafkaFactoryMockRecorder) MakeSyncProducer() *gomock.Call {
 }
 
 // MakeConsumerWorker mocks base method
-func (m *MockKafkaFactory) MakeConsumerWorker(name string, consumer types.ClusterConsumer) types.ConsumerWorker {
+func (m *MockKafkaFactory) MakeConsumerWorker(name string, consumer types.GroupConsumer) types.ConsumerWorker {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "MakeConsumerWorker", name, consumer)
 	ret0, _ := ret[0].(types.ConsumerWorker)
@@ -197,6 +212,20 @@ func (mr *MockKafkaAdminMockRecorder) CreateTopic(topic, numPartitions, replicat
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTopic", reflect.TypeOf((*MockKafkaAdmin)(nil).CreateTopic), topic, numPartitions, replicationFactor)
 }
 
+// Ping mocks base method
+func (m *MockKafkaAdmin) Ping() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockKafkaAdminMockRecorder) Ping() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockKafkaAdmin)(nil).Ping))
+}
+
 // Close mocks base method
 func (m *MockKafkaAdmin) Close() {
 	m.ctrl.T.Helper()
@@ -209,31 +238,31 @@ func (mr *MockKafkaAdminMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockKafkaAdmin)(nil).Close))
 }
 
-// MockClusterConsumer is a mock of ClusterConsumer interface
-type MockClusterConsumer struct {
+// MockGroupConsumer is a mock of GroupConsumer interface
+type MockGroupConsumer struct {
 	ctrl     *gomock.Controller
-	recorder *MockClusterConsumerMockRecorder
+	recorder *MockGroupConsumerMockRecorder
 }
 
-// MockClusterConsumerMockRecorder is the mock recorder for MockClusterConsumer
-type MockClusterConsumerMockRecorder struct {
-	mock *MockClusterConsumer
+// MockGroupConsumerMockRecorder is the mock recorder for MockGroupConsumer
+type MockGroupConsumerMockRecorder struct {
+	mock *MockGroupConsumer
 }
 
-// NewMockClusterConsumer creates a new mock instance
-func NewMockClusterConsumer(ctrl *gomock.Controller) *MockClusterConsumer {
-	mock := &MockClusterConsumer{ctrl: ctrl}
-	mock.recorder = &MockClusterConsumerMockRecorder{mock}
+// NewMockGroupConsumer creates a new mock instance
+func NewMockGroupConsumer(ctrl *gomock.Controller) *MockGroupConsumer {
+	mock := &MockGroupConsumer{ctrl: ctrl}
+	mock.recorder = &MockGroupConsumerMockRecorder{mock}
 	return mock
 }
 
 // EXPECT returns an object that allows the caller to indicate expected use
-func (m *MockClusterConsumer) EXPECT() *MockClusterConsumerMockRecorder {
+func (m *MockGroupConsumer) EXPECT() *MockGroupConsumerMockRecorder {
 	return m.recorder
 }
 
 // Messages mocks base method
-func (m *MockClusterConsumer) Messages() <-chan *sarama.ConsumerMessage {
+func (m *MockGroupConsumer) Messages() <-chan *sarama.ConsumerMessage {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Messages")
 	ret0, _ := ret[0].(<-chan *sarama.ConsumerMessage)
@@ -241,27 +270,27 @@ func (m *MockClusterConsumer) Messages() <-chan *sarama.ConsumerMessage {
 }
 
 // Messages indicates an expected call of Messages
-func (mr *MockClusterConsumerMockRecorder) Messages() *gomock.Call {
+func (mr *MockGroupConsumerMockRecorder) Messages() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Messages", reflect.TypeOf((*MockClusterConsumer)(nil).Messages))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Messages", reflect.TypeOf((*MockGroupConsumer)(nil).Messages))
 }
 
 // Notifications mocks base method
-func (m *MockClusterConsumer) Notifications() <-chan *cluster.Notification {
+func (m *MockGroupConsumer) Notifications() <-chan *types.Notification {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Notifications")
-	ret0, _ := ret[0].(<-chan *cluster.Notification)
+	ret0, _ := ret[0].(<-chan *types.Notification)
 	return ret0
 }
 
 // Notifications indicates an expected call of Notifications
-func (mr *MockClusterConsumerMockRecorder) Notifications() *gomock.Call {
+func (mr *MockGroupConsumerMockRecorder) Notifications() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notifications", reflect.TypeOf((*MockClusterConsumer)(nil).Notifications))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notifications", reflect.TypeOf((*MockGroupConsumer)(nil).Notifications))
 }
 
 // Errors mocks base method
-func (m *MockClusterConsumer) Errors() <-chan error {
+func (m *MockGroupConsumer) Errors() <-chan error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Errors")
 	ret0, _ := ret[0].(<-chan error)
@@ -269,25 +298,25 @@ func (m *MockClusterConsumer) Errors() <-chan error {
 }
 
 // Errors indicates an expected call of Errors
-func (mr *MockClusterConsumerMockRecorder) Errors() *gomock.Call {
+func (mr *MockGroupConsumerMockRecorder) Errors() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Errors", reflect.TypeOf((*MockClusterConsumer)(nil).Errors))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Errors", reflect.TypeOf((*MockGroupConsumer)(nil).Errors))
 }
 
 // MarkOffset mocks base method
-func (m *MockClusterConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+func (m *MockGroupConsumer) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
 	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "MarkOffset", msg, metadata)
 }
 
 // MarkOffset indicates an expected call of MarkOffset
-func (mr *MockClusterConsumerMockRecorder) MarkOffset(msg, metadata interface{}) *gomock.Call {
+func (mr *MockGroupConsumerMockRecorder) MarkOffset(msg, metadata interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOffset", reflect.TypeOf((*MockClusterConsumer)(nil).MarkOffset), msg, metadata)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOffset", reflect.TypeOf((*MockGroupConsumer)(nil).MarkOffset), msg, metadata)
 }
 
 // CommitOffsets mocks base method
-func (m *MockClusterConsumer) CommitOffsets() error {
+func (m *MockGroupConsumer) CommitOffsets() error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "CommitOffsets")
 	ret0, _ := ret[0].(error)
@@ -295,13 +324,13 @@ func (m *MockClusterConsumer) CommitOffsets() error {
 }
 
 // CommitOffsets indicates an expected call of CommitOffsets
-func (mr *MockClusterConsumerMockRecorder) CommitOffsets() *gomock.Call {
+func (mr *MockGroupConsumerMockRecorder) CommitOffsets() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitOffsets", reflect.TypeOf((*MockClusterConsumer)(nil).CommitOffsets))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitOffsets", reflect.TypeOf((*MockGroupConsumer)(nil).CommitOffsets))
 }
 
 // Close mocks base method
-func (m *MockClusterConsumer) Close() error {
+func (m *MockGroupConsumer) Close() error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Close")
 	ret0, _ := ret[0].(error)
@@ -309,9 +338,9 @@ func (m *MockClusterConsumer) Close() error {
 }
 
 // Close indicates an expected call of Close
-func (mr *MockClusterConsumerMockRecorder) Close() *gomock.Call {
+func (mr *MockGroupConsumerMockRecorder) Close() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockClusterConsumer)(nil).Close))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockGroupConsumer)(nil).Close))
 }
 
 // MockConsumerWorker is a mock of ConsumerWorker interface
@@ -426,7 +455,7 @@ func (mr *MockConsumerWorkerMockRecorder) OnSuccess(f interface{}) *gomock.Call
 }
 
 // OnNotification mocks base method
-func (m *MockConsumerWorker) OnNotification(f func(*cluster.Notification)) {
+func (m *MockConsumerWorker) OnNotification(f func(*types.Notification)) {
 	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "OnNotification", f)
 }
@@ -29,6 +29,14 @@ var consumerKafkaMessagesIncomingCounter *prometheus.CounterVec
 var consumerElasticsearchClientFailed *prometheus.CounterVec
 var consumerCustomErrorTotal *prometheus.CounterVec
 var consumerFailedToEnsureIndexExists *prometheus.CounterVec
+var consumerRecordLagSecond *prometheus.SummaryVec
+var consumerUncommittedOffsets *prometheus.GaugeVec
+var consumerUndeliveredMessageTotal *prometheus.CounterVec
+var consumerDeadLetterTotal *prometheus.CounterVec
+var consumerBulkRetryTotal *prometheus.CounterVec
+var consumerEventTimeRouteTotal *prometheus.CounterVec
+var consumerIndexTemplateTotal *prometheus.CounterVec
+var consumerSubscribedTopics prometheus.Gauge
 
 var consumerGCSInfo *prometheus.GaugeVec
 var consumerGCSBufferSize *prometheus.GaugeVec
@@ -42,11 +50,23 @@ var producerSendToKafkaTimeSecond *prometheus.SummaryVec
 var producerKafkaClientFailed *prometheus.CounterVec
 var producerTotalLogBytesIngested *prometheus.CounterVec
 var producerTPSExceededLogBytes *prometheus.CounterVec
+var producerPeerRejectedTotal *prometheus.CounterVec
+var producerInvalidRequestTotal *prometheus.CounterVec
+var producerMicroBatchItems *prometheus.SummaryVec
+var producerSyslogMessageTotal *prometheus.CounterVec
+var producerFluentForwardEventTotal *prometheus.CounterVec
+var producerEsBulkItemTotal *prometheus.CounterVec
+var producerMirrorMessageTotal *prometheus.CounterVec
+var producerKafkaActiveCluster *prometheus.GaugeVec
+var producerKafkaFailoverTotal *prometheus.CounterVec
+var producerSchemaViolationTotal *prometheus.CounterVec
+var producerPeerBannedTotal prometheus.Counter
+var producerPeerActiveConnections prometheus.Gauge
+var producerPeerInflightStreams prometheus.Gauge
+var producerTailActive prometheus.Gauge
 
 var redactionEnabledTotal *prometheus.GaugeVec
 
-var indexDatePattern *regexp.Regexp = regexp.MustCompile(`-\d{4}\.\d{2}\.\d{2}$`)
-
 var logStoredErrorMap map[string]string = map[string]string{
 	"the final mapping":           "multiple_type",
 	"to parse field":              "mapping_failed",
@@ -80,6 +100,39 @@ func InitConsumerInstrumentation() {
 		Name: "barito_consumer_failed_to_ensure_index_exists_total",
 		Help: "Number of failed to ensure index exists",
 	}, []string{"index"})
+	consumerRecordLagSecond = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "barito_consumer_record_lag_second",
+		Help:       "Time between the record produced and consumed in second, taken from record header",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	}, []string{"topic"})
+	consumerUncommittedOffsets = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "barito_consumer_uncommitted_offsets",
+		Help: "Number of consumed messages waiting for their documents to be acknowledged before their offset is committed",
+	}, []string{"topic"})
+	consumerUndeliveredMessageTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_consumer_undelivered_message_total",
+		Help: "Number of messages whose documents failed to be stored, redelivered or dropped when they would fail again",
+	}, []string{"topic", "result"})
+	consumerDeadLetterTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_consumer_dead_letter_total",
+		Help: "Number of undecodable records and rejected documents published into the dead letter topic",
+	}, []string{"topic", "kind", "error"})
+	consumerBulkRetryTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_consumer_bulk_retry_total",
+		Help: "Number of bulk items rejected with a retryable status, re-queued or given up after the retry budget",
+	}, []string{"index", "status", "result"})
+	consumerEventTimeRouteTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_consumer_event_time_route_total",
+		Help: "Number of timbers routed by event time, late, ahead and invalid ones are stored into the catch all index",
+	}, []string{"index", "route"})
+	consumerIndexTemplateTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_consumer_index_template_total",
+		Help: "Number of index templates checked at startup by result, updated ones had drifted from their template file",
+	}, []string{"template", "result"})
+	consumerSubscribedTopics = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "barito_consumer_subscribed_topics",
+		Help: "Number of topics subscribed by the consumer group of the single group mode",
+	})
 	consumerKafkaMessagesIncomingCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "barito_consumer_kafka_message_incoming_total",
 		Help: "Number of messages incoming from kafka",
@@ -142,6 +195,63 @@ func InitProducerInstrumentation() {
 		Name: "barito_producer_tps_exceeded_log_bytes",
 		Help: "Log bytes of TPS exceeded requests",
 	}, []string{"app_name"})
+	producerSyslogMessageTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_syslog_message_total",
+		Help: "Number of syslog messages received",
+	}, []string{"transport", "result"})
+	producerFluentForwardEventTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_fluent_forward_event_total",
+		Help: "Number of events received with fluent forward protocol, unauthorized and invalid count the closed connections",
+	}, []string{"result"})
+	producerEsBulkItemTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_es_bulk_item_total",
+		Help: "Number of Elasticsearch bulk items received",
+	}, []string{"result"})
+	producerMirrorMessageTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_mirror_message_total",
+		Help: "Number of records mirrored into the secondary kafka cluster",
+	}, []string{"result"})
+	producerKafkaActiveCluster = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "barito_producer_kafka_active_cluster",
+		Help: "Kafka cluster the producer writes into, 1 when active",
+	}, []string{"cluster"})
+	producerKafkaFailoverTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_kafka_failover_total",
+		Help: "Number of times the producer switched its active kafka cluster",
+	}, []string{"cluster"})
+	producerSchemaViolationTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_schema_violation_total",
+		Help: "Number of content fields violating the JSON schema of their app",
+	}, []string{"app", "path"})
+	producerMicroBatchItems = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "barito_producer_micro_batch_items",
+		Help:       "Number of logs per micro batch written to kafka",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	}, []string{"flush_reason"})
+	producerInvalidRequestTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_invalid_request_total",
+		Help: "Number of requests rejected by validation",
+	}, []string{"reason"})
+	producerPeerRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "barito_producer_peer_rejected_total",
+		Help: "Number of connections or requests rejected by peer guard",
+	}, []string{"reason"})
+	producerPeerBannedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "barito_producer_peer_banned_total",
+		Help: "Number of peers temporarily banned",
+	})
+	producerPeerActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "barito_producer_peer_active_connections",
+		Help: "Number of active client connections",
+	})
+	producerPeerInflightStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "barito_producer_peer_inflight_streams",
+		Help: "Number of in-flight client streams",
+	})
+	producerTailActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "barito_producer_tail_active",
+		Help: "Number of running live tails",
+	})
 }
 
 func SetRedactionEnabledTotal(appName, ruleType string, count int) {
@@ -177,48 +287,81 @@ func ObserveTPSExceededBytes(topic string, suffix string, timber *pb.Timber) {
 	producerTPSExceededLogBytes.WithLabelValues(appName).Add(math.Round(float64(len(b))))
 }
 
-func IncreaseLogStoredCounter(index string, result string, status int, errorDetail *elastic.ErrorDetails) {
+// IncreaseLogStoredCounter counts a bulk item of app, i.e. the index prefix the index is named after
+func IncreaseLogStoredCounter(app string, result string, status int, errorDetail *elastic.ErrorDetails) {
+	errorType := LogStoredErrorType(errorDetail)
+	if errorType == "undefined_error" {
+		errorDetailStr, _ := json.Marshal(errorDetail)
+		log.Errorf("Found undefined error when consumer fail: %s, details: %s", errorDetail.Reason, string(errorDetailStr))
+	}
+
+	consumerLogStoredCounter.WithLabelValues(app, result, strconv.Itoa(status), errorType).Inc()
+}
+
+// LogStoredErrorType classifies the error of a bulk item by logStoredErrorMap, it is empty when there is no error
+func LogStoredErrorType(errorDetail *elastic.ErrorDetails) string {
 	errorMessage := ""
 	if errorDetail != nil {
 		errorMessage = errorDetail.Reason
 	}
-	errorType := ""
-	if errorMessage != "" {
-		errorType = "undefined_error"
-		for k, v := range logStoredErrorMap {
-			if strings.Contains(errorMessage, k) {
-				errorType = v
-				break
-			}
-		}
+	if errorMessage == "" {
+		return ""
+	}
 
-		// try using caused_by.reason
-		if causedBy, ok := errorDetail.CausedBy["reason"]; ok && errorType == "undefined_error" {
-			causedBy, ok := causedBy.(string)
-			if ok {
-				for k, v := range logStoredErrorMap {
-					if strings.Contains(causedBy, k) {
-						errorType = v
-						break
-					}
-				}
-			}
+	for k, v := range logStoredErrorMap {
+		if strings.Contains(errorMessage, k) {
+			return v
 		}
+	}
 
-		if errorType == "undefined_error" {
-			errorDetailStr, _ := json.Marshal(errorDetail)
-			log.Errorf("Found undefined error when consumer fail: %s, details: %s", errorMessage, string(errorDetailStr))
+	// try using caused_by.reason
+	if causedBy, ok := errorDetail.CausedBy["reason"].(string); ok {
+		for k, v := range logStoredErrorMap {
+			if strings.Contains(causedBy, k) {
+				return v
+			}
 		}
 	}
 
-	indexWithoutDate := indexDatePattern.ReplaceAllString(index, "")
-	consumerLogStoredCounter.WithLabelValues(indexWithoutDate, result, strconv.Itoa(status), errorType).Inc()
+	return "undefined_error"
 }
 
 func IncreaseKafkaMessagesIncoming(topic string) {
 	consumerKafkaMessagesIncomingCounter.WithLabelValues(topic).Inc()
 }
 
+func SetConsumerUncommittedOffsets(topic string, count int) {
+	consumerUncommittedOffsets.WithLabelValues(topic).Set(float64(count))
+}
+
+func IncreaseConsumerUndeliveredMessage(topic, result string) {
+	consumerUndeliveredMessageTotal.WithLabelValues(topic, result).Inc()
+}
+
+func IncreaseConsumerDeadLetter(topic, kind, errorType string) {
+	consumerDeadLetterTotal.WithLabelValues(topic, kind, errorType).Inc()
+}
+
+func IncreaseConsumerBulkRetry(index string, status int, result string) {
+	consumerBulkRetryTotal.WithLabelValues(index, strconv.Itoa(status), result).Inc()
+}
+
+func IncreaseConsumerEventTimeRoute(index, route string) {
+	consumerEventTimeRouteTotal.WithLabelValues(index, route).Inc()
+}
+
+func IncreaseConsumerIndexTemplate(template, result string) {
+	consumerIndexTemplateTotal.WithLabelValues(template, result).Inc()
+}
+
+func SetConsumerSubscribedTopics(count int) {
+	consumerSubscribedTopics.Set(float64(count))
+}
+
+func ObserveConsumerRecordLag(topic string, elapsedTime float64) {
+	consumerRecordLagSecond.WithLabelValues(topic).Observe(elapsedTime)
+}
+
 func ObserveBulkProcessTime(elapsedTime float64) {
 	consumerBulkProcessTimeSecond.Observe(elapsedTime)
 }
@@ -251,6 +394,63 @@ func IncreaseProducerKafkaClientFailed() {
 	producerKafkaClientFailed.WithLabelValues().Inc()
 }
 
+func ObserveProducerMicroBatch(flushReason string, items int) {
+	producerMicroBatchItems.WithLabelValues(flushReason).Observe(float64(items))
+}
+
+func IncreaseProducerSyslogMessage(transport string, result string) {
+	producerSyslogMessageTotal.WithLabelValues(transport, result).Inc()
+}
+
+func IncreaseProducerFluentForwardEvents(result string, n int) {
+	producerFluentForwardEventTotal.WithLabelValues(result).Add(float64(n))
+}
+
+func IncreaseProducerEsBulkItems(result string, n int) {
+	producerEsBulkItemTotal.WithLabelValues(result).Add(float64(n))
+}
+
+func IncreaseProducerMirrorMessage(result string) {
+	producerMirrorMessageTotal.WithLabelValues(result).Inc()
+}
+
+func SetProducerKafkaActiveCluster(active string, inactive string) {
+	producerKafkaActiveCluster.WithLabelValues(active).Set(1)
+	producerKafkaActiveCluster.WithLabelValues(inactive).Set(0)
+}
+
+func IncreaseProducerKafkaFailover(cluster string) {
+	producerKafkaFailoverTotal.WithLabelValues(cluster).Inc()
+}
+
+func IncreaseProducerSchemaViolation(app string, path string) {
+	producerSchemaViolationTotal.WithLabelValues(app, path).Inc()
+}
+
+func IncreaseProducerInvalidRequest(reason string) {
+	producerInvalidRequestTotal.WithLabelValues(reason).Inc()
+}
+
+func IncreaseProducerPeerRejected(reason string) {
+	producerPeerRejectedTotal.WithLabelValues(reason).Inc()
+}
+
+func IncreaseProducerPeerBanned() {
+	producerPeerBannedTotal.Inc()
+}
+
+func SetProducerActiveConnections(n int) {
+	producerPeerActiveConnections.Set(float64(n))
+}
+
+func IncreaseProducerPeerInflightStreams(n int) {
+	producerPeerInflightStreams.Add(float64(n))
+}
+
+func IncreaseProducerTailActive(n int) {
+	producerTailActive.Add(float64(n))
+}
+
 func IncreaseConsumerGCSUploadAttemptTotal(name string, projectId string, bucket string, path string, success string) {
 	consumerGCSUploadAttemptTotal.WithLabelValues(success, name, projectId, bucket, path).Inc()
 }